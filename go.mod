@@ -5,7 +5,8 @@ go 1.13
 require (
 	github.com/gorilla/websocket v1.4.2
 	github.com/jirenius/timerqueue v1.0.0
-	github.com/nats-io/nats.go v1.10.0
+	github.com/nats-io/jwt v0.3.2 // indirect
+	github.com/nats-io/nats.go v1.11.0
 	github.com/posener/wstest v1.2.0
 	github.com/rs/xid v1.2.1
 )
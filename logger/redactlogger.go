@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RedactLogger writes log messages to an underlying logger, with the values
+// of a set of query parameters replaced by [REDACTED].
+type RedactLogger struct {
+	Logger
+	re *regexp.Regexp
+}
+
+// Redacted is the replacement for redacted query parameter values.
+const Redacted = "[REDACTED]"
+
+// NewRedactLogger returns a logger that redacts the values of the query
+// parameters before writing to l. If params is empty, l is returned.
+func NewRedactLogger(l Logger, params []string) Logger {
+	if len(params) == 0 {
+		return l
+	}
+	quoted := make([]string, len(params))
+	for i, p := range params {
+		quoted[i] = regexp.QuoteMeta(p)
+	}
+	// A parameter starts the query, or follows a separator. In JSON encoded
+	// strings, the & separator may be escaped as &.
+	re := regexp.MustCompile(`(^|[?&"\s]|\\u0026)(` + strings.Join(quoted, "|") + `)=[^&"\s\\]*`)
+	return &RedactLogger{Logger: l, re: re}
+}
+
+func (l *RedactLogger) redact(s string) string {
+	return l.re.ReplaceAllString(s, "${1}${2}="+Redacted)
+}
+
+// Log writes a log entry
+func (l *RedactLogger) Log(s string) {
+	l.Logger.Log(l.redact(s))
+}
+
+// Error writes an error entry
+func (l *RedactLogger) Error(s string) {
+	l.Logger.Error(l.redact(s))
+}
+
+// Debug writes a debug entry
+func (l *RedactLogger) Debug(s string) {
+	l.Logger.Debug(l.redact(s))
+}
+
+// Trace writes a trace entry
+func (l *RedactLogger) Trace(s string) {
+	l.Logger.Trace(l.redact(s))
+}
@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactLogger(t *testing.T) {
+	tbl := []struct {
+		Message  string
+		Expected string
+	}{
+		{"subscribe.test.model?token=secret", "subscribe.test.model?token=[REDACTED]"},
+		{"subscribe.test.model?foo=bar&token=secret&bar=baz", "subscribe.test.model?foo=bar&token=[REDACTED]&bar=baz"},
+		{`{"query":"token=secret&foo=bar"}`, `{"query":"token=[REDACTED]&foo=bar"}`},
+		{`{"query":"foo=bar&token=secret"}`, `{"query":"foo=bar&token=[REDACTED]"}`},
+		{`{"query":"foo=bar\u0026token=secret\u0026bar=baz"}`, `{"query":"foo=bar\u0026token=[REDACTED]\u0026bar=baz"}`},
+		{"test.model?email=foo@example.com&token=", "test.model?email=[REDACTED]&token=[REDACTED]"},
+		{"test.model?mytoken=secret&token2=secret", "test.model?mytoken=secret&token2=secret"},
+		{"test.model?foo=token=secret", "test.model?foo=token=secret"},
+		{"no query", "no query"},
+	}
+
+	for i, l := range tbl {
+		ml := NewMemLogger(true, true)
+		rl := NewRedactLogger(ml, []string{"token", "email"})
+		rl.Log(l.Message)
+		rl.Trace(l.Message)
+		out := ml.String()
+		if strings.Count(out, l.Expected) != 2 {
+			t.Errorf("test #%d: expected log to contain %q twice, but got:\n%s", i+1, l.Expected, out)
+		}
+	}
+}
+
+func TestRedactLogger_WithoutParams_ReturnsLogger(t *testing.T) {
+	ml := NewMemLogger(false, false)
+	if l := NewRedactLogger(ml, nil); l != ml {
+		t.Fatal("expected the same logger to be returned")
+	}
+}
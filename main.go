@@ -280,7 +280,7 @@ func main() {
 		URL:            cfg.NatsURL,
 		Creds:          cfg.NatsCreds,
 		RequestTimeout: time.Duration(cfg.RequestTimeout) * time.Millisecond,
-		Logger:         l,
+		Logger:         logger.NewRedactLogger(l, cfg.RedactQueryParams),
 	}, cfg.Config)
 	if err != nil {
 		printAndDie(fmt.Sprintf("Failed to initialize server: %s", err.Error()), false)
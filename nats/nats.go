@@ -1,9 +1,11 @@
 package nats
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,8 +17,16 @@ import (
 
 const (
 	natsChannelSize = 256
+	// debugPayloadSize is the maximum number of payload bytes included in
+	// debug logging of requests.
+	debugPayloadSize = 128
 )
 
+// redactedFields are request payload fields that may hold credentials, such
+// as the access token or cookie headers, and are never included in debug
+// logging of requests.
+var redactedFields = []string{"token", "header"}
+
 // Client holds a client connection to a nats server.
 type Client struct {
 	RequestTimeout time.Duration
@@ -24,13 +34,15 @@ type Client struct {
 	Creds          *string
 	Logger         logger.Logger
 
-	mq           *nats.Conn
-	mqCh         chan *nats.Msg
-	mqReqs       map[*nats.Subscription]*responseCont
-	tq           *timerqueue.Queue
-	mu           sync.Mutex
-	closeHandler func(error)
-	stopped      chan struct{}
+	inboxPrefix      string
+	mq               *nats.Conn
+	mqCh             chan *nats.Msg
+	mqReqs           map[*nats.Subscription]*responseCont
+	tq               *timerqueue.Queue
+	mu               sync.Mutex
+	closeHandler     func(error)
+	reconnectHandler func()
+	stopped          chan struct{}
 }
 
 // Subscription implements the mq.Unsubscriber interface.
@@ -71,13 +83,22 @@ func (c *Client) Connect() error {
 
 	c.Logf("Connecting to NATS at %s", c.URL)
 
-	// Create connection options
-	opts := []nats.Option{nats.NoReconnect(), nats.ClosedHandler(c.onClose)}
+	// Create connection options. Unless a reconnect handler is set, there
+	// are no reconnects as all resources are instantly stale anyhow.
+	opts := []nats.Option{nats.ClosedHandler(c.onClose)}
+	if c.reconnectHandler != nil {
+		opts = append(opts,
+			nats.MaxReconnects(-1),
+			nats.DisconnectErrHandler(c.onDisconnect),
+			nats.ReconnectHandler(c.onReconnect),
+		)
+	} else {
+		opts = append(opts, nats.NoReconnect())
+	}
 	if c.Creds != nil {
 		opts = append(opts, nats.UserCredentials(*c.Creds))
 	}
 
-	// No reconnects as all resources are instantly stale anyhow
 	nc, err := nats.Connect(c.URL, opts...)
 	if err != nil {
 		return err
@@ -140,11 +161,49 @@ func (c *Client) Close() {
 	c.Debugf("NATS listener stopped")
 }
 
+// Timeout returns the duration after which a request times out, unless
+// the timeout is extended by a pre-response.
+func (c *Client) Timeout() time.Duration {
+	return c.RequestTimeout
+}
+
 // SetClosedHandler sets the handler when the connection is closed
 func (c *Client) SetClosedHandler(cb func(error)) {
 	c.closeHandler = cb
 }
 
+// SetInboxPrefix sets the prefix of the reply inbox subjects, used instead of
+// the default _INBOX prefix. It must be called before Connect.
+func (c *Client) SetInboxPrefix(prefix string) {
+	c.inboxPrefix = prefix
+}
+
+// newInbox returns a new unique reply inbox subject.
+func (c *Client) newInbox() string {
+	inbox := nats.NewInbox()
+	if c.inboxPrefix == "" {
+		return inbox
+	}
+	return c.inboxPrefix + inbox[len(nats.InboxPrefix)-1:]
+}
+
+// SetReconnectHandler sets the handler called once reconnected after a lost
+// connection. It must be called before Connect to enable reconnects.
+func (c *Client) SetReconnectHandler(cb func()) {
+	c.reconnectHandler = cb
+}
+
+func (c *Client) onDisconnect(conn *nats.Conn, err error) {
+	if err != nil {
+		c.Logf("Lost NATS connection: %s. Reconnecting...", err)
+	}
+}
+
+func (c *Client) onReconnect(conn *nats.Conn) {
+	c.Logf("Reconnected to NATS at %s", conn.ConnectedUrl())
+	c.reconnectHandler()
+}
+
 func (c *Client) onClose(conn *nats.Conn) {
 	if c.closeHandler != nil {
 		err := conn.LastError()
@@ -154,7 +213,24 @@ func (c *Client) onClose(conn *nats.Conn) {
 
 // SendRequest sends a request to the MQ.
 func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
-	inbox := nats.NewInbox()
+	c.sendRequest(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithHeader sends a request to the MQ, with the message headers
+// set. The NATS server must support headers.
+func (c *Client) SendRequestWithHeader(subj string, header map[string][]string, payload []byte, cb mq.Response) {
+	c.sendRequest(subj, header, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends a request to the MQ, with the message headers
+// set unless nil, timing out after the timeout duration instead of the
+// RequestTimeout.
+func (c *Client) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	c.sendRequest(subj, header, payload, timeout, cb)
+}
+
+func (c *Client) sendRequest(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	inbox := c.newInbox()
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -165,17 +241,43 @@ func (c *Client) SendRequest(subj string, payload []byte, cb mq.Response) {
 		return
 	}
 
+	if c.Logger.IsDebug() {
+		c.Debugf("Request %s (reply %s): %s", subj, inbox, truncatePayload(redactPayload(payload)))
+	}
 	c.Tracef("<== (%s) %s: %s", inboxSubstr(inbox), subj, payload)
 
-	err = c.mq.PublishRequest(subj, inbox, payload)
+	if header == nil {
+		err = c.mq.PublishRequest(subj, inbox, payload)
+	} else {
+		err = c.mq.PublishMsg(&nats.Msg{Subject: subj, Reply: inbox, Header: nats.Header(header), Data: payload})
+	}
 	if err != nil {
 		sub.Unsubscribe()
 		cb("", nil, err)
 		return
 	}
 
-	c.tq.Add(sub)
-	c.mqReqs[sub] = &responseCont{isReq: true, f: cb}
+	rc := &responseCont{isReq: true, f: cb}
+	if timeout > 0 {
+		rc.t = time.AfterFunc(timeout, func() {
+			c.onTimeout(sub)
+		})
+	} else {
+		c.tq.Add(sub)
+	}
+	c.mqReqs[sub] = rc
+}
+
+// Publish publishes the payload on a subject, not expecting a response.
+func (c *Client) Publish(subj string, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mq == nil {
+		return nats.ErrConnectionClosed
+	}
+	c.Tracef("P=> %s: %s", subj, payload)
+	return c.mq.Publish(subj, payload)
 }
 
 // Subscribe to all events on a resource namespace.
@@ -184,7 +286,12 @@ func (c *Client) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, e
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	sub, err := c.mq.ChanSubscribe(namespace+".*", c.mqCh)
+	subj := namespace + ".*"
+	// A full wildcard namespace already matches the event name token
+	if strings.HasSuffix(namespace, ".>") {
+		subj = namespace
+	}
+	sub, err := c.mq.ChanSubscribe(subj, c.mqCh)
 	if err != nil {
 		return nil, err
 	}
@@ -287,6 +394,40 @@ func (c *Client) onTimeout(v interface{}) {
 	rc.f("", nil, mq.ErrRequestTimeout)
 }
 
+// redactPayload returns the request payload with the values of any
+// redactedFields replaced. If the payload is not a JSON object, only its size
+// is returned.
+func redactPayload(payload []byte) []byte {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &m); err != nil || m == nil {
+		return []byte(fmt.Sprintf("(%d bytes)", len(payload)))
+	}
+	redacted := false
+	for _, k := range redactedFields {
+		if _, ok := m[k]; ok {
+			m[k] = json.RawMessage(`"[redacted]"`)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return payload
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return []byte(fmt.Sprintf("(%d bytes)", len(payload)))
+	}
+	return b
+}
+
+// truncatePayload returns the payload as a string, truncated to
+// debugPayloadSize bytes.
+func truncatePayload(payload []byte) string {
+	if len(payload) <= debugPayloadSize {
+		return string(payload)
+	}
+	return string(payload[:debugPayloadSize]) + fmt.Sprintf("... (%d bytes)", len(payload))
+}
+
 func inboxSubstr(s string) string {
 	l := len(s)
 	if l <= 6 {
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// resourceAlias is an alias resource pattern mapped to a target resource
+// pattern, with the wildcard tokens of the alias substituted in the target.
+type resourceAlias struct {
+	pattern rescache.ResourcePattern
+	alias   []string // Alias pattern tokens
+	target  []string // Target pattern tokens
+}
+
+// resourceAliases is a list of resource aliases, sorted by specificity of
+// the alias patterns.
+type resourceAliases []resourceAlias
+
+var errAliasWildcards = errors.New("must be a valid resource pattern with the same wildcards as the alias")
+
+// parseResourceAliases parses a map of alias resource patterns to target
+// resource patterns. The target must contain the same number of single
+// wildcards, "*", as the alias, and a full wildcard, ">", only if the alias
+// does. The setting name is used in any error returned.
+func parseResourceAliases(setting string, m map[string]string) (resourceAliases, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	patterns := make([]string, 0, len(m))
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+	rps, err := parsePatterns(setting, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	ra := make(resourceAliases, 0, len(patterns))
+	for i, p := range patterns {
+		v := m[p]
+		a := resourceAlias{
+			pattern: rps[i],
+			alias:   strings.Split(p, "."),
+			target:  strings.Split(v, "."),
+		}
+		if !rescache.ParseResourcePattern(v).IsValid() || wildcards(a.alias) != wildcards(a.target) {
+			return nil, fmt.Errorf("invalid %s setting for %s (%s)\n\t%s", setting, p, v, errAliasWildcards)
+		}
+		ra = append(ra, a)
+	}
+	return ra, nil
+}
+
+// wildcards returns a string describing the wildcard tokens of a pattern,
+// with the number of single wildcards followed by any full wildcard.
+func wildcards(tokens []string) string {
+	n, full := 0, ""
+	for _, t := range tokens {
+		switch t {
+		case "*":
+			n++
+		case ">":
+			full = ">"
+		}
+	}
+	return fmt.Sprintf("%d%s", n, full)
+}
+
+// resolve returns the target resource name of the first alias pattern
+// matching the resource name.
+func (ra resourceAliases) resolve(rname string) (string, bool) {
+	for _, a := range ra {
+		if a.pattern.Match(rname) {
+			return a.substitute(rname), true
+		}
+	}
+	return "", false
+}
+
+// substitute returns the target resource name, replacing the wildcards of
+// the target pattern with the tokens they match in the resource name.
+func (a resourceAlias) substitute(rname string) string {
+	tokens := strings.Split(rname, ".")
+	var captures []string
+	var tail string
+	for i, t := range a.alias {
+		switch t {
+		case "*":
+			captures = append(captures, tokens[i])
+		case ">":
+			tail = strings.Join(tokens[i:], ".")
+		}
+	}
+
+	var b strings.Builder
+	for i, t := range a.target {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		switch t {
+		case "*":
+			b.WriteString(captures[0])
+			captures = captures[1:]
+		case ">":
+			b.WriteString(tail)
+		default:
+			b.WriteString(t)
+		}
+	}
+	return b.String()
+}
+
+// aliasClient wraps a mq.Client, sending access, get, call, and auth
+// requests, and subscribing to events, for resources matching an alias
+// pattern on the target resource instead. Events on the target resource are
+// passed on as events on the alias resource.
+type aliasClient struct {
+	mq.Client
+	aliases resourceAliases
+}
+
+// SendRequest sends the request on the subject of the target resource if the
+// requested resource is an alias.
+func (c *aliasClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends the request with any headers and timeout, on
+// the subject of the target resource if the requested resource is an alias.
+func (c *aliasClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	idx := strings.IndexByte(subj, '.')
+	if idx >= 0 {
+		rname, method := subj[idx+1:], ""
+		switch subj[:idx] {
+		case "call", "auth":
+			if i := strings.LastIndexByte(rname, '.'); i >= 0 {
+				rname, method = rname[:i], rname[i:]
+			}
+			fallthrough
+		case "get", "access":
+			if target, ok := c.aliases.resolve(rname); ok {
+				subj = subj[:idx+1] + target + method
+			}
+		}
+	}
+	mq.SendRequest(c.Client, subj, header, payload, timeout, cb)
+}
+
+// Subscribe subscribes to the events of the target resource if the resource
+// of the namespace is an alias, passing them on with the alias subject.
+func (c *aliasClient) Subscribe(namespace string, cb mq.Response) (mq.Unsubscriber, error) {
+	if !strings.HasPrefix(namespace, "event.") {
+		return c.Client.Subscribe(namespace, cb)
+	}
+	target, ok := c.aliases.resolve(namespace[len("event."):])
+	if !ok {
+		return c.Client.Subscribe(namespace, cb)
+	}
+	prefix := "event." + target
+	return c.Client.Subscribe(prefix, func(subj string, payload []byte, err error) {
+		cb(namespace+strings.TrimPrefix(subj, prefix), payload, err)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/rescache"
@@ -124,11 +125,11 @@ func init() {
 	}
 
 	RegisterAPIEncoderFactory("json", func(cfg Config) APIEncoder {
-		return &encoderJSON{apiPath: cfg.APIPath, notFoundBytes: b}
+		return &encoderJSON{apiPath: cfg.APIPath, notFoundBytes: b, emptyNull: cfg.EmptyResourceNull}
 
 	})
 	RegisterAPIEncoderFactory("jsonflat", func(cfg Config) APIEncoder {
-		return &encoderJSONFlat{apiPath: cfg.APIPath, notFoundBytes: b}
+		return &encoderJSONFlat{apiPath: cfg.APIPath, notFoundBytes: b, emptyNull: cfg.EmptyResourceNull}
 	})
 }
 
@@ -137,6 +138,7 @@ type encoderJSON struct {
 	path          []string
 	apiPath       string
 	notFoundBytes []byte
+	emptyNull     bool // Encode empty models and collections as null
 }
 
 func (e *encoderJSON) ContentType() string {
@@ -148,6 +150,7 @@ func (e *encoderJSON) EncodeGET(s *Subscription) ([]byte, error) {
 	ec := encoderJSON{
 		apiPath:       e.apiPath,
 		notFoundBytes: e.notFoundBytes,
+		emptyNull:     e.emptyNull,
 	}
 
 	err := ec.encodeSubscription(s, false)
@@ -208,8 +211,12 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 		if wrap {
 			e.b.Write([]byte(`,"collection":`))
 		}
-		e.b.WriteByte('[')
 		vals := s.CollectionValues()
+		if e.emptyNull && len(vals) == 0 {
+			e.b.Write(nullBytes)
+			break
+		}
+		e.b.WriteByte('[')
 		for i, v := range vals {
 			if i > 0 {
 				e.b.WriteByte(',')
@@ -224,15 +231,17 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 		if wrap {
 			e.b.Write([]byte(`,"model":`))
 		}
-		e.b.WriteByte('{')
 		vals := s.ModelValues()
-		first := true
-		for k, v := range vals {
+		if e.emptyNull && len(vals) == 0 {
+			e.b.Write(nullBytes)
+			break
+		}
+		e.b.WriteByte('{')
+		for i, k := range s.ModelKeys() {
 			// Write comma separator
-			if !first {
+			if i > 0 {
 				e.b.WriteByte(',')
 			}
-			first = false
 
 			// Write object key
 			dta, err := json.Marshal(k)
@@ -242,7 +251,7 @@ func (e *encoderJSON) encodeSubscription(s *Subscription, wrap bool) error {
 			e.b.Write(dta)
 			e.b.WriteByte(':')
 
-			if err := e.encodeValue(s, v); err != nil {
+			if err := e.encodeValue(s, vals[k]); err != nil {
 				return err
 			}
 		}
@@ -283,6 +292,7 @@ type encoderJSONFlat struct {
 	path          []string
 	apiPath       string
 	notFoundBytes []byte
+	emptyNull     bool // Encode empty models and collections as null
 }
 
 func (e *encoderJSONFlat) ContentType() string {
@@ -294,6 +304,7 @@ func (e *encoderJSONFlat) EncodeGET(s *Subscription) ([]byte, error) {
 	ec := encoderJSONFlat{
 		apiPath:       e.apiPath,
 		notFoundBytes: e.notFoundBytes,
+		emptyNull:     e.emptyNull,
 	}
 
 	err := ec.encodeSubscription(s)
@@ -345,8 +356,12 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 
 	switch s.ResourceType() {
 	case rescache.TypeCollection:
-		e.b.WriteByte('[')
 		vals := s.CollectionValues()
+		if e.emptyNull && len(vals) == 0 {
+			e.b.Write(nullBytes)
+			break
+		}
+		e.b.WriteByte('[')
 		for i, v := range vals {
 			if i > 0 {
 				e.b.WriteByte(',')
@@ -358,15 +373,17 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 		e.b.WriteByte(']')
 
 	case rescache.TypeModel:
-		e.b.WriteByte('{')
 		vals := s.ModelValues()
-		first := true
-		for k, v := range vals {
+		if e.emptyNull && len(vals) == 0 {
+			e.b.Write(nullBytes)
+			break
+		}
+		e.b.WriteByte('{')
+		for i, k := range s.ModelKeys() {
 			// Write comma separator
-			if !first {
+			if i > 0 {
 				e.b.WriteByte(',')
 			}
-			first = false
 
 			// Write object key
 			dta, err := json.Marshal(k)
@@ -376,7 +393,7 @@ func (e *encoderJSONFlat) encodeSubscription(s *Subscription) error {
 			e.b.Write(dta)
 			e.b.WriteByte(':')
 
-			if err := e.encodeValue(s, v); err != nil {
+			if err := e.encodeValue(s, vals[k]); err != nil {
 				return err
 			}
 		}
@@ -418,3 +435,112 @@ func jsonEncodeError(rerr *reserr.Error) []byte {
 	}
 	return out
 }
+
+// encoderMsgpack wraps a JSON APIEncoder, converting its output to msgpack.
+type encoderMsgpack struct {
+	enc           APIEncoder
+	notFoundBytes []byte
+}
+
+func newEncoderMsgpack(enc APIEncoder) *encoderMsgpack {
+	b, _ := codec.JSONToMsgpack(enc.NotFoundError())
+	return &encoderMsgpack{enc: enc, notFoundBytes: b}
+}
+
+func (e *encoderMsgpack) ContentType() string {
+	return codec.MsgpackContentType
+}
+
+func (e *encoderMsgpack) EncodeGET(s *Subscription) ([]byte, error) {
+	return e.convert(e.enc.EncodeGET(s))
+}
+
+func (e *encoderMsgpack) EncodePOST(r json.RawMessage) ([]byte, error) {
+	return e.convert(e.enc.EncodePOST(r))
+}
+
+func (e *encoderMsgpack) EncodeError(rerr *reserr.Error) []byte {
+	out, err := codec.JSONToMsgpack(e.enc.EncodeError(rerr))
+	if err != nil {
+		out, _ = codec.JSONToMsgpack(jsonEncodeError(reserr.RESError(err)))
+	}
+	return out
+}
+
+func (e *encoderMsgpack) NotFoundError() []byte {
+	return e.notFoundBytes
+}
+
+func (e *encoderMsgpack) convert(b []byte, err error) ([]byte, error) {
+	if err != nil || b == nil {
+		return b, err
+	}
+	return codec.JSONToMsgpack(b)
+}
+
+// encoderEnvelope wraps a JSON APIEncoder, wrapping its output in an envelope
+// with the response under "data", or the error under "error", and the
+// response metadata under "meta".
+type encoderEnvelope struct {
+	enc APIEncoder
+	rid string
+}
+
+type envelope struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error json.RawMessage `json:"error,omitempty"`
+	Meta  envelopeMeta    `json:"meta"`
+}
+
+type envelopeMeta struct {
+	RID       string `json:"rid"`
+	Timestamp string `json:"timestamp"`
+}
+
+func newEncoderEnvelope(enc APIEncoder, rid string) *encoderEnvelope {
+	return &encoderEnvelope{enc: enc, rid: rid}
+}
+
+func (e *encoderEnvelope) ContentType() string {
+	return e.enc.ContentType()
+}
+
+func (e *encoderEnvelope) EncodeGET(s *Subscription) ([]byte, error) {
+	b, err := e.enc.EncodeGET(s)
+	if err != nil {
+		return nil, err
+	}
+	return e.wrap(envelope{Data: b})
+}
+
+func (e *encoderEnvelope) EncodePOST(r json.RawMessage) ([]byte, error) {
+	b, err := e.enc.EncodePOST(r)
+	if err != nil || b == nil {
+		return b, err
+	}
+	return e.wrap(envelope{Data: b})
+}
+
+func (e *encoderEnvelope) EncodeError(rerr *reserr.Error) []byte {
+	out, err := e.wrap(envelope{Error: e.enc.EncodeError(rerr)})
+	if err != nil {
+		return jsonEncodeError(reserr.RESError(err))
+	}
+	return out
+}
+
+func (e *encoderEnvelope) NotFoundError() []byte {
+	out, err := e.wrap(envelope{Error: e.enc.NotFoundError()})
+	if err != nil {
+		return jsonEncodeError(reserr.RESError(err))
+	}
+	return out
+}
+
+func (e *encoderEnvelope) wrap(env envelope) ([]byte, error) {
+	env.Meta = envelopeMeta{
+		RID:       e.rid,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	return json.Marshal(env)
+}
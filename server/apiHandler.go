@@ -1,17 +1,30 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
 	"github.com/resgateio/resgate/server/reserr"
 )
 
+// returnRepresentationParam is the query parameter used on HTTP calls to
+// request the resulting state of the resource in the response.
+const returnRepresentationParam = "return=representation"
+
+// ridHeader is the HTTP response header containing the resource ID of the
+// resource serving the request.
+const ridHeader = "X-RID"
+
 func (s *Service) initAPIHandler() error {
 	f := apiEncoderFactories[strings.ToLower(s.cfg.APIEncoding)]
 	if f == nil {
@@ -22,17 +35,113 @@ func (s *Service) initAPIHandler() error {
 		return fmt.Errorf("invalid apiEncoding setting (%s) - available encodings: %s", s.cfg.APIEncoding, strings.Join(keys, ", "))
 	}
 	s.enc = f(s.cfg)
+	s.encFactory = f
+	s.msgpackEnc = newEncoderMsgpack(s.enc)
 	mimetype, _, err := mime.ParseMediaType(s.enc.ContentType())
 	s.mimetype = mimetype
 	return err
 }
 
+// apiEncoder returns the encoder for the response to the HTTP request. The
+// msgpack encoder is used if the Accept header includes application/msgpack,
+// otherwise the configured encoder. If absoluteURLs is set, a new encoder is
+// created using the absolute API URL of the request. If the resource ID is
+// set and matches an httpEnvelope pattern, the response is wrapped in an
+// envelope.
+func (s *Service) apiEncoder(r *http.Request, rid string) APIEncoder {
+	enc, msgpackEnc := s.enc, s.msgpackEnc
+	if s.cfg.AbsoluteURLs {
+		cfg := s.cfg
+		cfg.APIPath = s.apiURL(r)
+		enc = s.encFactory(cfg)
+		msgpackEnc = newEncoderMsgpack(enc)
+	}
+	if rid != "" && s.cfg.httpEnvelope != nil {
+		if rname, _ := parseRID(rid); s.cfg.envelope(rname) {
+			enc = newEncoderEnvelope(enc, rid)
+			msgpackEnc = newEncoderMsgpack(enc)
+		}
+	}
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			if isMsgpack(part) {
+				return msgpackEnc
+			}
+		}
+	}
+	return enc
+}
+
+// apiURL returns the prefix of the Location header and href values. It is
+// the API path, or the absolute API URL of the request if absoluteURLs is
+// set.
+func (s *Service) apiURL(r *http.Request) string {
+	if !s.cfg.AbsoluteURLs {
+		return s.cfg.APIPath
+	}
+	return requestScheme(r, s.cfg.isTrustedProxy(r.RemoteAddr)) + "://" + r.Host + s.cfg.APIPath
+}
+
+// requestScheme returns the scheme, http or https, used by the client. If the
+// request is from a trusted proxy, the proto parameter of the Forwarded
+// header, or else the X-Forwarded-Proto header, is used if set.
+func requestScheme(r *http.Request, trusted bool) string {
+	if trusted {
+		if proto := forwardedProto(r.Header); proto != "" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedProto returns the scheme set by the first proxy in the Forwarded
+// header, or else in the X-Forwarded-Proto header. An empty string is
+// returned if neither header has a scheme of http or https.
+func forwardedProto(h http.Header) string {
+	var proto string
+	if fwd := h.Get("Forwarded"); fwd != "" {
+		elem := strings.SplitN(fwd, ",", 2)[0]
+		for _, pair := range strings.Split(elem, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "proto") {
+				proto = strings.Trim(kv[1], `"`)
+				break
+			}
+		}
+	} else if xfp := h.Get("X-Forwarded-Proto"); xfp != "" {
+		proto = strings.SplitN(xfp, ",", 2)[0]
+	}
+	proto = strings.ToLower(strings.TrimSpace(proto))
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+	return proto
+}
+
+// isMsgpack reports whether the media type is application/msgpack.
+// A media type with a quality value of zero is not accepted.
+func isMsgpack(mediaType string) bool {
+	mt, params, err := mime.ParseMediaType(mediaType)
+	if err != nil || mt != codec.MsgpackContentType {
+		return false
+	}
+	if q, ok := params["q"]; ok {
+		if f, err := strconv.ParseFloat(q, 64); err != nil || f == 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // setCommonHeaders sets common headers such as Access-Control-*.
 // It returns error if the origin header does not match any allowed origin.
 func (s *Service) setCommonHeaders(w http.ResponseWriter, r *http.Request) error {
 	if s.cfg.allowOrigin[0] == "*" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "content-type, authorization")
+		s.setAllowHeaders(w)
 		return nil
 	}
 
@@ -43,12 +152,12 @@ func (s *Service) setCommonHeaders(w http.ResponseWriter, r *http.Request) error
 	if len(origin) > 0 && origin[0] != "null" {
 		if matchesOrigins(s.cfg.allowOrigin, origin[0]) {
 			w.Header().Set("Access-Control-Allow-Origin", origin[0])
-			w.Header().Set("Access-Control-Allow-Headers", "content-type, authorization")
+			s.setAllowHeaders(w)
 			w.Header().Set("Vary", "Origin")
 		} else {
 			// No matching origin
 			w.Header().Set("Access-Control-Allow-Origin", s.cfg.allowOrigin[0])
-			w.Header().Set("Access-Control-Allow-Headers", "content-type, authorization")
+			s.setAllowHeaders(w)
 			w.Header().Set("Vary", "Origin")
 			return reserr.ErrForbiddenOrigin
 		}
@@ -56,14 +165,31 @@ func (s *Service) setCommonHeaders(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// setAllowHeaders sets the Access-Control-Allow-Headers header, unless no
+// headers are allowed.
+func (s *Service) setAllowHeaders(w http.ResponseWriter) {
+	if s.cfg.allowHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", s.cfg.allowHeaders)
+	}
+}
+
 func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
+	enc := s.apiEncoder(r, "")
 	err := s.setCommonHeaders(w, r)
 	if r.Method == "OPTIONS" {
 		w.Header().Set("Access-Control-Allow-Methods", s.cfg.allowMethods)
+		if s.cfg.OptionsAllow {
+			w.Header().Set("Allow", s.cfg.allow)
+			w.WriteHeader(http.StatusNoContent)
+		}
 		return
 	}
 	if err != nil {
-		httpError(w, err, s.enc)
+		httpError(w, err, enc)
+		return
+	}
+	if s.shedLoad() {
+		httpError(w, s.errLoadShed(), enc)
 		return
 	}
 
@@ -74,36 +200,67 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 
 	apiPath := s.cfg.APIPath
 
-	// NotFound on oaths with trailing slash (unless it is only the APIPath)
+	// Strip a single trailing slash, if configured
+	if s.cfg.StripTrailingSlash && len(path) > len(apiPath) && path[len(path)-1] == '/' {
+		path = path[:len(path)-1]
+	}
+
+	// NotFound on paths with trailing slash (unless it is only the APIPath)
 	if len(path) > len(apiPath) && path[len(path)-1] == '/' {
-		notFoundHandler(w, r, s.enc)
+		notFoundHandler(w, r, enc)
 		return
 	}
 
 	var rid, action string
+	query, representation := r.URL.RawQuery, false
+	if r.Method != "GET" && r.Method != "HEAD" {
+		query, representation = extractReturnRepresentation(query)
+	}
 	switch r.Method {
 	case "HEAD":
 		fallthrough
 	case "GET":
+		if s.cfg.MetadataSuffix != "" {
+			if p := strings.TrimSuffix(path, "/"+s.cfg.MetadataSuffix); len(p) < len(path) && len(p) > len(apiPath) {
+				s.handleMetadata(w, r, enc, PathToRID(p, r.URL.RawQuery, apiPath))
+				return
+			}
+		}
 		rid = PathToRID(path, r.URL.RawQuery, apiPath)
 		if !codec.IsValidRID(rid, true) {
-			notFoundHandler(w, r, s.enc)
+			notFoundHandler(w, r, enc)
 			return
 		}
+		enc = s.apiEncoder(r, rid)
 
-		s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
+		s.temporaryConn(w, r, enc, func(c *wsConn, cb func([]byte, error)) {
 			c.GetSubscription(rid, func(sub *Subscription, err error) {
 				if err != nil {
 					cb(nil, err)
 					return
 				}
-				cb(s.enc.EncodeGET(sub))
+				w.Header().Set(ridHeader, sub.RID())
+				if s.cfg.LastModified && notModified(w, r, sub.LastModified()) {
+					w.WriteHeader(http.StatusNotModified)
+					cb(nil, nil)
+					return
+				}
+				if s.cfg.CollectionRange && sub.ResourceType() == rescache.TypeCollection && !c.rangeCollection(w, r, sub) {
+					cb(nil, nil)
+					return
+				}
+				cb(enc.EncodeGET(sub))
 			})
 		})
 		return
 
 	case "POST":
-		rid, action = PathToRIDAction(path, r.URL.RawQuery, apiPath)
+		rid = PathToRID(path, query, apiPath)
+		if m := s.cfg.postMethod(strings.SplitN(rid, "?", 2)[0]); m != nil {
+			action = *m
+		} else {
+			rid, action = PathToRIDAction(path, query, apiPath)
+		}
 	default:
 		var m *string
 		switch r.Method {
@@ -122,14 +279,20 @@ func (s *Service) apiHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// Return error if we have no mapping for the method
 		if m == nil {
-			httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+			httpError(w, reserr.ErrMethodNotAllowed, enc)
 			return
 		}
-		rid = PathToRID(path, r.URL.RawQuery, apiPath)
+		rid = PathToRID(path, query, apiPath)
 		action = *m
 	}
 
-	s.handleCall(w, r, rid, action)
+	s.handleCall(w, r, enc, rid, action, representation)
+}
+
+// isConditionalMethod reports whether the HTTP method is one for which
+// If-Match and If-None-Match preconditions are forwarded.
+func isConditionalMethod(method string) bool {
+	return method == "PUT" || method == "DELETE" || method == "PATCH"
 }
 
 func notFoundHandler(w http.ResponseWriter, r *http.Request, enc APIEncoder) {
@@ -138,47 +301,134 @@ func notFoundHandler(w http.ResponseWriter, r *http.Request, enc APIEncoder) {
 	w.Write(enc.NotFoundError())
 }
 
-func (s *Service) handleCall(w http.ResponseWriter, r *http.Request, rid string, action string) {
-	if !codec.IsValidRID(rid, true) || !codec.IsValidRIDPart(action) {
-		notFoundHandler(w, r, s.enc)
-		return
+// extractReturnRepresentation removes any return=representation parameter
+// from the query, and reports whether it was found.
+func extractReturnRepresentation(query string) (string, bool) {
+	if query == "" {
+		return query, false
+	}
+	found := false
+	parts := strings.Split(query, "&")
+	rest := parts[:0]
+	for _, part := range parts {
+		if part == returnRepresentationParam {
+			found = true
+		} else {
+			rest = append(rest, part)
+		}
 	}
+	return strings.Join(rest, "&"), found
+}
 
-	// Try to parse the body
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}, s.enc)
+func (s *Service) handleCall(w http.ResponseWriter, r *http.Request, enc APIEncoder, rid string, action string, representation bool) {
+	if !codec.IsValidRID(rid, true) || !codec.IsValidRIDPart(action) {
+		notFoundHandler(w, r, enc)
 		return
 	}
+	enc = s.apiEncoder(r, rid)
 
-	var params json.RawMessage
-	if strings.TrimSpace(string(b)) != "" {
-		err = json.Unmarshal(b, &params)
+	// Parse the body, or defer it until call access is granted
+	var params interface{}
+	if s.cfg.AccessBeforeBody {
+		params = lazyParams(func() (interface{}, error) {
+			return s.readCallParams(r)
+		})
+	} else {
+		p, err := s.readCallParams(r)
 		if err != nil {
-			httpError(w, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}, s.enc)
+			httpError(w, err, enc)
 			return
 		}
+		params = p
+	}
+
+	apiURL := s.apiURL(r)
+	if representation {
+		s.temporaryConn(w, r, enc, func(c *wsConn, cb func([]byte, error)) {
+			c.CallHTTPResourceRepresentation(rid, action, params, func(r json.RawMessage, sub *Subscription, refRID string, err error) {
+				if err != nil {
+					cb(nil, err)
+					return
+				}
+				if refRID != "" {
+					w.Header().Set("Location", RIDToPath(refRID, apiURL))
+					w.Header().Set(ridHeader, refRID)
+				} else {
+					w.Header().Set(ridHeader, rid)
+				}
+				switch {
+				case sub != nil:
+					cb(enc.EncodeGET(sub))
+				case refRID != "":
+					w.WriteHeader(http.StatusOK)
+					cb(nil, nil)
+				default:
+					cb(enc.EncodePOST(r))
+				}
+			})
+		})
+		return
 	}
 
-	s.temporaryConn(w, r, func(c *wsConn, cb func([]byte, error)) {
-		c.CallHTTPResource(rid, s.cfg.APIPath, action, params, func(r json.RawMessage, href string, err error) {
+	s.temporaryConn(w, r, enc, func(c *wsConn, cb func([]byte, error)) {
+		c.CallHTTPResource(rid, action, params, func(r json.RawMessage, refRID string, err error) {
 			if err != nil {
 				cb(nil, err)
-			} else if href != "" {
-				w.Header().Set("Location", href)
+			} else if refRID != "" {
+				w.Header().Set("Location", RIDToPath(refRID, apiURL))
+				w.Header().Set(ridHeader, refRID)
 				w.WriteHeader(http.StatusOK)
 				cb(nil, nil)
 			} else {
-				cb(s.enc.EncodePOST(r))
+				w.Header().Set(ridHeader, rid)
+				cb(enc.EncodePOST(r))
 			}
 		})
 	})
 }
 
-func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(*wsConn, func([]byte, error))) {
+// readCallParams reads and decodes the request body as call params.
+func (s *Service) readCallParams(r *http.Request) (json.RawMessage, error) {
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error reading request body: " + err.Error()}
+	}
+
+	if isMsgpack(r.Header.Get("Content-Type")) && len(b) > 0 {
+		if b, err = codec.MsgpackToJSON(b); err != nil {
+			return nil, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}
+		}
+	}
+
+	var params json.RawMessage
+	if strings.TrimSpace(string(b)) != "" {
+		if s.cfg.MaxParamsDepth > 0 && codec.ExceedsJSONDepth(b, s.cfg.MaxParamsDepth) {
+			return nil, reserr.ErrInvalidParams
+		}
+		br := bytes.NewReader(b)
+		dec := json.NewDecoder(br)
+		err = dec.Decode(&params)
+		if err != nil {
+			return nil, &reserr.Error{Code: reserr.CodeBadRequest, Message: "Error decoding request body: " + err.Error()}
+		}
+		if s.cfg.TrailingBodyData != TrailingBodyDataIgnore && hasTrailingData(io.MultiReader(dec.Buffered(), br)) {
+			return nil, reserr.ErrInvalidParams
+		}
+	}
+	return params, nil
+}
+
+// hasTrailingData reports whether the reader contains any non-whitespace
+// data.
+func hasTrailingData(r io.Reader) bool {
+	rest, _ := ioutil.ReadAll(r)
+	return len(bytes.TrimSpace(rest)) > 0
+}
+
+func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, enc APIEncoder, cb func(*wsConn, func([]byte, error))) {
 	c := s.newWSConn(nil, r, versionLatest)
 	if c == nil {
-		httpError(w, reserr.ErrServiceUnavailable, s.enc)
+		httpError(w, reserr.ErrServiceUnavailable, enc)
 		return
 	}
 
@@ -187,27 +437,47 @@ func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(
 		defer c.dispose()
 		defer close(done)
 
+		if c.timing != nil {
+			w.Header().Set("Server-Timing", c.timing.header())
+		}
+
 		if err != nil {
 			// Convert system.methodNotFound to system.methodNotAllowed for PUT/DELETE/PATCH
 			if rerr, ok := err.(*reserr.Error); ok {
 				if rerr.Code == reserr.CodeMethodNotFound && (r.Method == "PUT" || r.Method == "DELETE" || r.Method == "PATCH") {
-					httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+					httpError(w, reserr.ErrMethodNotAllowed, enc)
 					return
 				}
 			}
-			httpError(w, err, s.enc)
+			httpError(w, err, enc)
 			return
 		}
 
 		if len(out) > 0 {
-			w.Header().Set("Content-Type", s.enc.ContentType())
-			w.Write(out)
+			w.Header().Set("Content-Type", enc.ContentType())
+			s.writeCompressed(w, r, w.Header().Get(ridHeader), c.httpStatus, out)
 			return
 		}
 
+		if c.httpStatus != 0 {
+			w.WriteHeader(c.httpStatus)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 	c.Enqueue(func() {
+		var token json.RawMessage
+		if s.cfg.TokenCookie != "" {
+			token = cookieToken(r, s.cfg.TokenCookie)
+		}
+		if s.cfg.TokenHeader != "" {
+			if t := headerToken(r.Header.Get(s.cfg.TokenHeader)); t != nil {
+				token = t
+			}
+		}
+		if token != nil {
+			c.setToken(token)
+		}
 		if s.cfg.HeaderAuth != nil {
 			c.AuthResource(s.cfg.headerAuthRID, s.cfg.headerAuthAction, nil, func(_ interface{}, err error) {
 				cb(c, rs)
@@ -219,6 +489,52 @@ func (s *Service) temporaryConn(w http.ResponseWriter, r *http.Request, cb func(
 	<-done
 }
 
+// notModified sets the Last-Modified header to the modification time, and
+// reports whether the resource is unmodified since the time of any
+// If-Modified-Since header of the request.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	modified = modified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.After(ims)
+}
+
+// headerToken returns the header value, with any Bearer authentication scheme
+// removed, as a JSON encoded string. It returns nil if the value is empty.
+func headerToken(v string) json.RawMessage {
+	v = bearerToken(v)
+	if v == "" {
+		return nil
+	}
+	token, _ := json.Marshal(v)
+	return token
+}
+
+// cookieToken returns the value of the named cookie of the request as a JSON
+// encoded string. It returns nil if the cookie is missing or empty. The value
+// is sensitive, and must not be logged.
+func cookieToken(r *http.Request, name string) json.RawMessage {
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	token, _ := json.Marshal(cookie.Value)
+	return token
+}
+
+// bearerToken returns the header value with any Bearer authentication scheme
+// removed.
+func bearerToken(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 6 && strings.EqualFold(v[:6], "bearer") && (len(v) == 6 || v[6] == ' ') {
+		v = strings.TrimSpace(v[6:])
+	}
+	return v
+}
+
 func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
 	rerr := reserr.RESError(err)
 
@@ -230,6 +546,10 @@ func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
 		fallthrough
 	case reserr.CodeTimeout:
 		code = http.StatusNotFound
+	case reserr.CodeGone:
+		code = http.StatusGone
+	case reserr.CodePreconditionFailed:
+		code = http.StatusPreconditionFailed
 	case reserr.CodeAccessDenied:
 		code = http.StatusUnauthorized
 	case reserr.CodeMethodNotAllowed:
@@ -244,6 +564,9 @@ func httpError(w http.ResponseWriter, err error, enc APIEncoder) {
 		code = http.StatusBadRequest
 	}
 
+	if sec := rerr.RetryAfter(); sec > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(sec))
+	}
 	w.Header().Set("Content-Type", enc.ContentType())
 	w.WriteHeader(code)
 	w.Write(enc.EncodeError(rerr))
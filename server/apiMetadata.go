@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// resourceMetadata is the metadata of a resource, served on the
+// metadataSuffix path of the resource.
+type resourceMetadata struct {
+	RID     string          `json:"rid"`
+	Type    string          `json:"type"`
+	Methods []string        `json:"methods"`
+	Schema  json.RawMessage `json:"schema,omitempty"`
+}
+
+// handleMetadata responds with the metadata of the resource, with its type,
+// the methods the client may call as given by the access response, and any
+// schema matching the resource. Get access is required.
+func (s *Service) handleMetadata(w http.ResponseWriter, r *http.Request, enc APIEncoder, rid string) {
+	if !codec.IsValidRID(rid, true) {
+		notFoundHandler(w, r, enc)
+		return
+	}
+
+	s.temporaryConn(w, r, enc, func(c *wsConn, cb func([]byte, error)) {
+		c.GetSubscription(rid, func(sub *Subscription, err error) {
+			if err != nil {
+				cb(nil, err)
+				return
+			}
+			w.Header().Set(ridHeader, sub.RID())
+			out, err := json.Marshal(s.resourceMetadata(sub))
+			if err != nil {
+				cb(nil, err)
+				return
+			}
+			cb(enc.EncodePOST(out))
+		})
+	})
+}
+
+// resourceMetadata returns the metadata of a loaded subscription.
+func (s *Service) resourceMetadata(sub *Subscription) resourceMetadata {
+	m := resourceMetadata{RID: sub.RID(), Methods: []string{}}
+	switch sub.ResourceType() {
+	case rescache.TypeModel:
+		m.Type = "model"
+	case rescache.TypeCollection:
+		m.Type = "collection"
+	}
+	if a := sub.access; a != nil && a.AccessResult != nil && a.Call != "" {
+		for _, method := range strings.Split(a.Call, ",") {
+			if method != "" {
+				m.Methods = append(m.Methods, method)
+			}
+		}
+	}
+	if v, ok := s.cfg.resourceSchemas.match(sub.ResourceName()); ok {
+		m.Schema = json.RawMessage(v)
+	}
+	return m
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/reserr"
 )
@@ -16,6 +20,34 @@ var (
 	errInvalidValue    = reserr.InternalError(errors.New("invalid value"))
 )
 
+// ErrResultAndResource is returned by DecodeCallResponse when a call response
+// contains both a result and a resource.
+var ErrResultAndResource = &InvalidResponseError{Reason: "response has both result and resource"}
+
+// Fields defined by the RES-service protocol for responses.
+var (
+	responseFields     = []string{"result", "resource", "error"}
+	errorFields        = []string{"code", "message", "data"}
+	accessResultFields = []string{"get", "call", "timeout"}
+	getResultFields    = []string{"model", "collection", "query"}
+)
+
+// InvalidResponseError is returned when a service response is malformed.
+// The error message is safe to send to clients, while Reason describes the
+// malformation in detail, and is intended for logging.
+type InvalidResponseError struct {
+	Reason string
+}
+
+// Error returns the error message, without the reason.
+func (e *InvalidResponseError) Error() string {
+	return "invalid service response"
+}
+
+func invalidResponse(format string, v ...interface{}) *InvalidResponseError {
+	return &InvalidResponseError{Reason: fmt.Sprintf(format, v...)}
+}
+
 const (
 	actionDelete = "delete"
 )
@@ -23,10 +55,17 @@ const (
 // Request represents a RES-service request
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#requests
 type Request struct {
-	Params interface{} `json:"params,omitempty"`
-	Token  interface{} `json:"token,omitempty"`
-	Query  string      `json:"query,omitempty"`
-	CID    string      `json:"cid"`
+	Params   interface{} `json:"params,omitempty"`
+	Token    interface{} `json:"token,omitempty"`
+	Query    string      `json:"query,omitempty"`
+	CID      string      `json:"cid"`
+	Header   http.Header `json:"header,omitempty"`
+	Deadline int64       `json:"deadline,omitempty"`
+	Locale   string      `json:"locale,omitempty"`
+	// The If-Match and If-None-Match headers of the HTTP request, if
+	// preconditions are forwarded.
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneMatch string `json:"ifNoneMatch,omitempty"`
 }
 
 // Response represents a RES-service response
@@ -46,14 +85,42 @@ type AccessResponse struct {
 
 // AccessResult represents the response result of a RES-service access request
 type AccessResult struct {
-	Get  bool   `json:"get"`
-	Call string `json:"call"`
+	Get     bool   `json:"get"`
+	Call    string `json:"call"`
+	Timeout int    `json:"timeout"` // Suggested call request timeout in milliseconds
+}
+
+// AccessBatchVersion is the version of the access batch request convention.
+const AccessBatchVersion = 1
+
+// AccessBatchRequest represents an access batch request, asking for the
+// access of multiple resources in a single request.
+type AccessBatchRequest struct {
+	Version int         `json:"version"`
+	RIDs    []string    `json:"rids"`
+	Token   interface{} `json:"token,omitempty"`
+	CID     string      `json:"cid"`
+	Header  http.Header `json:"header,omitempty"`
+	Locale  string      `json:"locale,omitempty"`
+}
+
+// AccessBatchResponse represents the response of an access batch request
+type AccessBatchResponse struct {
+	Result *AccessBatchResult `json:"result"`
+	Error  *reserr.Error      `json:"error"`
+}
+
+// AccessBatchResult represents the response result of an access batch
+// request, with the access of each resource ID.
+type AccessBatchResult struct {
+	Access map[string]*AccessResult `json:"access"`
 }
 
 // GetRequest represents a RES-service get request
 // https://github.com/resgateio/resgate/blob/master/docs/res-service-protocol.md#get-request
 type GetRequest struct {
-	Query string `json:"query,omitempty"`
+	Query    string `json:"query,omitempty"`
+	Deadline int64  `json:"deadline,omitempty"`
 }
 
 // GetResponse represents the response of a RES-service get request
@@ -159,6 +226,21 @@ type SystemReset struct {
 type Requester interface {
 	// CID returns the connection of the requester
 	CID() string
+	// ForwardedHeader returns the HTTP headers to forward in the request,
+	// or nil if no headers are forwarded.
+	ForwardedHeader() http.Header
+	// Locale returns the normalized locale of the requester, or an empty
+	// string if no locale is forwarded.
+	Locale() string
+}
+
+// PreconditionRequester is a Requester with HTTP request preconditions to
+// forward in call requests.
+type PreconditionRequester interface {
+	Requester
+	// Precondition returns the If-Match and If-None-Match header values of
+	// the HTTP request, or empty strings if none are forwarded.
+	Precondition() (ifMatch string, ifNoneMatch string)
 }
 
 // AuthRequester is the connection making the auth request
@@ -167,6 +249,9 @@ type AuthRequester interface {
 	CID() string
 	// HTTPRequest returns the http.Request from requesters (upgraded) HTTP connection
 	HTTPRequest() *http.Request
+	// Locale returns the normalized locale of the requester, or an empty
+	// string if no locale is forwarded.
+	Locale() string
 }
 
 // ValueType is an enum reprenting the value type
@@ -205,6 +290,11 @@ func (v Value) IsProper() bool {
 	return v.Type >= ValueTypePrimitive
 }
 
+// IsNull returns true if the value is the primitive null.
+func (v Value) IsNull() bool {
+	return v.Type == ValueTypePrimitive && isNull(v.RawMessage)
+}
+
 // DeleteValue is a predeclared delete action value
 var DeleteValue = Value{
 	RawMessage: json.RawMessage(`{"action":"delete"}`),
@@ -300,26 +390,58 @@ func (v Value) Equal(w Value) bool {
 	return true
 }
 
-// CreateRequest creates a JSON encoded RES-service request
-func CreateRequest(params interface{}, r Requester, query string, token interface{}) []byte {
-	out, _ := json.Marshal(Request{Params: params, Token: token, Query: query, CID: r.CID()})
+// CreateRequest creates a JSON encoded RES-service request.
+// A deadline greater than zero is included as the number of milliseconds the
+// requester will wait for a response.
+func CreateRequest(params interface{}, r Requester, query string, token interface{}, deadline time.Duration) []byte {
+	out, _ := json.Marshal(Request{Params: params, Token: token, Query: query, CID: r.CID(), Header: r.ForwardedHeader(), Deadline: deadlineMillis(deadline), Locale: r.Locale()})
+	return out
+}
+
+// CreateCallRequest creates a JSON encoded RES-service call request. If the
+// requester is a PreconditionRequester, its preconditions are included.
+func CreateCallRequest(params interface{}, r Requester, query string, token interface{}, deadline time.Duration) []byte {
+	req := Request{Params: params, Token: token, Query: query, CID: r.CID(), Header: r.ForwardedHeader(), Deadline: deadlineMillis(deadline), Locale: r.Locale()}
+	if pr, ok := r.(PreconditionRequester); ok {
+		req.IfMatch, req.IfNoneMatch = pr.Precondition()
+	}
+	out, _ := json.Marshal(req)
 	return out
 }
 
-// CreateGetRequest creates a JSON encoded RES-service get request
-func CreateGetRequest(query string) []byte {
-	if query == "" {
+// CreateGetRequest creates a JSON encoded RES-service get request.
+// A deadline greater than zero is included as the number of milliseconds the
+// requester will wait for a response.
+func CreateGetRequest(query string, deadline time.Duration) []byte {
+	if query == "" && deadline <= 0 {
 		return noQueryGetRequest
 	}
-	out, _ := json.Marshal(GetRequest{Query: query})
+	out, _ := json.Marshal(GetRequest{Query: query, Deadline: deadlineMillis(deadline)})
+	return out
+}
+
+// deadlineMillis returns the deadline in whole milliseconds, rounded up to
+// not be zero, or zero if there is no deadline.
+func deadlineMillis(deadline time.Duration) int64 {
+	if deadline <= 0 {
+		return 0
+	}
+	return int64((deadline + time.Millisecond - 1) / time.Millisecond)
+}
+
+// CreateAccessBatchRequest creates a JSON encoded access batch request.
+func CreateAccessBatchRequest(r Requester, rids []string, token interface{}) []byte {
+	out, _ := json.Marshal(AccessBatchRequest{Version: AccessBatchVersion, RIDs: rids, Token: token, CID: r.CID(), Header: r.ForwardedHeader(), Locale: r.Locale()})
 	return out
 }
 
-// CreateAuthRequest creates a JSON encoded RES-service auth request
-func CreateAuthRequest(params interface{}, r AuthRequester, query string, token interface{}) []byte {
+// CreateAuthRequest creates a JSON encoded RES-service auth request.
+// A deadline greater than zero is included as the number of milliseconds the
+// requester will wait for a response.
+func CreateAuthRequest(params interface{}, r AuthRequester, query string, token interface{}, deadline time.Duration) []byte {
 	hr := r.HTTPRequest()
 	out, _ := json.Marshal(AuthRequest{
-		Request:    Request{Params: params, Token: token, Query: query, CID: r.CID()},
+		Request:    Request{Params: params, Token: token, Query: query, CID: r.CID(), Deadline: deadlineMillis(deadline), Locale: r.Locale()},
 		Header:     hr.Header,
 		Host:       hr.Host,
 		RemoteAddr: hr.RemoteAddr,
@@ -328,46 +450,163 @@ func CreateAuthRequest(params interface{}, r AuthRequester, query string, token
 	return out
 }
 
-// DecodeGetResponse decodes a JSON encoded RES-service get response
+// DecodeResource decodes a JSON encoded model object or collection array, as
+// it would be set in the result of a get response.
+// A malformed resource results in an *InvalidResponseError.
+func DecodeResource(data json.RawMessage) (*GetResult, error) {
+	if !json.Valid(data) {
+		return nil, invalidResponse("malformed JSON")
+	}
+	switch firstByte(data) {
+	case '{':
+		return DecodeGetResponse([]byte(`{"result":{"model":` + string(data) + `}}`))
+	case '[':
+		return DecodeGetResponse([]byte(`{"result":{"collection":` + string(data) + `}}`))
+	}
+	return nil, invalidResponse("resource is not an object or an array")
+}
+
+// DecodeModelKeys returns the keys of the model in a JSON encoded RES-service
+// get response, in the order they appear. Nil is returned if the response has
+// no model, or is malformed.
+func DecodeModelKeys(payload []byte) []string {
+	var r struct {
+		Result struct {
+			Model json.RawMessage `json:"model"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(payload, &r) != nil || firstByte(r.Result.Model) != '{' {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(r.Result.Model))
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+	keys := []string{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		k, ok := t.(string)
+		if !ok {
+			return nil
+		}
+		var v json.RawMessage
+		if dec.Decode(&v) != nil {
+			return nil
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// DecodeGetResponse decodes a JSON encoded RES-service get response.
+// A malformed response results in an *InvalidResponseError.
 func DecodeGetResponse(payload []byte) (*GetResult, error) {
-	var r GetResponse
+	var r struct {
+		Result json.RawMessage `json:"result"`
+		Error  *reserr.Error   `json:"error"`
+	}
 	err := json.Unmarshal(payload, &r)
 	if err != nil {
-		return nil, reserr.InternalError(err)
+		return nil, invalidResponse("malformed JSON: %s", err)
 	}
 
 	if r.Error != nil {
 		return nil, r.Error
 	}
 
-	if r.Result == nil {
+	if isNull(r.Result) {
 		return nil, errMissingResult
 	}
 
+	var res struct {
+		Model      json.RawMessage `json:"model"`
+		Collection json.RawMessage `json:"collection"`
+		Query      json.RawMessage `json:"query"`
+	}
+	if firstByte(r.Result) != '{' || json.Unmarshal(r.Result, &res) != nil {
+		return nil, invalidResponse("result is not an object")
+	}
+
+	result := &GetResult{}
+	if !isNull(res.Query) {
+		if json.Unmarshal(res.Query, &result.Query) != nil {
+			return nil, invalidResponse("query is not a string")
+		}
+	}
+
 	// Assert we got either a model or a collection
-	res := r.Result
-	if res.Model != nil {
-		if res.Collection != nil {
-			return nil, errInvalidResponse
+	hasModel, hasCollection := !isNull(res.Model), !isNull(res.Collection)
+	switch {
+	case hasModel && hasCollection:
+		return nil, invalidResponse("both model and collection are set")
+	case hasModel:
+		var m map[string]json.RawMessage
+		if firstByte(res.Model) != '{' || json.Unmarshal(res.Model, &m) != nil {
+			return nil, invalidResponse("model is not an object")
 		}
-		// Assert model only has proper values
-		for _, v := range res.Model {
-			if !v.IsProper() {
-				return nil, errInvalidResponse
+		result.Model = make(map[string]Value, len(m))
+		for k, raw := range m {
+			v, err := decodeProperValue(raw)
+			if err != nil {
+				return nil, invalidResponse("model property %#v: %s", k, err)
 			}
+			result.Model[k] = v
 		}
-	} else if res.Collection != nil {
-		// Assert collection only has proper values
-		for _, v := range res.Collection {
-			if !v.IsProper() {
-				return nil, errInvalidResponse
+	case hasCollection:
+		var c []json.RawMessage
+		if firstByte(res.Collection) != '[' || json.Unmarshal(res.Collection, &c) != nil {
+			return nil, invalidResponse("collection is not an array")
+		}
+		result.Collection = make([]Value, len(c))
+		for i, raw := range c {
+			v, err := decodeProperValue(raw)
+			if err != nil {
+				return nil, invalidResponse("collection index %d: %s", i, err)
 			}
+			result.Collection[i] = v
 		}
-	} else {
-		return nil, errInvalidResponse
+	default:
+		return nil, invalidResponse("neither model nor collection is set")
 	}
 
-	return r.Result, nil
+	return result, nil
+}
+
+// decodeProperValue decodes a value that may be a primitive, a resource
+// reference, or a data value, and returns an error describing why it is not.
+func decodeProperValue(raw json.RawMessage) (Value, error) {
+	var v Value
+	if err := json.Unmarshal(raw, &v); err != nil {
+		switch firstByte(raw) {
+		case '[':
+			return v, fmt.Errorf("array values must be wrapped in a data value object: %s", raw)
+		case '{':
+			return v, fmt.Errorf("invalid value object: %s", raw)
+		}
+		return v, fmt.Errorf("invalid value: %s", raw)
+	}
+	if !v.IsProper() {
+		return v, fmt.Errorf("delete action not allowed: %s", raw)
+	}
+	return v, nil
+}
+
+// isNull reports whether the raw JSON is missing or null.
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// firstByte returns the first non-whitespace byte of the raw JSON,
+// or 0 if there is none.
+func firstByte(raw json.RawMessage) byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return 0
+	}
+	return raw[0]
 }
 
 // DecodeEvent decodes a JSON encoded RES-service event
@@ -558,7 +797,28 @@ func DecodeAccessResponse(payload []byte) (*AccessResult, *reserr.Error) {
 	return r.Result, nil
 }
 
-// DecodeCallResponse decodes a JSON encoded RES-service call response
+// DecodeAccessBatchResponse decodes a JSON encoded access batch response
+func DecodeAccessBatchResponse(payload []byte) (map[string]*AccessResult, *reserr.Error) {
+	var r AccessBatchResponse
+	err := json.Unmarshal(payload, &r)
+	if err != nil {
+		return nil, reserr.RESError(err)
+	}
+
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	if r.Result == nil {
+		return nil, errMissingResult
+	}
+
+	return r.Result.Access, nil
+}
+
+// DecodeCallResponse decodes a JSON encoded RES-service call response. If the
+// response contains both a result and a resource, the resource ID is returned
+// together with ErrResultAndResource.
 func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 	var r Response
 	err := json.Unmarshal(payload, &r)
@@ -575,6 +835,9 @@ func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 		if !IsValidRID(rid, true) {
 			return nil, "", errInvalidResponse
 		}
+		if !isNull(r.Result) {
+			return nil, rid, ErrResultAndResource
+		}
 		return nil, rid, nil
 	}
 
@@ -585,6 +848,94 @@ func DecodeCallResponse(payload []byte) (json.RawMessage, string, error) {
 	return r.Result, "", nil
 }
 
+// UnexpectedAccessFields returns the fields of a JSON encoded RES-service
+// access response that are not defined by the RES-service protocol.
+func UnexpectedAccessFields(payload []byte) []string {
+	return unexpectedFields(payload, accessResultFields)
+}
+
+// UnexpectedGetFields returns the fields of a JSON encoded RES-service get
+// response that are not defined by the RES-service protocol.
+func UnexpectedGetFields(payload []byte) []string {
+	return unexpectedFields(payload, getResultFields)
+}
+
+// ValidateGetResponse strictly validates a JSON encoded RES-service get
+// response against the RES-service protocol, in addition to the validation
+// made by DecodeGetResponse. Fields not defined by the protocol, a response
+// with both result and error, and null model, collection, or query fields,
+// results in an *InvalidResponseError.
+func ValidateGetResponse(payload []byte) error {
+	var r map[string]json.RawMessage
+	if json.Unmarshal(payload, &r) != nil {
+		return nil
+	}
+	if fields := unexpectedFields(payload, getResultFields); len(fields) > 0 {
+		return invalidResponse("unexpected fields: %s", strings.Join(fields, ", "))
+	}
+	resRaw, hasResult := r["result"]
+	if _, hasError := r["error"]; hasError && hasResult {
+		return invalidResponse("both result and error are set")
+	}
+	var res map[string]json.RawMessage
+	if !hasResult || json.Unmarshal(resRaw, &res) != nil {
+		return nil
+	}
+	for _, f := range getResultFields {
+		if raw, ok := res[f]; ok && isNull(raw) {
+			return invalidResponse("%s is null", f)
+		}
+	}
+	return nil
+}
+
+// UnexpectedCallFields returns the fields of a JSON encoded RES-service call
+// or auth response that are not defined by the RES-service protocol. The
+// result may be any value, and its fields are not checked.
+func UnexpectedCallFields(payload []byte) []string {
+	return unexpectedFields(payload, nil)
+}
+
+// unexpectedFields returns the fields of a JSON encoded response, and of its
+// error and result objects, that are not found in the known field lists.
+// Fields of nested objects are prefixed with the parent field name, and the
+// list is sorted. If resultFields is nil, the result fields are not checked.
+// A malformed response returns nil, as it is reported when decoded.
+func unexpectedFields(payload []byte, resultFields []string) []string {
+	var r map[string]json.RawMessage
+	if json.Unmarshal(payload, &r) != nil {
+		return nil
+	}
+	fields := appendUnexpected(nil, "", r, responseFields)
+	if raw, ok := r["error"]; ok {
+		var e map[string]json.RawMessage
+		if json.Unmarshal(raw, &e) == nil {
+			fields = appendUnexpected(fields, "error.", e, errorFields)
+		}
+	}
+	if raw, ok := r["result"]; ok && resultFields != nil {
+		var res map[string]json.RawMessage
+		if json.Unmarshal(raw, &res) == nil {
+			fields = appendUnexpected(fields, "result.", res, resultFields)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func appendUnexpected(fields []string, prefix string, m map[string]json.RawMessage, known []string) []string {
+next:
+	for k := range m {
+		for _, f := range known {
+			if k == f {
+				continue next
+			}
+		}
+		fields = append(fields, prefix+k)
+	}
+	return fields
+}
+
 // TryDecodeLegacyNewResult tries to detect legacy v1.1.1 behavior.
 // Returns empty string and nil error when the result is not detected as legacy.
 // [DEPRECATED:deprecatedNewCallRequest]
@@ -661,6 +1012,38 @@ func IsValidRID(rid string, allowQuery bool) bool {
 	return !start
 }
 
+// ExceedsJSONDepth returns true if the nesting depth of objects and arrays in
+// the JSON encoded data exceeds max. Scanning stops as soon as the depth is
+// exceeded, and the data is not otherwise validated.
+func ExceedsJSONDepth(data []byte, max int) bool {
+	depth := 0
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
+
 // IsValidRIDPart returns true if the RID part is valid, otherwise false.
 func IsValidRIDPart(part string) bool {
 	for _, r := range part {
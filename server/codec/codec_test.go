@@ -0,0 +1,260 @@
+package codec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func TestDecodeGetResponse_WithMalformedResponse_ReturnsReason(t *testing.T) {
+	tbl := []struct {
+		Payload string
+		Reason  string
+	}{
+		{`{"invalid":JSON}`, "malformed JSON"},
+		{`{"result":"foo"}`, "result is not an object"},
+		{`{"result":{"model":{},"collection":[]}}`, "both model and collection are set"},
+		{`{"result":{}}`, "neither model nor collection is set"},
+		{`{"result":{"model":null,"collection":null}}`, "neither model nor collection is set"},
+		{`{"result":{"foo":"bar"}}`, "neither model nor collection is set"},
+		{`{"result":{"model":["foo"]}}`, "model is not an object"},
+		{`{"result":{"collection":{"foo":"bar"}}}`, "collection is not an array"},
+		{`{"result":{"model":{},"query":12}}`, "query is not a string"},
+		{`{"result":{"model":{"foo":[1,2]}}}`, `model property "foo": array values must be wrapped in a data value object`},
+		{`{"result":{"model":{"foo":{"action":"delete"}}}}`, `model property "foo": delete action not allowed`},
+		{`{"result":{"model":{"foo":{"rid":false}}}}`, `model property "foo": invalid value object`},
+		{`{"result":{"collection":["foo",{"unknown":true}]}}`, `collection index 1: invalid value object`},
+		{`{"result":{"collection":["foo",{"action":"delete"}]}}`, `collection index 1: delete action not allowed`},
+	}
+
+	for i, l := range tbl {
+		_, err := DecodeGetResponse([]byte(l.Payload))
+		ierr, ok := err.(*InvalidResponseError)
+		if !ok {
+			t.Errorf("#%d: expected *InvalidResponseError, but got %#v", i+1, err)
+			continue
+		}
+		if !strings.HasPrefix(ierr.Reason, l.Reason) {
+			t.Errorf("#%d: expected reason to start with %#v, but got %#v", i+1, l.Reason, ierr.Reason)
+		}
+		if rerr := reserr.RESError(err); rerr.Code != reserr.CodeInternalError || rerr.Message != "Internal error: invalid service response" {
+			t.Errorf("#%d: expected safe internal error, but got %#v", i+1, rerr)
+		}
+	}
+}
+
+func TestDecodeGetResponse_WithValidResponse_ReturnsResult(t *testing.T) {
+	r, err := DecodeGetResponse([]byte(`{"result":{"model":{"foo":"bar","ref":{"rid":"test.model"},"data":{"data":[1]}},"query":"q=1"}}`))
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if len(r.Model) != 3 || r.Model["foo"].Type != ValueTypePrimitive || r.Model["ref"].Type != ValueTypeReference || r.Model["data"].Type != ValueTypeData {
+		t.Fatalf("unexpected model: %#v", r.Model)
+	}
+	if r.Collection != nil || r.Query != "q=1" {
+		t.Fatalf("unexpected result: %#v", r)
+	}
+
+	r, err = DecodeGetResponse([]byte(`{"result":{"collection":[]}}`))
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if r.Collection == nil || len(r.Collection) != 0 || r.Model != nil {
+		t.Fatalf("unexpected result: %#v", r)
+	}
+}
+
+func TestDecodeGetResponse_WithErrorResponse_ReturnsError(t *testing.T) {
+	_, err := DecodeGetResponse([]byte(`{"error":{"code":"system.notFound","message":"Not found"}}`))
+	if !reserr.IsError(err, reserr.CodeNotFound) {
+		t.Fatalf("expected system.notFound error, but got %#v", err)
+	}
+	_, err = DecodeGetResponse([]byte(`{}`))
+	if err != errMissingResult {
+		t.Fatalf("expected missing result error, but got %#v", err)
+	}
+}
+
+func TestDecodeResource(t *testing.T) {
+	r, err := DecodeResource([]byte(`{"foo":"bar","ref":{"rid":"test.model"}}`))
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if len(r.Model) != 2 || r.Model["ref"].Type != ValueTypeReference || r.Collection != nil {
+		t.Fatalf("unexpected result: %#v", r)
+	}
+
+	r, err = DecodeResource([]byte(` ["foo",42]`))
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if len(r.Collection) != 2 || r.Model != nil {
+		t.Fatalf("unexpected result: %#v", r)
+	}
+
+	for i, data := range []string{``, `"foo"`, `null`, `{"foo":[1]}`, `[{"action":"delete"}]`, `{}},"collection":[`} {
+		if _, err := DecodeResource([]byte(data)); err == nil {
+			t.Errorf("#%d: expected an error, but got none", i+1)
+		}
+	}
+}
+
+func TestCreateGetRequest_WithDeadline_IncludesDeadline(t *testing.T) {
+	tbl := []struct {
+		Query    string
+		Deadline time.Duration
+		Expected string
+	}{
+		{"", 0, `{}`},
+		{"q=foo", 0, `{"query":"q=foo"}`},
+		{"", 3 * time.Second, `{"deadline":3000}`},
+		{"q=foo", 1500 * time.Microsecond, `{"query":"q=foo","deadline":2}`},
+	}
+
+	for i, l := range tbl {
+		got := string(CreateGetRequest(l.Query, l.Deadline))
+		if got != l.Expected {
+			t.Errorf("test #%d: expected payload %s, but got %s", i+1, l.Expected, got)
+		}
+	}
+}
+
+func TestValueIsNull(t *testing.T) {
+	tbl := []struct {
+		Value    string
+		Expected bool
+	}{
+		{`null`, true},
+		{` null `, true},
+		{`{"data":null}`, true},
+		{`"null"`, false},
+		{`0`, false},
+		{`false`, false},
+		{`{"rid":"test.model"}`, false},
+		{`{"action":"delete"}`, false},
+		{`{"data":{"foo":null}}`, false},
+	}
+
+	for i, l := range tbl {
+		var v Value
+		if err := v.UnmarshalJSON([]byte(l.Value)); err != nil {
+			t.Fatalf("#%d: expected no error, but got %s", i+1, err)
+		}
+		if v.IsNull() != l.Expected {
+			t.Errorf("#%d: expected IsNull for %s to be %v, but got %v", i+1, l.Value, l.Expected, !l.Expected)
+		}
+	}
+}
+
+func TestUnexpectedFields(t *testing.T) {
+	tbl := []struct {
+		Decode   func([]byte) []string
+		Payload  string
+		Expected []string
+	}{
+		{UnexpectedAccessFields, `{"result":{"get":true,"call":"*"}}`, nil},
+		{UnexpectedAccessFields, `{"result":{"get":true,"cal":"*"}}`, []string{"result.cal"}},
+		{UnexpectedAccessFields, `{"result":{"get":true},"meta":{}}`, []string{"meta"}},
+		{UnexpectedAccessFields, `{"error":{"code":"system.accessDenied","message":"Access denied","details":"foo"}}`, []string{"error.details"}},
+		{UnexpectedGetFields, `{"result":{"model":{"foo":"bar"},"query":"q=foo"}}`, nil},
+		{UnexpectedGetFields, `{"result":{"models":{"foo":"bar"},"query":"q=foo"},"errors":null}`, []string{"errors", "result.models"}},
+		{UnexpectedCallFields, `{"result":{"foo":"bar"}}`, nil},
+		{UnexpectedCallFields, `{"resource":{"rid":"test.model"}}`, nil},
+		{UnexpectedCallFields, `{"result":null,"resrouce":{"rid":"test.model"}}`, []string{"resrouce"}},
+		{UnexpectedCallFields, `malformed`, nil},
+	}
+
+	for i, l := range tbl {
+		got := l.Decode([]byte(l.Payload))
+		if !reflect.DeepEqual(got, l.Expected) {
+			t.Errorf("#%d: expected unexpected fields for %s to be %#v, but got %#v", i+1, l.Payload, l.Expected, got)
+		}
+	}
+}
+
+func TestValidateGetResponse(t *testing.T) {
+	tbl := []struct {
+		Payload string
+		Reason  string
+	}{
+		{`{"result":{"model":{"foo":"bar"},"query":"q=foo"}}`, ""},
+		{`{"result":{"collection":["foo"]}}`, ""},
+		{`{"error":{"code":"system.notFound","message":"Not found"}}`, ""},
+		{`malformed`, ""},
+		{`{"result":{"collection":["foo"],"models":{"foo":"bar"}}}`, "unexpected fields: result.models"},
+		{`{"result":{"model":{"foo":"bar"}},"meta":{}}`, "unexpected fields: meta"},
+		{`{"result":{"model":{"foo":"bar"}},"error":{"code":"system.notFound","message":"Not found"}}`, "both result and error are set"},
+		{`{"result":{"collection":["foo"],"model":null}}`, "model is null"},
+		{`{"result":{"model":{"foo":"bar"},"collection":null}}`, "collection is null"},
+		{`{"result":{"model":{"foo":"bar"},"query":null}}`, "query is null"},
+	}
+
+	for i, l := range tbl {
+		err := ValidateGetResponse([]byte(l.Payload))
+		if l.Reason == "" {
+			if err != nil {
+				t.Errorf("#%d: expected no error, but got %#v", i+1, err)
+			}
+			continue
+		}
+		ierr, ok := err.(*InvalidResponseError)
+		if !ok {
+			t.Errorf("#%d: expected *InvalidResponseError, but got %#v", i+1, err)
+			continue
+		}
+		if ierr.Reason != l.Reason {
+			t.Errorf("#%d: expected reason %#v, but got %#v", i+1, l.Reason, ierr.Reason)
+		}
+	}
+}
+
+func TestDecodeCallResponse(t *testing.T) {
+	tbl := []struct {
+		Payload string
+		Result  string
+		RID     string
+		Err     error
+	}{
+		{`{"result":{"foo":"bar"}}`, `{"foo":"bar"}`, "", nil},
+		{`{"resource":{"rid":"test.model"}}`, "", "test.model", nil},
+		{`{"result":null,"resource":{"rid":"test.model"}}`, "", "test.model", nil},
+		{`{"result":{"foo":"bar"},"resource":{"rid":"test.model"}}`, "", "test.model", ErrResultAndResource},
+	}
+
+	for i, l := range tbl {
+		result, rid, err := DecodeCallResponse([]byte(l.Payload))
+		if err != l.Err {
+			t.Errorf("#%d: expected error %#v, but got %#v", i+1, l.Err, err)
+		}
+		if string(result) != l.Result {
+			t.Errorf("#%d: expected result %#v, but got %#v", i+1, l.Result, string(result))
+		}
+		if rid != l.RID {
+			t.Errorf("#%d: expected rid %#v, but got %#v", i+1, l.RID, rid)
+		}
+	}
+}
+
+func TestDecodeModelKeys(t *testing.T) {
+	tbl := []struct {
+		Payload  string
+		Expected []string
+	}{
+		{`{"result":{"model":{"zeta":1,"alpha":{"rid":"test.model"},"mid":[1,2]}}}`, []string{"zeta", "alpha", "mid"}},
+		{`{"result":{"model":{}}}`, []string{}},
+		{`{"result":{"model":{"foo":1,"foo":2}}}`, []string{"foo", "foo"}},
+		{`{"result":{"collection":[1,2,3]}}`, nil},
+		{`{"error":{"code":"system.notFound","message":"Not found"}}`, nil},
+		{`malformed`, nil},
+	}
+
+	for i, l := range tbl {
+		got := DecodeModelKeys([]byte(l.Payload))
+		if !reflect.DeepEqual(got, l.Expected) {
+			t.Errorf("#%d: expected model keys for %s to be %#v, but got %#v", i+1, l.Payload, l.Expected, got)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// MsgpackContentType is the media type of msgpack encoded data.
+const MsgpackContentType = "application/msgpack"
+
+var (
+	errMsgpackTruncated   = errors.New("msgpack: unexpected end of data")
+	errMsgpackTrailing    = errors.New("msgpack: unexpected data after value")
+	errMsgpackUnsupported = errors.New("msgpack: binary and extension types are not supported")
+	errMsgpackMapKey      = errors.New("msgpack: map keys must be strings")
+)
+
+// JSONToMsgpack converts JSON encoded data to msgpack. Object keys are
+// encoded in sorted order, and numbers are encoded as integers if they fit
+// in a 64 bit integer, otherwise as 64 bit floats.
+func JSONToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("invalid json: unexpected data after value")
+	}
+	var b bytes.Buffer
+	if err := encodeMsgpack(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MsgpackToJSON converts msgpack encoded data to JSON. Map keys must be
+// strings, and binary and extension types are not supported.
+func MsgpackToJSON(data []byte) ([]byte, error) {
+	d := msgpackDecoder{b: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+	if d.i != len(d.b) {
+		return nil, errMsgpackTrailing
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Remove the newline added by the encoder
+	return bytes.TrimRight(b.Bytes(), "\n"), nil
+}
+
+func encodeMsgpack(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			encodeMsgpackInt(b, i)
+		} else if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			b.WriteByte(0xcf)
+			writeUint(b, u, 8)
+		} else {
+			f, err := v.Float64()
+			if err != nil {
+				return err
+			}
+			b.WriteByte(0xcb)
+			writeUint(b, math.Float64bits(f), 8)
+		}
+	case string:
+		encodeMsgpackString(b, v)
+	case []interface{}:
+		n := len(v)
+		switch {
+		case n < 16:
+			b.WriteByte(0x90 | byte(n))
+		case n <= math.MaxUint16:
+			b.WriteByte(0xdc)
+			writeUint(b, uint64(n), 2)
+		default:
+			b.WriteByte(0xdd)
+			writeUint(b, uint64(n), 4)
+		}
+		for _, item := range v {
+			if err := encodeMsgpack(b, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		n := len(v)
+		switch {
+		case n < 16:
+			b.WriteByte(0x80 | byte(n))
+		case n <= math.MaxUint16:
+			b.WriteByte(0xde)
+			writeUint(b, uint64(n), 2)
+		default:
+			b.WriteByte(0xdf)
+			writeUint(b, uint64(n), 4)
+		}
+		keys := make([]string, 0, n)
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeMsgpackString(b, k)
+			if err := encodeMsgpack(b, v[k]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encodeMsgpackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		b.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		b.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		b.WriteByte(0xcc)
+		b.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		b.WriteByte(0xcd)
+		writeUint(b, uint64(i), 2)
+	case i >= 0 && i <= math.MaxUint32:
+		b.WriteByte(0xce)
+		writeUint(b, uint64(i), 4)
+	case i >= 0:
+		b.WriteByte(0xcf)
+		writeUint(b, uint64(i), 8)
+	case i >= math.MinInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		b.WriteByte(0xd1)
+		writeUint(b, uint64(uint16(int16(i))), 2)
+	case i >= math.MinInt32:
+		b.WriteByte(0xd2)
+		writeUint(b, uint64(uint32(int32(i))), 4)
+	default:
+		b.WriteByte(0xd3)
+		writeUint(b, uint64(i), 8)
+	}
+}
+
+func encodeMsgpackString(b *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		b.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		b.WriteByte(0xd9)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(0xda)
+		writeUint(b, uint64(n), 2)
+	default:
+		b.WriteByte(0xdb)
+		writeUint(b, uint64(n), 4)
+	}
+	b.WriteString(s)
+}
+
+// writeUint writes the size least significant bytes of u in big endian order.
+func writeUint(b *bytes.Buffer, u uint64, size int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	b.Write(buf[8-size:])
+}
+
+type msgpackDecoder struct {
+	b []byte
+	i int
+}
+
+// next returns the next n bytes.
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.i < n {
+		return nil, errMsgpackTruncated
+	}
+	p := d.b[d.i : d.i+n]
+	d.i += n
+	return p, nil
+}
+
+// uint reads an unsigned big endian integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	p, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range p {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// length reads a length of size bytes. The length is validated against the
+// remaining data, assuming each element takes at least one byte.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	u, err := d.uint(size)
+	if err != nil {
+		return 0, err
+	}
+	if u > uint64(len(d.b)-d.i) {
+		return 0, errMsgpackTruncated
+	}
+	return int(u), nil
+}
+
+func (d *msgpackDecoder) value() (interface{}, error) {
+	p, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := p[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayValue(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.stringValue(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0:
+		u, err := d.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.uint(8)
+		return int64(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringValue(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(n)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(n)
+	}
+	return nil, errMsgpackUnsupported
+}
+
+func (d *msgpackDecoder) stringValue(n int) (interface{}, error) {
+	p, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(p), nil
+}
+
+func (d *msgpackDecoder) arrayValue(n int) (interface{}, error) {
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapValue(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errMsgpackMapKey
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
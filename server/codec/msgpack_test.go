@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestJSONToMsgpack_EncodesValues(t *testing.T) {
+	tbl := []struct {
+		JSON     string
+		Expected []byte
+	}{
+		{`null`, []byte{0xc0}},
+		{`true`, []byte{0xc3}},
+		{`false`, []byte{0xc2}},
+		{`0`, []byte{0x00}},
+		{`127`, []byte{0x7f}},
+		{`128`, []byte{0xcc, 0x80}},
+		{`256`, []byte{0xcd, 0x01, 0x00}},
+		{`65536`, []byte{0xce, 0x00, 0x01, 0x00, 0x00}},
+		{`4294967296`, []byte{0xcf, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{`18446744073709551615`, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{`-1`, []byte{0xff}},
+		{`-32`, []byte{0xe0}},
+		{`-33`, []byte{0xd0, 0xdf}},
+		{`-129`, []byte{0xd1, 0xff, 0x7f}},
+		{`-32769`, []byte{0xd2, 0xff, 0xff, 0x7f, 0xff}},
+		{`-2147483649`, []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xff, 0xff}},
+		{`1.5`, []byte{0xcb, 0x3f, 0xf8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{`""`, []byte{0xa0}},
+		{`"foo"`, []byte{0xa3, 'f', 'o', 'o'}},
+		{`[]`, []byte{0x90}},
+		{`[1,"a"]`, []byte{0x92, 0x01, 0xa1, 'a'}},
+		{`{}`, []byte{0x80}},
+		{`{"b":1,"a":null}`, []byte{0x82, 0xa1, 'a', 0xc0, 0xa1, 'b', 0x01}},
+	}
+
+	for i, l := range tbl {
+		out, err := JSONToMsgpack([]byte(l.JSON))
+		if err != nil {
+			t.Errorf("#%d: expected no error, but got %s", i+1, err)
+			continue
+		}
+		if !bytes.Equal(out, l.Expected) {
+			t.Errorf("#%d: expected % x, but got % x", i+1, l.Expected, out)
+		}
+	}
+}
+
+func TestJSONToMsgpack_EncodesLongValues(t *testing.T) {
+	tbl := []struct {
+		JSON   string
+		Header []byte
+	}{
+		{`"` + strings.Repeat("a", 32) + `"`, []byte{0xd9, 32}},
+		{`"` + strings.Repeat("a", 256) + `"`, []byte{0xda, 0x01, 0x00}},
+		{`"` + strings.Repeat("a", 65536) + `"`, []byte{0xdb, 0x00, 0x01, 0x00, 0x00}},
+		{`[` + strings.Repeat("0,", 15) + `0]`, []byte{0xdc, 0x00, 16}},
+	}
+
+	for i, l := range tbl {
+		out, err := JSONToMsgpack([]byte(l.JSON))
+		if err != nil {
+			t.Errorf("#%d: expected no error, but got %s", i+1, err)
+			continue
+		}
+		if !bytes.HasPrefix(out, l.Header) {
+			t.Errorf("#%d: expected header % x, but got % x", i+1, l.Header, out[:len(l.Header)])
+		}
+	}
+}
+
+func TestMsgpack_RoundTrip(t *testing.T) {
+	tbl := []string{
+		`null`,
+		`{"models":{"test.model":{"bool":true,"int":42,"null":null,"string":"foo"}}}`,
+		`{"collections":{"test.collection":["foo",{"rid":"test.model"},42,-12,1.25,true,null]}}`,
+		`{"id":1,"result":{"payload":{"nested":[[],{},{"a":[1,2,[3]]}]}}}`,
+		`{"error":{"code":"system.notFound","message":"Not found <>"}}`,
+		`"` + strings.Repeat("x", 300) + `"`,
+		`-9223372036854775808`,
+		`18446744073709551615`,
+	}
+
+	for i, l := range tbl {
+		mp, err := JSONToMsgpack([]byte(l))
+		if err != nil {
+			t.Errorf("#%d: expected no error encoding, but got %s", i+1, err)
+			continue
+		}
+		out, err := MsgpackToJSON(mp)
+		if err != nil {
+			t.Errorf("#%d: expected no error decoding, but got %s", i+1, err)
+			continue
+		}
+		if string(out) != l {
+			t.Errorf("#%d: expected:\n%s\nbut got:\n%s", i+1, l, out)
+		}
+	}
+}
+
+func TestMsgpackToJSON_DecodesFloat32(t *testing.T) {
+	out, err := MsgpackToJSON([]byte{0xca, 0x3f, 0xc0, 0x00, 0x00})
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if string(out) != "1.5" {
+		t.Errorf("expected 1.5, but got %s", out)
+	}
+}
+
+func TestMsgpackToJSON_WithInvalidData_ReturnsError(t *testing.T) {
+	tbl := [][]byte{
+		{},
+		{0xa3, 'f', 'o'},
+		{0x92, 0x01},
+		{0x81, 0x01, 0x02},
+		{0xc4, 0x01, 0x00},
+		{0xd4, 0x01, 0x00},
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0xc0, 0xc0},
+		{0xc1},
+	}
+
+	for i, l := range tbl {
+		if _, err := MsgpackToJSON(l); err == nil {
+			t.Errorf("#%d: expected an error, but got none", i+1)
+		}
+	}
+}
+
+func TestJSONToMsgpack_WithInvalidJSON_ReturnsError(t *testing.T) {
+	tbl := []string{``, `{`, `{"foo":}`, `1 2`}
+
+	for i, l := range tbl {
+		if _, err := JSONToMsgpack([]byte(l)); err == nil {
+			t.Errorf("#%d: expected an error, but got none", i+1)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// filterQueryParam is the query parameter used for gateway-side collection filters.
+const filterQueryParam = "filter"
+
+// refilterEvent is the name of an internal event on a filtered collection,
+// queued when a referenced model has changed. It contains a dot, so it never
+// collides with an event name of a service.
+const refilterEvent = "filter.refilter"
+
+var errInvalidFilter = &reserr.Error{Code: reserr.CodeInvalidQuery, Message: "Invalid query: filter must be on the form field:value and only used on collections"}
+
+// collectionFilter is a gateway-side equality filter, applied on the items of
+// a collection. An item matches if it is a reference to a model, or a data
+// value object, with a primitive field equal to the filter value.
+type collectionFilter struct {
+	field   string
+	value   string
+	err     error
+	base    []codec.Value // Unfiltered collection values
+	matches []bool        // Match state of each base value. Nil until applied.
+}
+
+// parseCollectionFilter extracts a filter parameter from the query, and
+// returns the filter and the remaining query. If the query has no filter
+// parameter, the filter returned is nil.
+func parseCollectionFilter(query string) (*collectionFilter, string) {
+	if query == "" {
+		return nil, query
+	}
+
+	var f *collectionFilter
+	parts := strings.Split(query, "&")
+	rest := parts[:0]
+	for _, part := range parts {
+		key := part
+		val := ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			key, val = part[:i], part[i+1:]
+		}
+		if k, err := url.QueryUnescape(key); err != nil || k != filterQueryParam {
+			rest = append(rest, part)
+			continue
+		}
+
+		// Only a single filter is allowed
+		if f != nil {
+			f.err = errInvalidFilter
+			continue
+		}
+		f = &collectionFilter{}
+		v, err := url.QueryUnescape(val)
+		idx := strings.IndexByte(v, ':')
+		if err != nil || idx <= 0 {
+			f.err = errInvalidFilter
+			continue
+		}
+		f.field = v[:idx]
+		f.value = v[idx+1:]
+	}
+
+	if f == nil {
+		return nil, query
+	}
+	return f, strings.Join(rest, "&")
+}
+
+// match reports whether the value matches the filter. Referenced models are
+// matched on their cached state, including any change not yet sent to the
+// client. Referenced resources are expected to be loaded.
+func (f *collectionFilter) match(s *Subscription, v codec.Value) bool {
+	switch v.Type {
+	case codec.ValueTypeReference:
+		sub := s.Ref(v.RID)
+		if sub == nil || sub.Error() != nil || sub.resourceSub == nil || sub.ResourceType() != rescache.TypeModel {
+			return false
+		}
+		fv, ok := sub.cachedModelValue(f.field)
+		return ok && fv.Type == codec.ValueTypePrimitive && f.matchRaw(fv.RawMessage)
+	case codec.ValueTypeData:
+		var m map[string]json.RawMessage
+		if json.Unmarshal(v.Inner, &m) != nil {
+			return false
+		}
+		raw, ok := m[f.field]
+		return ok && f.matchRaw(raw)
+	}
+	return false
+}
+
+// matchRaw reports whether a JSON encoded primitive equals the filter value.
+// Strings are compared by their decoded value, and other primitives by their
+// JSON encoding.
+func (f *collectionFilter) matchRaw(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return false
+	}
+	switch raw[0] {
+	case '"':
+		var str string
+		return json.Unmarshal(raw, &str) == nil && str == f.value
+	case '{', '[':
+		return false
+	}
+	return string(raw) == f.value
+}
+
+// filteredIdx returns the index in the filtered collection corresponding to
+// the index in the base collection.
+func (f *collectionFilter) filteredIdx(idx int) int {
+	fidx := 0
+	for _, m := range f.matches[:idx] {
+		if m {
+			fidx++
+		}
+	}
+	return fidx
+}
+
+// insertValue returns a copy of vals with v inserted at idx.
+func insertValue(vals []codec.Value, idx int, v codec.Value) []codec.Value {
+	nv := make([]codec.Value, len(vals)+1)
+	copy(nv, vals[:idx])
+	nv[idx] = v
+	copy(nv[idx+1:], vals[idx:])
+	return nv
+}
+
+// removeValue returns a copy of vals with the value at idx removed.
+func removeValue(vals []codec.Value, idx int) []codec.Value {
+	nv := make([]codec.Value, len(vals)-1)
+	copy(nv, vals[:idx])
+	copy(nv[idx:], vals[idx+1:])
+	return nv
+}
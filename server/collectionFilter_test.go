@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseCollectionFilter(t *testing.T) {
+	tbl := []struct {
+		Query         string
+		ExpectedField string
+		ExpectedValue string
+		ExpectedQuery string
+		ExpectedNil   bool
+		ExpectedError bool
+	}{
+		{"", "", "", "", true, false},
+		{"q=foo", "", "", "q=foo", true, false},
+		{"filter=role:admin", "role", "admin", "", false, false},
+		{"q=foo&filter=role:admin&f=bar", "role", "admin", "q=foo&f=bar", false, false},
+		{"filter=role%3Aadmin%20user", "role", "admin user", "", false, false},
+		{"filter=url:http://example.com", "url", "http://example.com", "", false, false},
+		{"filter=role:", "role", "", "", false, false},
+		{"filter=role", "", "", "", false, true},
+		{"filter=:admin", "", "", "", false, true},
+		{"filter=a:b&filter=c:d", "", "", "", false, true},
+	}
+
+	for i, r := range tbl {
+		f, q := parseCollectionFilter(r.Query)
+		if r.ExpectedNil {
+			if f != nil {
+				t.Fatalf("expected filter to be nil, but got %+v in test #%d", f, i+1)
+			}
+			compareString(t, "query", q, r.ExpectedQuery, i)
+			continue
+		}
+		if f == nil {
+			t.Fatalf("expected filter not to be nil in test #%d", i+1)
+		}
+		if r.ExpectedError {
+			if f.err == nil {
+				t.Fatalf("expected filter error, but got none in test #%d", i+1)
+			}
+			continue
+		}
+		if f.err != nil {
+			t.Fatalf("expected no filter error, but got %s in test #%d", f.err, i+1)
+		}
+		compareString(t, "field", f.field, r.ExpectedField, i)
+		compareString(t, "value", f.value, r.ExpectedValue, i)
+		compareString(t, "query", q, r.ExpectedQuery, i)
+	}
+}
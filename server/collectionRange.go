@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// itemsRangeUnit is the range unit used for gateway-side collection ranges.
+const itemsRangeUnit = "items"
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// itemsRange is a range of collection items, with inclusive start and end
+// indexes.
+type itemsRange struct {
+	start int
+	end   int
+}
+
+// parseItemsRange parses a Range header value with the items unit, such as
+// "items=0-49", "items=50-", or "items=-10", for a collection of the given
+// length. It returns nil if the value is not a single syntactically valid
+// items range, in which case the header is to be ignored, and
+// errRangeNotSatisfiable if the range starts outside of the collection.
+func parseItemsRange(v string, length int) (*itemsRange, error) {
+	v = strings.TrimSpace(v)
+	if len(v) <= len(itemsRangeUnit) || !strings.EqualFold(v[:len(itemsRangeUnit)], itemsRangeUnit) || v[len(itemsRangeUnit)] != '=' {
+		return nil, nil
+	}
+	spec := strings.TrimSpace(v[len(itemsRangeUnit)+1:])
+	idx := strings.IndexByte(spec, '-')
+	if idx < 0 {
+		return nil, nil
+	}
+	first, last := spec[:idx], spec[idx+1:]
+
+	// Suffix range of the last items
+	if first == "" {
+		n, ok := parseRangeIndex(last)
+		if !ok {
+			return nil, nil
+		}
+		if n == 0 || length == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if n > length {
+			n = length
+		}
+		return &itemsRange{start: length - n, end: length - 1}, nil
+	}
+
+	start, ok := parseRangeIndex(first)
+	if !ok {
+		return nil, nil
+	}
+	end := length - 1
+	if last != "" {
+		if end, ok = parseRangeIndex(last); !ok || end < start {
+			return nil, nil
+		}
+	}
+	if start >= length {
+		return nil, errRangeNotSatisfiable
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return &itemsRange{start: start, end: end}, nil
+}
+
+// parseRangeIndex parses a non-negative decimal index of a range.
+func parseRangeIndex(s string) (int, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// rangeCollection slices the collection of the subscription to any items
+// range of the request's Range header, setting the Accept-Ranges and
+// Content-Range headers, and the partial content status. It returns false if
+// the range is not satisfiable, with the status set accordingly.
+func (c *wsConn) rangeCollection(w http.ResponseWriter, r *http.Request, sub *Subscription) bool {
+	w.Header().Set("Accept-Ranges", itemsRangeUnit)
+	n := len(sub.CollectionValues())
+	rng, err := parseItemsRange(r.Header.Get("Range"), n)
+	if err != nil {
+		w.Header().Set("Content-Range", itemsRangeUnit+" */"+strconv.Itoa(n))
+		c.httpStatus = http.StatusRequestedRangeNotSatisfiable
+		return false
+	}
+	if rng == nil {
+		return true
+	}
+	sub.sliceCollection(rng.start, rng.end)
+	w.Header().Set("Content-Range", fmt.Sprintf("%s %d-%d/%d", itemsRangeUnit, rng.start, rng.end, n))
+	c.httpStatus = http.StatusPartialContent
+	return true
+}
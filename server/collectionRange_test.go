@@ -0,0 +1,56 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseItemsRange(t *testing.T) {
+	tbl := []struct {
+		Header        string
+		Length        int
+		ExpectedStart int
+		ExpectedEnd   int
+		ExpectedNil   bool
+		ExpectedError bool
+	}{
+		{"", 10, 0, 0, true, false},
+		{"bytes=0-4", 10, 0, 0, true, false},
+		{"items=0-4", 10, 0, 4, false, false},
+		{"Items=2-2", 10, 2, 2, false, false},
+		{"items=5-", 10, 5, 9, false, false},
+		{"items=5-20", 10, 5, 9, false, false},
+		{"items=-3", 10, 7, 9, false, false},
+		{"items=-20", 10, 0, 9, false, false},
+		{"items=4-2", 10, 0, 0, true, false},
+		{"items=0-1,4-5", 10, 0, 0, true, false},
+		{"items=a-b", 10, 0, 0, true, false},
+		{"items=+1-2", 10, 0, 0, true, false},
+		{"items=-", 10, 0, 0, true, false},
+		{"items=10-12", 10, 0, 0, false, true},
+		{"items=-0", 10, 0, 0, false, true},
+		{"items=0-", 0, 0, 0, false, true},
+		{"items=-1", 0, 0, 0, false, true},
+	}
+
+	for i, r := range tbl {
+		rng, err := parseItemsRange(r.Header, r.Length)
+		if r.ExpectedError {
+			if err != errRangeNotSatisfiable {
+				t.Fatalf("expected range not satisfiable, but got %+v, %v in test #%d", rng, err, i+1)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected no error, but got %s in test #%d", err, i+1)
+		}
+		if r.ExpectedNil {
+			if rng != nil {
+				t.Fatalf("expected range to be nil, but got %+v in test #%d", rng, i+1)
+			}
+			continue
+		}
+		if rng == nil || rng.start != r.ExpectedStart || rng.end != r.ExpectedEnd {
+			t.Fatalf("expected range %d-%d, but got %+v in test #%d", r.ExpectedStart, r.ExpectedEnd, rng, i+1)
+		}
+	}
+}
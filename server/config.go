@@ -1,44 +1,266 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
 )
 
+// HTTPCompressionOverride holds compression settings overriding the
+// httpCompressionLevel and httpCompressionMinSize settings for resources
+// matching a pattern. A zero value uses the overridden setting.
+type HTTPCompressionOverride struct {
+	Level   int `json:"level"`
+	MinSize int `json:"minSize"`
+}
+
+// WSRedirect is a rule for redirecting WebSocket clients to another gateway.
+type WSRedirect struct {
+	URL         string `json:"url"`
+	Connections int    `json:"connections"`
+	Claim       string `json:"claim"`
+	Value       string `json:"value"`
+}
+
 // Config holds server configuration
 type Config struct {
-	Addr         *string `json:"addr"`
-	Port         uint16  `json:"port"`
-	WSPath       string  `json:"wsPath"`
-	APIPath      string  `json:"apiPath"`
-	APIEncoding  string  `json:"apiEncoding"`
-	HeaderAuth   *string `json:"headerAuth"`
-	AllowOrigin  *string `json:"allowOrigin"`
-	PUTMethod    *string `json:"putMethod"`
-	DELETEMethod *string `json:"deleteMethod"`
-	PATCHMethod  *string `json:"patchMethod"`
+	Addr                 *string  `json:"addr"`
+	Port                 uint16   `json:"port"`
+	ListenAddrs          []string `json:"listenAddrs"`
+	WSPath               string   `json:"wsPath"`
+	APIPath              string   `json:"apiPath"`
+	APIEncoding          string   `json:"apiEncoding"`
+	HeaderAuth           *string  `json:"headerAuth"`
+	TokenHeader          string   `json:"tokenHeader"`
+	TokenCookie          string   `json:"tokenCookie"`
+	AllowOrigin          *string  `json:"allowOrigin"`
+	PUTMethod            *string  `json:"putMethod"`
+	DELETEMethod         *string  `json:"deleteMethod"`
+	PATCHMethod          *string  `json:"patchMethod"`
+	ForwardPreconditions bool     `json:"forwardPreconditions"`
+	POSTMethod           *string  `json:"postMethod"`
+	POSTMethodResources  []string `json:"postMethodResources"`
+	AllowMethods         []string `json:"allowMethods"`
+	AllowHeaders         []string `json:"allowHeaders"`
+	OptionsAllow         bool     `json:"optionsAllow"`
+
+	StripTrailingSlash bool   `json:"stripTrailingSlash"`
+	AccessBeforeBody   bool   `json:"accessBeforeBody"`
+	TrailingBodyData   string `json:"trailingBodyData"`
+
+	AbsoluteURLs   bool     `json:"absoluteURLs"`
+	TrustedProxies []string `json:"trustedProxies"`
+
+	HTTPEnvelope      []string `json:"httpEnvelope"`
+	LastModified      bool     `json:"lastModified"`
+	CollectionRange   bool     `json:"collectionRange"`
+	EmptyResourceNull bool     `json:"emptyResourceNull"`
+
+	MetadataSuffix  string                     `json:"metadataSuffix"`
+	ResourceSchemas map[string]json.RawMessage `json:"resourceSchemas"`
+
+	HTTPCompression          bool                               `json:"httpCompression"`
+	HTTPCompressionLevel     int                                `json:"httpCompressionLevel"`
+	HTTPCompressionMinSize   int                                `json:"httpCompressionMinSize"`
+	HTTPCompressionOverrides map[string]HTTPCompressionOverride `json:"httpCompressionOverrides"`
+
+	NATSConnectRetries    int    `json:"natsConnectRetries"`
+	NATSConnectRetryDelay int    `json:"natsConnectRetryDelay"`
+	NATSHeaders           bool   `json:"natsHeaders"`
+	NATSReconnect         bool   `json:"natsReconnect"`
+	NATSInboxPrefix       string `json:"natsInboxPrefix"`
 
 	TLS     bool   `json:"tls"`
 	TLSCert string `json:"certFile"`
 	TLSKey  string `json:"keyFile"`
 
-	WSCompression bool `json:"wsCompression"`
+	ListenBacklog int  `json:"listenBacklog"`
+	ReusePort     bool `json:"reusePort"`
+	TCPKeepAlive  int  `json:"tcpKeepAlive"`
+
+	MaxConnections        int          `json:"maxConnections"`
+	MaxConnectionLifetime int          `json:"maxConnectionLifetime"`
+	WSRedirects           []WSRedirect `json:"wsRedirects"`
+	WSCompression         bool         `json:"wsCompression"`
+	WSMaxMessageSize      int          `json:"wsMaxMessageSize"`
+	WSSendQueueHighWater  int          `json:"wsSendQueueHighWater"`
+	ReconnectDelay        int          `json:"reconnectDelay"`
+	ReconnectJitter       int          `json:"reconnectJitter"`
+	ResyncManifest        bool         `json:"resyncManifest"`
+	ResumeTTL             int          `json:"resumeTTL"`
+	DisconnectLog         string       `json:"disconnectLog"`
+
+	CollectionDiffWindow int  `json:"collectionDiffWindow"`
+	MaxParamsDepth       int  `json:"maxParamsDepth"`
+	MaxHTTPResources     int  `json:"maxHTTPResources"`
+	ReferenceTimeout     int  `json:"referenceTimeout"`
+	MaxProtocolErrors    int  `json:"maxProtocolErrors"`
+	MaxQueryLength       int  `json:"maxQueryLength"`
+	MaxQueryVariations   int  `json:"maxQueryVariations"`
+	MaxResponseSize      int  `json:"maxResponseSize"`
+	CollectionFilter     bool `json:"collectionFilter"`
+	RequestDeadline      bool `json:"requestDeadline"`
+	ServerTiming         bool `json:"serverTiming"`
+	MaxCallTimeout       int  `json:"maxCallTimeout"`
+	CloseOnAccessRevoked bool `json:"closeOnAccessRevoked"`
+	OmitNullFields       bool `json:"omitNullFields"`
+	StrictResponses      bool `json:"strictResponses"`
+	StrictGetResponses   bool `json:"strictGetResponses"`
+	ConsistentSnapshots  bool `json:"consistentSnapshots"`
+	EventSequence        bool `json:"eventSequence"`
+	SerializeAuth        bool `json:"serializeAuth"`
+
+	MetricsPort      uint16   `json:"metricsPort"`
+	LoadShedLatency  int      `json:"loadShedLatency"`
+	LoadShedWindow   int      `json:"loadShedWindow"`
+	LoadShedFraction float64  `json:"loadShedFraction"`
+	MetricsPatterns  []string `json:"metricsPatterns"`
+	TenantClaim      string   `json:"tenantClaim"`
+	Tenants          []string `json:"tenants"`
+
+	SubscriberThresholds       []int  `json:"subscriberThresholds"`
+	SubscriberThresholdSubject string `json:"subscriberThresholdSubject"`
+
+	ConnectionSummarySubject string `json:"connectionSummarySubject"`
+
+	EventMirrorSubject   string   `json:"eventMirrorSubject"`
+	EventMirrorResources []string `json:"eventMirrorResources"`
+
+	BackpressureRequests int  `json:"backpressureRequests"`
+	BackpressureLoadShed bool `json:"backpressureLoadShed"`
+
+	MaxConcurrentRequests int            `json:"maxConcurrentRequests"`
+	MaxConnRequests       int            `json:"maxConnRequests"`
+	RequestPriority       map[string]int `json:"requestPriority"`
+
+	ForwardHeaders    []string `json:"forwardHeaders"`
+	RedactQueryParams []string `json:"redactQueryParams"`
+
+	Locale          bool     `json:"locale"`
+	LocaleResources []string `json:"localeResources"`
+
+	SharedAccess    map[string]string `json:"sharedAccess"`
+	InjectQuery     map[string]string `json:"injectQuery"`
+	NormalizeQuery  []string          `json:"normalizeQuery"`
+	ResourceAliases map[string]string `json:"resourceAliases"`
+
+	RequestFallbacks map[string]string `json:"requestFallbacks"`
+
+	AccessBatch       map[string]string `json:"accessBatch"`
+	AccessBatchWindow int               `json:"accessBatchWindow"`
+
+	AccessCacheTTL map[string]int `json:"accessCacheTTL"`
+	ChangeDebounce map[string]int `json:"changeDebounce"`
+	MaxCacheAge    map[string]int `json:"maxCacheAge"`
+
+	RetainedResources []string `json:"retainedResources"`
+
+	WarmupResources []string `json:"warmupResources"`
+	WarmupReject    bool     `json:"warmupReject"`
+
+	SubscriptionQuotas      map[string]int `json:"subscriptionQuotas"`
+	SubscriptionIdleTimeout int            `json:"subscriptionIdleTimeout"`
+
+	NotFoundDefault       map[string]json.RawMessage `json:"notFoundDefault"`
+	AccessTimeoutFallback map[string]json.RawMessage `json:"accessTimeoutFallback"`
+
+	OrderingDomains   [][]string `json:"orderingDomains"`
+	PendingGetEvents  string     `json:"pendingGetEvents"`
+	MalformedEvents   string     `json:"malformedEvents"`
+	FieldOrder        string     `json:"fieldOrder"`
+	DualCallResponses string     `json:"dualCallResponses"`
+
+	AckEvents       []string       `json:"ackEvents"`
+	AckRedeliveries int            `json:"ackRedeliveries"`
+	AckBufferSize   map[string]int `json:"ackBufferSize"`
+	AckBufferTTL    map[string]int `json:"ackBufferTTL"`
+
+	MockResources                  map[string]json.RawMessage `json:"mockResources"`
+	DangerouslyEnableMockResources bool                       `json:"dangerouslyEnableMockResources"`
+
+	JWTPublicKeyFile string `json:"jwtPublicKeyFile"`
+	JWKSURL          string `json:"jwksUrl"`
+	JWKSCacheTTL     int    `json:"jwksCacheTTL"`
+	JWTTokenField    string `json:"jwtTokenField"`
+	JWTExpiredClose  bool   `json:"jwtExpiredClose"`
+
+	WebhookPath       string `json:"webhookPath"`
+	WebhookToken      string `json:"webhookToken"`
+	WebhookFile       string `json:"webhookFile"`
+	WebhookRetries    int    `json:"webhookRetries"`
+	WebhookRetryDelay int    `json:"webhookRetryDelay"`
 
 	NoHTTP bool `json:"-"` // Disable start of the HTTP server. Used for testing
 
-	scheme           string
-	netAddr          string
-	headerAuthRID    string
-	headerAuthAction string
-	allowOrigin      []string
-	allowMethods     string
+	scheme                string
+	netAddr               string
+	netAddrs              []string
+	headerAuthRID         string
+	headerAuthAction      string
+	allowOrigin           []string
+	allowMethods          string
+	allow                 string
+	allowHeaders          string
+	trustedProxies        []*net.IPNet
+	collectionDiffWindow  time.Duration
+	subscriptionIdle      time.Duration
+	maxConnectionLifetime time.Duration
+	wsMaxMessageSize      int64
+	shutdownCloseText     string
+	sharedAccess          patternValues
+	accessBatch           patternValues
+	accessBatchWindow     time.Duration
+	subscriberThresholds  []int
+	resourceAliases       resourceAliases
+	requestFallbacks      resourceAliases
+	httpCompression       patternCompressions
+	injectQuery           patternValues
+	accessCacheTTL        patternDurations
+	changeDebounce        patternDurations
+	maxCacheAge           patternDurations
+	subscriptionQuotas    patternLimits
+	metricsPatterns       patternValues
+	tenants               map[string]bool
+	notFoundDefault       patternValues
+	accessTimeoutFallback patternValues
+	resourceSchemas       patternValues
+	mockResources         patternValues
+	orderingDomains       [][]rescache.ResourcePattern
+	ackEvents             []rescache.ResourcePattern
+	httpEnvelope          []rescache.ResourcePattern
+	localeResources       []rescache.ResourcePattern
+	normalizeQuery        []rescache.ResourcePattern
+	postMethodResources   []rescache.ResourcePattern
+	retainedResources     []rescache.ResourcePattern
+	referenceTimeout      time.Duration
+	eventMirrorResources  []rescache.ResourcePattern
+	ackRedeliveries       int
+	ackBufferSize         patternLimits
+	ackBufferTTL          patternDurations
+	resumeTTL             time.Duration
+	maxCallTimeout        time.Duration
+	forwardHeaders        []string
+	metricsNetAddr        string
+	loadShedLatency       time.Duration
+	loadShedWindow        time.Duration
+	loadShedFraction      float64
+	jwksCacheTTL          time.Duration
+	listenOptions         listenOptions
+	natsConnectRetryDelay time.Duration
+	webhookRetries        int
+	webhookRetryDelay     time.Duration
 }
 
 // SetDefault sets the default values
@@ -101,6 +323,35 @@ func (c *Config) prepare() error {
 	}
 	c.netAddr += fmt.Sprintf(":%d", c.Port)
 
+	c.netAddrs = []string{c.netAddr}
+	for _, a := range c.ListenAddrs {
+		na, err := parseListenAddr(a)
+		if err != nil {
+			return fmt.Errorf("invalid listenAddrs setting (%s)\n\t%s", a, err)
+		}
+		for _, v := range c.netAddrs {
+			if v == na {
+				return fmt.Errorf("invalid listenAddrs setting (%s)\n\tmust not duplicate another listen address", a)
+			}
+		}
+		c.netAddrs = append(c.netAddrs, na)
+	}
+
+	if c.ListenBacklog < 0 {
+		return fmt.Errorf("invalid listenBacklog setting (%d)\n\tmust be zero or a positive number", c.ListenBacklog)
+	}
+	if c.ListenBacklog > 0 && !listenOptionsSupported {
+		return fmt.Errorf("invalid listenBacklog setting (%d)\n\tnot supported on %s", c.ListenBacklog, runtime.GOOS)
+	}
+	if c.ReusePort && !listenOptionsSupported {
+		return fmt.Errorf("invalid reusePort setting (%v)\n\tnot supported on %s", c.ReusePort, runtime.GOOS)
+	}
+	c.listenOptions = listenOptions{
+		backlog:   c.ListenBacklog,
+		reusePort: c.ReusePort,
+		keepAlive: time.Duration(c.TCPKeepAlive) * time.Millisecond,
+	}
+
 	if c.HeaderAuth != nil {
 		s := *c.HeaderAuth
 		idx := strings.LastIndexByte(s, '.')
@@ -141,10 +392,454 @@ func (c *Config) prepare() error {
 		}
 		c.allowMethods += ", PATCH"
 	}
+	c.allow = c.allowMethods
+	if c.AllowMethods != nil {
+		if len(c.AllowMethods) == 0 {
+			return errors.New("invalid allowMethods setting\n\tmust contain at least one HTTP method")
+		}
+		for _, m := range c.AllowMethods {
+			if !isValidHeaderName(m) {
+				return fmt.Errorf("invalid allowMethods setting (%s)\n\tmust be a list of valid HTTP methods", m)
+			}
+		}
+		c.allowMethods = strings.Join(c.AllowMethods, ", ")
+	}
+	c.allowHeaders = DefaultAllowHeaders
+	if c.AllowHeaders != nil {
+		for _, h := range c.AllowHeaders {
+			if !isValidHeaderName(h) {
+				return fmt.Errorf("invalid allowHeaders setting (%s)\n\tmust be a list of valid HTTP header names", h)
+			}
+		}
+		c.allowHeaders = strings.Join(c.AllowHeaders, ", ")
+	}
+
+	if c.WSMaxMessageSize < 0 {
+		return fmt.Errorf("invalid wsMaxMessageSize setting (%d)\n\tmust be zero or a positive number of bytes", c.WSMaxMessageSize)
+	}
+	c.wsMaxMessageSize = int64(c.WSMaxMessageSize)
+	if c.WSMaxMessageSize == 0 {
+		c.wsMaxMessageSize = DefaultWSMaxMessageSize
+	}
+
+	if c.WSSendQueueHighWater < 0 {
+		return fmt.Errorf("invalid wsSendQueueHighWater setting (%d)\n\tmust be zero or a positive number", c.WSSendQueueHighWater)
+	}
+
+	if c.ReconnectDelay < 0 {
+		return fmt.Errorf("invalid reconnectDelay setting (%d)\n\tmust be zero or a positive number of milliseconds", c.ReconnectDelay)
+	}
+	if c.ReconnectJitter < 0 {
+		return fmt.Errorf("invalid reconnectJitter setting (%d)\n\tmust be zero or a positive number of milliseconds", c.ReconnectJitter)
+	}
+	c.shutdownCloseText = shutdownCloseText(c.ReconnectDelay, c.ReconnectJitter)
+	if c.ResumeTTL < 0 {
+		return fmt.Errorf("invalid resumeTTL setting (%d)\n\tmust be zero or a positive number of milliseconds", c.ResumeTTL)
+	}
+	c.resumeTTL = time.Duration(c.ResumeTTL) * time.Millisecond
+
+	if c.MaxCallTimeout < 0 {
+		return fmt.Errorf("invalid maxCallTimeout setting (%d)\n\tmust be zero or a positive number of milliseconds", c.MaxCallTimeout)
+	}
+	c.maxCallTimeout = time.Duration(c.MaxCallTimeout) * time.Millisecond
+
+	if c.CollectionDiffWindow < 0 {
+		return fmt.Errorf("invalid collectionDiffWindow setting (%d)\n\tmust be zero or a positive number of milliseconds", c.CollectionDiffWindow)
+	}
+	c.collectionDiffWindow = time.Duration(c.CollectionDiffWindow) * time.Millisecond
+
+	if c.MaxParamsDepth < 0 {
+		return fmt.Errorf("invalid maxParamsDepth setting (%d)\n\tmust be zero or a positive number", c.MaxParamsDepth)
+	}
+
+	if c.MaxHTTPResources < 0 {
+		return fmt.Errorf("invalid maxHTTPResources setting (%d)\n\tmust be zero or a positive number", c.MaxHTTPResources)
+	}
+	if c.ReferenceTimeout < 0 {
+		return fmt.Errorf("invalid referenceTimeout setting (%d)\n\tmust be zero or a positive number", c.ReferenceTimeout)
+	}
+	c.referenceTimeout = time.Duration(c.ReferenceTimeout) * time.Millisecond
+
+	if c.MaxProtocolErrors < 0 {
+		return fmt.Errorf("invalid maxProtocolErrors setting (%d)\n\tmust be zero or a positive number", c.MaxProtocolErrors)
+	}
+
+	if c.MaxQueryLength < 0 {
+		return fmt.Errorf("invalid maxQueryLength setting (%d)\n\tmust be zero or a positive number", c.MaxQueryLength)
+	}
+	if c.MaxQueryVariations < 0 {
+		return fmt.Errorf("invalid maxQueryVariations setting (%d)\n\tmust be zero or a positive number", c.MaxQueryVariations)
+	}
+	if c.MaxResponseSize < 0 {
+		return fmt.Errorf("invalid maxResponseSize setting (%d)\n\tmust be zero or a positive number of bytes", c.MaxResponseSize)
+	}
+
+	c.metricsNetAddr = ""
+	if c.MetricsPort != 0 {
+		if c.MetricsPort == c.Port {
+			return fmt.Errorf("invalid metricsPort setting (%d)\n\tmust be separate from port", c.MetricsPort)
+		}
+		c.metricsNetAddr = c.netAddr[:strings.LastIndexByte(c.netAddr, ':')] + fmt.Sprintf(":%d", c.MetricsPort)
+	}
+
+	if c.LoadShedLatency < 0 {
+		return fmt.Errorf("invalid loadShedLatency setting (%d)\n\tmust be zero or a positive number of milliseconds", c.LoadShedLatency)
+	}
+	if c.LoadShedWindow < 0 {
+		return fmt.Errorf("invalid loadShedWindow setting (%d)\n\tmust be zero or a positive number of milliseconds", c.LoadShedWindow)
+	}
+	if c.LoadShedFraction < 0 || c.LoadShedFraction > 1 {
+		return fmt.Errorf("invalid loadShedFraction setting (%v)\n\tmust be a number between 0 and 1", c.LoadShedFraction)
+	}
+	c.loadShedLatency = time.Duration(c.LoadShedLatency) * time.Millisecond
+	c.loadShedWindow = time.Duration(c.LoadShedWindow) * time.Millisecond
+	if c.LoadShedWindow == 0 {
+		c.loadShedWindow = DefaultLoadShedWindow * time.Millisecond
+	}
+	c.loadShedFraction = c.LoadShedFraction
+	if c.LoadShedFraction == 0 {
+		c.loadShedFraction = DefaultLoadShedFraction
+	}
+
+	if c.BackpressureRequests < 0 {
+		return fmt.Errorf("invalid backpressureRequests setting (%d)\n\tmust be zero or a positive number", c.BackpressureRequests)
+	}
+
+	if c.MaxConcurrentRequests < 0 {
+		return fmt.Errorf("invalid maxConcurrentRequests setting (%d)\n\tmust be zero or a positive number", c.MaxConcurrentRequests)
+	}
+	if c.MaxConnRequests < 0 {
+		return fmt.Errorf("invalid maxConnRequests setting (%d)\n\tmust be zero or a positive number", c.MaxConnRequests)
+	}
+	for typ := range c.RequestPriority {
+		switch typ {
+		case "access", "auth", "call", "get":
+		default:
+			return fmt.Errorf("invalid requestPriority setting (%s)\n\tmust be a map with request types access, auth, call, or get as keys", typ)
+		}
+	}
+	if len(c.RequestPriority) > 0 && c.MaxConcurrentRequests == 0 {
+		return errors.New("invalid requestPriority setting\n\trequires maxConcurrentRequests to be set")
+	}
+
+	if c.TokenHeader != "" && !isValidHeaderName(c.TokenHeader) {
+		return fmt.Errorf("invalid tokenHeader setting (%s)\n\tmust be a valid HTTP header name", c.TokenHeader)
+	}
+
+	if c.TokenCookie != "" && !isValidHeaderName(c.TokenCookie) {
+		return fmt.Errorf("invalid tokenCookie setting (%s)\n\tmust be a valid cookie name", c.TokenCookie)
+	}
+
+	c.forwardHeaders = nil
+	for _, h := range c.ForwardHeaders {
+		if !isValidHeaderName(h) {
+			return fmt.Errorf("invalid forwardHeaders setting (%s)\n\tmust be a list of valid HTTP header names", h)
+		}
+		c.forwardHeaders = append(c.forwardHeaders, http.CanonicalHeaderKey(h))
+	}
+
+	if c.JWKSURL != "" {
+		u, err := url.Parse(c.JWKSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid jwksUrl setting (%s)\n\tmust be a valid http or https URL", c.JWKSURL)
+		}
+	}
+	if c.JWKSCacheTTL < 0 {
+		return fmt.Errorf("invalid jwksCacheTTL setting (%d)\n\tmust be zero or a positive number of milliseconds", c.JWKSCacheTTL)
+	}
+	c.jwksCacheTTL = time.Duration(c.JWKSCacheTTL) * time.Millisecond
+	if c.JWKSCacheTTL == 0 {
+		c.jwksCacheTTL = DefaultJWKSCacheTTL * time.Millisecond
+	}
+	if c.JWTTokenField != "" && (c.JWTPublicKeyFile == "" && c.JWKSURL == "") {
+		return fmt.Errorf("invalid jwtTokenField setting (%s)\n\trequires jwtPublicKeyFile or jwksUrl to be set", c.JWTTokenField)
+	}
+	if c.JWTExpiredClose && (c.JWTPublicKeyFile == "" && c.JWKSURL == "") {
+		return errors.New("invalid jwtExpiredClose setting (true)\n\trequires jwtPublicKeyFile or jwksUrl to be set")
+	}
+
+	for _, p := range c.RedactQueryParams {
+		if p == "" || strings.ContainsAny(p, "?&=# \t\r\n") {
+			return fmt.Errorf("invalid redactQueryParams setting (%s)\n\tmust be a list of query parameter names", p)
+		}
+	}
+
+	var err error
+	if c.sharedAccess, err = parsePatternValues("sharedAccess", c.SharedAccess, func(v string) error {
+		if !codec.IsValidRID(v, false) {
+			return errInvalidResourceName
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if c.accessBatch, err = parsePatternValues("accessBatch", c.AccessBatch, func(v string) error {
+		if !codec.IsValidRID(v, false) {
+			return errInvalidResourceName
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if c.AccessBatchWindow < 0 {
+		return fmt.Errorf("invalid accessBatchWindow setting (%d)\n\tmust be zero or a positive number of milliseconds", c.AccessBatchWindow)
+	}
+	c.accessBatchWindow = time.Duration(c.AccessBatchWindow) * time.Millisecond
+	if c.AccessBatchWindow == 0 {
+		c.accessBatchWindow = DefaultAccessBatchWindow * time.Millisecond
+	}
+	if c.normalizeQuery, err = parsePatterns("normalizeQuery", c.NormalizeQuery); err != nil {
+		return err
+	}
+	if c.injectQuery, err = parsePatternValues("injectQuery", c.InjectQuery, validateQueryTemplate); err != nil {
+		return err
+	}
+	if c.resourceAliases, err = parseResourceAliases("resourceAliases", c.ResourceAliases); err != nil {
+		return err
+	}
+	if c.requestFallbacks, err = parseResourceAliases("requestFallbacks", c.RequestFallbacks); err != nil {
+		return err
+	}
+	if c.httpCompression, err = c.parseHTTPCompression(); err != nil {
+		return err
+	}
+	if c.accessCacheTTL, err = parsePatternDurations("accessCacheTTL", c.AccessCacheTTL); err != nil {
+		return err
+	}
+	if c.changeDebounce, err = parsePatternDurations("changeDebounce", c.ChangeDebounce); err != nil {
+		return err
+	}
+	if c.maxCacheAge, err = parsePatternDurations("maxCacheAge", c.MaxCacheAge); err != nil {
+		return err
+	}
+	for _, rid := range c.WarmupResources {
+		if !codec.IsValidRID(rid, true) {
+			return fmt.Errorf("invalid warmupResources setting (%s)\n\tmust be a valid resource ID", rid)
+		}
+	}
+	if c.WarmupReject && len(c.WarmupResources) == 0 {
+		return errors.New("invalid warmupReject setting\n\trequires warmupResources to be set")
+	}
+	if c.subscriptionQuotas, err = parsePatternLimits("subscriptionQuotas", c.SubscriptionQuotas); err != nil {
+		return err
+	}
+	if c.SubscriptionIdleTimeout < 0 {
+		return fmt.Errorf("invalid subscriptionIdleTimeout setting (%d)\n\tmust be zero or a positive number of milliseconds", c.SubscriptionIdleTimeout)
+	}
+	c.subscriptionIdle = time.Duration(c.SubscriptionIdleTimeout) * time.Millisecond
+	if c.notFoundDefault, err = parseNotFoundDefault(c.NotFoundDefault); err != nil {
+		return err
+	}
+	if c.accessTimeoutFallback, err = parseAccessTimeoutFallback(c.AccessTimeoutFallback); err != nil {
+		return err
+	}
+	if c.trustedProxies, err = parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+	if c.MetadataSuffix != "" && !codec.IsValidRIDPart(c.MetadataSuffix) {
+		return fmt.Errorf("invalid metadataSuffix setting (%s)\n\tmust be a valid resource ID part", c.MetadataSuffix)
+	}
+	if len(c.ResourceSchemas) > 0 && c.MetadataSuffix == "" {
+		return errors.New("invalid resourceSchemas setting\n\trequires metadataSuffix to be set")
+	}
+	if c.resourceSchemas, err = parseResourceSchemas(c.ResourceSchemas); err != nil {
+		return err
+	}
+	if len(c.MockResources) > 0 && !c.DangerouslyEnableMockResources {
+		return fmt.Errorf("invalid mockResources setting\n\tmust only be used for testing, with dangerouslyEnableMockResources set")
+	}
+	if c.mockResources, err = parseMockResources(c.MockResources); err != nil {
+		return err
+	}
+	if c.OrderingDomains != nil {
+		c.orderingDomains = make([][]rescache.ResourcePattern, len(c.OrderingDomains))
+		for i, patterns := range c.OrderingDomains {
+			if len(patterns) == 0 {
+				return fmt.Errorf("invalid orderingDomains setting\n\tdomain must contain at least one resource pattern")
+			}
+			if c.orderingDomains[i], err = parsePatterns("orderingDomains", patterns); err != nil {
+				return err
+			}
+		}
+	}
+	switch c.DisconnectLog {
+	case "", DisconnectLogInfo, DisconnectLogDebug:
+	default:
+		return fmt.Errorf("invalid disconnectLog setting (%s)\n\tmust be either %s or %s", c.DisconnectLog, DisconnectLogInfo, DisconnectLogDebug)
+	}
+	switch c.PendingGetEvents {
+	case "", PendingGetEventsDiscard, PendingGetEventsBuffer:
+	default:
+		return fmt.Errorf("invalid pendingGetEvents setting (%s)\n\tmust be either %s or %s", c.PendingGetEvents, PendingGetEventsDiscard, PendingGetEventsBuffer)
+	}
+	switch c.MalformedEvents {
+	case "", MalformedEventsDiscard, MalformedEventsResync:
+	default:
+		return fmt.Errorf("invalid malformedEvents setting (%s)\n\tmust be either %s or %s", c.MalformedEvents, MalformedEventsDiscard, MalformedEventsResync)
+	}
+	switch c.FieldOrder {
+	case "", FieldOrderSorted, FieldOrderPreserve:
+	default:
+		return fmt.Errorf("invalid fieldOrder setting (%s)\n\tmust be either %s or %s", c.FieldOrder, FieldOrderSorted, FieldOrderPreserve)
+	}
+	switch c.TrailingBodyData {
+	case "", TrailingBodyDataReject, TrailingBodyDataIgnore:
+	default:
+		return fmt.Errorf("invalid trailingBodyData setting (%s)\n\tmust be either %s or %s", c.TrailingBodyData, TrailingBodyDataReject, TrailingBodyDataIgnore)
+	}
+	switch c.DualCallResponses {
+	case "", DualCallResponsesError, DualCallResponsesResource:
+	default:
+		return fmt.Errorf("invalid dualCallResponses setting (%s)\n\tmust be either %s or %s", c.DualCallResponses, DualCallResponsesError, DualCallResponsesResource)
+	}
+	if len(c.AckEvents) > 0 {
+		if c.ackEvents, err = parsePatterns("ackEvents", c.AckEvents); err != nil {
+			return err
+		}
+	}
+	if len(c.HTTPEnvelope) > 0 {
+		if c.httpEnvelope, err = parsePatterns("httpEnvelope", c.HTTPEnvelope); err != nil {
+			return err
+		}
+	}
+	if len(c.LocaleResources) > 0 {
+		if !c.Locale {
+			return errors.New("invalid localeResources setting\n\trequires locale to be set")
+		}
+		if c.localeResources, err = parsePatterns("localeResources", c.LocaleResources); err != nil {
+			return err
+		}
+	}
+	if c.POSTMethod != nil {
+		if !codec.IsValidRIDPart(*c.POSTMethod) {
+			return fmt.Errorf("invalid postMethod setting (%s)\n\tmust be a valid call method name", *c.POSTMethod)
+		}
+		if len(c.POSTMethodResources) == 0 {
+			return errors.New("invalid postMethod setting\n\trequires postMethodResources to be set")
+		}
+	}
+	if len(c.POSTMethodResources) > 0 {
+		if c.POSTMethod == nil {
+			return errors.New("invalid postMethodResources setting\n\trequires postMethod to be set")
+		}
+		if c.postMethodResources, err = parsePatterns("postMethodResources", c.POSTMethodResources); err != nil {
+			return err
+		}
+	}
+	if len(c.RetainedResources) > 0 {
+		if c.retainedResources, err = parsePatterns("retainedResources", c.RetainedResources); err != nil {
+			return err
+		}
+	}
+	if c.AckRedeliveries < 0 {
+		return fmt.Errorf("invalid ackRedeliveries setting (%d)\n\tmust be zero or a positive number", c.AckRedeliveries)
+	}
+	c.ackRedeliveries = c.AckRedeliveries
+	if c.AckRedeliveries == 0 {
+		c.ackRedeliveries = DefaultAckRedeliveries
+	}
+	if len(c.AckBufferSize) > 0 && len(c.AckEvents) == 0 {
+		return errors.New("invalid ackBufferSize setting\n\trequires ackEvents to be set")
+	}
+	if c.ackBufferSize, err = parsePatternLimits("ackBufferSize", c.AckBufferSize); err != nil {
+		return err
+	}
+	if len(c.AckBufferTTL) > 0 && len(c.AckEvents) == 0 {
+		return errors.New("invalid ackBufferTTL setting\n\trequires ackEvents to be set")
+	}
+	if c.ackBufferTTL, err = parsePatternDurations("ackBufferTTL", c.AckBufferTTL); err != nil {
+		return err
+	}
+	if c.metricsPatterns, err = parseMetricsPatterns(c.MetricsPatterns, c.SharedAccess, c.AccessCacheTTL); err != nil {
+		return err
+	}
+	if c.tenants, err = parseTenants(c.TenantClaim, c.Tenants); err != nil {
+		return err
+	}
+	for _, r := range c.WSRedirects {
+		if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("invalid wsRedirects setting (%s)\n\tmust be an absolute ws or wss URL", r.URL)
+		}
+		if r.Connections < 0 {
+			return fmt.Errorf("invalid wsRedirects setting for %s (%d)\n\tconnections must be zero or a positive number", r.URL, r.Connections)
+		}
+		if (r.Connections > 0) == (r.Claim != "") {
+			return fmt.Errorf("invalid wsRedirects setting for %s\n\tmust have either connections or claim set", r.URL)
+		}
+		if (r.Claim == "") != (r.Value == "") {
+			return fmt.Errorf("invalid wsRedirects setting for %s\n\tclaim and value must be set together", r.URL)
+		}
+	}
+	for _, n := range c.SubscriberThresholds {
+		if n < 0 {
+			return fmt.Errorf("invalid subscriberThresholds setting (%d)\n\tmust be a list of zero or positive numbers", n)
+		}
+	}
+	if c.NATSInboxPrefix != "" && !codec.IsValidRID(c.NATSInboxPrefix, false) {
+		return fmt.Errorf("invalid natsInboxPrefix setting (%s)\n\tmust be a valid subject without wildcards", c.NATSInboxPrefix)
+	}
+	if len(c.SubscriberThresholds) > 0 && !codec.IsValidRID(c.SubscriberThresholdSubject, false) {
+		return fmt.Errorf("invalid subscriberThresholdSubject setting (%s)\n\tmust be a valid subject without wildcards when subscriberThresholds is set", c.SubscriberThresholdSubject)
+	}
+	c.subscriberThresholds = append([]int(nil), c.SubscriberThresholds...)
+	sort.Ints(c.subscriberThresholds)
+	if c.ConnectionSummarySubject != "" && !codec.IsValidRID(c.ConnectionSummarySubject, false) {
+		return fmt.Errorf("invalid connectionSummarySubject setting (%s)\n\tmust be a valid subject without wildcards", c.ConnectionSummarySubject)
+	}
+	if c.EventMirrorSubject != "" && !codec.IsValidRID(c.EventMirrorSubject, false) {
+		return fmt.Errorf("invalid eventMirrorSubject setting (%s)\n\tmust be a valid subject without wildcards", c.EventMirrorSubject)
+	}
+	if len(c.EventMirrorResources) > 0 {
+		if c.EventMirrorSubject == "" {
+			return errors.New("invalid eventMirrorResources setting\n\trequires eventMirrorSubject to be set")
+		}
+		if c.eventMirrorResources, err = parsePatterns("eventMirrorResources", c.EventMirrorResources); err != nil {
+			return err
+		}
+	}
 
 	if c.WSPath == "" {
 		c.WSPath = "/"
 	}
+	if c.WebhookPath != "" {
+		c.WebhookPath = strings.TrimRight(c.WebhookPath, "/")
+		if c.WebhookPath == "" || c.WebhookPath[0] != '/' || c.WebhookPath == c.WSPath {
+			return fmt.Errorf("invalid webhookPath setting (%s)\n\tmust be a path starting with /, separate from wsPath", c.WebhookPath)
+		}
+		if c.WebhookToken == "" {
+			return errors.New("invalid webhookPath setting\n\trequires webhookToken to be set")
+		}
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("invalid maxConnections setting (%d)\n\tmust be zero or a positive number", c.MaxConnections)
+	}
+	if c.MaxConnectionLifetime < 0 {
+		return fmt.Errorf("invalid maxConnectionLifetime setting (%d)\n\tmust be zero or a positive number of milliseconds", c.MaxConnectionLifetime)
+	}
+	c.maxConnectionLifetime = time.Duration(c.MaxConnectionLifetime) * time.Millisecond
+	if c.NATSConnectRetries < 0 {
+		return fmt.Errorf("invalid natsConnectRetries setting (%d)\n\tmust be zero or a positive number", c.NATSConnectRetries)
+	}
+	if c.NATSConnectRetryDelay < 0 {
+		return fmt.Errorf("invalid natsConnectRetryDelay setting (%d)\n\tmust be zero or a positive number of milliseconds", c.NATSConnectRetryDelay)
+	}
+	c.natsConnectRetryDelay = time.Duration(c.NATSConnectRetryDelay) * time.Millisecond
+	if c.NATSConnectRetryDelay == 0 {
+		c.natsConnectRetryDelay = DefaultNATSConnectRetryDelay
+	}
+	c.webhookRetries = c.WebhookRetries
+	if c.WebhookRetries == 0 {
+		c.webhookRetries = DefaultWebhookRetries
+	} else if c.WebhookRetries < 0 {
+		c.webhookRetries = 0
+	}
+	if c.WebhookRetryDelay < 0 {
+		return fmt.Errorf("invalid webhookRetryDelay setting (%d)\n\tmust be zero or a positive number of milliseconds", c.WebhookRetryDelay)
+	}
+	c.webhookRetryDelay = time.Duration(c.WebhookRetryDelay) * time.Millisecond
+	if c.WebhookRetryDelay == 0 {
+		c.webhookRetryDelay = DefaultWebhookRetryDelay
+	}
 	if c.APIPath == "" || c.APIPath[len(c.APIPath)-1] != '/' {
 		c.APIPath = c.APIPath + "/"
 	}
@@ -152,6 +847,379 @@ func (c *Config) prepare() error {
 	return nil
 }
 
+// parseTenants parses the allow-list of tenant IDs used as labels for
+// connection metrics. The list bounds the number of labels, and requires the
+// token claim the tenant is derived from.
+func parseTenants(claim string, tenants []string) (map[string]bool, error) {
+	if claim == "" {
+		if len(tenants) > 0 {
+			return nil, errors.New("invalid tenants setting\n\trequires tenantClaim to be set")
+		}
+		return nil, nil
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("invalid tenantClaim setting (%s)\n\trequires tenants to be set", claim)
+	}
+	m := make(map[string]bool, len(tenants))
+	for _, t := range tenants {
+		if t == "" || t == otherMetricsTenant {
+			return nil, fmt.Errorf("invalid tenants setting (%#v)\n\tmust be a non-empty tenant ID other than %#v", t, otherMetricsTenant)
+		}
+		m[t] = true
+	}
+	return m, nil
+}
+
+// parseMetricsPatterns parses the resource patterns used as labels for
+// service request metrics. The patterns of the sharedAccess and
+// accessCacheTTL settings are included, together with any pattern in the
+// metricsPatterns setting.
+func parseMetricsPatterns(patterns []string, sharedAccess map[string]string, accessCacheTTL map[string]int) (patternValues, error) {
+	m := make(map[string]string, len(patterns)+len(sharedAccess)+len(accessCacheTTL))
+	for _, p := range patterns {
+		m[p] = p
+	}
+	for p := range sharedAccess {
+		m[p] = p
+	}
+	for p := range accessCacheTTL {
+		m[p] = p
+	}
+	return parsePatternValues("metricsPatterns", m, func(string) error { return nil })
+}
+
+// parseMockResources parses the map of resource patterns to mocked resources,
+// validating that each is a model or a collection. The values of the
+// returned patternValues are the get responses for the mocked resources.
+func parseMockResources(m map[string]json.RawMessage) (patternValues, error) {
+	sm := make(map[string]string, len(m))
+	for p, v := range m {
+		sm[p] = string(v)
+	}
+	pv, err := parsePatternValues("mockResources", sm, func(v string) error {
+		if _, err := codec.DecodeResource(json.RawMessage(v)); err != nil {
+			return errors.New("must be a valid model object or collection array")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range pv {
+		key := "model"
+		if strings.HasPrefix(strings.TrimSpace(p.value), "[") {
+			key = "collection"
+		}
+		pv[i].value = `{"result":{"` + key + `":` + p.value + `}}`
+	}
+	return pv, nil
+}
+
+// parseNotFoundDefault parses the map of resource patterns to default
+// resources, validating that each default is a model or a collection.
+func parseNotFoundDefault(m map[string]json.RawMessage) (patternValues, error) {
+	sm := make(map[string]string, len(m))
+	for p, v := range m {
+		sm[p] = string(v)
+	}
+	return parsePatternValues("notFoundDefault", sm, func(v string) error {
+		if _, err := codec.DecodeResource(json.RawMessage(v)); err != nil {
+			return errors.New("must be a valid model object or collection array")
+		}
+		return nil
+	})
+}
+
+// parseAccessTimeoutFallback parses the map of resource patterns to fallback
+// access results, validating that each is an access result object.
+func parseAccessTimeoutFallback(m map[string]json.RawMessage) (patternValues, error) {
+	sm := make(map[string]string, len(m))
+	for p, v := range m {
+		sm[p] = string(v)
+	}
+	return parsePatternValues("accessTimeoutFallback", sm, func(v string) error {
+		var ar *codec.AccessResult
+		if json.Unmarshal([]byte(v), &ar) != nil || ar == nil {
+			return errors.New("must be an access result object")
+		}
+		return nil
+	})
+}
+
+// parseResourceSchemas parses the map of resource patterns to schemas
+// included in resource metadata.
+func parseResourceSchemas(m map[string]json.RawMessage) (patternValues, error) {
+	sm := make(map[string]string, len(m))
+	for p, v := range m {
+		sm[p] = string(v)
+	}
+	return parsePatternValues("resourceSchemas", sm, func(v string) error {
+		var o map[string]json.RawMessage
+		if json.Unmarshal([]byte(v), &o) != nil {
+			return errors.New("must be a JSON object")
+		}
+		return nil
+	})
+}
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges of
+// trusted proxies. A single IP address is parsed as a range containing only
+// that address.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trustedProxies setting (%s)\n\tmust be a valid IP address or CIDR range", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy returns true if the remote address, on the form
+// <host>:<port>, is the address of a trusted proxy.
+func (c *Config) isTrustedProxy(remoteAddr string) bool {
+	if c.trustedProxies == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresAck returns true if events on the resource must be acknowledged
+// by the client.
+func (c *Config) requiresAck(rname string) bool {
+	for _, p := range c.ackEvents {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// envelope returns true if HTTP responses for the resource are wrapped in a
+// JSON envelope.
+func (c *Config) envelope(rname string) bool {
+	for _, p := range c.httpEnvelope {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// postMethod returns the call method name that HTTP POST requests on the
+// resource path are mapped to, or nil if the last path part is the method.
+func (c *Config) postMethod(rname string) *string {
+	for _, p := range c.postMethodResources {
+		if p.Match(rname) {
+			return c.POSTMethod
+		}
+	}
+	return nil
+}
+
+// localized returns true if the resource is fetched separately for each
+// client locale.
+func (c *Config) localized(rname string) bool {
+	for _, p := range c.localeResources {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryNormalized returns true if the query is normalized before caching and
+// forwarding requests for the resource.
+func (c *Config) queryNormalized(rname string) bool {
+	for _, p := range c.normalizeQuery {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// retained returns true if the resource is kept in the cache without
+// subscribers.
+func (c *Config) retained(rname string) bool {
+	for _, p := range c.retainedResources {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrored returns true if events applied to the resource are mirrored to the
+// eventMirrorSubject. If no eventMirrorResources are set, all resources are
+// mirrored.
+func (c *Config) mirrored(rname string) bool {
+	if c.eventMirrorResources == nil {
+		return true
+	}
+	for _, p := range c.eventMirrorResources {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsPattern returns the configured resource pattern matching the
+// resource name, or "other" if no pattern matches.
+// If multiple patterns match, the most specific one is used.
+func (c *Config) metricsPattern(rname string) string {
+	if p, ok := c.metricsPatterns.match(rname); ok {
+		return p
+	}
+	return otherMetricsPattern
+}
+
+// tenantLabel returns the tenant label used for connection metrics, as the
+// value of the tenantClaim field of the token if it is among the configured
+// tenants, otherwise "other".
+func (c *Config) tenantLabel(token json.RawMessage) string {
+	if v, ok := tokenFieldString(token, c.TenantClaim); ok && c.tenants[v] {
+		return v
+	}
+	return otherMetricsTenant
+}
+
+// parseListenAddr parses an address on the form <host>:<port>, where host is
+// an IPv4 or IPv6 address, or empty for all addresses. It returns the address
+// in the same format as netAddr.
+func parseListenAddr(s string) (string, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", errors.New("must be on the form <host>:<port>")
+	}
+	if host != "" {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return "", errors.New("host must be a valid IPv4 or IPv6 address")
+		}
+		host = ip.String()
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return "", errors.New("port must be a number between 1 and 65535")
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(p, 10)), nil
+}
+
+// shutdownCloseText returns the close message text sent to WebSocket clients
+// on shutdown, with a hint on how long to wait before reconnecting.
+func shutdownCloseText(delay, jitter int) string {
+	out, _ := json.Marshal(struct {
+		Reason          string `json:"reason"`
+		ReconnectDelay  int    `json:"reconnectDelay,omitempty"`
+		ReconnectJitter int    `json:"reconnectJitter,omitempty"`
+	}{"shutdown", delay, jitter})
+	return string(out)
+}
+
+// redirectCloseText returns the close message text sent to WebSocket clients
+// redirected to another gateway.
+func redirectCloseText(u string) string {
+	out, _ := json.Marshal(struct {
+		Reason string `json:"reason"`
+		URL    string `json:"url"`
+	}{"redirect", u})
+	return string(out)
+}
+
+// connectionsRedirect returns the URL of the first wsRedirects rule with a
+// connections limit below the number of connections, or an empty string if
+// no rule matches.
+func (c *Config) connectionsRedirect(conns int) string {
+	for _, r := range c.WSRedirects {
+		if r.Connections > 0 && conns > r.Connections {
+			return r.URL
+		}
+	}
+	return ""
+}
+
+// tokenRedirect returns the URL of the first wsRedirects rule with a claim
+// matching the token, or an empty string if no rule matches.
+func (c *Config) tokenRedirect(token json.RawMessage) string {
+	for _, r := range c.WSRedirects {
+		if r.Claim != "" {
+			if v, ok := tokenFieldString(token, r.Claim); ok && v == r.Value {
+				return r.URL
+			}
+		}
+	}
+	return ""
+}
+
+// forwardedHeader returns the safelisted headers of the HTTP request,
+// or nil if there are none.
+func (c *Config) forwardedHeader(r *http.Request) http.Header {
+	if r == nil || len(c.forwardHeaders) == 0 {
+		return nil
+	}
+	var h http.Header
+	for _, k := range c.forwardHeaders {
+		if v, ok := r.Header[k]; ok {
+			if h == nil {
+				h = make(http.Header, len(c.forwardHeaders))
+			}
+			h[k] = v
+		}
+	}
+	return h
+}
+
+// isValidHeaderName reports whether s is a valid HTTP header field name.
+func isValidHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedAccessRID returns the name of the access resource shared by the
+// resource in multiple subscribe requests, or an empty string if the resource
+// has no shared access. If multiple patterns match, the most specific one is
+// used.
+func (c *Config) sharedAccessRID(rname string) string {
+	rid, _ := c.sharedAccess.match(rname)
+	return rid
+}
+
 func validateAllowOrigin(s []string) error {
 	for i, o := range s {
 		o = toLowerASCII(o)
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 )
 
 func compareString(t *testing.T, name string, str, exp string, i int) {
@@ -65,6 +67,9 @@ func TestConfigPrepare(t *testing.T) {
 		{Config{WSPath: "/", DELETEMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", DELETEMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, DELETE"}, false},
 		{Config{WSPath: "/", PATCHMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", PATCHMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, PATCH"}, false},
 		{Config{WSPath: "/", PUTMethod: &method, DELETEMethod: &method, PATCHMethod: &method}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", PUTMethod: &method, DELETEMethod: &method, PATCHMethod: &method, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST, PUT, DELETE, PATCH"}, false},
+		{Config{WSPath: "/", AllowMethods: []string{"GET", "POST"}}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, POST"}, false},
+		// Collection diff window
+		{Config{WSPath: "/", CollectionDiffWindow: 100}, Config{Addr: nil, Port: 80, WSPath: "/", APIPath: "/", CollectionDiffWindow: 100, scheme: "http", netAddr: "0.0.0.0:80", allowOrigin: []string{"*"}, allowMethods: "GET, HEAD, OPTIONS, POST", collectionDiffWindow: 100 * time.Millisecond}, false},
 		// Invalid config
 		{Config{Addr: &invalidAddr, WSPath: "/"}, Config{}, true},
 		{Config{HeaderAuth: &invalidHeaderAuth, WSPath: "/"}, Config{}, true},
@@ -76,6 +81,153 @@ func TestConfigPrepare(t *testing.T) {
 		{Config{PUTMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
 		{Config{DELETEMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
 		{Config{PATCHMethod: &invalidMethod, WSPath: "/"}, Config{}, true},
+		{Config{POSTMethod: &invalidMethod, POSTMethodResources: []string{"test.>"}, WSPath: "/"}, Config{}, true},
+		{Config{POSTMethod: &method, WSPath: "/"}, Config{}, true},
+		{Config{POSTMethodResources: []string{"test.>"}, WSPath: "/"}, Config{}, true},
+		{Config{POSTMethod: &method, POSTMethodResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{AllowMethods: []string{}, WSPath: "/"}, Config{}, true},
+		{Config{TokenHeader: "Author ization", WSPath: "/"}, Config{}, true},
+		{Config{TokenCookie: "session id", WSPath: "/"}, Config{}, true},
+		{Config{WebhookPath: "/webhooks", WSPath: "/"}, Config{}, true},
+		{Config{WebhookPath: "webhooks", WebhookToken: "secret", WSPath: "/"}, Config{}, true},
+		{Config{WebhookPath: "/ws", WebhookToken: "secret", WSPath: "/ws"}, Config{}, true},
+		{Config{WebhookRetryDelay: -1, WSPath: "/"}, Config{}, true},
+		{Config{AllowMethods: []string{"GET", "PO ST"}, WSPath: "/"}, Config{}, true},
+		{Config{AllowHeaders: []string{"content-type", ""}, WSPath: "/"}, Config{}, true},
+		{Config{WSMaxMessageSize: -1, WSPath: "/"}, Config{}, true},
+		{Config{WSSendQueueHighWater: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxConnectionLifetime: -1, WSPath: "/"}, Config{}, true},
+		{Config{ReconnectDelay: -1, WSPath: "/"}, Config{}, true},
+		{Config{ReconnectJitter: -1, WSPath: "/"}, Config{}, true},
+		{Config{ResumeTTL: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxCallTimeout: -1, WSPath: "/"}, Config{}, true},
+		{Config{MetadataSuffix: "foo.bar", WSPath: "/"}, Config{}, true},
+		{Config{ResourceSchemas: map[string]json.RawMessage{"test.model": json.RawMessage(`{}`)}, WSPath: "/"}, Config{}, true},
+		{Config{MetadataSuffix: "_meta", ResourceSchemas: map[string]json.RawMessage{"test.model": json.RawMessage(`"foo"`)}, WSPath: "/"}, Config{}, true},
+		{Config{TenantClaim: "org.id", WSPath: "/"}, Config{}, true},
+		{Config{WarmupResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{NormalizeQuery: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{WarmupReject: true, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "http://example.com", Connections: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "/ws", Connections: 1}}, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "wss://example.com", Connections: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "wss://example.com"}}, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "wss://example.com", Connections: 1, Claim: "region", Value: "eu"}}, WSPath: "/"}, Config{}, true},
+		{Config{WSRedirects: []WSRedirect{{URL: "wss://example.com", Claim: "region"}}, WSPath: "/"}, Config{}, true},
+		{Config{Tenants: []string{"acme"}, WSPath: "/"}, Config{}, true},
+		{Config{TenantClaim: "org.id", Tenants: []string{""}, WSPath: "/"}, Config{}, true},
+		{Config{TenantClaim: "org.id", Tenants: []string{"other"}, WSPath: "/"}, Config{}, true},
+		{Config{CollectionDiffWindow: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxParamsDepth: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxHTTPResources: -1, WSPath: "/"}, Config{}, true},
+		{Config{ReferenceTimeout: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxProtocolErrors: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxQueryLength: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxQueryVariations: -1, WSPath: "/"}, Config{}, true},
+		{Config{NATSConnectRetries: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxConnections: -1, WSPath: "/"}, Config{}, true},
+		{Config{NATSConnectRetryDelay: -1, WSPath: "/"}, Config{}, true},
+		{Config{ListenBacklog: -1, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"127.0.0.1"}, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"localhost:8080"}, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"127.0.0.1:0"}, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"127.0.0.1:65536"}, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"0.0.0.0:80"}, WSPath: "/"}, Config{}, true},
+		{Config{ListenAddrs: []string{"127.0.0.1:8080", "127.0.0.1:8080"}, WSPath: "/"}, Config{}, true},
+		{Config{NotFoundDefault: map[string]json.RawMessage{"test.>": json.RawMessage(`"foo"`)}, WSPath: "/"}, Config{}, true},
+		{Config{MockResources: map[string]json.RawMessage{"test.model": json.RawMessage(`{}`)}, WSPath: "/"}, Config{}, true},
+		{Config{MockResources: map[string]json.RawMessage{"test.model": json.RawMessage(`"foo"`)}, DangerouslyEnableMockResources: true, WSPath: "/"}, Config{}, true},
+		{Config{MockResources: map[string]json.RawMessage{"test..model": json.RawMessage(`{}`)}, DangerouslyEnableMockResources: true, WSPath: "/"}, Config{}, true},
+		{Config{NotFoundDefault: map[string]json.RawMessage{"test.>": json.RawMessage(`{"foo":[1]}`)}, WSPath: "/"}, Config{}, true},
+		{Config{NotFoundDefault: map[string]json.RawMessage{"test..foo": json.RawMessage(`{}`)}, WSPath: "/"}, Config{}, true},
+		{Config{AccessTimeoutFallback: map[string]json.RawMessage{"test.>": json.RawMessage(`true`)}, WSPath: "/"}, Config{}, true},
+		{Config{AccessTimeoutFallback: map[string]json.RawMessage{"test.>": json.RawMessage(`null`)}, WSPath: "/"}, Config{}, true},
+		{Config{AccessTimeoutFallback: map[string]json.RawMessage{"test..foo": json.RawMessage(`{"get":true}`)}, WSPath: "/"}, Config{}, true},
+		{Config{OrderingDomains: [][]string{{"test.>"}, {}}, WSPath: "/"}, Config{}, true},
+		{Config{OrderingDomains: [][]string{{"test..model"}}, WSPath: "/"}, Config{}, true},
+		{Config{RedactQueryParams: []string{""}, WSPath: "/"}, Config{}, true},
+		{Config{RedactQueryParams: []string{"token="}, WSPath: "/"}, Config{}, true},
+		{Config{RedactQueryParams: []string{"a&b"}, WSPath: "/"}, Config{}, true},
+		{Config{MaxConcurrentRequests: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxConcurrentRequests: 10, RequestPriority: map[string]int{"new": 1}, WSPath: "/"}, Config{}, true},
+		{Config{RequestPriority: map[string]int{"call": 1}, WSPath: "/"}, Config{}, true},
+		{Config{JWKSURL: "ftp://example.com/jwks.json", WSPath: "/"}, Config{}, true},
+		{Config{JWKSURL: "example.com/jwks.json", WSPath: "/"}, Config{}, true},
+		{Config{JWKSURL: "https://example.com/jwks.json", JWKSCacheTTL: -1, WSPath: "/"}, Config{}, true},
+		{Config{JWTTokenField: "jwt", WSPath: "/"}, Config{}, true},
+		{Config{JWTExpiredClose: true, WSPath: "/"}, Config{}, true},
+		{Config{Port: 8080, MetricsPort: 8080, WSPath: "/"}, Config{}, true},
+		{Config{LoadShedLatency: -1, WSPath: "/"}, Config{}, true},
+		{Config{LoadShedWindow: -1, WSPath: "/"}, Config{}, true},
+		{Config{LoadShedFraction: -0.1, WSPath: "/"}, Config{}, true},
+		{Config{LoadShedFraction: 1.5, WSPath: "/"}, Config{}, true},
+		{Config{BackpressureRequests: -1, WSPath: "/"}, Config{}, true},
+		{Config{TrustedProxies: []string{"10.0.0.1", ""}, WSPath: "/"}, Config{}, true},
+		{Config{TrustedProxies: []string{"10.0.0.0/33"}, WSPath: "/"}, Config{}, true},
+		{Config{TrustedProxies: []string{"localhost"}, WSPath: "/"}, Config{}, true},
+		{Config{ForwardHeaders: []string{"X-Foo", ""}, WSPath: "/"}, Config{}, true},
+		{Config{ForwardHeaders: []string{"X Foo"}, WSPath: "/"}, Config{}, true},
+		{Config{ForwardHeaders: []string{"X-Foo:"}, WSPath: "/"}, Config{}, true},
+		{Config{SharedAccess: map[string]string{"test..model": "test.access"}, WSPath: "/"}, Config{}, true},
+		{Config{SharedAccess: map[string]string{"test.>": "test.*"}, WSPath: "/"}, Config{}, true},
+		{Config{SharedAccess: map[string]string{"test.>": "test.access?q=foo"}, WSPath: "/"}, Config{}, true},
+		{Config{AccessBatch: map[string]string{"test..model": "test.batch"}, WSPath: "/"}, Config{}, true},
+		{Config{AccessBatch: map[string]string{"test.>": "test.*"}, WSPath: "/"}, Config{}, true},
+		{Config{AccessBatchWindow: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxConnRequests: -1, WSPath: "/"}, Config{}, true},
+		{Config{SubscriberThresholds: []int{-1}, SubscriberThresholdSubject: "resgate.subscribers", WSPath: "/"}, Config{}, true},
+		{Config{SubscriberThresholds: []int{100}, WSPath: "/"}, Config{}, true},
+		{Config{SubscriberThresholds: []int{100}, SubscriberThresholdSubject: "resgate.*", WSPath: "/"}, Config{}, true},
+		{Config{InjectQuery: map[string]string{"test..model": "tenant=foo"}, WSPath: "/"}, Config{}, true},
+		{Config{AccessCacheTTL: map[string]int{"test..model": 1000}, WSPath: "/"}, Config{}, true},
+		{Config{AccessCacheTTL: map[string]int{"test.>": 0}, WSPath: "/"}, Config{}, true},
+		{Config{AccessCacheTTL: map[string]int{"test.>": -1}, WSPath: "/"}, Config{}, true},
+		{Config{ChangeDebounce: map[string]int{"test..model": 100}, WSPath: "/"}, Config{}, true},
+		{Config{ChangeDebounce: map[string]int{"test.>": 0}, WSPath: "/"}, Config{}, true},
+		{Config{MaxCacheAge: map[string]int{"test..model": 100}, WSPath: "/"}, Config{}, true},
+		{Config{MaxCacheAge: map[string]int{"test.>": -1}, WSPath: "/"}, Config{}, true},
+		{Config{RetainedResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{ConnectionSummarySubject: "resgate.*", WSPath: "/"}, Config{}, true},
+		{Config{EventMirrorSubject: "resgate.>", WSPath: "/"}, Config{}, true},
+		{Config{EventMirrorResources: []string{"test.>"}, WSPath: "/"}, Config{}, true},
+		{Config{EventMirrorSubject: "resgate.mirror", EventMirrorResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionLevel: -1, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionLevel: 10, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionMinSize: -1, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionOverrides: map[string]HTTPCompressionOverride{"test..model": {}}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionOverrides: map[string]HTTPCompressionOverride{"test.>": {Level: 10}}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPCompressionOverrides: map[string]HTTPCompressionOverride{"test.>": {MinSize: -1}}, WSPath: "/"}, Config{}, true},
+		{Config{ResourceAliases: map[string]string{"test..model": "test.model"}, WSPath: "/"}, Config{}, true},
+		{Config{RequestFallbacks: map[string]string{"test.>": "test.fallback.*"}, WSPath: "/"}, Config{}, true},
+		{Config{ResourceAliases: map[string]string{"test.old": "test..new"}, WSPath: "/"}, Config{}, true},
+		{Config{ResourceAliases: map[string]string{"test.old.*": "test.new"}, WSPath: "/"}, Config{}, true},
+		{Config{ResourceAliases: map[string]string{"test.old.*": "test.new.*.*"}, WSPath: "/"}, Config{}, true},
+		{Config{ResourceAliases: map[string]string{"test.old.>": "test.new.*"}, WSPath: "/"}, Config{}, true},
+		{Config{SubscriptionQuotas: map[string]int{"test..model": 5}, WSPath: "/"}, Config{}, true},
+		{Config{SubscriptionQuotas: map[string]int{"test.>": 0}, WSPath: "/"}, Config{}, true},
+		{Config{PendingGetEvents: "apply", WSPath: "/"}, Config{}, true},
+		{Config{MalformedEvents: "drop", WSPath: "/"}, Config{}, true},
+		{Config{DisconnectLog: "trace", WSPath: "/"}, Config{}, true},
+		{Config{AckEvents: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{HTTPEnvelope: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{SubscriptionIdleTimeout: -1, WSPath: "/"}, Config{}, true},
+		{Config{MaxResponseSize: -1, WSPath: "/"}, Config{}, true},
+		{Config{TrailingBodyData: "strict", WSPath: "/"}, Config{}, true},
+		{Config{FieldOrder: "random", WSPath: "/"}, Config{}, true},
+		{Config{DualCallResponses: "result", WSPath: "/"}, Config{}, true},
+		{Config{NATSInboxPrefix: "_INBOX.>", WSPath: "/"}, Config{}, true},
+		{Config{NATSInboxPrefix: "_INBOX..resgate", WSPath: "/"}, Config{}, true},
+		{Config{NATSInboxPrefix: "_INBOX resgate", WSPath: "/"}, Config{}, true},
+		{Config{LocaleResources: []string{"test.>"}, WSPath: "/"}, Config{}, true},
+		{Config{Locale: true, LocaleResources: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{AckRedeliveries: -1, WSPath: "/"}, Config{}, true},
+		{Config{AckBufferSize: map[string]int{"test.>": 10}, WSPath: "/"}, Config{}, true},
+		{Config{AckEvents: []string{"test.>"}, AckBufferSize: map[string]int{"test.>": 0}, WSPath: "/"}, Config{}, true},
+		{Config{AckBufferTTL: map[string]int{"test.>": 1000}, WSPath: "/"}, Config{}, true},
+		{Config{AckEvents: []string{"test.>"}, AckBufferTTL: map[string]int{"test.>": -1}, WSPath: "/"}, Config{}, true},
+		{Config{MetricsPatterns: []string{"test..model"}, WSPath: "/"}, Config{}, true},
+		{Config{MetricsPatterns: []string{""}, WSPath: "/"}, Config{}, true},
+		{Config{InjectQuery: map[string]string{"test.>": "tenant={cid}"}, WSPath: "/"}, Config{}, true},
 	}
 
 	for i, r := range tbl {
@@ -118,6 +270,10 @@ func TestConfigPrepare(t *testing.T) {
 		}
 
 		compareStringPtr(t, "HeaderAuth", cfg.HeaderAuth, r.Expected.HeaderAuth, i)
+
+		if cfg.collectionDiffWindow != r.Expected.collectionDiffWindow {
+			t.Fatalf("expected collectionDiffWindow to be:\n%s\nbut got:\n%s\nin test %d", r.Expected.collectionDiffWindow, cfg.collectionDiffWindow, i+1)
+		}
 	}
 }
 
@@ -188,3 +344,184 @@ func TestMatchesOrigins(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigSharedAccessRID(t *testing.T) {
+	cfg := Config{WSPath: "/", SharedAccess: map[string]string{
+		"test.>":       "test.access",
+		"test.model.*": "test.model.access",
+		"example.foo":  "example.access",
+	}}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	tbl := []struct {
+		ResourceName string
+		Expected     string
+	}{
+		{"test.model", "test.access"},
+		{"test.model.foo", "test.model.access"},
+		{"example.foo", "example.access"},
+		{"example.bar", ""},
+		{"test", ""},
+	}
+	for i, r := range tbl {
+		compareString(t, "sharedAccessRID", cfg.sharedAccessRID(r.ResourceName), r.Expected, i)
+	}
+}
+
+func TestConfigSharedAccessRID_MultipleMatches_UsesMostSpecificPattern(t *testing.T) {
+	cfg := Config{WSPath: "/", SharedAccess: map[string]string{
+		"test.>":         "test.full",
+		"test.*":         "test.single",
+		"test.model.*":   "test.model.single",
+		"test.*.foo":     "test.single.foo",
+		"test.model.foo": "test.model.foo",
+		"x.*.z":          "x.single.z",
+		"*.y.z":          "single.y.z",
+	}}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	tbl := []struct {
+		ResourceName string
+		Expected     string
+	}{
+		{"test.model.foo", "test.model.foo"},
+		{"test.model.bar", "test.model.single"},
+		{"test.other.foo", "test.single.foo"},
+		{"test.model", "test.single"},
+		{"test.model.foo.bar", "test.full"},
+		{"x.y.z", "single.y.z"},
+	}
+	for i, r := range tbl {
+		compareString(t, "sharedAccessRID", cfg.sharedAccessRID(r.ResourceName), r.Expected, i)
+	}
+}
+
+func TestConfigMetricsPattern(t *testing.T) {
+	cfg := Config{
+		WSPath:          "/",
+		MetricsPatterns: []string{"test.model.*", "example.>"},
+		SharedAccess:    map[string]string{"test.>": "test.access"},
+		AccessCacheTTL:  map[string]int{"cached.*": 1000},
+	}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	tbl := []struct {
+		ResourceName string
+		Expected     string
+	}{
+		{"test.model", "test.>"},
+		{"test.model.foo", "test.model.*"},
+		{"example.foo.bar", "example.>"},
+		{"cached.foo", "cached.*"},
+		{"cached.foo.bar", "other"},
+		{"foo", "other"},
+		{"", "other"},
+	}
+	for i, r := range tbl {
+		compareString(t, "metricsPattern", cfg.metricsPattern(r.ResourceName), r.Expected, i)
+	}
+}
+
+func TestConfigTenantLabel(t *testing.T) {
+	cfg := Config{
+		WSPath:      "/",
+		TenantClaim: "org.id",
+		Tenants:     []string{"acme", "globex", "42"},
+	}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	tbl := []struct {
+		Token    string
+		Expected string
+	}{
+		{`{"org":{"id":"acme"}}`, "acme"},
+		{`{"org":{"id":"globex"},"user":"foo"}`, "globex"},
+		{`{"org":{"id":42}}`, "42"},
+		{`{"org":{"id":"initech"}}`, "other"},
+		{`{"org":{"id":null}}`, "other"},
+		{`{"org":{"id":["acme"]}}`, "other"},
+		{`{"org":"acme"}`, "other"},
+		{`{"id":"acme"}`, "other"},
+		{`"acme"`, "other"},
+		{`null`, "other"},
+		{``, "other"},
+	}
+	for i, r := range tbl {
+		compareString(t, "tenantLabel", cfg.tenantLabel(json.RawMessage(r.Token)), r.Expected, i)
+	}
+}
+
+func TestConfigListenAddrs(t *testing.T) {
+	cfg := Config{WSPath: "/", Port: 8080, ListenAddrs: []string{"10.0.0.1:8081", "[::1]:8080", ":9000", "[0:0::1]:8081"}}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	expected := []string{"0.0.0.0:8080", "10.0.0.1:8081", "[::1]:8080", ":9000", "[::1]:8081"}
+	if len(cfg.netAddrs) != len(expected) {
+		t.Fatalf("expected netAddrs to be %#v, but got %#v", expected, cfg.netAddrs)
+	}
+	for i, addr := range expected {
+		compareString(t, "netAddrs", cfg.netAddrs[i], addr, i)
+	}
+}
+
+func TestConfigJWKSCacheTTL(t *testing.T) {
+	tbl := []struct {
+		JWKSCacheTTL int
+		Expected     time.Duration
+	}{
+		{0, DefaultJWKSCacheTTL * time.Millisecond},
+		{5000, 5 * time.Second},
+	}
+	for i, r := range tbl {
+		cfg := Config{WSPath: "/", JWKSURL: "https://example.com/jwks.json", JWKSCacheTTL: r.JWKSCacheTTL}
+		if err := cfg.prepare(); err != nil {
+			t.Fatalf("expected no error, but got:\n%s", err)
+		}
+		if cfg.jwksCacheTTL != r.Expected {
+			t.Errorf("test #%d: expected jwksCacheTTL to be %s, but got %s", i+1, r.Expected, cfg.jwksCacheTTL)
+		}
+	}
+}
+
+// Test isTrustedProxy matches remote addresses against trusted IP addresses
+// and CIDR ranges
+func TestConfigIsTrustedProxy(t *testing.T) {
+	cfg := Config{
+		WSPath:         "/",
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+	}
+	if err := cfg.prepare(); err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+
+	tbl := []struct {
+		RemoteAddr string
+		Expected   bool
+	}{
+		{"10.0.0.1:1234", true},
+		{"10.255.255.255:1234", true},
+		{"192.168.1.1:1234", true},
+		{"[::1]:1234", true},
+		{"192.168.1.1", true},
+		{"11.0.0.1:1234", false},
+		{"192.168.1.2:1234", false},
+		{"[::2]:1234", false},
+		{"", false},
+		{"foo:1234", false},
+	}
+	for i, r := range tbl {
+		if got := cfg.isTrustedProxy(r.RemoteAddr); got != r.Expected {
+			t.Errorf("expected isTrustedProxy(%#v) to be %v, but got %v in test %d", r.RemoteAddr, r.Expected, got, i+1)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// connStats holds the activity counters of a WebSocket connection, used
+// for its summary on disconnect.
+type connStats struct {
+	requests      uint64 // Client requests received. Accessed atomically
+	bytesReceived uint64 // Bytes of messages received. Accessed atomically
+	bytesSent     uint64 // Bytes of messages sent. Accessed atomically
+}
+
+// connSummary is the payload published to the connectionSummarySubject when
+// a WebSocket connection is closed.
+type connSummary struct {
+	CID           string `json:"cid"`
+	Tenant        string `json:"tenant,omitempty"`
+	Duration      int64  `json:"duration"`
+	Requests      uint64 `json:"requests"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	BytesSent     uint64 `json:"bytesSent"`
+	Subscriptions int    `json:"subscriptions"`
+}
+
+// received counts a message of n bytes received from the client.
+func (st *connStats) received(n int) {
+	if st != nil {
+		atomic.AddUint64(&st.requests, 1)
+		atomic.AddUint64(&st.bytesReceived, uint64(n))
+	}
+}
+
+// sent counts a message of n bytes sent to the client.
+func (st *connStats) sent(n int) {
+	if st != nil {
+		atomic.AddUint64(&st.bytesSent, uint64(n))
+	}
+}
+
+// publishSummary publishes the summary of the connection to the
+// connectionSummarySubject, with the number of subscriptions at the time.
+func (c *wsConn) publishSummary(subs int) {
+	if c.stats == nil {
+		return
+	}
+	pc, ok := c.serv.mq.(mq.PublishClient)
+	if !ok {
+		return
+	}
+	payload, _ := json.Marshal(connSummary{
+		CID:           c.cid,
+		Tenant:        c.tenant,
+		Duration:      int64(time.Since(c.connected) / time.Millisecond),
+		Requests:      atomic.LoadUint64(&c.stats.requests),
+		BytesReceived: atomic.LoadUint64(&c.stats.bytesReceived),
+		BytesSent:     atomic.LoadUint64(&c.stats.bytesSent),
+		Subscriptions: subs,
+	})
+	if err := pc.Publish(c.serv.cfg.ConnectionSummarySubject, payload); err != nil && !c.serv.mq.IsClosed() {
+		c.Errorf("Error publishing connection summary: %s", err)
+	}
+}
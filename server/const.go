@@ -7,7 +7,7 @@ const (
 	Version = "1.6.0"
 
 	// ProtocolVersion is the implemented RES protocol version.
-	ProtocolVersion = "1.2.1"
+	ProtocolVersion = "1.2.2"
 
 	// DefaultAddr is the default host for client connections.
 	DefaultAddr = "0.0.0.0"
@@ -24,21 +24,200 @@ const (
 	// DefaultAPIEncoding is the default encoding for web resources.
 	DefaultAPIEncoding = "json"
 
+	// DefaultAllowHeaders is the default list of headers allowed in CORS requests.
+	DefaultAllowHeaders = "content-type, authorization"
+
+	// DefaultHTTPCompressionLevel is the default gzip compression level of
+	// HTTP responses, balancing CPU usage against compression ratio.
+	DefaultHTTPCompressionLevel = 5
+
+	// DefaultHTTPCompressionMinSize is the default size in bytes below which
+	// HTTP responses are not compressed.
+	DefaultHTTPCompressionMinSize = 1024
+
+	// DefaultNATSConnectRetryDelay is the default delay before the first retry
+	// of a failed NATS connection attempt on startup. The delay is doubled for
+	// each retry.
+	DefaultNATSConnectRetryDelay = time.Second
+
+	// NATSConnectMaxRetryDelay is the maximum delay between NATS connection
+	// attempts on startup.
+	NATSConnectMaxRetryDelay = 30 * time.Second
+
+	// DefaultWebhookRetries is the default number of retries of a failed
+	// webhook delivery before the event is moved to the dead letter list.
+	DefaultWebhookRetries = 5
+
+	// DefaultWebhookRetryDelay is the default delay before the first retry of
+	// a failed webhook delivery. The delay is doubled for each retry.
+	DefaultWebhookRetryDelay = time.Second
+
+	// WebhookMaxRetryDelay is the maximum delay between webhook delivery retries.
+	WebhookMaxRetryDelay = time.Minute
+
+	// WebhookTimeout is the timeout of each webhook delivery request.
+	WebhookTimeout = 5 * time.Second
+
+	// WebhookQueueSize is the number of events queued for delivery to a
+	// webhook. Events exceeding it are moved to the dead letter list.
+	WebhookQueueSize = 256
+
+	// WebhookDeadLetterSize is the number of undelivered events kept for each
+	// webhook. Once exceeded, the oldest events are dropped.
+	WebhookDeadLetterSize = 100
+
+	// DefaultAckRedeliveries is the default number of times an unacknowledged
+	// event is redelivered on reconnect before it is dropped.
+	DefaultAckRedeliveries = 3
+
+	// AckPendingLimit is the number of unacknowledged events kept for each
+	// connection. Once exceeded, the oldest events are dropped.
+	AckPendingLimit = 1000
+
+	// AckRetention is the duration the unacknowledged events of a
+	// disconnected client are kept for redelivery.
+	AckRetention = 5 * time.Minute
+
+	// DisconnectLogInfo is the disconnectLog level of logging closed
+	// WebSocket connections as info messages.
+	DisconnectLogInfo = "info"
+
+	// DisconnectLogDebug is the disconnectLog level of logging closed
+	// WebSocket connections as debug messages.
+	DisconnectLogDebug = "debug"
+
+	// PendingGetEventsDiscard is the pendingGetEvents policy of discarding
+	// resource events received while the get request is pending.
+	PendingGetEventsDiscard = "discard"
+
+	// PendingGetEventsBuffer is the pendingGetEvents policy of buffering
+	// resource events received while the get request is pending, applying
+	// them after the get response.
+	PendingGetEventsBuffer = "buffer"
+
+	// MalformedEventsDiscard is the malformedEvents policy of logging and
+	// discarding malformed resource events.
+	MalformedEventsDiscard = "discard"
+
+	// MalformedEventsResync is the malformedEvents policy of fetching the
+	// resource again after a malformed resource event.
+	MalformedEventsResync = "resync"
+
+	// FieldOrderSorted is the fieldOrder mode of encoding model fields sorted
+	// by key.
+	FieldOrderSorted = "sorted"
+
+	// FieldOrderPreserve is the fieldOrder mode of encoding model fields in
+	// the order received from the service.
+	FieldOrderPreserve = "preserve"
+
+	// TrailingBodyDataReject is the trailingBodyData policy of rejecting HTTP
+	// request bodies with data after the JSON params.
+	TrailingBodyDataReject = "reject"
+
+	// TrailingBodyDataIgnore is the trailingBodyData policy of ignoring any
+	// data after the JSON params of HTTP request bodies.
+	TrailingBodyDataIgnore = "ignore"
+
+	// DualCallResponsesError is the dualCallResponses policy of treating a
+	// call response with both a result and a resource as an error.
+	DualCallResponsesError = "error"
+
+	// DualCallResponsesResource is the dualCallResponses policy of using the
+	// resource of a call response with both a result and a resource, logging
+	// a warning.
+	DualCallResponsesResource = "resource"
+
+	// HeaderCID is the NATS message header holding the connection ID, set when
+	// natsHeaders is enabled.
+	HeaderCID = "Resgate-Cid"
+
+	// HeaderTokenSub is the NATS message header holding the sub claim of the
+	// connection's token, set when natsHeaders is enabled.
+	HeaderTokenSub = "Resgate-Token-Sub"
+
+	// HeaderTiming is the HTTP request header asking for server-side timing,
+	// when serverTiming is enabled.
+	HeaderTiming = "Resgate-Timing"
+
 	// WSTimeout is the wait time for WebSocket connections to close on shutdown.
 	WSTimeout = 3 * time.Second
 
+	// MaxConnectionsRetryAfter is the number of seconds a client is told to
+	// wait before retrying, when rejected due to the maxConnections limit.
+	MaxConnectionsRetryAfter = 5
+
+	// WarmupRetryAfter is the number of seconds a client is told to wait
+	// before retrying, when rejected due to a resource warming up.
+	WarmupRetryAfter = 1
+
+	// RequestLimiterQueueSize is the maximum number of requests queued when
+	// the maxConcurrentRequests limit is reached. Requests exceeding it are
+	// rejected.
+	RequestLimiterQueueSize = 10000
+
+	// ConnRequestQueueSize is the maximum number of client requests queued on
+	// a connection when the maxConnRequests limit is reached. Requests
+	// exceeding it are rejected.
+	ConnRequestQueueSize = 256
+
 	// WSConnWorkerQueueSize is the size of the queue for each connection worker.
 	WSConnWorkerQueueSize = 256
 
+	// WSSendQueueHighWaterLogInterval is the minimum interval between two
+	// logged high-water messages for the send queue of a single connection.
+	WSSendQueueHighWaterLogInterval = 10 * time.Second
+
 	// CIDPlaceholder is the placeholder tag for the connection ID.
 	CIDPlaceholder = "{cid}"
 
+	// DefaultWSMaxMessageSize is the default maximum size, in bytes, of an inbound WebSocket message.
+	DefaultWSMaxMessageSize = 1 << 20
+
 	// SubscriptionCountLimit is the subscription limit of a single connection.
 	SubscriptionCountLimit = 256
 
 	// CacheWorkers is the number of goroutines handling cached resources.
 	CacheWorkers = 10
 
+	// DefaultLoadShedWindow is the default window, in milliseconds, over which the service request latency is measured for load shedding.
+	DefaultLoadShedWindow = 5000
+
+	// DefaultAccessBatchWindow is the default window, in milliseconds, during which access requests are collected into a batch.
+	DefaultAccessBatchWindow = 5
+
+	// DefaultLoadShedFraction is the default fraction of new requests rejected while load shedding.
+	DefaultLoadShedFraction = 0.5
+
+	// DefaultJWKSCacheTTL is the default duration, in milliseconds, a fetched JSON Web Key Set is cached.
+	DefaultJWKSCacheTTL = 3600000
+
 	// UnsubscribeDelay is the delay for the cache to unsubscribe and evict resources no longer used.
 	UnsubscribeDelay = 5 * time.Second
+
+	// MsgpackSubprotocol is the WebSocket subprotocol for exchanging msgpack
+	// encoded messages instead of JSON.
+	MsgpackSubprotocol = "msgpack"
+
+	// ReauthenticateCloseText is the close message text sent to WebSocket clients
+	// closed on loss of access to all subscriptions.
+	ReauthenticateCloseText = `{"reason":"reauthenticate"}`
+
+	// ReconnectCloseText is the close message text sent to WebSocket clients
+	// closed on reaching the maximum connection lifetime.
+	ReconnectCloseText = `{"reason":"reconnect"}`
+
+	// EventMirrorBufferSize is the number of mirrored events that may be
+	// queued for publishing before further events are dropped.
+	EventMirrorBufferSize = 1024
+
+	// EventMirrorDropLogInterval is the minimum interval between two logged
+	// messages about dropped mirrored events.
+	EventMirrorDropLogInterval = 10 * time.Second
+
+	// EvictLogLimit is the maximum number of cache eviction debug messages logged per EvictLogInterval.
+	EvictLogLimit = 10
+
+	// EvictLogInterval is the interval over which cache eviction debug messages are limited.
+	EvictLogInterval = time.Second
 )
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// mirroredEvent is the payload of an event published to the
+// eventMirrorSubject when an event is applied to a cached resource.
+type mirroredEvent struct {
+	RID   string          `json:"rid"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// startEventMirror starts the goroutine publishing mirrored events, in the
+// order they are applied.
+// Service.mu is held when called
+func (s *Service) startEventMirror() {
+	if s.cfg.EventMirrorSubject == "" {
+		return
+	}
+	pc, ok := s.mq.(mq.PublishClient)
+	if !ok {
+		s.Logf("Messaging client does not support publishing. Ignoring eventMirrorSubject setting")
+		return
+	}
+	ch := make(chan []byte, EventMirrorBufferSize)
+	done := make(chan struct{})
+	s.mirrorMu.Lock()
+	s.mirrorCh = ch
+	s.mirrorDone = done
+	s.mirrorMu.Unlock()
+
+	go func() {
+		defer close(done)
+		for payload := range ch {
+			if err := pc.Publish(s.cfg.EventMirrorSubject, payload); err != nil && !s.mq.IsClosed() {
+				s.Errorf("Error publishing mirrored event: %s", err)
+			}
+		}
+	}()
+}
+
+// stopEventMirror stops the event mirror, waiting for queued events to be
+// handled.
+func (s *Service) stopEventMirror() {
+	s.mirrorMu.Lock()
+	ch, done := s.mirrorCh, s.mirrorDone
+	s.mirrorCh = nil
+	s.mirrorDone = nil
+	s.mirrorMu.Unlock()
+
+	if ch != nil {
+		close(ch)
+		<-done
+	}
+}
+
+// handleMirrorEvent queues an event applied to a cached resource to be
+// mirrored, if the resource matches eventMirrorResources. The event is
+// dropped if the buffer is full, to not delay the primary event delivery.
+func (s *Service) handleMirrorEvent(rid string, r *rescache.ResourceEvent) {
+	rname, _ := parseRID(rid)
+	if !s.cfg.mirrored(rname) {
+		return
+	}
+	data := r.Payload
+	if bytes.Equal(bytes.TrimSpace(data), nullBytes) {
+		data = nil
+	}
+	payload, err := json.Marshal(mirroredEvent{RID: rid, Event: r.Event, Data: data})
+	if err != nil {
+		s.Errorf("Error encoding mirrored event %s.%s: %s", rid, r.Event, err)
+		return
+	}
+
+	s.mirrorMu.Lock()
+	defer s.mirrorMu.Unlock()
+	if s.mirrorCh == nil {
+		return
+	}
+	select {
+	case s.mirrorCh <- payload:
+	default:
+		if ok, suppressed := s.mirrorLog.allow(); ok {
+			s.Errorf("Event mirror buffer full, dropped event %s.%s, %d drop messages suppressed", rid, r.Event, suppressed)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import "sync/atomic"
+
+// eventSequence assigns sequence numbers to the events of a subscription in
+// the order they are received, and verifies that they are delivered in the
+// same order.
+type eventSequence struct {
+	assigned  uint64 // Last assigned sequence number. Accessed atomically
+	delivered uint64 // Largest delivered sequence number
+}
+
+// next assigns the next sequence number.
+func (es *eventSequence) next() uint64 {
+	return atomic.AddUint64(&es.assigned, 1)
+}
+
+// deliver marks the event with the sequence number as delivered. It returns
+// false, and the largest delivered sequence number, if an event with the same
+// or a later sequence number has already been delivered. Gaps, caused by
+// discarded events, are allowed.
+func (es *eventSequence) deliver(seq uint64) (bool, uint64) {
+	if seq <= es.delivered {
+		return false, es.delivered
+	}
+	es.delivered = seq
+	return true, seq
+}
@@ -0,0 +1,30 @@
+package server
+
+import "testing"
+
+func TestEventSequence(t *testing.T) {
+	tbl := []struct {
+		Order    []int  // Order of delivery, by index of the assigned sequence numbers
+		Expected []bool // Expected delivery results
+	}{
+		{[]int{0, 1, 2}, []bool{true, true, true}},
+		{[]int{0, 2}, []bool{true, true}},
+		{[]int{1, 0, 2}, []bool{true, false, true}},
+		{[]int{0, 2, 1}, []bool{true, true, false}},
+		{[]int{0, 0, 1}, []bool{true, false, true}},
+	}
+
+	for i, r := range tbl {
+		var es eventSequence
+		seqs := []uint64{es.next(), es.next(), es.next()}
+		for j, idx := range r.Order {
+			ok, last := es.deliver(seqs[idx])
+			if ok != r.Expected[j] {
+				t.Fatalf("expected delivery #%d of sequence %d to be %v, but got %v, in test %d", j+1, seqs[idx], r.Expected[j], ok, i+1)
+			}
+			if !ok && last < seqs[idx] {
+				t.Fatalf("expected last delivered sequence to be greater than or equal to %d, but got %d, in test %d", seqs[idx], last, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// fallbackClient wraps a mq.Client, resending access and auth requests for
+// resources matching a primary resource pattern to the fallback resource if
+// the request to the primary resource times out.
+type fallbackClient struct {
+	mq.Client
+	fallbacks resourceAliases
+}
+
+// SendRequest sends the request, and resends it on the subject of any
+// fallback resource if it times out.
+func (c *fallbackClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends the request with any headers and timeout, and
+// resends it the same way on the subject of any fallback resource if it times
+// out.
+func (c *fallbackClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	fsubj := c.fallbackSubject(subj)
+	if fsubj == "" {
+		mq.SendRequest(c.Client, subj, header, payload, timeout, cb)
+		return
+	}
+	mq.SendRequest(c.Client, subj, header, payload, timeout, func(rsubj string, data []byte, err error) {
+		if err != mq.ErrRequestTimeout {
+			cb(rsubj, data, err)
+			return
+		}
+		mq.SendRequest(c.Client, fsubj, header, payload, timeout, cb)
+	})
+}
+
+// fallbackSubject returns the subject of the request on the fallback
+// resource, or an empty string if the request is not an access or auth
+// request for a resource with a fallback.
+func (c *fallbackClient) fallbackSubject(subj string) string {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return ""
+	}
+	rname, method := subj[idx+1:], ""
+	switch subj[:idx] {
+	case "auth":
+		i := strings.LastIndexByte(rname, '.')
+		if i < 0 {
+			return ""
+		}
+		rname, method = rname[:i], rname[i:]
+	case "access":
+	default:
+		return ""
+	}
+	fallback, ok := c.fallbacks.resolve(rname)
+	if !ok {
+		return ""
+	}
+	return subj[:idx+1] + fallback + method
+}
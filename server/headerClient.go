@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// headerClient wraps a mq.Client that supports message headers, setting
+// headers with the request metadata found in the payload. The payload itself
+// is sent unaltered.
+type headerClient struct {
+	mq.Client
+	forwardHeaders []string
+}
+
+// headerPayload holds the request payload fields copied to headers.
+type headerPayload struct {
+	CID    string              `json:"cid"`
+	Token  json.RawMessage     `json:"token"`
+	Header map[string][]string `json:"header"`
+}
+
+// newHeaderClient returns a headerClient wrapping the client, or the client
+// itself if it does not support message headers.
+func newHeaderClient(c mq.Client, forwardHeaders []string) mq.Client {
+	if _, ok := c.(mq.HeaderClient); !ok {
+		return c
+	}
+	return &headerClient{Client: c, forwardHeaders: forwardHeaders}
+}
+
+// SendRequest sends the request with headers for the connection ID, the
+// token sub claim, and any forwarded HTTP headers. Requests without any such
+// metadata, such as get requests, are sent without headers.
+func (c *headerClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends the request with the request metadata headers
+// added to any headers set, and with the timeout if greater than zero.
+func (c *headerClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	var p headerPayload
+	if json.Unmarshal(payload, &p) != nil {
+		mq.SendRequest(c.Client, subj, header, payload, timeout, cb)
+		return
+	}
+
+	h := make(map[string][]string, len(header))
+	for k, v := range header {
+		h[k] = v
+	}
+	if p.CID != "" {
+		h[HeaderCID] = []string{p.CID}
+	}
+	if len(p.Token) > 0 {
+		var t struct {
+			Sub string `json:"sub"`
+		}
+		if json.Unmarshal(p.Token, &t) == nil && t.Sub != "" {
+			h[HeaderTokenSub] = []string{t.Sub}
+		}
+	}
+	for _, k := range c.forwardHeaders {
+		if v, ok := p.Header[k]; ok {
+			h[k] = v
+		}
+	}
+
+	if len(h) == 0 {
+		h = nil
+	}
+	mq.SendRequest(c.Client, subj, h, payload, timeout, cb)
+}
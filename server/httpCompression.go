@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// patternCompression is a resource pattern mapped to HTTP compression
+// settings.
+type patternCompression struct {
+	pattern rescache.ResourcePattern
+	level   int
+	minSize int
+}
+
+// patternCompressions is a list of HTTP compression settings, sorted by
+// specificity of the patterns, with a last entry holding the default
+// settings, matching any resource.
+type patternCompressions []patternCompression
+
+// parseHTTPCompression validates the HTTP compression settings, and returns
+// the settings for each override pattern, followed by the default settings.
+// Returns nil if compression is disabled.
+func (c *Config) parseHTTPCompression() (patternCompressions, error) {
+	level, err := validateCompressionLevel("httpCompressionLevel", c.HTTPCompressionLevel, DefaultHTTPCompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	minSize, err := validateCompressionMinSize("httpCompressionMinSize", c.HTTPCompressionMinSize, DefaultHTTPCompressionMinSize)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := make([]string, 0, len(c.HTTPCompressionOverrides))
+	for p := range c.HTTPCompressionOverrides {
+		patterns = append(patterns, p)
+	}
+	rps, err := parsePatterns("httpCompressionOverrides", patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := make(patternCompressions, 0, len(patterns)+1)
+	for i, p := range patterns {
+		o := c.HTTPCompressionOverrides[p]
+		setting := "httpCompressionOverrides setting for " + p
+		l, err := validateCompressionLevel(setting+" level", o.Level, level)
+		if err != nil {
+			return nil, err
+		}
+		ms, err := validateCompressionMinSize(setting+" minSize", o.MinSize, minSize)
+		if err != nil {
+			return nil, err
+		}
+		pc = append(pc, patternCompression{pattern: rps[i], level: l, minSize: ms})
+	}
+
+	if !c.HTTPCompression {
+		return nil, nil
+	}
+	return append(pc, patternCompression{level: level, minSize: minSize}), nil
+}
+
+func validateCompressionLevel(setting string, v, def int) (int, error) {
+	if v == 0 {
+		return def, nil
+	}
+	if v < gzip.BestSpeed || v > gzip.BestCompression {
+		return 0, fmt.Errorf("invalid %s (%d)\n\tmust be a compression level between %d and %d", setting, v, gzip.BestSpeed, gzip.BestCompression)
+	}
+	return v, nil
+}
+
+func validateCompressionMinSize(setting string, v, def int) (int, error) {
+	if v == 0 {
+		return def, nil
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("invalid %s (%d)\n\tmust be a positive number of bytes", setting, v)
+	}
+	return v, nil
+}
+
+// match returns the compression settings of the first pattern matching the
+// resource name, or the default settings.
+func (pc patternCompressions) match(rname string) patternCompression {
+	last := len(pc) - 1
+	for _, p := range pc[:last] {
+		if p.pattern.Match(rname) {
+			return p
+		}
+	}
+	return pc[last]
+}
+
+// writeCompressed writes the HTTP response body, gzip compressed if
+// compression is enabled, the request accepts gzip encoding, and the body is
+// no smaller than the minimum size configured for the resource.
+func (s *Service) writeCompressed(w http.ResponseWriter, r *http.Request, rid string, status int, out []byte) {
+	if s.cfg.httpCompression == nil {
+		writeStatus(w, status, out)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	rname, _ := parseRID(rid)
+	pc := s.cfg.httpCompression.match(rname)
+	if len(out) < pc.minSize || !acceptsGzip(r.Header["Accept-Encoding"]) {
+		writeStatus(w, status, out)
+		return
+	}
+
+	var b bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&b, pc.level)
+	if err == nil {
+		_, err = gw.Write(out)
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if err != nil {
+		s.Errorf("Error compressing response for %s: %s", rid, err)
+		writeStatus(w, status, out)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	writeStatus(w, status, b.Bytes())
+}
+
+// writeStatus writes the response status, unless zero, followed by the body.
+func writeStatus(w http.ResponseWriter, status int, out []byte) {
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	w.Write(out)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header values accept gzip
+// encoding, either explicitly or by wildcard, with a non-zero quality value.
+func acceptsGzip(values []string) bool {
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			params := strings.Split(part, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			q := 1.0
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+						q = f
+					}
+				}
+			}
+			if q > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -11,31 +13,74 @@ func (s *Service) initHTTPServer() {
 }
 
 // startHTTPServer initializes the server and starts a goroutine with a http server
+// for each listen address, all sharing the same handler. An error is returned if
+// any of the addresses could not be bound.
 // Service.mu is held when called
-func (s *Service) startHTTPServer() {
+func (s *Service) startHTTPServer() error {
 	if s.cfg.NoHTTP {
-		return
+		return nil
+	}
+
+	lns, err := listenAll(s.cfg.netAddrs, s.cfg.listenOptions)
+	if err != nil {
+		return err
 	}
 
-	s.Logf("Listening on %s://%s", s.cfg.scheme, s.cfg.netAddr)
 	h := &http.Server{Addr: s.cfg.netAddr, Handler: s}
 	s.h = h
 
-	go func() {
-		var err error
-		if s.cfg.TLS {
-			err = h.ListenAndServeTLS(s.cfg.TLSCert, s.cfg.TLSKey)
-		} else {
-			err = h.ListenAndServe()
-		}
+	for _, ln := range lns {
+		s.Logf("Listening on %s://%s", s.cfg.scheme, ln.Addr())
+		go func(ln net.Listener) {
+			var err error
+			if s.cfg.TLS {
+				err = h.ServeTLS(ln, s.cfg.TLSCert, s.cfg.TLSKey)
+			} else {
+				err = h.Serve(ln)
+			}
+
+			if err != nil && err != http.ErrServerClosed {
+				s.Stop(err)
+			}
+		}(ln)
+	}
+	return nil
+}
+
+// listenOptions holds socket options for the TCP listeners.
+type listenOptions struct {
+	backlog   int           // Accept backlog. Zero means the OS default.
+	reusePort bool          // Set SO_REUSEPORT on the listening socket.
+	keepAlive time.Duration // Keep-alive period. Zero means the default, negative disables.
+}
 
+// listenAll binds a TCP listener to each address, using the socket options.
+// If any address fails, all listeners already bound are closed, and an error
+// is returned.
+func listenAll(addrs []string, opts listenOptions) ([]net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: opts.keepAlive}
+	if opts.reusePort {
+		lc.Control = controlReusePort
+	}
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err == nil && opts.backlog > 0 {
+			if err = setListenBacklog(ln, opts.backlog); err != nil {
+				ln.Close()
+			}
+		}
 		if err != nil {
-			s.Stop(err)
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %s", addr, err)
 		}
-	}()
+		lns = append(lns, ln)
+	}
+	return lns, nil
 }
 
-// stopHTTPServer stops the http server
 func (s *Service) stopHTTPServer() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -71,6 +116,8 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case r.URL.Path == s.cfg.WSPath:
 		s.wsHandler(w, r)
+	case s.cfg.WebhookPath != "" && (r.URL.Path == s.cfg.WebhookPath || strings.HasPrefix(r.URL.Path, s.cfg.WebhookPath+"/")):
+		s.webhookHandler(w, r)
 	case strings.HasPrefix(r.URL.Path, s.cfg.APIPath):
 		s.apiHandler(w, r)
 	default:
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenAll_WithFreeAddresses_BindsAll(t *testing.T) {
+	lns, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"}, listenOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	defer func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	}()
+	if len(lns) != 2 {
+		t.Fatalf("expected 2 listeners, but got %d", len(lns))
+	}
+}
+
+func TestListenAll_WithAddressInUse_ReturnsErrorAndClosesListeners(t *testing.T) {
+	used, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	defer used.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	lns, err := listenAll([]string{freeAddr, used.Addr().String()}, listenOptions{})
+	if err == nil {
+		for _, ln := range lns {
+			ln.Close()
+		}
+		t.Fatal("expected an error, but got none")
+	}
+
+	// Assert the first listener was closed
+	ln, err := net.Listen("tcp", freeAddr)
+	if err != nil {
+		t.Fatalf("expected address %s to be released, but got:\n%s", freeAddr, err)
+	}
+	ln.Close()
+}
+
+func TestListenAll_WithReusePort_BindsSameAddressTwice(t *testing.T) {
+	if !listenOptionsSupported {
+		t.Skip("reusePort not supported on this platform")
+	}
+	opts := listenOptions{reusePort: true}
+	first, err := listenAll([]string{"127.0.0.1:0"}, opts)
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	defer first[0].Close()
+
+	second, err := listenAll([]string{first[0].Addr().String()}, opts)
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	second[0].Close()
+}
+
+func TestListenAll_WithBacklog_AcceptsConnections(t *testing.T) {
+	if !listenOptionsSupported {
+		t.Skip("listenBacklog not supported on this platform")
+	}
+	lns, err := listenAll([]string{"127.0.0.1:0"}, listenOptions{backlog: 16, keepAlive: -1})
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	defer lns[0].Close()
+
+	conn, err := net.Dial("tcp", lns[0].Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, but got:\n%s", err)
+	}
+	conn.Close()
+}
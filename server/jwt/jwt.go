@@ -0,0 +1,417 @@
+// Package jwt provides validation of the signature and expiration time of
+// JSON Web Tokens, signed with RSA or ECDSA keys. Keys are either a static
+// public key, or fetched from a JSON Web Key Set (JWKS) endpoint.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// Register hash functions used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Validation errors
+var (
+	ErrMalformed      = errors.New("malformed token")
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+	ErrUnknownKey     = errors.New("no matching key")
+	ErrSignature      = errors.New("invalid signature")
+	ErrExpired        = errors.New("token is expired")
+	ErrNotValidYet    = errors.New("token is not valid yet")
+)
+
+// jwksMinRefetch is the minimum duration between fetching the key set when
+// a token refers to an unknown key ID.
+const jwksMinRefetch = 30 * time.Second
+
+// jwksTimeout is the timeout for requests to the JWKS endpoint.
+const jwksTimeout = 5 * time.Second
+
+// Validator validates JSON Web Tokens.
+type Validator struct {
+	key     crypto.PublicKey
+	jwksURL string
+	ttl     time.Duration
+	client  *http.Client
+	now     func() time.Time
+
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey // Key set by key ID
+	fetched    time.Time                   // Time of last attempted fetch
+	fetching   chan struct{}               // Closed once the ongoing fetch is done, or nil
+	minRefetch time.Duration
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type claims struct {
+	Exp *json.Number `json:"exp"`
+	Nbf *json.Number `json:"nbf"`
+}
+
+type algorithm struct {
+	hash crypto.Hash
+	ec   bool
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {crypto.SHA256, false},
+	"RS384": {crypto.SHA384, false},
+	"RS512": {crypto.SHA512, false},
+	"ES256": {crypto.SHA256, true},
+	"ES384": {crypto.SHA384, true},
+	"ES512": {crypto.SHA512, true},
+}
+
+// NewValidator creates a new Validator using the PEM encoded public key, the
+// key set of the JWKS URL, or both. The key set is cached for the duration of
+// ttl, and refetched if a token refers to an unknown key ID.
+func NewValidator(publicKeyPEM []byte, jwksURL string, ttl time.Duration) (*Validator, error) {
+	v := &Validator{
+		jwksURL:    jwksURL,
+		ttl:        ttl,
+		client:     &http.Client{Timeout: jwksTimeout},
+		now:        time.Now,
+		minRefetch: jwksMinRefetch,
+	}
+	if len(publicKeyPEM) > 0 {
+		key, err := ParsePublicKey(publicKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		v.key = key
+	}
+	if v.key == nil && jwksURL == "" {
+		return nil, errors.New("no public key or JWKS URL")
+	}
+	return v, nil
+}
+
+// ParsePublicKey parses a PEM encoded RSA or ECDSA public key, either as a
+// PKIX public key, a PKCS #1 RSA public key, or an X.509 certificate.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("key must be an RSA or ECDSA public key")
+}
+
+// Validate verifies the signature of the token, and that the token is not
+// expired. It returns the expiration time, or the zero time if the token has
+// no exp claim.
+func (v *Validator) Validate(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return time.Time{}, ErrMalformed
+	}
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return time.Time{}, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return time.Time{}, ErrMalformed
+	}
+
+	alg, ok := algorithms[h.Alg]
+	if !ok {
+		return time.Time{}, ErrUnsupportedAlg
+	}
+	hh := alg.hash.New()
+	hh.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hh.Sum(nil)
+
+	keys := v.keysFor(h.Kid)
+	if len(keys) == 0 {
+		return time.Time{}, ErrUnknownKey
+	}
+	verified := false
+	for _, key := range keys {
+		if verify(key, alg, digest, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return time.Time{}, ErrSignature
+	}
+
+	now := v.now()
+	if c.Nbf != nil {
+		nbf, err := numericDate(*c.Nbf)
+		if err != nil {
+			return time.Time{}, ErrMalformed
+		}
+		if now.Before(nbf) {
+			return time.Time{}, ErrNotValidYet
+		}
+	}
+	var exp time.Time
+	if c.Exp != nil {
+		if exp, err = numericDate(*c.Exp); err != nil {
+			return time.Time{}, ErrMalformed
+		}
+		if !now.Before(exp) {
+			return exp, ErrExpired
+		}
+	}
+	return exp, nil
+}
+
+// keysFor returns the keys to try when verifying a token with the key ID.
+// If the key ID is not found in the key set, the set is refetched, unless
+// recently fetched. An expired key set is refetched in the background, while
+// the cached keys are used.
+func (v *Validator) keysFor(kid string) []crypto.PublicKey {
+	if v.jwksURL == "" {
+		return []crypto.PublicKey{v.key}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	if v.keys == nil {
+		v.wait(v.fetch(now))
+	} else if now.Sub(v.fetched) >= v.ttl {
+		v.fetch(now)
+	}
+	if kid != "" {
+		if key, ok := v.keys[kid]; ok {
+			return []crypto.PublicKey{key}
+		}
+		if v.fetching != nil || now.Sub(v.fetched) >= v.minRefetch {
+			v.wait(v.fetch(now))
+			if key, ok := v.keys[kid]; ok {
+				return []crypto.PublicKey{key}
+			}
+		}
+		if v.key != nil {
+			return []crypto.PublicKey{v.key}
+		}
+		return nil
+	}
+
+	// Without a key ID, try the static key and all keys in the set
+	keys := make([]crypto.PublicKey, 0, len(v.keys)+1)
+	if v.key != nil {
+		keys = append(keys, v.key)
+	}
+	for _, key := range v.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// fetch starts fetching the key set from the JWKS URL in the background,
+// unless a fetch is already ongoing, and returns a channel closed once the
+// fetch is done. On failure, any previously fetched key set is kept.
+// v.mu is held when called.
+func (v *Validator) fetch(now time.Time) <-chan struct{} {
+	if v.fetching != nil {
+		return v.fetching
+	}
+	done := make(chan struct{})
+	v.fetching = done
+	v.fetched = now
+	go func() {
+		keys, err := fetchKeySet(v.client, v.jwksURL)
+		v.mu.Lock()
+		if err == nil {
+			v.keys = keys
+		} else if v.keys == nil {
+			v.keys = make(map[string]crypto.PublicKey)
+		}
+		v.fetching = nil
+		v.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// wait releases v.mu until the fetch is done.
+// v.mu is held when called.
+func (v *Validator) wait(done <-chan struct{}) {
+	v.mu.Unlock()
+	<-done
+	v.mu.Lock()
+}
+
+func verify(key crypto.PublicKey, alg algorithm, digest, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return !alg.ec && rsa.VerifyPKCS1v15(k, alg.hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !alg.ec || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// numericDate converts a JSON numeric date, the number of seconds since the
+// epoch, to a time.
+func numericDate(n json.Number) (time.Time, error) {
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), nil
+}
+
+// jwk represents a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeySet fetches a JSON Web Key Set, and returns the RSA and EC
+// signature keys by key ID. Keys of other types are ignored.
+func fetchKeySet(client *http.Client, url string) (map[string]crypto.PublicKey, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", i)
+		}
+		keys[kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
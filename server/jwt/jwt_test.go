@@ -0,0 +1,262 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _    = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign creates a token with the claims, signed by the key.
+func sign(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	h, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(input))
+	sum := digest.Sum(nil)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return input + "." + b64(sig)
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func rsaJWK(kid string, k *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes())}
+}
+
+func future() int64 { return time.Now().Add(time.Hour).Unix() }
+func past() int64   { return time.Now().Add(-time.Hour).Unix() }
+
+func TestValidate_WithPublicKey(t *testing.T) {
+	tbl := []struct {
+		Token string
+		Err   error
+	}{
+		{sign(t, rsaKey, "", map[string]interface{}{"exp": future()}), nil},
+		{sign(t, rsaKey, "", map[string]interface{}{"sub": "foo"}), nil},
+		{sign(t, rsaKey, "", map[string]interface{}{"exp": past()}), ErrExpired},
+		{sign(t, rsaKey, "", map[string]interface{}{"nbf": future()}), ErrNotValidYet},
+		{sign(t, otherKey, "", map[string]interface{}{"exp": future()}), ErrSignature},
+		{sign(t, ecKey, "", map[string]interface{}{"exp": future()}), ErrSignature},
+		{"foo.bar", ErrMalformed},
+		{"e30.e30.@", ErrMalformed},
+		{b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{}`)) + ".", ErrUnsupportedAlg},
+		{b64([]byte(`{"alg":"HS256"}`)) + "." + b64([]byte(`{}`)) + ".", ErrUnsupportedAlg},
+	}
+
+	v, err := NewValidator(publicKeyPEM(t, &rsaKey.PublicKey), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range tbl {
+		if _, err := v.Validate(l.Token); err != l.Err {
+			t.Errorf("test #%d: expected error %v, but got %v", i+1, l.Err, err)
+		}
+	}
+}
+
+func TestValidate_WithECPublicKey(t *testing.T) {
+	v, err := NewValidator(publicKeyPEM(t, &ecKey.PublicKey), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := future()
+	got, err := v.Validate(sign(t, ecKey, "", map[string]interface{}{"exp": exp}))
+	if err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+	if got.Unix() != exp {
+		t.Fatalf("expected exp %d, but got %d", exp, got.Unix())
+	}
+}
+
+func TestValidate_WithJWKS(t *testing.T) {
+	var keys atomic.Value
+	keys.Store([]map[string]string{rsaJWK("rsa", &rsaKey.PublicKey), ecJWK("ec", &ecKey.PublicKey)})
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys.Load()})
+	}))
+	defer srv.Close()
+
+	v, err := NewValidator(nil, srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.minRefetch = 0
+
+	tbl := []struct {
+		Token string
+		Err   error
+	}{
+		{sign(t, rsaKey, "rsa", map[string]interface{}{"exp": future()}), nil},
+		{sign(t, ecKey, "ec", map[string]interface{}{"exp": future()}), nil},
+		{sign(t, ecKey, "", map[string]interface{}{"exp": future()}), nil},
+		{sign(t, rsaKey, "rsa", map[string]interface{}{"exp": past()}), ErrExpired},
+		{sign(t, otherKey, "rsa", map[string]interface{}{"exp": future()}), ErrSignature},
+		{sign(t, otherKey, "unknown", map[string]interface{}{"exp": future()}), ErrUnknownKey},
+	}
+	for i, l := range tbl {
+		if _, err := v.Validate(l.Token); err != l.Err {
+			t.Errorf("test #%d: expected error %v, but got %v", i+1, l.Err, err)
+		}
+	}
+
+	// Rotate keys and validate the new key is fetched
+	keys.Store([]map[string]string{rsaJWK("rotated", &otherKey.PublicKey)})
+	if _, err := v.Validate(sign(t, otherKey, "rotated", map[string]interface{}{"exp": future()})); err != nil {
+		t.Fatalf("expected no error after key rotation, but got %s", err)
+	}
+}
+
+func TestValidate_WithJWKS_CachesKeySet(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{rsaJWK("rsa", &rsaKey.PublicKey)}})
+	}))
+	defer srv.Close()
+
+	v, err := NewValidator(nil, srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, rsaKey, "rsa", map[string]interface{}{"exp": future()})
+	unknown := sign(t, rsaKey, "unknown", map[string]interface{}{"exp": future()})
+	for i := 0; i < 3; i++ {
+		v.Validate(token)
+		v.Validate(unknown)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected 1 fetch, but got %d", n)
+	}
+
+	// Expire the cache
+	now := time.Now()
+	v.now = func() time.Time { return now.Add(2 * time.Hour) }
+	v.Validate(sign(t, rsaKey, "rsa", map[string]interface{}{"exp": now.Add(3 * time.Hour).Unix()}))
+	waitFetches(t, &fetches, 2)
+}
+
+func TestValidate_WithExpiredJWKS_UsesCachedKeysWhileRefetching(t *testing.T) {
+	var fetches int32
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{rsaJWK("rsa", &rsaKey.PublicKey)}})
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	v, err := NewValidator(nil, srv.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(sign(t, rsaKey, "rsa", map[string]interface{}{"exp": future()})); err != nil {
+		t.Fatalf("expected no error, but got %s", err)
+	}
+
+	// Expire the cache, and validate the cached key is used while the
+	// refetch is blocked
+	now := time.Now()
+	v.now = func() time.Time { return now.Add(2 * time.Hour) }
+	for i := 0; i < 3; i++ {
+		done := make(chan error, 1)
+		go func() {
+			_, err := v.Validate(sign(t, rsaKey, "rsa", map[string]interface{}{"exp": now.Add(3 * time.Hour).Unix()}))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("expected no error, but got %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected validation not to wait for the refetch")
+		}
+	}
+	waitFetches(t, &fetches, 2)
+}
+
+// waitFetches waits for the number of fetches to reach n.
+func waitFetches(t *testing.T, fetches *int32, n int32) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(fetches) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c := atomic.LoadInt32(fetches); c != n {
+		t.Fatalf("expected %d fetches, but got %d", n, c)
+	}
+}
+
+func TestNewValidator_WithInvalidKey_ReturnsError(t *testing.T) {
+	tbl := [][]byte{
+		[]byte("not a key"),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("invalid")}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+	}
+	for i, l := range tbl {
+		if _, err := NewValidator(l, "", 0); err == nil {
+			t.Errorf("test #%d: expected an error, but got none", i+1)
+		}
+	}
+	if _, err := NewValidator(nil, "", 0); err == nil {
+		t.Error("expected an error without key or JWKS URL, but got none")
+	}
+}
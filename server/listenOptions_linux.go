@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// soReusePort is the SO_REUSEPORT socket option, not defined by the syscall
+// package for Linux.
+const soReusePort = 0x0f
+
+// listenOptionsSupported reports whether the listenBacklog and reusePort
+// settings are supported on the platform.
+const listenOptionsSupported = true
+
+// controlReusePort sets SO_REUSEPORT on the socket before it is bound.
+func controlReusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setListenBacklog sets the accept backlog of the listener by calling listen
+// again on the listening socket.
+func setListenBacklog(ln net.Listener, backlog int) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var lerr error
+	err = rc.Control(func(fd uintptr) {
+		lerr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return lerr
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errListenOptionsUnsupported = errors.New("socket option not supported on this platform")
+
+// listenOptionsSupported reports whether the listenBacklog and reusePort
+// settings are supported on the platform.
+const listenOptionsSupported = false
+
+func controlReusePort(network, address string, c syscall.RawConn) error {
+	return errListenOptionsUnsupported
+}
+
+func setListenBacklog(ln net.Listener, backlog int) error {
+	return errListenOptionsUnsupported
+}
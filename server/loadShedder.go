@@ -0,0 +1,102 @@
+package server
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// loadShedder tracks the latency of requests sent to the services, and
+// rejects a fraction of new requests while the average latency of the last
+// completed window exceeds the threshold.
+type loadShedder struct {
+	threshold time.Duration
+	window    time.Duration
+	fraction  float64
+
+	mu          sync.Mutex
+	windowStart time.Time
+	sum         time.Duration
+	count       int
+	shedding    bool
+	rnd         *rand.Rand
+	now         func() time.Time
+	onChange    func(shedding bool)
+}
+
+func newLoadShedder(threshold, window time.Duration, fraction float64, onChange func(bool)) *loadShedder {
+	return &loadShedder{
+		threshold: threshold,
+		window:    window,
+		fraction:  fraction,
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:       time.Now,
+		onChange:  onChange,
+	}
+}
+
+// observe adds the latency of a completed service request.
+func (ls *loadShedder) observe(d time.Duration) {
+	ls.mu.Lock()
+	ls.rollWindow(ls.now())
+	ls.sum += d
+	ls.count++
+	ls.mu.Unlock()
+}
+
+// shed reports whether a new request should be rejected.
+func (ls *loadShedder) shed() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.rollWindow(ls.now())
+	return ls.shedding && ls.rnd.Float64() < ls.fraction
+}
+
+// rollWindow evaluates the current window if it has passed, and starts a new
+// one. A window without any observed latency turns off shedding.
+// ls.mu is held when called.
+func (ls *loadShedder) rollWindow(now time.Time) {
+	if ls.windowStart.IsZero() {
+		ls.windowStart = now
+		return
+	}
+	if now.Sub(ls.windowStart) < ls.window {
+		return
+	}
+
+	shedding := ls.count > 0 && ls.sum/time.Duration(ls.count) > ls.threshold
+	ls.windowStart = now
+	ls.sum = 0
+	ls.count = 0
+	if shedding != ls.shedding {
+		ls.shedding = shedding
+		if ls.onChange != nil {
+			ls.onChange(shedding)
+		}
+	}
+}
+
+// timedClient is a mq.Client that reports the duration of each request.
+type timedClient struct {
+	mq.Client
+	observe func(subj string, d time.Duration, err error)
+}
+
+// SendRequest sends an asynchronous request, and reports the duration once
+// the response is received.
+func (c *timedClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends an asynchronous request with any headers and
+// timeout, and reports the duration and any error once the response is
+// received.
+func (c *timedClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	start := time.Now()
+	mq.SendRequest(c.Client, subj, header, payload, timeout, func(rsubj string, data []byte, err error) {
+		c.observe(subj, time.Since(start), err)
+		cb(rsubj, data, err)
+	})
+}
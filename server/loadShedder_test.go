@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	now := time.Now()
+	var changes []bool
+	ls := newLoadShedder(10*time.Millisecond, time.Second, 1, func(shedding bool) {
+		changes = append(changes, shedding)
+	})
+	ls.now = func() time.Time { return now }
+
+	assertShed := func(expected bool) {
+		t.Helper()
+		if ls.shed() != expected {
+			t.Fatalf("expected shed to be %v, but it wasn't", expected)
+		}
+	}
+
+	// First window with high latency
+	assertShed(false)
+	ls.observe(5 * time.Millisecond)
+	ls.observe(25 * time.Millisecond)
+	now = now.Add(500 * time.Millisecond)
+	assertShed(false)
+
+	// Second window starts shedding
+	now = now.Add(500 * time.Millisecond)
+	assertShed(true)
+	ls.observe(5 * time.Millisecond)
+
+	// Third window with low latency stops shedding
+	now = now.Add(time.Second)
+	assertShed(false)
+
+	// Fourth window starts shedding, and is followed by a window without requests
+	ls.observe(50 * time.Millisecond)
+	now = now.Add(time.Second)
+	assertShed(true)
+	now = now.Add(time.Second)
+	assertShed(false)
+
+	expected := []bool{true, false, true, false}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected changes %v, but got %v", expected, changes)
+	}
+	for i, c := range changes {
+		if c != expected[i] {
+			t.Fatalf("expected changes %v, but got %v", expected, changes)
+		}
+	}
+}
+
+func TestLoadShedder_WithFraction_ShedsSomeRequests(t *testing.T) {
+	now := time.Now()
+	ls := newLoadShedder(10*time.Millisecond, time.Second, 0.5, nil)
+	ls.now = func() time.Time { return now }
+	ls.shed()
+	ls.observe(time.Second)
+	now = now.Add(time.Second)
+
+	shed := 0
+	for i := 0; i < 1000; i++ {
+		if ls.shed() {
+			shed++
+		}
+	}
+	if shed < 300 || shed > 700 {
+		t.Fatalf("expected about half of the requests to be shed, but %d out of 1000 were", shed)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requestLocale returns the normalized locale of the HTTP request, or an
+// empty string if none is found. If useQuery is true, a locale query
+// parameter takes precedence over the Accept-Language header.
+func requestLocale(r *http.Request, useQuery bool) string {
+	if r == nil {
+		return ""
+	}
+	if useQuery && r.URL != nil {
+		if v := r.URL.Query().Get("locale"); v != "" {
+			return normalizeLocale(v)
+		}
+	}
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// parseAcceptLanguage returns the normalized language tag with the highest
+// quality value in an Accept-Language header value. Wildcards, malformed
+// tags, and tags with a quality value of zero are ignored.
+func parseAcceptLanguage(s string) string {
+	var best string
+	bestQ := 0.0
+	for _, part := range strings.Split(s, ",") {
+		tag := part
+		q := 1.0
+		if idx := strings.IndexByte(part, ';'); idx >= 0 {
+			tag = part[:idx]
+			param := strings.TrimSpace(part[idx+1:])
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				continue
+			}
+			q = v
+		}
+		if q <= bestQ {
+			continue
+		}
+		if l := normalizeLocale(strings.TrimSpace(tag)); l != "" {
+			best = l
+			bestQ = q
+		}
+	}
+	return best
+}
+
+// normalizeLocale returns the language tag with the language in lower case,
+// the region in upper case, and the script in title case, using hyphen as
+// separator. An empty string is returned if the tag is malformed.
+func normalizeLocale(tag string) string {
+	if tag == "" || tag == "*" {
+		return ""
+	}
+	subtags := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	if len(subtags) == 0 || strings.Count(tag, "-")+strings.Count(tag, "_") != len(subtags)-1 {
+		return ""
+	}
+	for i, st := range subtags {
+		if len(st) > 8 || !isAlphanumeric(st) {
+			return ""
+		}
+		st = strings.ToLower(st)
+		switch {
+		case i == 0:
+			if !isAlpha(st) {
+				return ""
+			}
+		case len(st) == 2 && isAlpha(st):
+			st = strings.ToUpper(st)
+		case len(st) == 4 && isAlpha(st):
+			st = strings.ToUpper(st[:1]) + st[1:]
+		}
+		subtags[i] = st
+	}
+	return strings.Join(subtags, "-")
+}
+
+// isAlpha reports whether s consists of ASCII letters only.
+func isAlpha(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlphanumeric reports whether s consists of ASCII letters and digits only.
+func isAlphanumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && !isAlpha(s[i:i+1]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tbl := []struct {
+		Tag      string
+		Expected string
+	}{
+		{"en", "en"},
+		{"EN", "en"},
+		{"en-us", "en-US"},
+		{"en_GB", "en-GB"},
+		{"zh-hant-tw", "zh-Hant-TW"},
+		{"es-419", "es-419"},
+		{"de-CH-1901", "de-CH-1901"},
+		{"", ""},
+		{"*", ""},
+		{"en-", ""},
+		{"-en", ""},
+		{"en--US", ""},
+		{"1234", ""},
+		{"en US", ""},
+		{"en-toolongsubtag", ""},
+	}
+
+	for i, r := range tbl {
+		compareString(t, "normalizeLocale", normalizeLocale(r.Tag), r.Expected, i)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tbl := []struct {
+		Header   string
+		Expected string
+	}{
+		{"", ""},
+		{"sv-se", "sv-SE"},
+		{"da, en-GB;q=0.8, en;q=0.7", "da"},
+		{"en;q=0.7, fr-ca;q=0.9", "fr-CA"},
+		{"en;q=0.8, de;q=0.8", "en"},
+		{"*, sv;q=0.5", "sv"},
+		{"*", ""},
+		{"en;q=0", ""},
+		{"en;q=foo, de;q=0.1", "de"},
+		{"en US, de", "de"},
+	}
+
+	for i, r := range tbl {
+		compareString(t, "parseAcceptLanguage", parseAcceptLanguage(r.Header), r.Expected, i)
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	tbl := []struct {
+		URL            string
+		AcceptLanguage string
+		UseQuery       bool
+		Expected       string
+	}{
+		{"/", "", true, ""},
+		{"/", "en-us", true, "en-US"},
+		{"/?locale=sv_se", "en-us", true, "sv-SE"},
+		{"/?locale=sv_se", "en-us", false, "en-US"},
+		{"/?locale=", "en-us", true, "en-US"},
+	}
+
+	for i, r := range tbl {
+		u, _ := url.Parse(r.URL)
+		req := &http.Request{URL: u, Header: http.Header{}}
+		if r.AcceptLanguage != "" {
+			req.Header.Set("Accept-Language", r.AcceptLanguage)
+		}
+		compareString(t, "requestLocale", requestLocale(req, r.UseQuery), r.Expected, i)
+	}
+}
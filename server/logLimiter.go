@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// logLimiter limits the rate of log messages, allowing at most max messages
+// per interval. Messages not allowed are counted as suppressed.
+type logLimiter struct {
+	max      int
+	interval time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	suppressed  int
+	now         func() time.Time
+}
+
+func newLogLimiter(max int, interval time.Duration) *logLimiter {
+	return &logLimiter{
+		max:      max,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// allow reports whether a message may be logged. If allowed, it also returns
+// the number of messages suppressed since the last allowed message.
+func (ll *logLimiter) allow() (bool, int) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := ll.now()
+	if now.Sub(ll.windowStart) >= ll.interval {
+		ll.windowStart = now
+		ll.count = 0
+	}
+	if ll.count >= ll.max {
+		ll.suppressed++
+		return false, 0
+	}
+	ll.count++
+	suppressed := ll.suppressed
+	ll.suppressed = 0
+	return true, suppressed
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogLimiter_AllowsMaxPerInterval(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ll := newLogLimiter(2, time.Second)
+	ll.now = func() time.Time { return now }
+
+	expected := []bool{true, true, false, false}
+	for i, exp := range expected {
+		if ok, _ := ll.allow(); ok != exp {
+			t.Errorf("expected allow #%d to be %v, but got %v", i+1, exp, ok)
+		}
+	}
+
+	now = now.Add(time.Second)
+	ok, suppressed := ll.allow()
+	if !ok {
+		t.Fatalf("expected allow after interval to be true")
+	}
+	if suppressed != 2 {
+		t.Errorf("expected 2 suppressed messages, but got %d", suppressed)
+	}
+	if _, suppressed = ll.allow(); suppressed != 0 {
+		t.Errorf("expected suppressed count to be reset, but got %d", suppressed)
+	}
+}
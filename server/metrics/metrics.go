@@ -0,0 +1,379 @@
+// Package metrics provides a lightweight set of metrics, exposed in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector is implemented by all metrics types.
+type Collector interface {
+	// collect writes all samples of the metric, with the given name.
+	collect(w io.Writer, name string)
+	// typ returns the metric type name.
+	typ() string
+}
+
+// Registry holds a set of named metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*entry
+}
+
+type entry struct {
+	help string
+	c    Collector
+}
+
+// NewRegistry creates a new Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*entry)}
+}
+
+// Register adds a metric to the registry. It panics if a metric with the
+// same name is already registered.
+func (r *Registry) Register(name, help string, c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.metrics[name] = &entry{help: help, c: c}
+}
+
+// Write writes all metrics in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	entries := make(map[string]*entry, len(names))
+	for k, v := range r.metrics {
+		entries[k] = v
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		e := entries[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, e.help, name, e.c.typ())
+		e.c.collect(w, name)
+	}
+}
+
+// ServeHTTP writes all metrics as the response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v uint64
+}
+
+// NewCounter creates a new Counter.
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Value returns the current counter value.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+func (c *Counter) typ() string { return "counter" }
+
+func (c *Counter) collect(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, c.Value())
+}
+
+// Gauge is a value that may go up and down.
+type Gauge struct {
+	v int64
+}
+
+// NewGauge creates a new Gauge.
+func NewGauge() *Gauge {
+	return &Gauge{}
+}
+
+// Set sets the gauge value.
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.v, v)
+}
+
+// Add adds n, which may be negative, to the gauge value.
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.v, n)
+}
+
+// Value returns the current gauge value.
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+func (g *Gauge) typ() string { return "gauge" }
+
+func (g *Gauge) collect(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %d\n", name, g.Value())
+}
+
+// Summary tracks the count and sum of observed values.
+type Summary struct {
+	mu    sync.Mutex
+	count uint64
+	sum   float64
+}
+
+// NewSummary creates a new Summary.
+func NewSummary() *Summary {
+	return &Summary{}
+}
+
+// Observe adds an observed value.
+func (s *Summary) Observe(v float64) {
+	s.mu.Lock()
+	s.count++
+	s.sum += v
+	s.mu.Unlock()
+}
+
+// Values returns the count and sum of observed values.
+func (s *Summary) Values() (uint64, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.sum
+}
+
+func (s *Summary) typ() string { return "summary" }
+
+func (s *Summary) collect(w io.Writer, name string) {
+	count, sum := s.Values()
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(sum), name, count)
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	labels   []string
+	mu       sync.Mutex
+	counters map[string]*labeledCounter
+}
+
+type labeledCounter struct {
+	values []string
+	c      *Counter
+}
+
+// NewCounterVec creates a new CounterVec with the given label names.
+func NewCounterVec(labels ...string) *CounterVec {
+	return &CounterVec{
+		labels:   labels,
+		counters: make(map[string]*labeledCounter),
+	}
+}
+
+// With returns the counter for the label values, creating it if needed.
+// It panics if the number of values differs from the number of labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic("metrics: label value count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	lc, ok := v.counters[key]
+	if !ok {
+		lc = &labeledCounter{values: append([]string(nil), values...), c: NewCounter()}
+		v.counters[key] = lc
+	}
+	return lc.c
+}
+
+func (v *CounterVec) typ() string { return "counter" }
+
+func (v *CounterVec) collect(w io.Writer, name string) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.counters))
+	for k := range v.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lcs := make([]*labeledCounter, len(keys))
+	for i, k := range keys {
+		lcs[i] = v.counters[k]
+	}
+	v.mu.Unlock()
+
+	for _, lc := range lcs {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, formatLabels(v.labels, lc.values), lc.c.Value())
+	}
+}
+
+// GaugeVec is a set of gauges partitioned by label values.
+type GaugeVec struct {
+	labels []string
+	mu     sync.Mutex
+	gauges map[string]*labeledGauge
+}
+
+type labeledGauge struct {
+	values []string
+	g      *Gauge
+}
+
+// NewGaugeVec creates a new GaugeVec with the given label names.
+func NewGaugeVec(labels ...string) *GaugeVec {
+	return &GaugeVec{
+		labels: labels,
+		gauges: make(map[string]*labeledGauge),
+	}
+}
+
+// With returns the gauge for the label values, creating it if needed.
+// It panics if the number of values differs from the number of labels.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	if len(values) != len(v.labels) {
+		panic("metrics: label value count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	lg, ok := v.gauges[key]
+	if !ok {
+		lg = &labeledGauge{values: append([]string(nil), values...), g: NewGauge()}
+		v.gauges[key] = lg
+	}
+	return lg.g
+}
+
+func (v *GaugeVec) typ() string { return "gauge" }
+
+func (v *GaugeVec) collect(w io.Writer, name string) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.gauges))
+	for k := range v.gauges {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lgs := make([]*labeledGauge, len(keys))
+	for i, k := range keys {
+		lgs[i] = v.gauges[k]
+	}
+	v.mu.Unlock()
+
+	for _, lg := range lgs {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, formatLabels(v.labels, lg.values), lg.g.Value())
+	}
+}
+
+// SummaryVec is a set of summaries partitioned by label values.
+type SummaryVec struct {
+	labels    []string
+	mu        sync.Mutex
+	summaries map[string]*labeledSummary
+}
+
+type labeledSummary struct {
+	values []string
+	s      *Summary
+}
+
+// NewSummaryVec creates a new SummaryVec with the given label names.
+func NewSummaryVec(labels ...string) *SummaryVec {
+	return &SummaryVec{
+		labels:    labels,
+		summaries: make(map[string]*labeledSummary),
+	}
+}
+
+// With returns the summary for the label values, creating it if needed.
+// It panics if the number of values differs from the number of labels.
+func (v *SummaryVec) With(values ...string) *Summary {
+	if len(values) != len(v.labels) {
+		panic("metrics: label value count mismatch")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ls, ok := v.summaries[key]
+	if !ok {
+		ls = &labeledSummary{values: append([]string(nil), values...), s: NewSummary()}
+		v.summaries[key] = ls
+	}
+	return ls.s
+}
+
+func (v *SummaryVec) typ() string { return "summary" }
+
+func (v *SummaryVec) collect(w io.Writer, name string) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.summaries))
+	for k := range v.summaries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lss := make([]*labeledSummary, len(keys))
+	for i, k := range keys {
+		lss[i] = v.summaries[k]
+	}
+	v.mu.Unlock()
+
+	for _, ls := range lss {
+		labels := formatLabels(v.labels, ls.values)
+		count, sum := ls.s.Values()
+		fmt.Fprintf(w, "%s_sum{%s} %s\n%s_count{%s} %d\n", name, labels, formatFloat(sum), name, labels, count)
+	}
+}
+
+// formatLabels returns label names and values formatted as name="value" pairs.
+func formatLabels(labels, values []string) string {
+	var b strings.Builder
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter()
+	g := NewGauge()
+	s := NewSummary()
+	v := NewCounterVec("pattern", "code")
+	sv := NewSummaryVec("type")
+	gv := NewGaugeVec("tenant")
+	r.Register("test_counter", "Counter help.", c)
+	r.Register("test_gauge", "Gauge help.", g)
+	r.Register("test_summary", "Summary help.", s)
+	r.Register("test_vec", "Vec help.", v)
+	r.Register("test_summary_vec", "Summary vec help.", sv)
+	r.Register("test_gauge_vec", "Gauge vec help.", gv)
+
+	c.Inc()
+	c.Add(2)
+	g.Set(5)
+	g.Add(-2)
+	s.Observe(0.25)
+	s.Observe(0.5)
+	v.With("test.>", "ok").Inc()
+	v.With("a\"b", "err").Add(4)
+	sv.With("get").Observe(1.5)
+	sv.With("get").Observe(0.5)
+	sv.With("access").Observe(0.125)
+	gv.With("foo").Add(2)
+	gv.With("bar").Set(1)
+	gv.With("foo").Add(-1)
+
+	var b bytes.Buffer
+	r.Write(&b)
+	expected := `# HELP test_counter Counter help.
+# TYPE test_counter counter
+test_counter 3
+# HELP test_gauge Gauge help.
+# TYPE test_gauge gauge
+test_gauge 3
+# HELP test_gauge_vec Gauge vec help.
+# TYPE test_gauge_vec gauge
+test_gauge_vec{tenant="bar"} 1
+test_gauge_vec{tenant="foo"} 1
+# HELP test_summary Summary help.
+# TYPE test_summary summary
+test_summary_sum 0.75
+test_summary_count 2
+# HELP test_summary_vec Summary vec help.
+# TYPE test_summary_vec summary
+test_summary_vec_sum{type="access"} 0.125
+test_summary_vec_count{type="access"} 1
+test_summary_vec_sum{type="get"} 2
+test_summary_vec_count{type="get"} 2
+# HELP test_vec Vec help.
+# TYPE test_vec counter
+test_vec{pattern="a\"b",code="err"} 4
+test_vec{pattern="test.>",code="ok"} 1
+`
+	if b.String() != expected {
+		t.Fatalf("expected output:\n%s\nbut got:\n%s", expected, b.String())
+	}
+}
+
+func TestRegistryRegister_WithDuplicateName_Panics(t *testing.T) {
+	r := NewRegistry()
+	r.Register("test", "", NewCounter())
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic, but got none")
+		}
+	}()
+	r.Register("test", "", NewGauge())
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/metrics"
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// otherMetricsPattern is the pattern label used for requests on resources
+// not matching any configured pattern.
+const otherMetricsPattern = "other"
+
+// readyPath is the path on the metrics server of the readiness endpoint.
+const readyPath = "/ready"
+
+// otherMetricsTenant is the tenant label used for connections without a
+// token claim matching any configured tenant.
+const otherMetricsTenant = "other"
+
+// serviceMetrics holds the metrics collected by the service.
+type serviceMetrics struct {
+	registry          *metrics.Registry
+	mqRequestDuration *metrics.Summary
+	mqPatternDuration *metrics.SummaryVec
+	mqPatternTimeouts *metrics.CounterVec
+	mqQueueWait       *metrics.SummaryVec
+	mqQueueLength     *metrics.Gauge
+	loadShed          *metrics.Counter
+	loadShedding      *metrics.Gauge
+	cacheEvictions    *metrics.CounterVec
+	tenantConns       *metrics.GaugeVec
+	tenantRequests    *metrics.CounterVec
+	eventSeqAnomalies *metrics.Counter
+}
+
+func (s *Service) initMetrics() {
+	m := serviceMetrics{
+		registry:          metrics.NewRegistry(),
+		mqRequestDuration: metrics.NewSummary(),
+		mqPatternDuration: metrics.NewSummaryVec("type", "pattern"),
+		mqPatternTimeouts: metrics.NewCounterVec("type", "pattern"),
+		mqQueueWait:       metrics.NewSummaryVec("priority"),
+		mqQueueLength:     metrics.NewGauge(),
+		loadShed:          metrics.NewCounter(),
+		loadShedding:      metrics.NewGauge(),
+		cacheEvictions:    metrics.NewCounterVec("reason"),
+		tenantConns:       metrics.NewGaugeVec("tenant"),
+		tenantRequests:    metrics.NewCounterVec("tenant"),
+		eventSeqAnomalies: metrics.NewCounter(),
+	}
+	m.registry.Register("resgate_mq_request_duration_seconds", "Duration of requests sent to services.", m.mqRequestDuration)
+	m.registry.Register("resgate_mq_pattern_request_duration_seconds", "Duration of requests sent to services, by request type and resource pattern.", m.mqPatternDuration)
+	m.registry.Register("resgate_mq_pattern_request_timeouts_total", "Number of requests sent to services that timed out, by request type and resource pattern.", m.mqPatternTimeouts)
+	m.registry.Register("resgate_mq_request_queue_wait_seconds", "Time requests wait for a concurrency slot before being sent to services, by priority.", m.mqQueueWait)
+	m.registry.Register("resgate_mq_request_queue_length", "Number of requests waiting for a concurrency slot before being sent to services.", m.mqQueueLength)
+	m.registry.Register("resgate_load_shed_total", "Number of requests rejected by load shedding.", m.loadShed)
+	m.registry.Register("resgate_load_shedding", "Set to 1 while load shedding, otherwise 0.", m.loadShedding)
+	m.registry.Register("resgate_cache_evictions_total", "Number of resources evicted from the cache, by reason.", m.cacheEvictions)
+	if s.cfg.EventSequence {
+		m.registry.Register("resgate_event_sequence_anomalies_total", "Number of events about to be delivered out of order to a client.", m.eventSeqAnomalies)
+	}
+	if s.cfg.tenants != nil {
+		m.registry.Register("resgate_tenant_connections", "Number of WebSocket connections, by tenant.", m.tenantConns)
+		m.registry.Register("resgate_tenant_requests_total", "Number of client requests received over WebSocket, by tenant.", m.tenantRequests)
+	}
+	s.metrics = m
+
+	if s.cfg.loadShedLatency > 0 {
+		s.shedder = newLoadShedder(s.cfg.loadShedLatency, s.cfg.loadShedWindow, s.cfg.loadShedFraction, s.handleLoadSheddingChange)
+	}
+}
+
+// GetMetricsHandler returns the metrics http.Handler
+// Used for testing purposes
+func (s *Service) GetMetricsHandler() http.Handler {
+	return s.metrics.registry
+}
+
+// GetReadyHandler returns the readiness http.Handler, responding with 200 OK
+// once connected to the messaging system, otherwise 503 Service Unavailable.
+// Used for testing purposes
+func (s *Service) GetReadyHandler() http.Handler {
+	return http.HandlerFunc(s.serveReady)
+}
+
+func (s *Service) serveReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.mqReady) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready"))
+}
+
+// startMetricsServer starts a goroutine with a http server serving metrics,
+// and the readiness endpoint on readyPath, if a metrics port is configured.
+// Service.mu is held when called
+func (s *Service) startMetricsServer() {
+	if s.cfg.NoHTTP || s.cfg.metricsNetAddr == "" {
+		return
+	}
+
+	s.Logf("Serving metrics on http://%s", s.cfg.metricsNetAddr)
+	mux := http.NewServeMux()
+	mux.Handle("/", s.metrics.registry)
+	mux.HandleFunc(readyPath, s.serveReady)
+	h := &http.Server{Addr: s.cfg.metricsNetAddr, Handler: mux}
+	s.mh = h
+
+	go func() {
+		if err := h.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.Stop(err)
+		}
+	}()
+}
+
+// stopMetricsServer stops the metrics http server
+func (s *Service) stopMetricsServer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mh == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.mh.Shutdown(ctx)
+	s.mh = nil
+}
+
+// observeMQRequest records the duration of a completed request sent to the
+// services, and counts it if it timed out.
+func (s *Service) observeMQRequest(subj string, d time.Duration, err error) {
+	s.metrics.mqRequestDuration.Observe(d.Seconds())
+	typ, rname := parseRequestSubject(subj)
+	pattern := s.cfg.metricsPattern(rname)
+	s.metrics.mqPatternDuration.With(typ, pattern).Observe(d.Seconds())
+	if err == mq.ErrRequestTimeout {
+		s.metrics.mqPatternTimeouts.With(typ, pattern).Inc()
+	}
+	if s.shedder != nil {
+		s.shedder.observe(d)
+	}
+}
+
+// observeQueueWait records the time a request waited in the request queue
+// before being sent to the services.
+func (s *Service) observeQueueWait(priority int, d time.Duration) {
+	s.metrics.mqQueueWait.With(strconv.Itoa(priority)).Observe(d.Seconds())
+}
+
+// setQueueLength records the number of requests waiting in the request queue.
+func (s *Service) setQueueLength(n int) {
+	s.metrics.mqQueueLength.Set(int64(n))
+}
+
+// parseRequestSubject splits a service request subject into the request type
+// and the resource name. For call and auth requests, the method is excluded.
+func parseRequestSubject(subj string) (typ string, rname string) {
+	idx := strings.IndexByte(subj, '.')
+	if idx < 0 {
+		return subj, ""
+	}
+	typ, rname = subj[:idx], subj[idx+1:]
+	if typ == "call" || typ == "auth" {
+		if idx = strings.LastIndexByte(rname, '.'); idx >= 0 {
+			rname = rname[:idx]
+		}
+	}
+	return typ, rname
+}
+
+// shedLoad reports whether a new request should be rejected due to load
+// shedding, and counts the rejection.
+func (s *Service) shedLoad() bool {
+	if s.shedder == nil || !s.shedder.shed() {
+		return false
+	}
+	s.metrics.loadShed.Inc()
+	return true
+}
+
+// errLoadShed returns the error for requests rejected due to load shedding,
+// telling the client to retry once the load shed window has passed.
+func (s *Service) errLoadShed() *reserr.Error {
+	return reserr.ErrServiceUnavailable.WithRetryAfter(s.cfg.loadShedWindow)
+}
+
+func (s *Service) handleLoadSheddingChange(shedding bool) {
+	if shedding {
+		s.metrics.loadShedding.Set(1)
+		s.Logf("Service request latency exceeds %s. Load shedding started", s.cfg.loadShedLatency)
+		if s.cfg.BackpressureLoadShed {
+			s.broadcastLoadShedBackpressure()
+		}
+	} else {
+		s.metrics.loadShedding.Set(0)
+		s.Logf("Load shedding stopped")
+	}
+}
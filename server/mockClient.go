@@ -0,0 +1,46 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// mockAccessResponse is the access response for mocked resources.
+var mockAccessResponse = []byte(`{"result":{"get":true}}`)
+
+// mockClient wraps a mq.Client, responding to get and access requests for
+// resources matching the mock patterns with canned responses, without sending
+// the requests to the services. Other requests are passed through.
+// Only intended for testing.
+type mockClient struct {
+	mq.Client
+	mocks patternValues // Get responses by resource pattern
+}
+
+// SendRequest responds to get and access requests for mocked resources, and
+// passes any other request to the underlying client.
+func (c *mockClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout responds to requests for mocked resources the same
+// way as SendRequest, and passes any other request, with any headers and
+// timeout, to the underlying client.
+func (c *mockClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	idx := strings.IndexByte(subj, '.')
+	if idx >= 0 {
+		if v, ok := c.mocks.match(subj[idx+1:]); ok {
+			switch subj[:idx] {
+			case "get":
+				go cb(subj, []byte(v), nil)
+				return
+			case "access":
+				go cb(subj, mockAccessResponse, nil)
+				return
+			}
+		}
+	}
+	mq.SendRequest(c.Client, subj, header, payload, timeout, cb)
+}
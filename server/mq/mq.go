@@ -1,6 +1,10 @@
 package mq
 
-import "github.com/resgateio/resgate/server/reserr"
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
 
 // Response sends a response to the messaging system
 type Response func(subj string, payload []byte, err error)
@@ -22,6 +26,8 @@ type Client interface {
 
 	// Subscribe to all events on a resource namespace.
 	// The namespace has the format "event."+resource
+	// The resource may be a resource pattern. A namespace ending with the
+	// full wildcard, ">", matches events on all resources below it.
 	Subscribe(namespace string, cb Response) (Unsubscriber, error)
 
 	// Close closes the connection.
@@ -32,6 +38,68 @@ type Client interface {
 
 	// Sets the closed handler
 	SetClosedHandler(cb func(error))
+
+	// Timeout returns the duration after which a request times out, unless
+	// the timeout is extended by a pre-response.
+	Timeout() time.Duration
+}
+
+// HeaderClient is implemented by a Client that supports message headers.
+type HeaderClient interface {
+	// SendRequestWithHeader sends an asynchronous request on a subject, with
+	// the message headers set, expecting the Response callback to be called
+	// once.
+	SendRequestWithHeader(subject string, header map[string][]string, payload []byte, cb Response)
+}
+
+// TimeoutClient is implemented by a Client that supports setting the timeout
+// of a single request.
+type TimeoutClient interface {
+	// SendRequestWithTimeout sends an asynchronous request on a subject, with
+	// the message headers set unless nil, expecting the Response callback to
+	// be called once. The request times out after the timeout duration,
+	// unless the timeout is extended by a pre-response.
+	SendRequestWithTimeout(subject string, header map[string][]string, payload []byte, timeout time.Duration, cb Response)
+}
+
+// ReconnectClient is implemented by a Client that can reconnect after a lost
+// connection, instead of closing it.
+type ReconnectClient interface {
+	// SetReconnectHandler sets a handler called each time the connection is
+	// reestablished, with all subscriptions restored. If set before Connect,
+	// the client reconnects after a lost connection, calling the closed
+	// handler only once it gives up.
+	SetReconnectHandler(cb func())
+}
+
+// InboxClient is implemented by a Client that supports setting the subject
+// prefix of the reply inboxes used for requests.
+type InboxClient interface {
+	// SetInboxPrefix sets the prefix of the reply inbox subjects. It must be
+	// called before Connect.
+	SetInboxPrefix(prefix string)
+}
+
+// PublishClient is implemented by a Client that can publish messages not
+// expecting a response.
+type PublishClient interface {
+	// Publish publishes the payload on a subject.
+	Publish(subject string, payload []byte) error
+}
+
+// SendRequest sends an asynchronous request using the client, with the
+// message headers set unless nil, and with the timeout if greater than zero.
+// Headers and timeout are ignored if not supported by the client.
+func SendRequest(c Client, subject string, header map[string][]string, payload []byte, timeout time.Duration, cb Response) {
+	if tc, ok := c.(TimeoutClient); ok && timeout > 0 {
+		tc.SendRequestWithTimeout(subject, header, payload, timeout, cb)
+		return
+	}
+	if hc, ok := c.(HeaderClient); ok && header != nil {
+		hc.SendRequestWithHeader(subject, header, payload, cb)
+		return
+	}
+	c.SendRequest(subject, payload, cb)
 }
 
 // ErrRequestTimeout is the error the client should pass to the Response
@@ -1,36 +1,233 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/rescache"
 )
 
 func (s *Service) initMQClient() {
-	s.cache = rescache.NewCache(s.mq, CacheWorkers, UnsubscribeDelay, s.logger)
+	var c mq.Client = s.mq
+	if s.cfg.NATSHeaders {
+		c = newHeaderClient(c, s.cfg.forwardHeaders)
+	}
+	if s.cfg.MaxResponseSize > 0 {
+		c = &responseSizeClient{Client: c, max: s.cfg.MaxResponseSize, logf: s.Errorf}
+	}
+	if s.cfg.requestFallbacks != nil {
+		c = &fallbackClient{Client: c, fallbacks: s.cfg.requestFallbacks}
+	}
+	if s.cfg.resourceAliases != nil {
+		c = &aliasClient{Client: c, aliases: s.cfg.resourceAliases}
+	}
+	c = &timedClient{Client: c, observe: s.observeMQRequest}
+	if s.cfg.MaxConcurrentRequests > 0 {
+		c = newRequestLimiter(c, s.cfg.MaxConcurrentRequests, s.cfg.RequestPriority, s.observeQueueWait, s.setQueueLength)
+	}
+	if s.cfg.DangerouslyEnableMockResources {
+		c = &mockClient{Client: c, mocks: s.cfg.mockResources}
+	}
+	s.cache = rescache.NewCache(c, CacheWorkers, UnsubscribeDelay, s.logger)
+	s.cache.SetRequestDeadline(s.cfg.RequestDeadline)
+	s.cache.SetMaxQueryVariations(s.cfg.MaxQueryVariations)
+	s.cache.SetBufferPendingEvents(s.cfg.PendingGetEvents == PendingGetEventsBuffer)
+	s.cache.SetResyncMalformedEvents(s.cfg.MalformedEvents == MalformedEventsResync)
+	s.cache.SetStrictResponses(s.cfg.StrictResponses)
+	s.cache.SetStrictGetResponses(s.cfg.StrictGetResponses)
+	s.cache.SetPreserveFieldOrder(s.cfg.FieldOrder == FieldOrderPreserve)
+	s.cache.SetPreferCallResource(s.cfg.DualCallResponses == DualCallResponsesResource)
+	if s.cfg.orderingDomains != nil {
+		s.cache.SetOrderingDomains(s.cfg.orderingDomains)
+	}
+	if s.cfg.maxCacheAge != nil {
+		s.cache.SetMaxCacheAge(func(rname string) time.Duration {
+			d, _ := s.cfg.maxCacheAge.match(rname)
+			return d
+		})
+	}
+	if s.cfg.retainedResources != nil {
+		s.cache.SetRetainedResources(s.cfg.retained)
+	}
+	s.evictLog = newLogLimiter(EvictLogLimit, EvictLogInterval)
+	s.cache.SetEvictHandler(s.handleCacheEvict)
+	if s.cfg.subscriberThresholds != nil {
+		s.cache.SetCountHandler(s.handleSubscriberCount)
+	}
+	if s.cfg.EventMirrorSubject != "" {
+		s.mirrorLog = newLogLimiter(1, EventMirrorDropLogInterval)
+		s.cache.SetEventHandler(s.handleMirrorEvent)
+	}
+	if s.cfg.notFoundDefault != nil {
+		s.cache.SetNotFoundDefault(func(rname string) (json.RawMessage, bool) {
+			v, ok := s.cfg.notFoundDefault.match(rname)
+			return json.RawMessage(v), ok
+		})
+	}
+}
+
+// handleCacheEvict counts the eviction of a resource from the cache, and
+// logs it in debug mode. Logging is rate limited to avoid flooding the log
+// when many resources are evicted at once.
+func (s *Service) handleCacheEvict(rname string, reason rescache.EvictReason) {
+	s.metrics.cacheEvictions.With(string(reason)).Inc()
+	if !s.logger.IsDebug() {
+		return
+	}
+	ok, suppressed := s.evictLog.allow()
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		s.Debugf("Evicted %s from cache (%s), %d eviction messages suppressed", rname, reason, suppressed)
+	} else {
+		s.Debugf("Evicted %s from cache (%s)", rname, reason)
+	}
+}
+
+// subscriberThresholdEvent is the payload of an event published when the
+// subscriber count of a resource crosses a subscriber threshold.
+type subscriberThresholdEvent struct {
+	RID       string `json:"rid"`
+	Count     int64  `json:"count"`
+	Threshold int    `json:"threshold"`
+	Direction string `json:"direction"`
+}
+
+// handleSubscriberCount publishes a subscriber threshold event for each
+// threshold crossed by the change in subscriber count of a resource.
+func (s *Service) handleSubscriberCount(rname string, old, new int64) {
+	pc, ok := s.mq.(mq.PublishClient)
+	if !ok {
+		return
+	}
+	for _, n := range s.cfg.subscriberThresholds {
+		t := int64(n)
+		var dir string
+		switch {
+		case old <= t && new > t:
+			dir = "up"
+		case old > t && new <= t:
+			dir = "down"
+		default:
+			continue
+		}
+		payload, _ := json.Marshal(subscriberThresholdEvent{RID: rname, Count: new, Threshold: n, Direction: dir})
+		if err := pc.Publish(s.cfg.SubscriberThresholdSubject, payload); err != nil {
+			s.Errorf("Error publishing subscriber threshold event for %s: %s", rname, err)
+		}
+	}
 }
 
 // startMQClients creates a connection to the messaging system.
 // Service.mu is held when called
 func (s *Service) startMQClient() error {
-	if err := s.mq.Connect(); err != nil {
+	if s.cfg.NATSReconnect {
+		if rc, ok := s.mq.(mq.ReconnectClient); ok {
+			rc.SetReconnectHandler(s.handleReconnectedMQ)
+		} else {
+			s.Logf("Messaging client does not support reconnects. Ignoring natsReconnect setting")
+		}
+	}
+	if s.cfg.NATSInboxPrefix != "" {
+		if ic, ok := s.mq.(mq.InboxClient); ok {
+			ic.SetInboxPrefix(s.cfg.NATSInboxPrefix)
+		} else {
+			s.Logf("Messaging client does not support inbox prefixes. Ignoring natsInboxPrefix setting")
+		}
+	}
+	if s.cfg.subscriberThresholds != nil {
+		if _, ok := s.mq.(mq.PublishClient); !ok {
+			s.Logf("Messaging client does not support publishing. Ignoring subscriberThresholds setting")
+		}
+	}
+	if err := s.connectMQ(); err != nil {
 		return err
 	}
 
+	s.startEventMirror()
 	if err := s.cache.Start(); err != nil {
 		return err
 	}
 
 	s.mq.SetClosedHandler(s.handleClosedMQ)
+	atomic.StoreInt32(&s.mqReady, 1)
 	return nil
 }
 
+// connectMQ connects to the messaging system. A failed attempt is retried up
+// to natsConnectRetries times, doubling the delay for each retry.
+// Service.mu is held when called, but is released while waiting to retry, to
+// let the service be stopped.
+func (s *Service) connectMQ() error {
+	delay := s.cfg.natsConnectRetryDelay
+	for i := 0; ; i++ {
+		err := s.mq.Connect()
+		if err == nil {
+			return nil
+		}
+		if i >= s.cfg.NATSConnectRetries {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("failed to connect to NATS after %d attempts: %s", i+1, err)
+		}
+		s.Logf("Failed to connect to NATS, retrying in %s: %s", delay, err)
+		if !s.waitConnectRetry(delay) {
+			return errors.New("server stopped while connecting to NATS")
+		}
+		if delay *= 2; delay > NATSConnectMaxRetryDelay {
+			delay = NATSConnectMaxRetryDelay
+		}
+	}
+}
+
+// waitConnectRetry releases Service.mu and waits for the delay to pass before
+// retrying to connect. It returns false if the service was stopped while
+// waiting.
+// Service.mu is held when called
+func (s *Service) waitConnectRetry(delay time.Duration) bool {
+	ch := make(chan struct{})
+	s.mqConnect = ch
+	s.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	stopped := false
+	select {
+	case <-t.C:
+	case <-ch:
+		stopped = true
+	}
+
+	s.mu.Lock()
+	if s.mqConnect == ch {
+		s.mqConnect = nil
+	}
+	return !stopped
+}
+
 // stopMQClient closes the connection to the nats server
 func (s *Service) stopMQClient() {
+	atomic.StoreInt32(&s.mqReady, 0)
 	s.mq.Close()
 	s.Debugf("Stopping cache workers...")
 	s.cache.Stop()
 	s.Debugf("Cache workers stopped")
+	s.stopEventMirror()
 }
 
 func (s *Service) handleClosedMQ(err error) {
 	s.Stop(err)
 }
+
+// handleReconnectedMQ resets the cache after reconnecting to the messaging
+// system, as events may have been lost while disconnected.
+func (s *Service) handleReconnectedMQ() {
+	s.Logf("Resetting cached resources after reconnect")
+	s.cache.Reset()
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// patternValue is a resource pattern mapped to a configured value.
+type patternValue struct {
+	pattern rescache.ResourcePattern
+	value   string
+}
+
+// patternValues is a list of resource patterns mapped to configured values,
+// sorted by specificity of the patterns.
+type patternValues []patternValue
+
+var errInvalidResourceName = errors.New("must be a valid resource name")
+
+// parsePatternValues parses a map of resource patterns to values, where each
+// value is validated by the validate function. The setting name is used in
+// any error returned.
+func parsePatternValues(setting string, m map[string]string, validate func(v string) error) (patternValues, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	patterns := make([]string, 0, len(m))
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+	rps, err := parsePatterns(setting, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	pv := make(patternValues, 0, len(patterns))
+	for i, p := range patterns {
+		v := m[p]
+		if err := validate(v); err != nil {
+			return nil, fmt.Errorf("invalid %s setting for %s (%s)\n\t%s", setting, p, v, err)
+		}
+		pv = append(pv, patternValue{pattern: rps[i], value: v})
+	}
+	return pv, nil
+}
+
+// parsePatterns sorts the patterns by specificity, and returns them parsed in
+// the same order.
+func parsePatterns(setting string, patterns []string) ([]rescache.ResourcePattern, error) {
+	sort.Slice(patterns, func(i, j int) bool {
+		return morePatternSpecific(patterns[i], patterns[j])
+	})
+	rps := make([]rescache.ResourcePattern, len(patterns))
+	for i, p := range patterns {
+		rp := rescache.ParseResourcePattern(p)
+		if !rp.IsValid() {
+			return nil, fmt.Errorf("invalid %s setting (%s)\n\tmust be a valid resource pattern", setting, p)
+		}
+		rps[i] = rp
+	}
+	return rps, nil
+}
+
+// morePatternSpecific reports whether pattern a is more specific than pattern
+// b. A pattern with more literal tokens is more specific, followed by one with
+// fewer wildcards, and then one without a full wildcard, ">". Patterns equally
+// specific are ordered lexically.
+func morePatternSpecific(a, b string) bool {
+	la, wa := patternTokenCount(a)
+	lb, wb := patternTokenCount(b)
+	if la != lb {
+		return la > lb
+	}
+	if wa != wb {
+		return wa < wb
+	}
+	if fa, fb := strings.HasSuffix(a, ">"), strings.HasSuffix(b, ">"); fa != fb {
+		return fb
+	}
+	return a < b
+}
+
+// patternTokenCount returns the number of literal and wildcard tokens of a
+// resource pattern.
+func patternTokenCount(p string) (literals int, wildcards int) {
+	for _, t := range strings.Split(p, ".") {
+		if t == "*" || t == ">" {
+			wildcards++
+		} else {
+			literals++
+		}
+	}
+	return
+}
+
+// match returns the value of the most specific pattern matching the resource
+// name.
+func (pv patternValues) match(rname string) (string, bool) {
+	for _, p := range pv {
+		if p.pattern.Match(rname) {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+// patternDuration is a resource pattern mapped to a configured duration.
+type patternDuration struct {
+	pattern rescache.ResourcePattern
+	value   time.Duration
+}
+
+// patternDurations is a list of resource patterns mapped to configured
+// durations, sorted by specificity of the patterns.
+type patternDurations []patternDuration
+
+// parsePatternDurations parses a map of resource patterns to positive
+// durations in milliseconds. The setting name is used in any error returned.
+func parsePatternDurations(setting string, m map[string]int) (patternDurations, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	patterns := make([]string, 0, len(m))
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+	rps, err := parsePatterns(setting, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	pd := make(patternDurations, 0, len(patterns))
+	for i, p := range patterns {
+		v := m[p]
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid %s setting for %s (%d)\n\tmust be a positive number of milliseconds", setting, p, v)
+		}
+		pd = append(pd, patternDuration{pattern: rps[i], value: time.Duration(v) * time.Millisecond})
+	}
+	return pd, nil
+}
+
+// match returns the duration of the most specific pattern matching the
+// resource name.
+func (pd patternDurations) match(rname string) (time.Duration, bool) {
+	for _, p := range pd {
+		if p.pattern.Match(rname) {
+			return p.value, true
+		}
+	}
+	return 0, false
+}
+
+// patternLimit is a resource pattern mapped to a configured limit.
+type patternLimit struct {
+	pattern rescache.ResourcePattern
+	value   int
+}
+
+// patternLimits is a list of resource patterns mapped to configured limits,
+// sorted by specificity of the patterns.
+type patternLimits []patternLimit
+
+// parsePatternLimits parses a map of resource patterns to positive limits.
+// The setting name is used in any error returned.
+func parsePatternLimits(setting string, m map[string]int) (patternLimits, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	patterns := make([]string, 0, len(m))
+	for p := range m {
+		patterns = append(patterns, p)
+	}
+	rps, err := parsePatterns(setting, patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	pl := make(patternLimits, 0, len(patterns))
+	for i, p := range patterns {
+		v := m[p]
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid %s setting for %s (%d)\n\tmust be a positive number", setting, p, v)
+		}
+		pl = append(pl, patternLimit{pattern: rps[i], value: v})
+	}
+	return pl, nil
+}
+
+// match returns the limit of the most specific pattern matching the resource
+// name.
+func (pl patternLimits) match(rname string) (int, bool) {
+	for _, p := range pl {
+		if p.pattern.Match(rname) {
+			return p.value, true
+		}
+	}
+	return 0, false
+}
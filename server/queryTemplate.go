@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const tokenPlaceholderPrefix = "token."
+
+var errInvalidQueryTemplate = errors.New("must be a query of key=value pairs separated by '&', where values may contain {token.<field>} placeholders")
+
+// validateQueryTemplate validates a query template, consisting of key=value
+// pairs where each value may contain {token.<field>} placeholders.
+func validateQueryTemplate(tmpl string) error {
+	if tmpl == "" {
+		return errInvalidQueryTemplate
+	}
+	for _, part := range strings.Split(tmpl, "&") {
+		i := strings.IndexByte(part, '=')
+		if i <= 0 || strings.ContainsAny(part[:i], "{}") {
+			return errInvalidQueryTemplate
+		}
+		val := part[i+1:]
+		for {
+			start := strings.IndexByte(val, '{')
+			end := strings.IndexByte(val, '}')
+			if start == -1 && end == -1 {
+				break
+			}
+			if start == -1 || end < start {
+				return errInvalidQueryTemplate
+			}
+			path := val[start+1 : end]
+			if !strings.HasPrefix(path, tokenPlaceholderPrefix) || !isValidFieldPath(path[len(tokenPlaceholderPrefix):]) {
+				return errInvalidQueryTemplate
+			}
+			val = val[end+1:]
+		}
+	}
+	return nil
+}
+
+// isValidFieldPath returns true if the path is a dot separated list of
+// non-empty field names.
+func isValidFieldPath(path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" || strings.ContainsAny(key, "{}") {
+			return false
+		}
+	}
+	return true
+}
+
+// expandQueryTemplate replaces any {token.<field>} placeholder in a validated
+// query template with the URL escaped value of the token field. If any field
+// is missing, null, or non-primitive, false is returned.
+func expandQueryTemplate(tmpl string, token json.RawMessage) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start == -1 {
+			b.WriteString(tmpl)
+			return b.String(), true
+		}
+		end := strings.IndexByte(tmpl, '}')
+		b.WriteString(tmpl[:start])
+		v, ok := tokenFieldString(token, tmpl[start+1+len(tokenPlaceholderPrefix):end])
+		if !ok {
+			return "", false
+		}
+		b.WriteString(url.QueryEscape(v))
+		tmpl = tmpl[end+1:]
+	}
+}
+
+// injectQuery merges an injected query into the client query. Any client
+// query parameter with the same key as an injected parameter is removed,
+// preventing the client from overriding the injected values.
+func injectQuery(query, injected string) string {
+	if query == "" {
+		return injected
+	}
+
+	keys := make(map[string]bool)
+	for _, part := range strings.Split(injected, "&") {
+		keys[queryKey(part)] = true
+	}
+
+	parts := strings.Split(query, "&")
+	rest := parts[:0]
+	for _, part := range parts {
+		if part != "" && !keys[queryKey(part)] {
+			rest = append(rest, part)
+		}
+	}
+	return strings.Join(append(rest, injected), "&")
+}
+
+// normalizeQuery returns the query with its key=value pairs sorted by
+// unescaped key, and with empty and duplicate pairs removed. Pairs with the
+// same key keep their relative order.
+func normalizeQuery(query string) string {
+	if query == "" {
+		return query
+	}
+	seen := make(map[string]bool)
+	parts := strings.Split(query, "&")
+	rest := parts[:0]
+	for _, part := range parts {
+		if part != "" && !seen[part] {
+			seen[part] = true
+			rest = append(rest, part)
+		}
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		return queryKey(rest[i]) < queryKey(rest[j])
+	})
+	return strings.Join(rest, "&")
+}
+
+// queryKey returns the unescaped key of a query key=value pair.
+func queryKey(part string) string {
+	if i := strings.IndexByte(part, '='); i >= 0 {
+		part = part[:i]
+	}
+	if k, err := url.QueryUnescape(part); err == nil {
+		return k
+	}
+	return part
+}
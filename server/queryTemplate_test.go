@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateQueryTemplate(t *testing.T) {
+	tbl := []struct {
+		Template string
+		Valid    bool
+	}{
+		{"tenant=foo", true},
+		{"tenant={token.tenantId}", true},
+		{"tenant={token.org.id}&region=eu", true},
+		{"id=user-{token.id}-{token.group}", true},
+		{"", false},
+		{"tenant", false},
+		{"=foo", false},
+		{"tenant={token}", false},
+		{"tenant={cid}", false},
+		{"tenant={token.}", false},
+		{"tenant={token..id}", false},
+		{"tenant={token.id", false},
+		{"tenant=token.id}", false},
+		{"{token.id}=foo", false},
+	}
+
+	for i, r := range tbl {
+		err := validateQueryTemplate(r.Template)
+		if (err == nil) != r.Valid {
+			t.Fatalf("expected validateQueryTemplate(%#v) to be valid: %v, but got error: %v, in test #%d", r.Template, r.Valid, err, i+1)
+		}
+	}
+}
+
+func TestExpandQueryTemplate(t *testing.T) {
+	token := json.RawMessage(`{"id":42,"name":"foo bar","org":{"id":"acme"},"admin":true,"list":[1],"null":null}`)
+	tbl := []struct {
+		Template string
+		Token    json.RawMessage
+		Expected string
+		OK       bool
+	}{
+		{"tenant=foo", token, "tenant=foo", true},
+		{"tenant=foo", nil, "tenant=foo", true},
+		{"id={token.id}", token, "id=42", true},
+		{"name={token.name}", token, "name=foo+bar", true},
+		{"org={token.org.id}&admin={token.admin}", token, "org=acme&admin=true", true},
+		{"id=u-{token.id}-{token.org.id}", token, "id=u-42-acme", true},
+		{"list={token.list}", token, "", false},
+		{"null={token.null}", token, "", false},
+		{"id={token.id}&missing={token.missing}", token, "", false},
+		{"org={token.org}", token, "", false},
+		{"id={token.id}", nil, "", false},
+	}
+
+	for i, r := range tbl {
+		v, ok := expandQueryTemplate(r.Template, r.Token)
+		if ok != r.OK {
+			t.Fatalf("expected expandQueryTemplate(%#v) to return ok %v, but got %v, in test #%d", r.Template, r.OK, ok, i+1)
+		}
+		compareString(t, "expandQueryTemplate", v, r.Expected, i)
+	}
+}
+
+func TestInjectQuery(t *testing.T) {
+	tbl := []struct {
+		Query    string
+		Injected string
+		Expected string
+	}{
+		{"", "tenant=foo", "tenant=foo"},
+		{"q=bar", "tenant=foo", "q=bar&tenant=foo"},
+		{"tenant=bar", "tenant=foo", "tenant=foo"},
+		{"q=bar&tenant=bar&tenant=baz&f=zoo", "tenant=foo", "q=bar&f=zoo&tenant=foo"},
+		{"q=bar&ten%61nt=bar", "tenant=foo", "q=bar&tenant=foo"},
+		{"tenant&q=bar", "tenant=foo&region=eu", "q=bar&tenant=foo&region=eu"},
+	}
+
+	for i, r := range tbl {
+		compareString(t, "injectQuery", injectQuery(r.Query, r.Injected), r.Expected, i)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tbl := []struct {
+		Query    string
+		Expected string
+	}{
+		{"", ""},
+		{"a=1", "a=1"},
+		{"b=2&a=1", "a=1&b=2"},
+		{"a=1&b=2&a=1", "a=1&b=2"},
+		{"b=2&a=3&a=1", "a=3&a=1&b=2"},
+		{"&b=2&&a=1&", "a=1&b=2"},
+		{"%62=2&a=1", "a=1&%62=2"},
+		{"c&b=2", "b=2&c"},
+	}
+
+	for i, r := range tbl {
+		compareString(t, "normalizeQuery", normalizeQuery(r.Query), r.Expected, i)
+	}
+}
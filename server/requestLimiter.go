@@ -0,0 +1,225 @@
+package server
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errRequestQueueFull = &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Request queue full"}
+
+// requestLimiter is a mq.Client that limits the number of concurrent
+// requests sent to the services. When the limit is reached, new requests are
+// queued, and sent in order of priority by request type once in-flight
+// requests complete. Requests of equal priority are sent in FIFO order.
+// Requests exceeding the queue size are rejected, and queued requests time
+// out after waiting for the request timeout.
+//
+// Response callbacks, queue timeouts, and sending of queued requests are all
+// called in order on a single callback path, so that a request timing out
+// cannot race with it being sent.
+type requestLimiter struct {
+	mq.Client
+	max      int
+	maxQueue int
+	priority map[string]int
+	observe  func(priority int, wait time.Duration)
+	queueLen func(n int)
+
+	mu       sync.Mutex
+	inFlight int
+	queue    requestQueue
+	seq      uint64
+	calls    []func()
+	running  bool
+}
+
+// queuedRequest is a request waiting to be sent.
+type queuedRequest struct {
+	subj     string
+	header   map[string][]string
+	payload  []byte
+	timeout  time.Duration
+	cb       mq.Response
+	priority int
+	seq      uint64
+	queued   time.Time
+	timer    *time.Timer // Timer for the queue timeout
+	index    int         // Index in the queue, or -1 if not queued
+}
+
+// requestQueue is a priority queue of requests, implementing heap.Interface.
+type requestQueue []*queuedRequest
+
+func (q requestQueue) Len() int { return len(q) }
+func (q requestQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q requestQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *requestQueue) Push(x interface{}) {
+	r := x.(*queuedRequest)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+func (q *requestQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	r := old[n-1]
+	old[n-1] = nil
+	r.index = -1
+	*q = old[:n-1]
+	return r
+}
+
+func newRequestLimiter(c mq.Client, max int, priority map[string]int, observe func(int, time.Duration), queueLen func(int)) *requestLimiter {
+	return &requestLimiter{
+		Client:   c,
+		max:      max,
+		maxQueue: RequestLimiterQueueSize,
+		priority: priority,
+		observe:  observe,
+		queueLen: queueLen,
+	}
+}
+
+// SendRequest sends the request if the number of in-flight requests is below
+// the limit, otherwise it is queued.
+func (l *requestLimiter) SendRequest(subj string, payload []byte, cb mq.Response) {
+	l.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends or queues the request the same way as
+// SendRequest, with any headers and timeout.
+func (l *requestLimiter) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	typ, _ := parseRequestSubject(subj)
+	r := &queuedRequest{
+		subj:     subj,
+		header:   header,
+		payload:  payload,
+		timeout:  timeout,
+		cb:       cb,
+		priority: l.priority[typ],
+		queued:   time.Now(),
+	}
+
+	l.mu.Lock()
+	if l.inFlight < l.max && len(l.queue) == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		l.send(r)
+		return
+	}
+	if len(l.queue) >= l.maxQueue {
+		l.mu.Unlock()
+		cb("", nil, errRequestQueueFull)
+		return
+	}
+	r.seq = l.seq
+	l.seq++
+	heap.Push(&l.queue, r)
+	l.setQueueLen()
+	wait := timeout
+	if wait <= 0 {
+		wait = l.Client.Timeout()
+	}
+	r.timer = time.AfterFunc(wait, func() { l.run(func() { l.expire(r) }) })
+	l.mu.Unlock()
+}
+
+// expire removes the request from the queue, if still queued, responding
+// with a timeout error.
+func (l *requestLimiter) expire(r *queuedRequest) {
+	l.mu.Lock()
+	if r.index < 0 {
+		l.mu.Unlock()
+		return
+	}
+	heap.Remove(&l.queue, r.index)
+	l.setQueueLen()
+	l.mu.Unlock()
+	r.cb("", nil, mq.ErrRequestTimeout)
+}
+
+// send sends the request, and on response releases its slot, calls the
+// response callback, and dispatches any queued requests.
+func (l *requestLimiter) send(r *queuedRequest) {
+	if l.observe != nil {
+		l.observe(r.priority, time.Since(r.queued))
+	}
+	mq.SendRequest(l.Client, r.subj, r.header, r.payload, r.timeout, func(rsubj string, data []byte, err error) {
+		l.mu.Lock()
+		l.inFlight--
+		l.mu.Unlock()
+		l.run(func() {
+			r.cb(rsubj, data, err)
+			l.dispatch()
+		})
+	})
+}
+
+// dispatch sends queued requests in order while below the limit.
+func (l *requestLimiter) dispatch() {
+	for {
+		l.mu.Lock()
+		if l.inFlight >= l.max || len(l.queue) == 0 {
+			l.mu.Unlock()
+			return
+		}
+		r := heap.Pop(&l.queue).(*queuedRequest)
+		r.timer.Stop()
+		l.setQueueLen()
+		l.inFlight++
+		l.mu.Unlock()
+		l.send(r)
+	}
+}
+
+// run queues f to be called on the callback path. The calls are made in
+// order on a separate goroutine, as the client may call the response callback
+// while holding its own lock, such as when failing to send the request.
+func (l *requestLimiter) run(f func()) {
+	l.mu.Lock()
+	l.calls = append(l.calls, f)
+	if l.running {
+		l.mu.Unlock()
+		return
+	}
+	l.running = true
+	l.mu.Unlock()
+	go l.runCalls()
+}
+
+// runCalls calls queued callback path calls until none remains.
+func (l *requestLimiter) runCalls() {
+	for {
+		l.mu.Lock()
+		if len(l.calls) == 0 {
+			l.running = false
+			l.mu.Unlock()
+			return
+		}
+		f := l.calls[0]
+		l.calls[0] = nil
+		l.calls = l.calls[1:]
+		l.mu.Unlock()
+		f()
+	}
+}
+
+// setQueueLen reports the current queue length.
+// It must be called with the mutex locked.
+func (l *requestLimiter) setQueueLen() {
+	if l.queueLen != nil {
+		l.queueLen(len(l.queue))
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// pendingClient is a mq.Client that keeps requests pending until responded
+// to.
+type pendingClient struct {
+	mq.Client
+	mu   sync.Mutex
+	subj []string
+	cbs  []mq.Response
+}
+
+func (c *pendingClient) Timeout() time.Duration {
+	return time.Second
+}
+
+func (c *pendingClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.mu.Lock()
+	c.subj = append(c.subj, subj)
+	c.cbs = append(c.cbs, cb)
+	c.mu.Unlock()
+}
+
+// respond responds to the oldest pending request.
+func (c *pendingClient) respond() {
+	c.mu.Lock()
+	cb := c.cbs[0]
+	c.cbs = c.cbs[1:]
+	c.mu.Unlock()
+	cb("", nil, nil)
+}
+
+func (c *pendingClient) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.subj...)
+}
+
+// waitSent waits for n requests to be sent, and returns the sent requests.
+func (c *pendingClient) waitSent(t *testing.T, n int) []string {
+	for i := 0; i < 100; i++ {
+		if sent := c.sent(); len(sent) >= n {
+			return sent
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d requests sent, but got %v", n, c.sent())
+	return nil
+}
+
+// failingClient is a mq.Client that, like the NATS client on a closed
+// connection, calls the response callback with an error while holding its
+// lock.
+type failingClient struct {
+	pendingClient
+	lock sync.Mutex
+	fail bool
+}
+
+func (c *failingClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.fail {
+		cb("", nil, errors.New("connection closed"))
+		return
+	}
+	c.pendingClient.SendRequest(subj, payload, cb)
+}
+
+func TestRequestLimiter(t *testing.T) {
+	tbl := []struct {
+		Priority map[string]int
+		Expected []string
+	}{
+		{nil, []string{"get.a", "access.b", "get.c", "call.d.method", "auth.e.method", "get.f"}},
+		{map[string]int{"call": 2, "auth": 1}, []string{"get.a", "call.d.method", "auth.e.method", "access.b", "get.c", "get.f"}},
+		{map[string]int{"get": -1, "access": 1}, []string{"get.a", "access.b", "call.d.method", "auth.e.method", "get.c", "get.f"}},
+	}
+
+	for i, l := range tbl {
+		c := &pendingClient{}
+		var waits []int
+		rl := newRequestLimiter(c, 1, l.Priority, func(priority int, _ time.Duration) {
+			waits = append(waits, priority)
+		}, nil)
+		var wg sync.WaitGroup
+		var responses int
+		for _, subj := range []string{"get.a", "access.b", "get.c", "call.d.method", "auth.e.method", "get.f"} {
+			wg.Add(1)
+			rl.SendRequest(subj, nil, func(string, []byte, error) {
+				responses++
+				wg.Done()
+			})
+		}
+		if n := len(c.sent()); n != 1 {
+			t.Fatalf("test #%d: expected 1 request sent, but got %d", i+1, n)
+		}
+		for j := 0; j < len(l.Expected); j++ {
+			c.waitSent(t, j+1)
+			c.respond()
+		}
+		wg.Wait()
+		sent := c.sent()
+		if len(sent) != len(l.Expected) {
+			t.Fatalf("test #%d: expected requests %v, but got %v", i+1, l.Expected, sent)
+		}
+		for j, subj := range l.Expected {
+			if sent[j] != subj {
+				t.Fatalf("test #%d: expected requests %v, but got %v", i+1, l.Expected, sent)
+			}
+		}
+		if responses != len(l.Expected) {
+			t.Errorf("test #%d: expected %d responses, but got %d", i+1, len(l.Expected), responses)
+		}
+		if len(waits) != len(l.Expected) {
+			t.Errorf("test #%d: expected %d observed waits, but got %d", i+1, len(l.Expected), len(waits))
+		}
+		rl.mu.Lock()
+		inFlight := rl.inFlight
+		rl.mu.Unlock()
+		if inFlight != 0 {
+			t.Errorf("test #%d: expected no requests in flight, but got %d", i+1, inFlight)
+		}
+	}
+}
+
+func TestRequestLimiter_BelowLimit_SendsDirectly(t *testing.T) {
+	c := &pendingClient{}
+	rl := newRequestLimiter(c, 3, nil, nil, nil)
+	for _, subj := range []string{"get.a", "get.b", "get.c", "get.d"} {
+		rl.SendRequest(subj, nil, func(string, []byte, error) {})
+	}
+	if n := len(c.sent()); n != 3 {
+		t.Fatalf("expected 3 requests sent, but got %d", n)
+	}
+	c.respond()
+	c.waitSent(t, 4)
+}
+
+func TestRequestLimiter_SynchronousFailureWithQueue_FailsQueuedRequests(t *testing.T) {
+	c := &failingClient{}
+	rl := newRequestLimiter(c, 1, nil, nil, nil)
+	errs := make(chan error, 3)
+	for _, subj := range []string{"get.a", "get.b", "get.c"} {
+		rl.SendRequest(subj, nil, func(_ string, _ []byte, err error) { errs <- err })
+	}
+	c.lock.Lock()
+	c.fail = true
+	c.lock.Unlock()
+	c.respond()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if (i == 0) != (err == nil) {
+				t.Errorf("unexpected error for response #%d: %v", i+1, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 responses, but got %d", i)
+		}
+	}
+}
+
+func TestRequestLimiter_QueuedTooLong_FailsWithTimeout(t *testing.T) {
+	c := &pendingClient{}
+	rl := newRequestLimiter(c, 1, nil, nil, nil)
+	errs := make(chan error, 1)
+	rl.SendRequest("get.a", nil, func(string, []byte, error) {})
+	start := time.Now()
+	rl.SendRequestWithTimeout("get.b", nil, nil, 20*time.Millisecond, func(_ string, _ []byte, err error) { errs <- err })
+
+	select {
+	case err := <-errs:
+		if err != mq.ErrRequestTimeout {
+			t.Errorf("expected request timeout, but got %v", err)
+		}
+		if d := time.Since(start); d < 20*time.Millisecond {
+			t.Errorf("expected timeout after waiting 20ms, but got it after %s", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected queued request to time out")
+	}
+	rl.mu.Lock()
+	queued := len(rl.queue)
+	rl.mu.Unlock()
+	if queued != 0 {
+		t.Errorf("expected empty queue, but got %d requests", queued)
+	}
+	c.respond()
+	time.Sleep(10 * time.Millisecond)
+	if n := len(c.sent()); n != 1 {
+		t.Errorf("expected timed out request not to be sent, but got %v", c.sent())
+	}
+}
+
+func TestRequestLimiter_QueueFull_RejectsRequest(t *testing.T) {
+	c := &pendingClient{}
+	rl := newRequestLimiter(c, 1, nil, nil, nil)
+	rl.maxQueue = 1
+	var err error
+	rl.SendRequest("get.a", nil, func(string, []byte, error) {})
+	rl.SendRequest("get.b", nil, func(string, []byte, error) {})
+	rl.SendRequest("get.c", nil, func(_ string, _ []byte, e error) { err = e })
+	if err != errRequestQueueFull {
+		t.Errorf("expected request queue full error, but got %v", err)
+	}
+}
+
+func TestRequestLimiter_QueuedRequests_ReportsQueueLength(t *testing.T) {
+	c := &pendingClient{}
+	var mu sync.Mutex
+	var lens []int
+	rl := newRequestLimiter(c, 1, nil, nil, func(n int) {
+		mu.Lock()
+		lens = append(lens, n)
+		mu.Unlock()
+	})
+	for _, subj := range []string{"get.a", "get.b", "get.c"} {
+		rl.SendRequest(subj, nil, func(string, []byte, error) {})
+	}
+	c.respond()
+	c.waitSent(t, 2)
+	c.respond()
+	c.waitSent(t, 3)
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []int{1, 2, 1, 0}
+	if len(lens) != len(expected) {
+		t.Fatalf("expected queue lengths %v, but got %v", expected, lens)
+	}
+	for i, n := range expected {
+		if lens[i] != n {
+			t.Fatalf("expected queue lengths %v, but got %v", expected, lens)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package rescache
 
 import (
 	"sync"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/mq"
@@ -18,11 +19,16 @@ const (
 	TypeError      ResourceType = ResourceType(stateError)
 )
 
+// ErrQueryLimitExceeded is returned when subscribing to a new query variation
+// of a resource already having the maximum number of cached query variations.
+var ErrQueryLimitExceeded = &reserr.Error{Code: "system.queryLimitExceeded", Message: "Query limit exceeded"}
+
 // EventSubscription represents a subscription for events on a specific resource
 type EventSubscription struct {
 	// Immutable
 	ResourceName string
 	cache        *Cache
+	domain       *orderingDomain // Ordering domain, or nil if not in one
 
 	// Protected by cache mutex
 	mqSub mq.Unsubscriber
@@ -33,10 +39,13 @@ type EventSubscription struct {
 	queries map[string]*ResourceSubscription
 	links   map[string]*ResourceSubscription
 
+	subscribers int64 // Subscribers of the resource and its queries
+
 	// Mutex protected
-	mu    sync.Mutex
-	queue []func()
-	locks []func()
+	mu       sync.Mutex
+	queue    []func()
+	locks    []func()
+	ageTimer *time.Timer // Timer for reaching the max cache age
 }
 
 func (e *EventSubscription) getResourceSubscription(q string) (rs *ResourceSubscription) {
@@ -66,14 +75,33 @@ func (e *EventSubscription) getResourceSubscription(q string) (rs *ResourceSubsc
 	return
 }
 
+// queryLimitExceeded reports whether the query is a new query variation, and
+// the maximum number of cached query variations is already reached.
+// Query variations linked to a normalized query are included in the count.
+func (e *EventSubscription) queryLimitExceeded(q string) bool {
+	max := e.cache.maxQueries
+	if max <= 0 || q == "" || e.queries[q] != nil || e.links[q] != nil {
+		return false
+	}
+	return len(e.queries)+len(e.links) >= max
+}
+
 func (e *EventSubscription) addSubscriber(sub Subscriber) {
 	e.Enqueue(func() {
 		var rs *ResourceSubscription
 		q := sub.ResourceQuery()
+		if e.queryLimitExceeded(q) {
+			e.removeCount(1)
+			e.mu.Unlock()
+			defer e.mu.Lock()
+			sub.Loaded(nil, ErrQueryLimitExceeded)
+			return
+		}
 		rs = e.getResourceSubscription(q)
 
 		if rs.state != stateError {
 			rs.subs[sub] = struct{}{}
+			e.addSubscribers(1)
 		}
 
 		switch rs.state {
@@ -84,7 +112,7 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 			rs.state = stateRequested
 			// Create request
 			subj := "get." + e.ResourceName
-			payload := codec.CreateGetRequest(q)
+			payload := codec.CreateGetRequest(q, e.cache.deadline())
 			e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
 				rs.enqueueGetResponse(data, err)
 			})
@@ -104,6 +132,10 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 
 		// stateModel or stateCollection
 		default:
+			// Refresh a retained resource kept without subscribers
+			if len(rs.subs) == 1 && rs.query == "" && e.isRetained() {
+				rs.handleResetResource()
+			}
 			e.mu.Unlock()
 			defer e.mu.Lock()
 			sub.Loaded(rs, nil)
@@ -115,6 +147,10 @@ func (e *EventSubscription) addSubscriber(sub Subscriber) {
 // If a worker is already executing a callback on the EventSubscription, the callback
 // will be queued on the EventSubscription, and executed in order.
 func (e *EventSubscription) Enqueue(f func()) {
+	if e.domain != nil {
+		e.domain.enqueue(e, f)
+		return
+	}
 	e.mu.Lock()
 	count := len(e.queue)
 	locks := e.locks
@@ -125,7 +161,7 @@ func (e *EventSubscription) Enqueue(f func()) {
 	// assigned to the event subscription, so we pass it to one.
 	// This only applies if no locks are active
 	if locks == nil && count == 0 {
-		e.cache.inCh <- e
+		e.cache.schedule(e)
 	}
 }
 
@@ -138,8 +174,12 @@ func (e *EventSubscription) enqueueUnlock(f func()) {
 	e.locks = append(e.locks, f)
 	e.mu.Unlock()
 
+	if e.domain != nil {
+		e.domain.enqueueUnlock(e)
+		return
+	}
 	if count == 0 {
-		e.cache.inCh <- e
+		e.cache.schedule(e)
 	}
 }
 
@@ -159,23 +199,9 @@ func (e *EventSubscription) processQueue() {
 	idx := 0
 
 	if e.locks != nil {
-		for len(e.locks) > idx {
-			f = e.locks[idx]
-			idx++
-			f()
-		}
-
-		e.locks = e.locks[idx:]
-
-		if cap(e.locks) > 0 {
-			return
-		}
-		e.locks = nil
-		if len(e.queue) == 0 {
+		if e.processLocks() || len(e.queue) == 0 {
 			return
 		}
-
-		idx = 0
 	}
 
 	for len(e.queue) > idx {
@@ -191,6 +217,26 @@ func (e *EventSubscription) processQueue() {
 	e.queue = e.queue[0:0]
 }
 
+// processLocks calls the lock callbacks passed to enqueueUnlock, and reports
+// whether events are still locked.
+// e.mu is held when called.
+func (e *EventSubscription) processLocks() bool {
+	idx := 0
+	for len(e.locks) > idx {
+		f := e.locks[idx]
+		idx++
+		f()
+	}
+
+	e.locks = e.locks[idx:]
+
+	if cap(e.locks) > 0 {
+		return true
+	}
+	e.locks = nil
+	return false
+}
+
 // addCount increments the subscription count.
 // If the previous count was 0, indicating it has previously been added to the unsubQueue,
 // the method will remove itself from the queue.
@@ -208,11 +254,33 @@ func (e *EventSubscription) addCount() {
 // in the unsubscribe queue if count reaches zero.
 func (e *EventSubscription) removeCount(n int64) {
 	e.count -= n
-	if e.count == 0 && n != 0 {
+	if e.count == 0 && n != 0 && !e.isRetained() {
 		e.cache.unsubQueue.Add(e)
 	}
 }
 
+// isRetained returns true if the resource is retained, and its base resource
+// is loaded.
+// e.mu is held when called.
+func (e *EventSubscription) isRetained() bool {
+	if e.cache.retained == nil || e.base == nil || e.base.query != "" || e.base.state < stateCollection {
+		return false
+	}
+	return e.cache.retained(e.ResourceName)
+}
+
+// addSubscribers adds n, which may be negative, to the number of subscribers,
+// calling any count handler.
+func (e *EventSubscription) addSubscribers(n int64) {
+	if n == 0 {
+		return
+	}
+	e.subscribers += n
+	if e.cache.onCount != nil {
+		e.cache.onCount(e.ResourceName, e.subscribers-n, e.subscribers)
+	}
+}
+
 func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
 	e.Enqueue(func() {
 		idx := len(e.ResourceName) + 7 // Length of "event." + "."
@@ -236,6 +304,7 @@ func (e *EventSubscription) enqueueEvent(subj string, payload []byte) {
 			ev, err := codec.DecodeEvent(payload)
 			if err != nil {
 				e.cache.Errorf("Error processing event %s: malformed payload %s", subj, payload)
+				e.base.resyncMalformed()
 				return
 			}
 
@@ -253,6 +322,9 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 	qe, err := codec.DecodeQueryEvent(payload)
 	if err != nil {
 		e.cache.Errorf("Error processing event %s: malformed payload %s", subj, payload)
+		for _, rs := range e.queries {
+			rs.resyncMalformed()
+		}
 		return
 	}
 
@@ -280,10 +352,10 @@ func (e *EventSubscription) handleQueryEvent(subj string, payload []byte) {
 
 				result, err := codec.DecodeEventQueryResponse(data)
 				if err != nil {
-					// In case of a system.notFound error,
+					// In case of a system.notFound or system.gone error,
 					// a delete event is generated. Otherwise we
 					// just log the error.
-					if reserr.IsError(err, reserr.CodeNotFound) {
+					if reserr.IsError(err, reserr.CodeNotFound) || reserr.IsError(err, reserr.CodeGone) {
 						rs.handleEvent(&ResourceEvent{Event: "delete"})
 					} else {
 						e.cache.Errorf("Error processing query event for %s?%s: %s", e.ResourceName, rs.query, err)
@@ -333,6 +405,7 @@ func (e *EventSubscription) mqUnsubscribe() bool {
 
 	// Clear the response queue
 	e.queue = nil
+	e.stopAgeTimerLocked()
 
 	// Unsubscribe from messaging system
 	if e.mqSub != nil {
@@ -346,15 +419,70 @@ func (e *EventSubscription) mqUnsubscribe() bool {
 }
 
 func (e *EventSubscription) handleResetResource() {
-	e.Enqueue(func() {
-		if e.base != nil && e.base.query == "" {
-			e.base.handleResetResource()
-		}
+	e.Enqueue(e.resetResources)
+}
 
-		for _, rs := range e.queries {
-			rs.handleResetResource()
+// resetResources fetches the base resource and all query resources again,
+// passing any difference from the cached state to the subscribers as events.
+func (e *EventSubscription) resetResources() {
+	if e.base != nil && e.base.query == "" {
+		e.base.handleResetResource()
+	}
+
+	for _, rs := range e.queries {
+		rs.handleResetResource()
+	}
+}
+
+// startAgeTimer starts a timer that resets the cached resources once the
+// max cache age is reached. It does nothing if a timer is already started, or
+// if the resource has no max cache age.
+// e.mu is held when called.
+func (e *EventSubscription) startAgeTimer() {
+	if e.ageTimer != nil || e.cache.maxAge == nil {
+		return
+	}
+	d := e.cache.maxAge(e.ResourceName)
+	if d <= 0 {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		e.mu.Lock()
+		current := e.ageTimer == t
+		e.mu.Unlock()
+		if !current {
+			return
 		}
+		e.Enqueue(func() {
+			if e.ageTimer != t {
+				return
+			}
+			e.ageTimer = nil
+			if e.base == nil && len(e.queries) == 0 {
+				return
+			}
+			e.resetResources()
+			e.startAgeTimer()
+		})
 	})
+	e.ageTimer = t
+}
+
+// stopAgeTimer stops any max cache age timer.
+func (e *EventSubscription) stopAgeTimer() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopAgeTimerLocked()
+}
+
+// stopAgeTimerLocked stops any max cache age timer.
+// e.mu is held when called.
+func (e *EventSubscription) stopAgeTimerLocked() {
+	if e.ageTimer != nil {
+		e.ageTimer.Stop()
+		e.ageTimer = nil
+	}
 }
 
 func (e *EventSubscription) handleResetAccess() {
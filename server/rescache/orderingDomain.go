@@ -0,0 +1,133 @@
+package rescache
+
+import "sync"
+
+// orderingDomain processes the callbacks of all event subscriptions for
+// resources within the domain by a single goroutine, in the order they were
+// enqueued. This ensures events on different resources within the domain are
+// handled, and passed on to the subscribers, in the order they were received.
+type orderingDomain struct {
+	patterns []ResourcePattern
+
+	mu      sync.Mutex
+	queue   []domainCallback
+	unlocks []*EventSubscription
+	work    chan struct{}
+	stopped bool
+
+	// Only accessed by the domain goroutine
+	blockedBy *EventSubscription
+}
+
+// domainCallback is a callback to be called for an event subscription.
+type domainCallback struct {
+	e *EventSubscription
+	f func()
+}
+
+func newOrderingDomain(patterns []ResourcePattern) *orderingDomain {
+	return &orderingDomain{patterns: patterns}
+}
+
+// match reports whether the resource name matches any of the domain patterns.
+func (d *orderingDomain) match(rname string) bool {
+	for _, p := range d.patterns {
+		if p.Match(rname) {
+			return true
+		}
+	}
+	return false
+}
+
+// start starts the goroutine processing the domain queue.
+func (d *orderingDomain) start() {
+	d.mu.Lock()
+	d.queue = nil
+	d.unlocks = nil
+	d.blockedBy = nil
+	d.stopped = false
+	d.work = make(chan struct{}, 1)
+	work := d.work
+	d.mu.Unlock()
+	go func() {
+		for range work {
+			d.process()
+		}
+	}()
+}
+
+// stop stops the goroutine processing the domain queue.
+func (d *orderingDomain) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stopped {
+		d.stopped = true
+		close(d.work)
+	}
+}
+
+// enqueue adds a callback for the event subscription to the domain queue.
+func (d *orderingDomain) enqueue(e *EventSubscription, f func()) {
+	d.mu.Lock()
+	d.queue = append(d.queue, domainCallback{e: e, f: f})
+	d.wake()
+	d.mu.Unlock()
+}
+
+// enqueueUnlock signals that the event subscription has a lock callback to
+// be processed.
+func (d *orderingDomain) enqueueUnlock(e *EventSubscription) {
+	d.mu.Lock()
+	d.unlocks = append(d.unlocks, e)
+	d.wake()
+	d.mu.Unlock()
+}
+
+// wake signals the domain goroutine that there is work to process.
+// d.mu is held when called.
+func (d *orderingDomain) wake() {
+	if d.stopped {
+		return
+	}
+	select {
+	case d.work <- struct{}{}:
+	default:
+	}
+}
+
+// process calls lock callbacks, and queued callbacks in order, until the queue
+// is empty. While an event subscription has its events locked, no queued
+// callbacks are called for any resource in the domain.
+func (d *orderingDomain) process() {
+	for {
+		d.mu.Lock()
+		if len(d.unlocks) > 0 {
+			e := d.unlocks[0]
+			d.unlocks = d.unlocks[1:]
+			d.mu.Unlock()
+
+			e.mu.Lock()
+			locked := e.locks != nil && e.processLocks()
+			e.mu.Unlock()
+			if !locked && d.blockedBy == e {
+				d.blockedBy = nil
+			}
+			continue
+		}
+		if d.blockedBy != nil || len(d.queue) == 0 {
+			d.mu.Unlock()
+			return
+		}
+		cb := d.queue[0]
+		d.queue[0] = domainCallback{}
+		d.queue = d.queue[1:]
+		d.mu.Unlock()
+
+		cb.e.mu.Lock()
+		cb.f()
+		if cb.e.locks != nil {
+			d.blockedBy = cb.e
+		}
+		cb.e.mu.Unlock()
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,14 +18,30 @@ import (
 
 // Cache is an in memory resource cache.
 type Cache struct {
-	mq               mq.Client
-	logger           logger.Logger
-	workers          int
-	unsubscribeDelay time.Duration
+	mq                 mq.Client
+	logger             logger.Logger
+	workers            int
+	unsubscribeDelay   time.Duration
+	requestDeadline    bool
+	maxQueries         int
+	bufferPending      bool
+	resyncMalformed    bool
+	strictResponses    bool
+	strictGetResponses bool
+	preferCallResource bool
+	preserveFieldOrder bool
+	maxAge             func(rname string) time.Duration
+	retained           func(rname string) bool
+	notFoundDefault    func(rname string) (json.RawMessage, bool)
+	onEvict            func(rname string, reason EvictReason)
+	onCount            func(rname string, old, new int64)
+	onEvent            func(rid string, r *ResourceEvent)
+	domains            []*orderingDomain
 
 	mu         sync.Mutex
 	started    bool
 	eventSubs  map[string]*EventSubscription
+	inMu       sync.RWMutex // Protects inCh
 	inCh       chan *EventSubscription
 	unsubQueue *timerqueue.Queue
 	resetSub   mq.Unsubscriber
@@ -33,9 +51,21 @@ type Cache struct {
 	depLogged map[string]featureType
 }
 
+// EvictReason is the reason for a resource being evicted from the cache.
+type EvictReason string
+
+// Evict reasons
+const (
+	// EvictLinger is used when a resource without subscribers is evicted
+	// after the unsubscribe delay.
+	EvictLinger EvictReason = "linger"
+)
+
 // Subscriber interface represents a subscription made on a client connection
 type Subscriber interface {
 	CID() string
+	ForwardedHeader() http.Header
+	Locale() string
 	Loaded(resourceSub *ResourceSubscription, err error)
 	Event(event *ResourceEvent)
 	ResourceName() string
@@ -51,6 +81,7 @@ type ResourceEvent struct {
 	Value     codec.Value
 	Changed   map[string]codec.Value
 	OldValues map[string]codec.Value
+	Seq       uint64 // Sequence number assigned by the subscriber, or zero
 }
 
 // NewCache creates a new Cache instance
@@ -69,6 +100,173 @@ func (c *Cache) SetLogger(l logger.Logger) {
 	c.logger = l
 }
 
+// SetRequestDeadline sets whether get, call, and auth requests should include
+// a deadline with the number of milliseconds until the request times out.
+// It must be called before Start.
+func (c *Cache) SetRequestDeadline(enabled bool) {
+	c.requestDeadline = enabled
+}
+
+// SetMaxQueryVariations sets the maximum number of distinct query variations
+// cached for a single resource. A subscription for a new query variation
+// beyond the limit fails with a system.queryLimitExceeded error. Zero means
+// no limit. It must be called before Start.
+func (c *Cache) SetMaxQueryVariations(n int) {
+	c.maxQueries = n
+}
+
+// SetBufferPendingEvents sets whether resource events received while the
+// resource's get request is pending are buffered, and applied in order after
+// the get response. By default, such events are discarded, as the get response
+// is expected to already reflect them. It must be called before Start.
+func (c *Cache) SetBufferPendingEvents(buffer bool) {
+	c.bufferPending = buffer
+}
+
+// SetResyncMalformedEvents sets whether a malformed event, or an add or
+// remove event with an out of bounds index, on a loaded resource causes the
+// resource to be fetched again, passing any difference from the cached state
+// to the subscribers as events. By default, such events are logged and
+// discarded. It must be called before Start.
+func (c *Cache) SetResyncMalformedEvents(resync bool) {
+	c.resyncMalformed = resync
+}
+
+// SetStrictResponses sets whether a warning is logged for service responses
+// containing fields not defined by the RES-service protocol. Such fields are
+// otherwise ignored. It must be called before Start.
+func (c *Cache) SetStrictResponses(strict bool) {
+	c.strictResponses = strict
+}
+
+// SetStrictGetResponses sets whether get responses are strictly validated
+// against the RES-service protocol, rejecting responses with fields not
+// defined by the protocol or with null fields, instead of ignoring such
+// fields. It must be called before Start.
+func (c *Cache) SetStrictGetResponses(strict bool) {
+	c.strictGetResponses = strict
+}
+
+// SetPreferCallResource sets whether the resource of a call response with
+// both a result and a resource is used, logging a warning, instead of
+// responding with an error. It must be called before Start.
+func (c *Cache) SetPreferCallResource(prefer bool) {
+	c.preferCallResource = prefer
+}
+
+// decodeCallResponse decodes a call or auth response, handling a response
+// with both a result and a resource as set by SetPreferCallResource.
+func (c *Cache) decodeCallResponse(subj string, payload []byte) (json.RawMessage, string, error) {
+	result, rid, err := codec.DecodeCallResponse(payload)
+	if err == codec.ErrResultAndResource {
+		if c.preferCallResource {
+			c.Errorf("Call response warning for %s - both result and resource set, using resource %s", subj, rid)
+			return nil, rid, nil
+		}
+		c.Errorf("Call response error for %s - both result and resource set", subj)
+		return nil, "", err
+	}
+	return result, rid, err
+}
+
+// decodeGetResponse decodes a get response, strictly validating it first if
+// strict get responses are enabled.
+func (c *Cache) decodeGetResponse(payload []byte) (*codec.GetResult, error) {
+	if c.strictGetResponses {
+		if err := codec.ValidateGetResponse(payload); err != nil {
+			return nil, err
+		}
+	}
+	return codec.DecodeGetResponse(payload)
+}
+
+// SetPreserveFieldOrder sets whether the model keys are kept in the order
+// received in get responses, to be used when encoding the model. Keys added
+// later are placed after them in sorted order. By default, keys are sorted.
+func (c *Cache) SetPreserveFieldOrder(preserve bool) {
+	c.preserveFieldOrder = preserve
+}
+
+// SetMaxCacheAge sets a callback returning the maximum age of a cached
+// resource. Once reached, the resource is fetched again, keeping all
+// subscriptions, and any difference from the cached state is passed to the
+// subscribers as events. Zero means no maximum age. It must be called before
+// Start.
+func (c *Cache) SetMaxCacheAge(f func(rname string) time.Duration) {
+	c.maxAge = f
+}
+
+// SetRetainedResources sets a callback returning true if a resource is
+// retained. A loaded retained resource is kept in the cache, and updated by
+// events, after its last subscriber unsubscribes. A new subscriber is served
+// the cached state directly, while the resource is fetched again in the
+// background, and any difference passed to the subscribers as events. It must
+// be called before Start.
+func (c *Cache) SetRetainedResources(f func(rname string) bool) {
+	c.retained = f
+}
+
+// SetNotFoundDefault sets a callback returning the default resource, as a
+// JSON encoded model or collection, to use for a resource when its get
+// response is a system.notFound error. If the callback returns false, the
+// error is kept.
+func (c *Cache) SetNotFoundDefault(f func(rname string) (json.RawMessage, bool)) {
+	c.notFoundDefault = f
+}
+
+// SetEvictHandler sets a callback called when a resource is evicted from the
+// cache, with the resource name and the reason for eviction.
+func (c *Cache) SetEvictHandler(f func(rname string, reason EvictReason)) {
+	c.onEvict = f
+}
+
+// SetCountHandler sets a callback called when the number of subscribers of a
+// resource, including its queries, changes, with the resource name and the
+// old and new count. The callback is called with the resource locked, and
+// must not block.
+func (c *Cache) SetCountHandler(f func(rname string, old, new int64)) {
+	c.onCount = f
+}
+
+// SetEventHandler sets a callback called for each event applied to a loaded
+// resource, with the resource ID and the event, in the order the events are
+// applied on the resource. The callback is called with the resource locked,
+// and must not block.
+func (c *Cache) SetEventHandler(f func(rid string, r *ResourceEvent)) {
+	c.onEvent = f
+}
+
+// SetOrderingDomains sets the ordering domains, each a list of resource
+// patterns. Events on resources within the same domain are handled in the
+// order they are received. A resource matching multiple domains belongs to
+// the first one. Must be called before Start.
+func (c *Cache) SetOrderingDomains(domains [][]ResourcePattern) {
+	c.domains = make([]*orderingDomain, len(domains))
+	for i, patterns := range domains {
+		c.domains[i] = newOrderingDomain(patterns)
+	}
+}
+
+// orderingDomain returns the ordering domain of the resource, or nil if it
+// belongs to none.
+func (c *Cache) orderingDomain(rname string) *orderingDomain {
+	for _, d := range c.domains {
+		if d.match(rname) {
+			return d
+		}
+	}
+	return nil
+}
+
+// deadline returns the request deadline to include in the request payloads,
+// or zero if no deadline should be included.
+func (c *Cache) deadline() time.Duration {
+	if !c.requestDeadline {
+		return 0
+	}
+	return c.mq.Timeout()
+}
+
 // Start will initialize the cache, subscribing to global events
 // It is assumed mq.Connect has already been called
 func (c *Cache) Start() error {
@@ -78,11 +276,16 @@ func (c *Cache) Start() error {
 	inCh := make(chan *EventSubscription, 100)
 	c.eventSubs = make(map[string]*EventSubscription)
 	c.unsubQueue = timerqueue.New(c.mqUnsubscribe, c.unsubscribeDelay)
+	c.inMu.Lock()
 	c.inCh = inCh
+	c.inMu.Unlock()
 
 	for i := 0; i < c.workers; i++ {
 		go c.startWorker(inCh)
 	}
+	for _, d := range c.domains {
+		d.start()
+	}
 
 	resetSub, err := c.mq.Subscribe("system", func(subj string, payload []byte, _ error) {
 		ev := subj[7:]
@@ -125,8 +328,12 @@ func (c *Cache) Subscribe(sub Subscriber) {
 
 // Access sends an access request
 func (c *Cache) Access(sub Subscriber, token interface{}, callback func(access *Access)) {
-	rname := sub.ResourceName()
-	payload := codec.CreateRequest(nil, sub, sub.ResourceQuery(), token)
+	c.AccessResource(sub, sub.ResourceName(), sub.ResourceQuery(), token, callback)
+}
+
+// AccessResource sends an access request for a resource on behalf of the requester
+func (c *Cache) AccessResource(req codec.Requester, rname, query string, token interface{}, callback func(access *Access)) {
+	payload := codec.CreateRequest(nil, req, query, token, 0)
 	subj := "access." + rname
 	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
 		if err != nil {
@@ -134,24 +341,62 @@ func (c *Cache) Access(sub Subscriber, token interface{}, callback func(access *
 			return
 		}
 
+		c.warnUnexpectedFields(subj, data, codec.UnexpectedAccessFields)
 		access, rerr := codec.DecodeAccessResponse(data)
 		callback(&Access{AccessResult: access, Error: rerr})
 	})
 }
 
-// Call sends a method call request
-func (c *Cache) Call(req codec.Requester, rname, query, action string, token, params interface{}, callback func(result json.RawMessage, rid string, err error)) {
-	payload := codec.CreateRequest(params, req, query, token)
+// AccessBatch sends a single access request for multiple resources to the
+// batch resource on behalf of the requester. The callback is called with the
+// access of each resource ID. Resource IDs missing in the response are denied
+// access.
+func (c *Cache) AccessBatch(req codec.Requester, name string, rids []string, token interface{}, callback func(access map[string]*Access)) {
+	payload := codec.CreateAccessBatchRequest(req, rids, token)
+	subj := "accessbatch." + name
+	c.sendRequest(name, subj, payload, func(data []byte, err error) {
+		m := make(map[string]*Access, len(rids))
+		var results map[string]*codec.AccessResult
+		var rerr *reserr.Error
+		if err != nil {
+			rerr = reserr.RESError(err)
+		} else {
+			results, rerr = codec.DecodeAccessBatchResponse(data)
+		}
+		for _, rid := range rids {
+			switch {
+			case rerr != nil:
+				m[rid] = &Access{Error: rerr}
+			case results[rid] != nil:
+				m[rid] = &Access{AccessResult: results[rid]}
+			default:
+				m[rid] = &Access{Error: reserr.ErrAccessDenied}
+			}
+		}
+		callback(m)
+	})
+}
+
+// Call sends a method call request. A timeout greater than zero is used
+// instead of the default request timeout.
+func (c *Cache) Call(req codec.Requester, rname, query, action string, token, params interface{}, timeout time.Duration, callback func(result json.RawMessage, rid string, err error)) {
+	deadline := c.deadline()
+	if deadline > 0 && timeout > 0 {
+		deadline = timeout
+	}
+	payload := codec.CreateCallRequest(params, req, query, token, deadline)
 	subj := "call." + rname + "." + action
-	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
+	c.sendRequestWithTimeout(rname, subj, payload, timeout, func(data []byte, err error) {
 		if err != nil {
 			callback(nil, "", err)
 			return
 		}
 
+		c.warnUnexpectedFields(subj, data, codec.UnexpectedCallFields)
+
 		// [DEPRECATED:deprecatedNewCallRequest]
 		if action == "new" {
-			result, rid, err := codec.DecodeCallResponse(data)
+			result, rid, err := c.decodeCallResponse(subj, data)
 			if err == nil && rid == "" {
 				rid, err = codec.TryDecodeLegacyNewResult(result)
 				if err != nil || rid != "" {
@@ -164,13 +409,13 @@ func (c *Cache) Call(req codec.Requester, rname, query, action string, token, pa
 			return
 		}
 
-		callback(codec.DecodeCallResponse(data))
+		callback(c.decodeCallResponse(subj, data))
 	})
 }
 
 // Auth sends an auth method call
 func (c *Cache) Auth(req codec.AuthRequester, rname, query, action string, token, params interface{}, callback func(result json.RawMessage, rid string, err error)) {
-	payload := codec.CreateAuthRequest(params, req, query, token)
+	payload := codec.CreateAuthRequest(params, req, query, token, c.deadline())
 	subj := "auth." + rname + "." + action
 	c.sendRequest(rname, subj, payload, func(data []byte, err error) {
 		if err != nil {
@@ -178,13 +423,31 @@ func (c *Cache) Auth(req codec.AuthRequester, rname, query, action string, token
 			return
 		}
 
-		callback(codec.DecodeCallResponse(data))
+		c.warnUnexpectedFields(subj, data, codec.UnexpectedCallFields)
+		callback(c.decodeCallResponse(subj, data))
 	})
 }
 
+// warnUnexpectedFields logs a warning if strict responses are enabled and the
+// response contains fields not defined by the RES-service protocol.
+func (c *Cache) warnUnexpectedFields(subj string, payload []byte, unexpected func([]byte) []string) {
+	if !c.strictResponses {
+		return
+	}
+	if fields := unexpected(payload); len(fields) > 0 {
+		c.Errorf("Strict response warning for %s - unexpected fields: %s", subj, strings.Join(fields, ", "))
+	}
+}
+
 func (c *Cache) sendRequest(rname, subj string, payload []byte, cb func(data []byte, err error)) {
+	c.sendRequestWithTimeout(rname, subj, payload, 0, cb)
+}
+
+// sendRequestWithTimeout sends a request, using the timeout instead of the
+// default request timeout if greater than zero.
+func (c *Cache) sendRequestWithTimeout(rname, subj string, payload []byte, timeout time.Duration, cb func(data []byte, err error)) {
 	eventSub, _ := c.getSubscription(rname, false)
-	c.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
+	mq.SendRequest(c.mq, subj, nil, payload, timeout, func(_ string, data []byte, err error) {
 		eventSub.Enqueue(func() {
 			cb(data, err)
 			eventSub.removeCount(1)
@@ -203,6 +466,7 @@ func (c *Cache) getSubscription(name string, subscribe bool) (*EventSubscription
 		eventSub = &EventSubscription{
 			ResourceName: name,
 			cache:        c,
+			domain:       c.orderingDomain(name),
 			count:        1,
 		}
 
@@ -231,12 +495,34 @@ func (c *Cache) Stop() {
 	if !c.started {
 		return
 	}
+	c.mu.Lock()
+	for _, e := range c.eventSubs {
+		e.stopAgeTimer()
+	}
+	c.mu.Unlock()
+	c.inMu.Lock()
 	close(c.inCh)
+	c.inCh = nil
+	c.inMu.Unlock()
+	for _, d := range c.domains {
+		d.stop()
+	}
 	c.unsubQueue.Clear()
 	c.resetSub = nil
 	c.started = false
 }
 
+// schedule passes the event subscription to one of the workers. Once the
+// cache is stopped, such as when a connection still releases its
+// subscriptions after the service stopped, it does nothing.
+func (c *Cache) schedule(e *EventSubscription) {
+	c.inMu.RLock()
+	if c.inCh != nil {
+		c.inCh <- e
+	}
+	c.inMu.RUnlock()
+}
+
 func (c *Cache) startWorker(ch chan *EventSubscription) {
 	for eventSub := range ch {
 		eventSub.processQueue()
@@ -246,13 +532,16 @@ func (c *Cache) startWorker(ch chan *EventSubscription) {
 func (c *Cache) mqUnsubscribe(v interface{}) {
 	eventSub := v.(*EventSubscription)
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if !eventSub.mqUnsubscribe() {
+		c.mu.Unlock()
 		return
 	}
-
 	delete(c.eventSubs, eventSub.ResourceName)
+	c.mu.Unlock()
+
+	if c.onEvict != nil {
+		c.onEvict(eventSub.ResourceName, EvictLinger)
+	}
 }
 
 func (c *Cache) handleSystemReset(payload []byte) {
@@ -274,6 +563,20 @@ func (c *Cache) handleSystemReset(payload []byte) {
 	})
 }
 
+// Reset fetches all cached resources again, passing any difference from the
+// cached state to the subscribers as events, and makes the subscribers
+// check their access again. It is used after reconnecting to the messaging
+// system, as events may have been lost.
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.eventSubs {
+		e.handleResetResource()
+		e.handleResetAccess()
+	}
+}
+
 func (c *Cache) forEachMatch(p []string, cb func(e *EventSubscription)) {
 	if len(p) == 0 {
 		return
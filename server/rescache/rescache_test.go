@@ -0,0 +1,83 @@
+package rescache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jirenius/timerqueue"
+)
+
+func TestMQUnsubscribe_WithoutSubscribers_CallsEvictHandler(t *testing.T) {
+	var evicted []string
+	c := &Cache{eventSubs: make(map[string]*EventSubscription)}
+	c.SetEvictHandler(func(rname string, reason EvictReason) {
+		evicted = append(evicted, rname+":"+string(reason))
+	})
+	eventSub := &EventSubscription{ResourceName: "test.model", cache: c}
+	c.eventSubs[eventSub.ResourceName] = eventSub
+
+	c.mqUnsubscribe(eventSub)
+
+	if _, ok := c.eventSubs["test.model"]; ok {
+		t.Errorf("expected event subscription to be removed")
+	}
+	if len(evicted) != 1 || evicted[0] != "test.model:linger" {
+		t.Errorf("expected eviction of test.model with reason linger, but got %v", evicted)
+	}
+}
+
+func TestMQUnsubscribe_WithSubscribers_DoesNotCallEvictHandler(t *testing.T) {
+	called := false
+	c := &Cache{eventSubs: make(map[string]*EventSubscription)}
+	c.SetEvictHandler(func(rname string, reason EvictReason) { called = true })
+	eventSub := &EventSubscription{ResourceName: "test.model", cache: c, count: 1}
+	c.eventSubs[eventSub.ResourceName] = eventSub
+
+	c.mqUnsubscribe(eventSub)
+
+	if _, ok := c.eventSubs["test.model"]; !ok {
+		t.Errorf("expected event subscription to remain")
+	}
+	if called {
+		t.Errorf("expected evict handler not to be called")
+	}
+}
+
+func TestRemoveCount_RetainedLoadedResource_IsNotQueuedForUnsubscribe(t *testing.T) {
+	tbl := []struct {
+		Retained bool
+		State    subscriptionState
+		Queued   bool
+	}{
+		{false, stateModel, true},
+		{true, stateModel, false},
+		{true, stateCollection, false},
+		{true, stateRequested, true},
+	}
+
+	for i, l := range tbl {
+		c := &Cache{unsubQueue: timerqueue.New(func(interface{}) {}, time.Hour)}
+		c.SetRetainedResources(func(rname string) bool { return l.Retained && rname == "test.model" })
+		eventSub := &EventSubscription{ResourceName: "test.model", cache: c, count: 1}
+		eventSub.base = &ResourceSubscription{e: eventSub, state: l.State}
+
+		eventSub.removeCount(1)
+
+		if queued := c.unsubQueue.Len() == 1; queued != l.Queued {
+			t.Errorf("#%d: expected queued to be %v, but got %v", i+1, l.Queued, queued)
+		}
+		c.unsubQueue.Clear()
+	}
+}
+
+func TestEnqueue_StoppedCache_DoesNothing(t *testing.T) {
+	c := &Cache{}
+	eventSub := &EventSubscription{ResourceName: "test.model", cache: c}
+	called := false
+
+	eventSub.Enqueue(func() { called = true })
+
+	if called {
+		t.Errorf("expected callback not to be called")
+	}
+}
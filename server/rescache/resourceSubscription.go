@@ -1,8 +1,11 @@
 package rescache
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/reserr"
@@ -18,25 +21,83 @@ const (
 	stateModel
 )
 
+var (
+	emptyModelBytes      = []byte("{}")
+	emptyCollectionBytes = []byte("[]")
+)
+
 // Model represents a RES model
 // https://github.com/resgateio/resgate/blob/master/docs/res-protocol.md#models
 type Model struct {
 	Values map[string]codec.Value
+	Keys   []string // Keys in the order received, or nil for sorted order
 	data   []byte
 }
 
-// MarshalJSON creates a JSON encoded representation of the model
+// MarshalJSON creates a JSON encoded representation of the model, with the
+// values in the order of OrderedKeys. An empty model is always encoded as {}.
 func (m *Model) MarshalJSON() ([]byte, error) {
+	if len(m.Values) == 0 {
+		return emptyModelBytes, nil
+	}
 	if m.data == nil {
-		data, err := json.Marshal(m.Values)
-		if err != nil {
-			return nil, err
+		if m.Keys == nil {
+			data, err := json.Marshal(m.Values)
+			if err != nil {
+				return nil, err
+			}
+			m.data = data
+			return m.data, nil
+		}
+		var b bytes.Buffer
+		b.WriteByte('{')
+		for i, k := range m.OrderedKeys() {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			kdata, _ := json.Marshal(k)
+			vdata, err := json.Marshal(m.Values[k])
+			if err != nil {
+				return nil, err
+			}
+			b.Write(kdata)
+			b.WriteByte(':')
+			b.Write(vdata)
 		}
-		m.data = data
+		b.WriteByte('}')
+		m.data = b.Bytes()
 	}
 	return m.data, nil
 }
 
+// OrderedKeys returns the keys of the model values in a deterministic order.
+// If Keys is set, the keys are in that order, followed by any keys not in
+// Keys in sorted order. Otherwise all keys are sorted.
+func (m *Model) OrderedKeys() []string {
+	keys := make([]string, 0, len(m.Values))
+	var added map[string]bool
+	if m.Keys != nil {
+		added = make(map[string]bool, len(m.Values))
+		for _, k := range m.Keys {
+			if _, ok := m.Values[k]; ok && !added[k] {
+				keys = append(keys, k)
+				added[k] = true
+			}
+		}
+		if len(keys) == len(m.Values) {
+			return keys
+		}
+	}
+	n := len(keys)
+	for k := range m.Values {
+		if !added[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys[n:])
+	return keys
+}
+
 // Collection represents a RES collection
 // https://github.com/resgateio/resgate/blob/master/docs/res-protocol.md#collections
 type Collection struct {
@@ -44,8 +105,12 @@ type Collection struct {
 	data   []byte
 }
 
-// MarshalJSON creates a JSON encoded representation of the collection
+// MarshalJSON creates a JSON encoded representation of the collection. An
+// empty collection is always encoded as [].
 func (c *Collection) MarshalJSON() ([]byte, error) {
+	if len(c.Values) == 0 {
+		return emptyCollectionBytes, nil
+	}
 	if c.data == nil {
 		data, err := json.Marshal(c.Values)
 		if err != nil {
@@ -64,6 +129,8 @@ type ResourceSubscription struct {
 	subs      map[Subscriber]struct{}
 	resetting bool
 	links     []string
+	pending   []*ResourceEvent // Events buffered while the get request is pending
+	modified  time.Time        // Time the resource was last fetched or changed
 	// Three types of values stored
 	model      *Model
 	collection *Collection
@@ -92,6 +159,14 @@ func (rs *ResourceSubscription) GetError() error {
 	return rs.err
 }
 
+// GetModified returns the time the resource was last fetched or changed, or
+// the zero time if it is not loaded.
+func (rs *ResourceSubscription) GetModified() time.Time {
+	rs.e.mu.Lock()
+	defer rs.e.mu.Unlock()
+	return rs.modified
+}
+
 // GetCollection will lock the EventSubscription for any changes
 // and return the collection string slice.
 // The lock must be released by calling Release
@@ -116,8 +191,9 @@ func (rs *ResourceSubscription) Release() {
 // Unsubscribe cancels the client subscriber's subscription
 func (rs *ResourceSubscription) Unsubscribe(sub Subscriber) {
 	rs.e.Enqueue(func() {
-		if sub != nil {
+		if _, ok := rs.subs[sub]; ok && sub != nil {
 			delete(rs.subs, sub)
+			rs.e.addSubscribers(-1)
 		}
 
 		// Directly unregister unsubscribed queries
@@ -132,7 +208,11 @@ func (rs *ResourceSubscription) Unsubscribe(sub Subscriber) {
 func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 	// Discard if event happened before resource was loaded,
 	// unless it is a reaccess. Then we let the event be passed further.
+	// If configured, events are buffered while the get request is pending.
 	if rs.state <= stateRequested && r.Event != "reaccess" {
+		if rs.state == stateRequested && rs.e.cache.bufferPending {
+			rs.pending = append(rs.pending, r)
+		}
 		return
 	}
 
@@ -141,21 +221,28 @@ func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 		if rs.resetting || !rs.handleEventChange(r) {
 			return
 		}
+		rs.modified = time.Now()
 	case "add":
 		if rs.resetting || !rs.handleEventAdd(r) {
 			return
 		}
+		rs.modified = time.Now()
 	case "remove":
 		if rs.resetting || !rs.handleEventRemove(r) {
 			return
 		}
+		rs.modified = time.Now()
 	case "delete":
 		if !rs.resetting {
+			rs.applied(r)
 			rs.handleEventDelete(r)
 		}
 		return
 	}
 
+	if r.Event != "reaccess" {
+		rs.applied(r)
+	}
 	rs.e.mu.Unlock()
 	for sub := range rs.subs {
 		sub.Event(r)
@@ -163,6 +250,13 @@ func (rs *ResourceSubscription) handleEvent(r *ResourceEvent) {
 	rs.e.mu.Lock()
 }
 
+// applied calls any event handler for an event applied to the resource.
+func (rs *ResourceSubscription) applied(r *ResourceEvent) {
+	if rs.e.cache.onEvent != nil {
+		rs.e.cache.onEvent(rs.resourceID(), r)
+	}
+}
+
 func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 	if rs.state == stateCollection {
 		rs.e.cache.Errorf("Error processing event %s.%s: change event on collection", rs.e.ResourceName, r.Event)
@@ -182,6 +276,8 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 
 	if err != nil {
 		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.resyncMalformed()
+		return false
 	}
 
 	// Clone old map using old map size as capacity.
@@ -215,7 +311,7 @@ func (rs *ResourceSubscription) handleEventChange(r *ResourceEvent) bool {
 
 	r.Changed = props
 	r.OldValues = rs.model.Values
-	rs.model = &Model{Values: m}
+	rs.model = &Model{Values: m, Keys: rs.model.Keys}
 	return true
 }
 
@@ -228,6 +324,7 @@ func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
 	params, err := codec.DecodeAddEvent(r.Payload)
 	if err != nil {
 		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.resyncMalformed()
 		return false
 	}
 
@@ -237,6 +334,7 @@ func (rs *ResourceSubscription) handleEventAdd(r *ResourceEvent) bool {
 
 	if idx < 0 || idx > l {
 		rs.e.cache.Errorf("Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
+		rs.resyncMalformed()
 		return false
 	}
 
@@ -263,6 +361,7 @@ func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
 	params, err := codec.DecodeRemoveEvent(r.Payload)
 	if err != nil {
 		rs.e.cache.Errorf("Error processing event %s.%s: %s", rs.e.ResourceName, r.Event, err)
+		rs.resyncMalformed()
 		return false
 	}
 
@@ -272,6 +371,7 @@ func (rs *ResourceSubscription) handleEventRemove(r *ResourceEvent) bool {
 
 	if idx < 0 || idx >= l {
 		rs.e.cache.Errorf("Error processing event %s.%s: idx %d is out of bounds", rs.e.ResourceName, r.Event, idx)
+		rs.resyncMalformed()
 		return false
 	}
 
@@ -293,6 +393,13 @@ func (rs *ResourceSubscription) handleEventDelete(r *ResourceEvent) {
 	rs.subs = nil
 	rs.unregister()
 	rs.e.removeCount(c)
+	rs.e.addSubscribers(-c)
+	// A deleted retained resource without subscribers is no longer retained,
+	// and is queued for unsubscribe unless it already is.
+	if c == 0 && rs.e.count == 0 && rs.e.cache.retained != nil {
+		rs.e.cache.unsubQueue.Remove(rs.e)
+		rs.e.cache.unsubQueue.Add(rs.e)
+	}
 
 	rs.e.mu.Unlock()
 	for sub := range subs {
@@ -303,7 +410,17 @@ func (rs *ResourceSubscription) handleEventDelete(r *ResourceEvent) {
 
 func (rs *ResourceSubscription) enqueueGetResponse(data []byte, err error) {
 	rs.e.Enqueue(func() {
+		pending := rs.pending
+		rs.pending = nil
 		rs, sublist := rs.processGetResponse(data, err)
+		if rs.state != stateError {
+			// Apply events buffered while the get request was pending on top
+			// of the get response, before the subscribers are loaded.
+			for _, r := range pending {
+				rs.handleEvent(r)
+			}
+			rs.e.startAgeTimer()
+		}
 
 		rs.e.mu.Unlock()
 		defer rs.e.mu.Lock()
@@ -337,12 +454,39 @@ func (rs *ResourceSubscription) unregister() {
 	rs.links = nil
 }
 
+// resourceID returns the resource ID, including any query.
+func (rs *ResourceSubscription) resourceID() string {
+	if rs.query == "" {
+		return rs.e.ResourceName
+	}
+	return rs.e.ResourceName + "?" + rs.query
+}
+
 func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (nrs *ResourceSubscription, sublist []Subscriber) {
 	var result *codec.GetResult
+	var keys []string
 	// Either we have an error making the request
 	// or an error in the service's response
 	if err == nil {
-		result, err = codec.DecodeGetResponse(payload)
+		rs.e.cache.warnUnexpectedFields("get."+rs.e.ResourceName, payload, codec.UnexpectedGetFields)
+		result, err = rs.e.cache.decodeGetResponse(payload)
+		if err == nil && result.Model != nil && rs.e.cache.preserveFieldOrder {
+			keys = codec.DecodeModelKeys(payload)
+		}
+		if ierr, ok := err.(*codec.InvalidResponseError); ok {
+			rs.e.cache.Logf("Subscription %s: Invalid get response - %s", rs.resourceID(), ierr.Reason)
+			err = reserr.RESError(err)
+		}
+	}
+
+	// Replace a not found resource with any configured default
+	if reserr.IsError(err, reserr.CodeNotFound) && rs.e.cache.notFoundDefault != nil {
+		if data, ok := rs.e.cache.notFoundDefault(rs.e.ResourceName); ok {
+			if dr, derr := codec.DecodeResource(data); derr == nil {
+				result, err = dr, nil
+				result.Query = rs.query
+			}
+		}
 	}
 
 	// Get request failed
@@ -365,6 +509,7 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 		rs.unregister()
 
 		rs.e.removeCount(c)
+		rs.e.addSubscribers(-c)
 		nrs = rs
 		return
 	}
@@ -414,12 +559,13 @@ func (rs *ResourceSubscription) processGetResponse(payload []byte, err error) (n
 	}
 
 	if result.Model != nil {
-		nrs.model = &Model{Values: result.Model}
+		nrs.model = &Model{Values: result.Model, Keys: keys}
 		nrs.state = stateModel
 	} else {
 		nrs.collection = &Collection{Values: result.Collection}
 		nrs.state = stateCollection
 	}
+	nrs.modified = time.Now()
 	return
 }
 
@@ -433,7 +579,7 @@ func (rs *ResourceSubscription) handleResetResource() {
 
 	// Create request
 	subj := "get." + rs.e.ResourceName
-	payload := codec.CreateGetRequest(rs.query)
+	payload := codec.CreateGetRequest(rs.query, rs.e.cache.deadline())
 	rs.e.cache.mq.SendRequest(subj, payload, func(_ string, data []byte, err error) {
 		rs.e.Enqueue(func() {
 			rs.resetting = false
@@ -442,6 +588,16 @@ func (rs *ResourceSubscription) handleResetResource() {
 	})
 }
 
+// resyncMalformed fetches a loaded resource again after a malformed event, if
+// the cache is set to resync on malformed events.
+func (rs *ResourceSubscription) resyncMalformed() {
+	if !rs.e.cache.resyncMalformed || rs.state < stateCollection {
+		return
+	}
+	rs.e.cache.Logf("Resyncing %s after malformed event", rs.resourceID())
+	rs.handleResetResource()
+}
+
 func (rs *ResourceSubscription) handleResetAccess() {
 	for sub := range rs.subs {
 		sub.Reaccess()
@@ -453,7 +609,8 @@ func (rs *ResourceSubscription) processResetGetResponse(payload []byte, err erro
 	// Either we have an error making the request
 	// or an error in the service's response
 	if err == nil {
-		result, err = codec.DecodeGetResponse(payload)
+		rs.e.cache.warnUnexpectedFields("get."+rs.e.ResourceName, payload, codec.UnexpectedGetFields)
+		result, err = rs.e.cache.decodeGetResponse(payload)
 		if err == nil && ((rs.state == stateModel && result.Model == nil) || (rs.state == stateCollection && result.Collection == nil)) {
 			err = errors.New("mismatching resource type")
 		}
@@ -461,11 +618,13 @@ func (rs *ResourceSubscription) processResetGetResponse(payload []byte, err erro
 
 	// Get request failed
 	if err != nil {
-		// In case of a system.notFound error,
+		// In case of a system.notFound or system.gone error,
 		// a delete event is generated. Otherwise we
 		// just log the error.
-		if reserr.IsError(err, reserr.CodeNotFound) {
+		if reserr.IsError(err, reserr.CodeNotFound) || reserr.IsError(err, reserr.CodeGone) {
 			rs.handleEvent(&ResourceEvent{Event: "delete"})
+		} else if ierr, ok := err.(*codec.InvalidResponseError); ok {
+			rs.e.cache.Errorf("Subscription %s: Reset get error - invalid get response: %s", rs.resourceID(), ierr.Reason)
 		} else {
 			rs.e.cache.Errorf("Subscription %s: Reset get error - %s", rs.e.ResourceName, err)
 		}
@@ -510,14 +669,64 @@ func (rs *ResourceSubscription) processResetModel(props map[string]codec.Value)
 }
 
 func (rs *ResourceSubscription) processResetCollection(collection []codec.Value) {
-	events := lcs(rs.collection.Values, collection)
+	var events []*ResourceEvent
+	lcs(rs.collection.Values, collection, func(idx int) {
+		events = append(events, &ResourceEvent{
+			Event: "remove",
+			Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{
+				Idx: idx,
+			}),
+		})
+	}, func(idx int, v codec.Value) {
+		events = append(events, &ResourceEvent{
+			Event: "add",
+			Payload: codec.EncodeAddEvent(&codec.AddEvent{
+				Value: v,
+				Idx:   idx,
+			}),
+		})
+	})
 
 	for _, r := range events {
 		rs.handleEvent(r)
 	}
 }
 
-func lcs(a, b []codec.Value) []*ResourceEvent {
+// Splice represents a splice operation on a collection, where Remove number
+// of values are removed at Idx, before Values are inserted at the same index.
+type Splice struct {
+	Idx    int
+	Remove int
+	Values []codec.Value
+}
+
+// DiffCollection returns the splice operations that, applied in order,
+// transforms the collection values a into b.
+// Consecutive removes and adds are merged into single splice operations.
+func DiffCollection(a, b []codec.Value) []Splice {
+	var splices []Splice
+	lcs(a, b, func(idx int) {
+		// Removes are made in descending index order
+		if l := len(splices) - 1; l >= 0 && splices[l].Values == nil && splices[l].Idx == idx+1 {
+			splices[l].Idx = idx
+			splices[l].Remove++
+			return
+		}
+		splices = append(splices, Splice{Idx: idx, Remove: 1})
+	}, func(idx int, v codec.Value) {
+		// Adds are made in ascending index order, after all removes
+		if l := len(splices) - 1; l >= 0 && splices[l].Idx+len(splices[l].Values) == idx {
+			splices[l].Values = append(splices[l].Values, v)
+			return
+		}
+		splices = append(splices, Splice{Idx: idx, Values: []codec.Value{v}})
+	})
+	return splices
+}
+
+// lcs calculates the longest common subsequence of a and b, and calls remove
+// and add for each step needed to transform a into b.
+func lcs(a, b []codec.Value, remove func(idx int), add func(idx int, v codec.Value)) {
 	var i, j int
 	// Do a LCS matric calculation
 	// https://en.wikipedia.org/wiki/Longest_common_subsequence_problem
@@ -531,7 +740,7 @@ func lcs(a, b []codec.Value) []*ResourceEvent {
 	}
 
 	if s == m && s == n {
-		return nil
+		return
 	}
 
 	for s < m && s < n && a[m-1].Equal(b[n-1]) {
@@ -573,8 +782,6 @@ func lcs(a, b []codec.Value) []*ResourceEvent {
 		}
 	}
 
-	steps := make([]*ResourceEvent, 0, m+n-2*c[w*(n+1)-1])
-
 	idx := m + s
 	i = m
 	j = n
@@ -599,12 +806,7 @@ Loop:
 			j--
 		case i > 0 && (j == 0 || c[i+w*n] < c[m+w*j]):
 			idx--
-			steps = append(steps, &ResourceEvent{
-				Event: "remove",
-				Payload: codec.EncodeRemoveEvent(&codec.RemoveEvent{
-					Idx: idx,
-				}),
-			})
+			remove(idx)
 			r++
 			i--
 		default:
@@ -615,15 +817,7 @@ Loop:
 	// Do the adds
 	l := len(adds) - 1
 	for i := l; i >= 0; i-- {
-		add := adds[i]
-		steps = append(steps, &ResourceEvent{
-			Event: "add",
-			Payload: codec.EncodeAddEvent(&codec.AddEvent{
-				Value: bb[add[0]],
-				Idx:   add[1] - r + add[2] + l - i,
-			}),
-		})
+		ad := adds[i]
+		add(ad[1]-r+ad[2]+l-i, bb[ad[0]])
 	}
-
-	return steps
 }
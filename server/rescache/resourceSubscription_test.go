@@ -0,0 +1,125 @@
+package rescache_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+func TestDiffCollection_ReturnsSplices(t *testing.T) {
+	tbl := []struct {
+		A        string
+		B        string
+		Expected string
+	}{
+		{`[]`, `[]`, `null`},
+		{`[1,2,3]`, `[1,2,3]`, `null`},
+		{`[]`, `[1,2]`, `[{"Idx":0,"Remove":0,"Values":[1,2]}]`},
+		{`[1,2]`, `[]`, `[{"Idx":0,"Remove":2,"Values":null}]`},
+		{`[1,2,3]`, `[1,4,3]`, `[{"Idx":1,"Remove":1,"Values":[4]}]`},
+		{`[1,2,3,4]`, `[0,1,3,5]`, `[{"Idx":3,"Remove":1,"Values":null},{"Idx":1,"Remove":1,"Values":null},{"Idx":0,"Remove":0,"Values":[0]},{"Idx":3,"Remove":0,"Values":[5]}]`},
+		{`["a",{"rid":"b"}]`, `[{"rid":"b"},"a"]`, `[{"Idx":0,"Remove":1,"Values":null},{"Idx":1,"Remove":0,"Values":["a"]}]`},
+	}
+
+	for i, l := range tbl {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			var a, b []codec.Value
+			if err := json.Unmarshal([]byte(l.A), &a); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(l.B), &b); err != nil {
+				t.Fatal(err)
+			}
+			out, err := json.Marshal(rescache.DiffCollection(a, b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			AssertEqualJSON(t, "DiffCollection", json.RawMessage(out), json.RawMessage(l.Expected))
+		})
+	}
+}
+
+func TestModelAndCollection_MarshalJSONEmpty_ReturnsEmptyObjectAndArray(t *testing.T) {
+	tbl := []struct {
+		Value    json.Marshaler
+		Expected string
+	}{
+		{&rescache.Model{}, `{}`},
+		{&rescache.Model{Values: map[string]codec.Value{}}, `{}`},
+		{&rescache.Collection{}, `[]`},
+		{&rescache.Collection{Values: []codec.Value{}}, `[]`},
+		{(*rescache.Legacy120Model)(&rescache.Model{}), `{}`},
+		{(*rescache.Legacy120Collection)(&rescache.Collection{}), `[]`},
+	}
+
+	for i, l := range tbl {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			out, err := l.Value.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != l.Expected {
+				t.Fatalf("expected %s, but got %s", l.Expected, out)
+			}
+		})
+	}
+}
+
+func TestModel_MarshalJSON_ReturnsStableFieldOrder(t *testing.T) {
+	tbl := []struct {
+		Data     string
+		Keys     []string
+		Expected string
+	}{
+		{`{"zeta":1,"alpha":2,"mid":3}`, nil, `{"alpha":2,"mid":3,"zeta":1}`},
+		{`{"zeta":1,"alpha":2,"mid":3}`, []string{"zeta", "alpha", "mid"}, `{"zeta":1,"alpha":2,"mid":3}`},
+		{`{"zeta":1,"alpha":2,"mid":3}`, []string{"mid"}, `{"mid":3,"alpha":2,"zeta":1}`},
+		{`{"zeta":1,"alpha":2}`, []string{"zeta", "gone", "zeta", "alpha"}, `{"zeta":1,"alpha":2}`},
+		{`{"zeta":1,"alpha":2}`, []string{}, `{"alpha":2,"zeta":1}`},
+	}
+
+	for i, l := range tbl {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			var vals map[string]codec.Value
+			if err := json.Unmarshal([]byte(l.Data), &vals); err != nil {
+				t.Fatal(err)
+			}
+			// Repeat to assert the order does not depend on map iteration
+			for j := 0; j < 10; j++ {
+				out, err := (&rescache.Model{Values: vals, Keys: l.Keys}).MarshalJSON()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(out) != l.Expected {
+					t.Fatalf("expected %s, but got %s", l.Expected, out)
+				}
+			}
+		})
+	}
+}
+
+func TestModelAndCollection_RoundTripEmpty_ReturnsEmptyObjectAndArray(t *testing.T) {
+	for _, data := range []string{`{}`, `[]`} {
+		t.Run(data, func(t *testing.T) {
+			r, err := codec.DecodeResource(json.RawMessage(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out []byte
+			if r.Model != nil {
+				out, err = (&rescache.Model{Values: r.Model}).MarshalJSON()
+			} else {
+				out, err = (&rescache.Collection{Values: r.Collection}).MarshalJSON()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != data {
+				t.Fatalf("expected %s, but got %s", data, out)
+			}
+		})
+	}
+}
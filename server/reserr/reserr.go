@@ -1,5 +1,10 @@
 package reserr
 
+import (
+	"math"
+	"time"
+)
+
 // Error represents a RES error
 type Error struct {
 	Code    string      `json:"code"`
@@ -34,6 +39,37 @@ func IsError(err error, code string) bool {
 	return rerr.Code == code
 }
 
+// RetryAfterData is the data of an error for a request that may be retried
+// after a number of seconds.
+type RetryAfterData struct {
+	RetryAfter int `json:"retryAfter"`
+}
+
+// WithRetryAfter returns a copy of the error, with data telling the client to
+// retry the request after the duration, rounded up to whole seconds.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	sec := int(math.Ceil(d.Seconds()))
+	if sec < 1 {
+		sec = 1
+	}
+	return &Error{Code: e.Code, Message: e.Message, Data: RetryAfterData{RetryAfter: sec}}
+}
+
+// RetryAfter returns the number of seconds after which the request may be
+// retried, as set by the retryAfter field of the error data. If not set, 0 is
+// returned.
+func (e *Error) RetryAfter() int {
+	switch d := e.Data.(type) {
+	case RetryAfterData:
+		return d.RetryAfter
+	case map[string]interface{}:
+		if v, ok := d["retryAfter"].(float64); ok && v > 0 {
+			return int(math.Ceil(v))
+		}
+	}
+	return 0
+}
+
 // Pre-defined RES error codes
 const (
 	CodeAccessDenied        = "system.accessDenied"
@@ -43,6 +79,8 @@ const (
 	CodeMethodNotFound      = "system.methodNotFound"
 	CodeNoSubscription      = "system.noSubscription"
 	CodeNotFound            = "system.notFound"
+	CodeGone                = "system.gone"
+	CodePreconditionFailed  = "system.preconditionFailed"
 	CodeTimeout             = "system.timeout"
 	CodeInvalidRequest      = "system.invalidRequest"
 	CodeUnsupportedProtocol = "system.unsupportedProtocol"
@@ -65,6 +103,8 @@ var (
 	ErrMethodNotFound      = &Error{Code: CodeMethodNotFound, Message: "Method not found"}
 	ErrNoSubscription      = &Error{Code: CodeNoSubscription, Message: "No subscription"}
 	ErrNotFound            = &Error{Code: CodeNotFound, Message: "Not found"}
+	ErrGone                = &Error{Code: CodeGone, Message: "Gone"}
+	ErrPreconditionFailed  = &Error{Code: CodePreconditionFailed, Message: "Precondition failed"}
 	ErrTimeout             = &Error{Code: CodeTimeout, Message: "Request timeout"}
 	ErrInvalidRequest      = &Error{Code: CodeInvalidRequest, Message: "Invalid request"}
 	ErrUnsupportedProtocol = &Error{Code: CodeUnsupportedProtocol, Message: "Unsupported protocol"}
@@ -0,0 +1,13 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// resourceVersion returns a version string for the JSON encoded resource
+// data. Equal data always results in the same version.
+func resourceVersion(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
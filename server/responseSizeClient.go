@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var errResponseTooLarge = &reserr.Error{Code: reserr.CodeInternalError, Message: "Internal error: response exceeds size limit"}
+
+// responseSizeClient wraps a mq.Client, rejecting responses larger than the
+// maximum size with an internal error before they are processed.
+type responseSizeClient struct {
+	mq.Client
+	max  int
+	logf func(format string, v ...interface{})
+}
+
+// SendRequest sends the request, rejecting an oversized response.
+func (c *responseSizeClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithHeader sends the request with the headers, rejecting an
+// oversized response.
+func (c *responseSizeClient) SendRequestWithHeader(subj string, header map[string][]string, payload []byte, cb mq.Response) {
+	c.SendRequestWithTimeout(subj, header, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends the request with any headers and timeout,
+// rejecting an oversized response.
+func (c *responseSizeClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	mq.SendRequest(c.Client, subj, header, payload, timeout, func(rsubj string, data []byte, err error) {
+		if err == nil && len(data) > c.max {
+			c.logf("Response on %s exceeds maxResponseSize (%d > %d bytes)", subj, len(data), c.max)
+			cb(rsubj, nil, errResponseTooLarge)
+			return
+		}
+		cb(rsubj, data, err)
+	})
+}
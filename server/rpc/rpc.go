@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/resgateio/resgate/server/codec"
@@ -14,13 +15,19 @@ import (
 type Requester interface {
 	Reply(data []byte)
 	GetResource(rid string, callback func(data *Resources, err error))
-	SubscribeResource(rid string, callback func(data *Resources, err error))
+	SubscribeResource(rid string, fields []string, callback func(data *Resources, err error))
+	SubscribeResources(rids []string, callback func(data *Resources, err error))
+	ResourceVersions(rids []string, callback func(data *VersionsResult, err error))
 	UnsubscribeResource(rid string, count int, callback func(ok bool))
 	CallResource(rid, action string, params interface{}, callback func(result interface{}, err error))
 	AuthResource(rid, action string, params interface{}, callback func(result interface{}, err error))
 	NewResource(rid string, params interface{}, callback func(result interface{}, err error))
 	SetVersion(protocol string) (string, error)
 	ProtocolVersion() int
+	MaxParamsDepth() int
+	Ack(ids []uint64)
+	Redeliver(key string, callback func(key string))
+	Resume(token string, callback func(data *ResumeResult, err error))
 }
 
 // Request represent a RES-client request
@@ -34,9 +41,16 @@ type Request struct {
 // Response represents a RES-client response
 type Response struct {
 	Result interface{} `json:"result,omitempty"`
+	Meta   interface{} `json:"meta,omitempty"`
 	ID     *uint64     `json:"id"`
 }
 
+// MetaResult is a result sent with a meta object in the response.
+type MetaResult struct {
+	Result interface{}
+	Meta   interface{}
+}
+
 // Event represent a RES-client event object
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#event-object
 type Event struct {
@@ -82,12 +96,40 @@ type ChangeEvent struct {
 	*Resources
 }
 
+// DiffEvent represents a RES-client collection diff event, replacing a burst
+// of collection add and remove events with a list of splice operations.
+type DiffEvent struct {
+	Splices []Splice `json:"splices"`
+	*Resources
+}
+
+// Splice represents a splice operation in a collection diff event, where
+// Remove number of values are removed at Idx before Values are inserted.
+type Splice struct {
+	Idx    int               `json:"idx"`
+	Remove int               `json:"remove,omitempty"`
+	Values []json.RawMessage `json:"values,omitempty"`
+}
+
 // UnsubscribeEvent represents a RES-client unsubscribe event
 // https://github.com/resgateio/resgate/blob/master/docs/res-client-protocol.md#unsubscribe-event
 type UnsubscribeEvent struct {
 	Reason *reserr.Error `json:"reason"`
 }
 
+// ResyncEvent represents a RES-client resync manifest event sent by a
+// gateway that is shutting down, listing the directly subscribed resources.
+type ResyncEvent struct {
+	RIDs []string `json:"rids"`
+}
+
+// BackpressureEvent represents a RES-client backpressure event sent by a
+// gateway, advising the client to reduce its request rate.
+type BackpressureEvent struct {
+	Reason     string `json:"reason"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+}
+
 // CallPayloadResult represents a RES-client result to a call or auth request with payload response
 type CallPayloadResult struct {
 	Payload json.RawMessage `json:"payload"`
@@ -99,11 +141,60 @@ type CallResourceResult struct {
 	*Resources
 }
 
+// SubscribeRequest represents the params of a subscribe request for multiple resources
+type SubscribeRequest struct {
+	RIDs []string `json:"rids"`
+}
+
+// VersionsRequest represents the params of a versions request
+type VersionsRequest struct {
+	RIDs []string `json:"rids"`
+}
+
+// VersionsResult represents the result of a versions request
+type VersionsResult struct {
+	Versions map[string]string        `json:"versions"`
+	Errors   map[string]*reserr.Error `json:"errors,omitempty"`
+}
+
+// SubscribeResourceRequest represents the params of a subscribe request for
+// a single resource
+type SubscribeResourceRequest struct {
+	Fields []string `json:"fields"`
+}
+
 // UnsubscribeRequest represents the params of an unsubscribe request
 type UnsubscribeRequest struct {
 	Count *int `json:"count"`
 }
 
+// AckRequest represents the params of an ack request
+type AckRequest struct {
+	IDs []uint64 `json:"ids"`
+}
+
+// RedeliverRequest represents the params of a redeliver request
+type RedeliverRequest struct {
+	Key string `json:"key"`
+}
+
+// RedeliverResult represents the result of a redeliver request
+type RedeliverResult struct {
+	Key string `json:"key"`
+}
+
+// ResumeRequest represents the params of a resume request
+type ResumeRequest struct {
+	Token string `json:"token"`
+}
+
+// ResumeResult represents the result of a resume request
+type ResumeResult struct {
+	Token   string `json:"token"`
+	Resumed bool   `json:"resumed"`
+	*Resources
+}
+
 var (
 	errMissingID = errors.New("Request is missing id property")
 )
@@ -112,6 +203,17 @@ var nullBytes = []byte("null")
 
 // HandleRequest unmarshals a request byte array and dispatches the request to the requester
 func HandleRequest(data []byte, req Requester) error {
+	// Reject over-deep params before the request is decoded. The request
+	// object itself adds one level of nesting.
+	if max := req.MaxParamsDepth(); max > 0 && codec.ExceedsJSONDepth(data, max+1) {
+		r := &Request{ID: requestID(data)}
+		if r.ID == nil {
+			return errMissingID
+		}
+		req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+		return nil
+	}
+
 	r := &Request{}
 	err := json.Unmarshal(data, r)
 	if err != nil {
@@ -141,6 +243,93 @@ func HandleRequest(data []byte, req Requester) error {
 			req.Reply(r.SuccessResponse(VersionResult{Protocol: p}))
 			return nil
 		}
+		if r.Method == "subscribe" {
+			var sr SubscribeRequest
+			err := json.Unmarshal(r.Params, &sr)
+			if err != nil || len(sr.RIDs) == 0 {
+				req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+				return nil
+			}
+			for _, rid := range sr.RIDs {
+				if !codec.IsValidRID(rid, true) {
+					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					return nil
+				}
+			}
+			req.SubscribeResources(sr.RIDs, func(data *Resources, err error) {
+				if err != nil {
+					req.Reply(r.ErrorResponse(err))
+				} else {
+					req.Reply(r.SuccessResponse(data))
+				}
+			})
+			return nil
+		}
+		if r.Method == "versions" {
+			var vr VersionsRequest
+			err := json.Unmarshal(r.Params, &vr)
+			if err != nil || len(vr.RIDs) == 0 {
+				req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+				return nil
+			}
+			for _, rid := range vr.RIDs {
+				if !codec.IsValidRID(rid, true) {
+					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					return nil
+				}
+			}
+			req.ResourceVersions(vr.RIDs, func(data *VersionsResult, err error) {
+				if err != nil {
+					req.Reply(r.ErrorResponse(err))
+				} else {
+					req.Reply(r.SuccessResponse(data))
+				}
+			})
+			return nil
+		}
+		if r.Method == "ack" {
+			var ar AckRequest
+			err := json.Unmarshal(r.Params, &ar)
+			if err != nil || len(ar.IDs) == 0 {
+				req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+				return nil
+			}
+			req.Ack(ar.IDs)
+			req.Reply(r.SuccessResponse(nil))
+			return nil
+		}
+		if r.Method == "redeliver" {
+			var rr RedeliverRequest
+			if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
+				err := json.Unmarshal(r.Params, &rr)
+				if err != nil {
+					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					return nil
+				}
+			}
+			req.Redeliver(rr.Key, func(key string) {
+				req.Reply(r.SuccessResponse(RedeliverResult{Key: key}))
+			})
+			return nil
+		}
+		if r.Method == "resume" {
+			var rr ResumeRequest
+			if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
+				err := json.Unmarshal(r.Params, &rr)
+				if err != nil {
+					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					return nil
+				}
+			}
+			req.Resume(rr.Token, func(data *ResumeResult, err error) {
+				if err != nil {
+					req.Reply(r.ErrorResponse(err))
+				} else {
+					req.Reply(r.SuccessResponse(data))
+				}
+			})
+			return nil
+		}
 		req.Reply(r.ErrorResponse(reserr.ErrInvalidRequest))
 		return nil
 	}
@@ -178,7 +367,21 @@ func HandleRequest(data []byte, req Requester) error {
 			}
 		})
 	case "subscribe":
-		req.SubscribeResource(rid, func(data *Resources, err error) {
+		var sr SubscribeResourceRequest
+		if len(r.Params) > 0 && !bytes.Equal(r.Params, nullBytes) {
+			err := json.Unmarshal(r.Params, &sr)
+			if err != nil || (sr.Fields != nil && len(sr.Fields) == 0) {
+				req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+				return nil
+			}
+			for _, f := range sr.Fields {
+				if f == "" {
+					req.Reply(r.ErrorResponse(reserr.ErrInvalidParams))
+					return nil
+				}
+			}
+		}
+		req.SubscribeResource(rid, sr.Fields, func(data *Resources, err error) {
 			if err != nil {
 				req.Reply(r.ErrorResponse(err))
 			} else {
@@ -245,6 +448,10 @@ func HandleRequest(data []byte, req Requester) error {
 
 // SuccessResponse encodes a result to a request response
 func (r *Request) SuccessResponse(result interface{}) []byte {
+	if mr, ok := result.(*MetaResult); ok {
+		out, _ := json.Marshal(Response{Result: mr.Result, Meta: mr.Meta, ID: r.ID})
+		return out
+	}
 	out, _ := json.Marshal(Response{Result: result, ID: r.ID})
 	return out
 }
@@ -255,6 +462,23 @@ func NewEvent(rid string, event string, data interface{}) []byte {
 	return out
 }
 
+// NewConnEvent creates an encoded event, not bound to any resource, to be
+// sent to the client
+func NewConnEvent(event string, data interface{}) []byte {
+	out, _ := json.Marshal(Event{Event: event, Data: data})
+	return out
+}
+
+// WithAck returns a copy of the encoded event with an ack ID added, to be
+// acknowledged by the client.
+func WithAck(event []byte, id uint64) []byte {
+	out := make([]byte, 0, len(event)+28)
+	out = append(out, `{"ack":`...)
+	out = strconv.AppendUint(out, id, 10)
+	out = append(out, ',')
+	return append(out, event[1:]...)
+}
+
 // ErrorResponse encodes an error to a request response
 func (r *Request) ErrorResponse(err error) []byte {
 	rerr := reserr.RESError(err)
@@ -264,3 +488,43 @@ func (r *Request) ErrorResponse(err error) []byte {
 	}
 	return d
 }
+
+// requestID returns the id of a JSON encoded request, or nil if it has no
+// valid id. Other values of the request are skipped without being decoded.
+func requestID(data []byte) *uint64 {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		if t == "id" {
+			var id *uint64
+			if dec.Decode(&id) != nil {
+				return nil
+			}
+			return id
+		}
+		// Skip the value
+		depth := 0
+		for {
+			t, err := dec.Token()
+			if err != nil {
+				return nil
+			}
+			switch t {
+			case json.Delim('{'), json.Delim('['):
+				depth++
+			case json.Delim('}'), json.Delim(']'):
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/resgateio/resgate/logger"
+	"github.com/resgateio/resgate/server/jwt"
 	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/rescache"
 )
@@ -21,18 +22,48 @@ type Service struct {
 	stopping bool
 	stop     chan error
 
-	mq    mq.Client
-	cache *rescache.Cache
+	mq        mq.Client
+	mqReady   int32         // Set to 1 while connected to the messaging system
+	mqConnect chan struct{} // Closed on stop to cancel a pending connect retry
+	cache     *rescache.Cache
+	evictLog  *logLimiter
+	jwt       *jwt.Validator
+
+	// eventMirror
+	mirrorMu   sync.Mutex
+	mirrorCh   chan []byte   // Queued mirrored events, or nil if not started
+	mirrorDone chan struct{} // Closed when the mirror goroutine exits
+	mirrorLog  *logLimiter
+
+	// warmup
+	warmupMu sync.Mutex
+	warming  map[string]bool // Resources with a pending initial load
 
 	// httpServer
-	h        *http.Server
-	enc      APIEncoder
-	mimetype string
+	h          *http.Server
+	enc        APIEncoder
+	encFactory APIEncoderFactory
+	msgpackEnc APIEncoder
+	mimetype   string
+
+	// metrics
+	metrics serviceMetrics
+	mh      *http.Server
+	shedder *loadShedder
+
+	// webhooks
+	webhookMu       sync.Mutex
+	webhooks        map[string]*webhook // Webhooks by ID
+	webhooksStarted bool
+	webhookClient   *http.Client
 
 	// wsListener/wsConn
 	upgrader websocket.Upgrader
-	conns    map[string]*wsConn // Connections by wsConn Id's
-	wg       sync.WaitGroup     // Wait for all connections to be disconnected
+	conns    map[string]*wsConn        // Connections by wsConn Id's
+	unacked  map[string]*unackedEvents // Unacknowledged events of disconnected clients by ack key
+	resumes  map[string]*resumeSession // Sessions of disconnected clients by resume token
+	reserved int                       // Connection slots reserved for WebSocket upgrades in progress
+	wg       sync.WaitGroup            // Wait for all connections to be disconnected
 }
 
 // NewService creates a new Service
@@ -45,12 +76,19 @@ func NewService(mq mq.Client, cfg Config) (*Service, error) {
 	if err := s.cfg.prepare(); err != nil {
 		return nil, err
 	}
+	s.initMetrics()
 	s.initHTTPServer()
 	s.initWSHandler()
 	s.initMQClient()
+	if err := s.initJWT(); err != nil {
+		return nil, err
+	}
 	if err := s.initAPIHandler(); err != nil {
 		return nil, err
 	}
+	if err := s.initWebhooks(); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -63,6 +101,7 @@ func (s *Service) SetLogger(l logger.Logger) *Service {
 		panic("SetLogger must be called before starting server")
 	}
 
+	l = logger.NewRedactLogger(l, s.cfg.RedactQueryParams)
 	s.logger = l
 	s.cache.SetLogger(l)
 	return s
@@ -115,13 +154,25 @@ func (s *Service) start() error {
 
 	s.Logf("Starting resgate version %s", Version)
 	s.Debugf("Go runtime version %s", runtime.Version())
+	if s.cfg.DangerouslyEnableMockResources {
+		s.Logf("WARNING: Mock resources enabled - not for production use")
+	}
 	s.stop = make(chan error, 1)
 
+	// The metrics server is started first to have the readiness endpoint
+	// report not ready while waiting to connect to the messaging system.
+	s.startMetricsServer()
 	if err := s.startMQClient(); err != nil {
 		return err
 	}
+	if err := s.startWebhooks(); err != nil {
+		return err
+	}
+	s.startWarmup()
 
-	s.startHTTPServer()
+	if err := s.startHTTPServer(); err != nil {
+		return err
+	}
 	s.Logf("Server ready")
 
 	return nil
@@ -135,6 +186,10 @@ func (s *Service) Stop(err error) {
 		return
 	}
 	s.stopping = true
+	if s.mqConnect != nil {
+		close(s.mqConnect)
+		s.mqConnect = nil
+	}
 	s.mu.Unlock()
 
 	if err != nil {
@@ -143,7 +198,11 @@ func (s *Service) Stop(err error) {
 	s.Logf("Stopping server...")
 
 	s.stopWSHandler()
+	s.clearUnackedEvents()
+	s.clearResumeSessions()
 	s.stopHTTPServer()
+	s.stopMetricsServer()
+	s.stopWebhooks()
 	s.stopMQClient()
 
 	s.mu.Lock()
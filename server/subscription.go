@@ -3,7 +3,9 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/rescache"
@@ -23,11 +25,27 @@ type ConnSubscriber interface {
 	Subscribe(rid string, direct bool) (*Subscription, error)
 	Unsubscribe(sub *Subscription, direct bool, count int, tryDelete bool)
 	Access(sub *Subscription, callback func(*rescache.Access))
+	SharedAccess(sub *Subscription, rid string, callback func(*rescache.Access))
 	Send(data []byte)
+	SendEvent(rname string, data []byte)
 	Enqueue(f func()) bool
 	ExpandCID(string) string
 	Disconnect(reason string)
 	ProtocolVersion() int
+	CollectionDiffWindow() time.Duration
+	SubscriptionIdleTimeout() time.Duration
+	ChangeDebounce(rname string) time.Duration
+	CollectionFilter() bool
+	OmitNullFields() bool
+	ConsistentSnapshots() bool
+	EventSequence() bool
+	Timing() *requestTiming
+	EventOutOfOrder(rid, event string, seq, last uint64)
+	InjectQuery(rname, query string) (string, bool)
+	ForwardedHeader() http.Header
+	Locale() string
+	ClearAccessCache()
+	AccessRevoked()
 }
 
 // Subscription represents a resource subscription made by a client connection
@@ -52,6 +70,21 @@ type Subscription struct {
 	access          *rescache.Access
 	accessCallbacks []func(*rescache.Access)
 	flags           uint8
+	diffTimer       *time.Timer
+	diffValues      []codec.Value // Collection values with changes of a pending diff window
+	diffRemoved     []string
+	changeTimer     *time.Timer
+	changeBase      map[string]codec.Value
+	changeRemoved   []string
+	filter          *collectionFilter
+	fields          map[string]bool        // Model fields of interest, or nil for all fields
+	idleTimer       *time.Timer            // Timer for expiring an idle direct subscription
+	active          time.Time              // Time of the last event or client request
+	eventSeq        eventSequence          // Sequence of events, if verified
+	loadStart       time.Time              // Time the resource started loading, if timed
+	loadTimer       *time.Timer            // Timer for the referenceTimeout of a HTTP reference
+	unresolved      bool                   // Injected query could not be resolved from the token
+	filteredBy      map[*Subscription]bool // Filtered collections referencing the model
 
 	// Protected by conn
 	direct   int // Number of direct subscriptions
@@ -79,6 +112,10 @@ const (
 	stateDeleted
 )
 
+// flushPendingEvent is an internal event, queued to flush a pending diff or
+// change once its window has passed while events are queued.
+var flushPendingEvent = &rescache.ResourceEvent{Event: "pending.flush"}
+
 const (
 	queueReasonLoading uint8 = 1 << iota
 	queueReasonReaccess
@@ -92,12 +129,20 @@ const (
 var (
 	errSubscriptionLimitExceeded = &reserr.Error{Code: "system.subscriptionLimitExceeded", Message: "Subscription limit exceeded"}
 	errDisposedSubscription      = &reserr.Error{Code: "system.disposedSubscription", Message: "Resource subscription is disposed"}
+	errSubscriptionExpired       = &reserr.Error{Code: "system.subscriptionExpired", Message: "Subscription expired"}
+	errReferenceTimeout          = &reserr.Error{Code: reserr.CodeTimeout, Message: "Reference timeout"}
 )
 
 // NewSubscription creates a new Subscription
 func NewSubscription(c ConnSubscriber, rid string) *Subscription {
 	name, query := parseRID(c.ExpandCID(rid))
 
+	var filter *collectionFilter
+	if c.CollectionFilter() {
+		filter, query = parseCollectionFilter(query)
+	}
+	query, ok := c.InjectQuery(name, query)
+
 	sub := &Subscription{
 		rid:           rid,
 		resourceName:  name,
@@ -105,6 +150,8 @@ func NewSubscription(c ConnSubscriber, rid string) *Subscription {
 		c:             c,
 		state:         stateLoading,
 		queueFlag:     queueReasonLoading,
+		filter:        filter,
+		unresolved:    !ok,
 	}
 
 	return sub
@@ -140,6 +187,42 @@ func (s *Subscription) CID() string {
 	return s.c.CID()
 }
 
+// ForwardedHeader returns the HTTP headers of the client connection to
+// forward in access requests.
+func (s *Subscription) ForwardedHeader() http.Header {
+	return s.c.ForwardedHeader()
+}
+
+// Locale returns the locale of the client connection to forward in requests.
+func (s *Subscription) Locale() string {
+	return s.c.Locale()
+}
+
+// LastModified returns the latest time the resource, or any resource it
+// references, was fetched or changed. It returns the zero time if no
+// resource is loaded.
+func (s *Subscription) LastModified() time.Time {
+	var t time.Time
+	visited := make(map[*Subscription]bool)
+	var walk func(sub *Subscription)
+	walk = func(sub *Subscription) {
+		if visited[sub] {
+			return
+		}
+		visited[sub] = true
+		if sub.resourceSub != nil {
+			if m := sub.resourceSub.GetModified(); m.After(t) {
+				t = m
+			}
+		}
+		for _, ref := range sub.refs {
+			walk(ref.sub)
+		}
+	}
+	walk(s)
+	return t
+}
+
 // IsReady returns true if the subscription and all of its dependencies are loaded.
 func (s *Subscription) IsReady() bool {
 	return s.state >= stateReady
@@ -164,12 +247,34 @@ func (s *Subscription) ModelValues() map[string]codec.Value {
 	return s.model.Values
 }
 
+// ModelKeys returns the keys of the subscriptions model values, in the
+// deterministic order they are encoded.
+// Panics if the subscription is not a loaded model.
+func (s *Subscription) ModelKeys() []string {
+	return s.model.OrderedKeys()
+}
+
 // CollectionValues returns the subscriptions collection values.
 // Panics if the subscription is not a loaded collection.
 func (s *Subscription) CollectionValues() []codec.Value {
+	s.filterCollection()
 	return s.collection.Values
 }
 
+// Version returns the version of the subscription's own resource data.
+// Panics if the subscription is not loaded.
+func (s *Subscription) Version() string {
+	var data []byte
+	switch s.typ {
+	case rescache.TypeCollection:
+		s.filterCollection()
+		data, _ = s.collection.MarshalJSON()
+	case rescache.TypeModel:
+		data, _ = s.model.MarshalJSON()
+	}
+	return resourceVersion(data)
+}
+
 // Ref returns the referenced subscription, or nil if subscription has no such reference.
 func (s *Subscription) Ref(rid string) *Subscription {
 	r := s.refs[rid]
@@ -183,7 +288,17 @@ func (s *Subscription) Ref(rid string) *Subscription {
 // If the resource was successfully loaded, err will be nil. If an error occurred
 // when loading the resource, resourceSub will be nil, and err will be the error.
 func (s *Subscription) Loaded(resourceSub *rescache.ResourceSubscription, err error) {
+	if !s.loadStart.IsZero() {
+		s.c.Timing().observe("get", s.loadStart)
+	}
 	if !s.c.Enqueue(func() {
+		s.stopLoadTimer()
+		if s.err == errReferenceTimeout {
+			if err == nil {
+				resourceSub.Unsubscribe(s)
+			}
+			return
+		}
 		if err != nil {
 			s.err = err
 			s.doneLoading()
@@ -220,6 +335,12 @@ func (s *Subscription) Loaded(resourceSub *rescache.ResourceSubscription, err er
 
 // setResource is called after Loaded is called
 func (s *Subscription) setResource() {
+	if s.filter != nil && (s.filter.err != nil || s.typ != rescache.TypeCollection) {
+		s.c.Debugf("Subscription %s: Invalid collection filter", s.rid)
+		s.err = errInvalidFilter
+		return
+	}
+
 	switch s.typ {
 	case rescache.TypeCollection:
 		s.setCollection()
@@ -277,16 +398,53 @@ func (s *Subscription) GetRPCResources() *rpc.Resources {
 // ReleaseRPCResources will unlock all resources locked by GetRPCResource,
 // unqueue any events, and mark the subscription as sent.
 func (s *Subscription) ReleaseRPCResources() {
+	if s.c.ConsistentSnapshots() {
+		releaseSnapshot([]*Subscription{s})
+		return
+	}
+	if !s.markSent() {
+		return
+	}
+	s.forEachSentRef(func(sub *Subscription) {
+		sub.ReleaseRPCResources()
+	})
+	s.unqueueEvents(queueReasonLoading)
+}
+
+// markSent marks the subscription as sent. Returns false if the subscription
+// is disposed, already sent, or has an error.
+func (s *Subscription) markSent() bool {
 	if s.state == stateDisposed ||
 		s.state == stateSent ||
 		s.err != nil {
-		return
+		return false
 	}
 	s.state = stateSent
-	for _, sc := range s.refs {
-		sc.sub.ReleaseRPCResources()
+	return true
+}
+
+// releaseSnapshot marks the subscriptions, and all references sent with
+// them, as sent before unqueueing the events of any of them. This ensures no
+// queued event is sent until the whole snapshot is released.
+func releaseSnapshot(subs []*Subscription) {
+	var sent []*Subscription
+	var mark func(sub *Subscription)
+	mark = func(sub *Subscription) {
+		if !sub.markSent() {
+			return
+		}
+		sub.forEachSentRef(mark)
+		sent = append(sent, sub)
+	}
+	for _, sub := range subs {
+		mark(sub)
+	}
+	for _, sub := range sent {
+		// An event of a previous subscription may have disposed it
+		if sub.state != stateDisposed {
+			sub.unqueueEvents(queueReasonLoading)
+		}
 	}
-	s.unqueueEvents(queueReasonLoading)
 }
 
 func (s *Subscription) queueEvents(reason uint8) {
@@ -346,6 +504,7 @@ func (s *Subscription) populateResources(r *rpc.Resources) {
 		if r.Collections == nil {
 			r.Collections = make(map[string]interface{})
 		}
+		s.filterCollection()
 		r.Collections[s.rid] = s.collection
 
 	case rescache.TypeModel:
@@ -358,9 +517,9 @@ func (s *Subscription) populateResources(r *rpc.Resources) {
 
 	s.state = stateToSend
 
-	for _, sc := range s.refs {
-		sc.sub.populateResources(r)
-	}
+	s.forEachSentRef(func(sub *Subscription) {
+		sub.populateResources(r)
+	})
 }
 
 // populateResourcesLegacy is the same as populateResources, but uses legacy
@@ -388,6 +547,7 @@ func (s *Subscription) populateResourcesLegacy(r *rpc.Resources) {
 		if r.Collections == nil {
 			r.Collections = make(map[string]interface{})
 		}
+		s.filterCollection()
 		r.Collections[s.rid] = (*rescache.Legacy120Collection)(s.collection)
 
 	case rescache.TypeModel:
@@ -400,9 +560,81 @@ func (s *Subscription) populateResourcesLegacy(r *rpc.Resources) {
 
 	s.state = stateToSend
 
-	for _, sc := range s.refs {
-		sc.sub.populateResourcesLegacy(r)
+	s.forEachSentRef(func(sub *Subscription) {
+		sub.populateResourcesLegacy(r)
+	})
+}
+
+// GetSentRPCResources returns a rpc.Resources object containing the
+// subscription's own data, as last sent to the client. It is used to respond
+// to a duplicate subscribe request for a resource already sent, where
+// GetRPCResources would return no data.
+// Referenced resources are not included, as the client already has them.
+func (s *Subscription) GetSentRPCResources() *rpc.Resources {
+	r := &rpc.Resources{}
+	legacy := s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue
+	switch s.typ {
+	case rescache.TypeCollection:
+		c := s.collection
+		if legacy {
+			r.Collections = map[string]interface{}{s.rid: (*rescache.Legacy120Collection)(c)}
+		} else {
+			r.Collections = map[string]interface{}{s.rid: c}
+		}
+	case rescache.TypeModel:
+		if legacy {
+			r.Models = map[string]interface{}{s.rid: (*rescache.Legacy120Model)(s.model)}
+		} else {
+			r.Models = map[string]interface{}{s.rid: s.model}
+		}
+	}
+	return r
+}
+
+// forEachSentRef calls the callback for each referenced subscription included
+// in the resource as sent to the client. For filtered collections, references
+// of non-matching items are excluded.
+func (s *Subscription) forEachSentRef(cb func(sub *Subscription)) {
+	if s.filter == nil {
+		for _, sc := range s.refs {
+			cb(sc.sub)
+		}
+		return
+	}
+	for _, v := range s.collection.Values {
+		if v.Type == codec.ValueTypeReference {
+			if sc := s.refs[v.RID]; sc != nil {
+				cb(sc.sub)
+			}
+		}
+	}
+}
+
+// filterCollection applies any filter on the loaded collection, unless
+// already applied. Referenced resources are expected to be loaded.
+func (s *Subscription) filterCollection() {
+	f := s.filter
+	if f == nil || f.matches != nil {
+		return
+	}
+	f.base = s.collection.Values
+	f.matches = make([]bool, len(f.base))
+	vals := make([]codec.Value, 0, len(f.base))
+	for i, v := range f.base {
+		if f.match(s, v) {
+			f.matches[i] = true
+			vals = append(vals, v)
+		}
 	}
+	s.collection = &rescache.Collection{Values: vals}
+}
+
+// sliceCollection replaces the collection values with the values from the
+// start to the end index, inclusive, after any filter is applied.
+// Panics if the subscription is not a loaded collection.
+func (s *Subscription) sliceCollection(start, end int) {
+	vals := s.CollectionValues()
+	s.collection = &rescache.Collection{Values: vals[start : end+1]}
 }
 
 // setModel subscribes to all resource references in the model.
@@ -415,6 +647,9 @@ func (s *Subscription) setModel() {
 			return
 		}
 	}
+	if s.c.OmitNullFields() {
+		m = omitNullValues(m)
+	}
 	s.model = m
 }
 
@@ -446,6 +681,7 @@ func (s *Subscription) subscribeRef(v codec.Value) bool {
 		// we unsubscribe to all and exit with error
 		s.c.Debugf("Failed to subscribe to %s. Aborting subscribeRef", v.RID)
 		for _, ref := range s.refs {
+			delete(ref.sub.filteredBy, s)
 			s.c.Unsubscribe(ref.sub, false, 1, true)
 		}
 		s.refs = nil
@@ -491,6 +727,7 @@ func containsString(path []string, rid string) bool {
 
 func (s *Subscription) unsubscribeRefs() {
 	for _, ref := range s.refs {
+		delete(ref.sub.filteredBy, s)
 		s.c.Unsubscribe(ref.sub, false, 1, false)
 	}
 	s.refs = nil
@@ -516,6 +753,12 @@ func (s *Subscription) addReference(rid string) (*Subscription, error) {
 
 		ref = &reference{sub: sub, count: 1}
 		refs[rid] = ref
+		if s.filter != nil {
+			if sub.filteredBy == nil {
+				sub.filteredBy = make(map[*Subscription]bool)
+			}
+			sub.filteredBy[s] = true
+		}
 	} else {
 		ref.count++
 	}
@@ -529,6 +772,7 @@ func (s *Subscription) removeReference(rid string) {
 	ref := s.refs[rid]
 	ref.count--
 	if ref.count == 0 {
+		delete(ref.sub.filteredBy, s)
 		s.c.Unsubscribe(ref.sub, false, 1, true)
 		delete(s.refs, rid)
 	}
@@ -536,6 +780,11 @@ func (s *Subscription) removeReference(rid string) {
 
 // Event passes an event to the subscription to be processed.
 func (s *Subscription) Event(event *rescache.ResourceEvent) {
+	if s.c.EventSequence() && event.Event != "reaccess" {
+		ev := *event
+		ev.Seq = s.eventSeq.next()
+		event = &ev
+	}
 	s.c.Enqueue(func() {
 		if event.Event == "reaccess" {
 			s.reaccess()
@@ -546,17 +795,131 @@ func (s *Subscription) Event(event *rescache.ResourceEvent) {
 		if s.resourceSub == nil {
 			return
 		}
+		s.touch()
 
 		if s.queueFlag != 0 {
-			s.eventQueue = append(s.eventQueue, event)
-			return
+			if !s.applyUnsentChange(event) {
+				s.eventQueue = append(s.eventQueue, event)
+			}
+		} else {
+			s.processEvent(event)
 		}
 
-		s.processEvent(event)
+		if event.Event == "change" {
+			s.refilter()
+		}
 	})
 }
 
+// applyUnsentChange applies a change event directly to a loaded model not
+// sent to the client, as it is only referenced by items excluded by collection
+// filters, instead of queueing it. This keeps the event queue of a model that
+// might never be sent from growing with each change. Changes setting or
+// replacing a reference are not applied, as they require the referenced
+// resource to be subscribed. Returns true if the change was applied.
+func (s *Subscription) applyUnsentChange(event *rescache.ResourceEvent) bool {
+	if event.Event != "change" ||
+		s.state != stateLoaded ||
+		s.typ != rescache.TypeModel ||
+		s.queueFlag != queueReasonLoading ||
+		s.flags&flagReaccess != 0 ||
+		len(s.eventQueue) > 0 ||
+		!s.excludedByFilters() {
+		return false
+	}
+	for k, v := range event.Changed {
+		if v.Type == codec.ValueTypeReference {
+			return false
+		}
+		if ov, ok := event.OldValues[k]; ok && ov.Type == codec.ValueTypeReference {
+			return false
+		}
+	}
+	if event.Seq != 0 {
+		if ok, last := s.eventSeq.deliver(event.Seq); !ok {
+			s.c.EventOutOfOrder(s.rid, event.Event, event.Seq, last)
+		}
+	}
+	ch := event.Changed
+	if s.c.OmitNullFields() {
+		ch = s.omitNullChanges(ch)
+	}
+	if s.fields != nil {
+		ch = s.filterChanges(ch)
+	}
+	s.model = applyChanged(s.model, ch)
+	return true
+}
+
+// excludedByFilters returns true if the subscription is only referenced by
+// filtered collections, each having the filter applied and excluding all
+// items referencing the resource.
+func (s *Subscription) excludedByFilters() bool {
+	if s.direct > 0 || len(s.filteredBy) == 0 || s.indirect != len(s.filteredBy) {
+		return false
+	}
+	for p := range s.filteredBy {
+		f := p.filter
+		if f.matches == nil {
+			return false
+		}
+		for idx, v := range f.base {
+			if f.matches[idx] && v.Type == codec.ValueTypeReference && v.RID == s.rid {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// refilter queues a refilter event on each filtered collection referencing
+// the model, to update whether the model matches the filter after a change.
+func (s *Subscription) refilter() {
+	ev := &rescache.ResourceEvent{Event: refilterEvent, Value: codec.Value{Type: codec.ValueTypeReference, RID: s.rid}}
+	for p := range s.filteredBy {
+		if p.state == stateDisposed || p.resourceSub == nil {
+			continue
+		}
+		if p.queueFlag != 0 {
+			p.eventQueue = append(p.eventQueue, ev)
+			continue
+		}
+		p.processEvent(ev)
+	}
+}
+
+// cachedModelValue returns the value of a field of the cached model, which
+// includes any change with an event not yet processed by the subscription.
+func (s *Subscription) cachedModelValue(field string) (codec.Value, bool) {
+	m := s.resourceSub.GetModel()
+	defer s.resourceSub.Release()
+	if m == nil {
+		return codec.Value{}, false
+	}
+	v, ok := m.Values[field]
+	return v, ok
+}
+
 func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
+	// Flush any pending diff or change before processing other events to
+	// keep order. Any referenced resource is loaded before the window is
+	// flushed, so the flush never queues events.
+	if s.diffTimer != nil && event.Event != "add" && event.Event != "remove" {
+		s.flushDiff()
+	}
+	if s.changeTimer != nil && event.Event != "change" {
+		s.flushChange()
+	}
+	if event == flushPendingEvent {
+		return
+	}
+
+	if event.Seq != 0 {
+		if ok, last := s.eventSeq.deliver(event.Seq); !ok {
+			s.c.EventOutOfOrder(s.rid, event.Event, event.Seq, last)
+		}
+	}
+
 	switch s.resourceSub.GetResourceType() {
 	case rescache.TypeCollection:
 		s.processCollectionEvent(event)
@@ -568,11 +931,28 @@ func (s *Subscription) processEvent(event *rescache.ResourceEvent) {
 }
 
 func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
+	if s.filter != nil {
+		s.processFilteredCollectionEvent(event)
+		return
+	}
+
+	if (event.Event == "add" || event.Event == "remove") &&
+		s.c.CollectionDiffWindow() > 0 &&
+		s.c.ProtocolVersion() >= versionCollectionDiff {
+		s.diffCollectionEvent(event)
+		return
+	}
+
 	switch event.Event {
 	case "add":
 		v := event.Value
 		idx := event.Idx
 
+		// References are added to the collection once sent to the client
+		if v.Type != codec.ValueTypeReference {
+			s.collection = &rescache.Collection{Values: insertValue(s.collection.Values, idx, v)}
+		}
+
 		switch v.Type {
 		case codec.ValueTypeReference:
 			rid := v.RID
@@ -585,7 +965,8 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 
 			// Quick exit if added resource is already sent to client
 			if sub.IsSent() {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
+				s.collection = &rescache.Collection{Values: insertValue(s.collection.Values, idx, v)}
+				s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
 				return
 			}
 
@@ -600,7 +981,8 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 				}
 
 				r := sub.GetRPCResources()
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage, Resources: r}))
+				s.collection = &rescache.Collection{Values: insertValue(s.collection.Values, idx, v)}
+				s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage, Resources: r}))
 				sub.ReleaseRPCResources()
 
 				s.unqueueEvents(queueReasonLoading)
@@ -609,12 +991,12 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 			fallthrough
 		case codec.ValueTypeSoftReference:
 			if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: rescache.Legacy120Value(v)}))
+				s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: rescache.Legacy120Value(v)}))
 				break
 			}
 			fallthrough
 		case codec.ValueTypePrimitive:
-			s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, rpc.AddEvent{Idx: idx, Value: v.RawMessage}))
 		}
 
 	case "remove":
@@ -624,13 +1006,307 @@ func (s *Subscription) processCollectionEvent(event *rescache.ResourceEvent) {
 		if v.Type == codec.ValueTypeReference {
 			s.removeReference(v.RID)
 		}
-		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
+		s.collection = &rescache.Collection{Values: removeValue(s.collection.Values, event.Idx)}
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, event.Payload))
 
 	case "delete":
 		s.state = stateDeleted
 		fallthrough
 	default:
-		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, event.Payload))
+	}
+}
+
+// diffCollectionEvent applies an add or remove event to the collection without
+// sending it to the client. Once the diff window has passed, the changes are
+// sent as a single diff event by flushDiff.
+func (s *Subscription) diffCollectionEvent(event *rescache.ResourceEvent) {
+	vals := s.collection.Values
+	if s.diffTimer != nil {
+		vals = s.diffValues
+	}
+	idx := event.Idx
+	v := event.Value
+	var nv []codec.Value
+
+	switch event.Event {
+	case "add":
+		if idx < 0 || idx > len(vals) {
+			s.c.Errorf("Subscription %s: Add event index out of range: %d", s.rid, idx)
+			return
+		}
+		if v.Type == codec.ValueTypeReference {
+			sub, err := s.addReference(v.RID)
+			if err != nil {
+				s.c.Errorf("Subscription %s: Error subscribing to resource %s: %s", s.rid, v.RID, err)
+				return
+			}
+			s.awaitReference(sub)
+		}
+		nv = insertValue(vals, idx, v)
+	case "remove":
+		if idx < 0 || idx >= len(vals) {
+			s.c.Errorf("Subscription %s: Remove event index out of range: %d", s.rid, idx)
+			return
+		}
+		// Removing references is delayed until flush to avoid unsubscribing
+		// to a resource that is added again within the same window.
+		if v.Type == codec.ValueTypeReference {
+			s.diffRemoved = append(s.diffRemoved, v.RID)
+		}
+		nv = removeValue(vals, idx)
+	}
+
+	if s.diffTimer == nil {
+		var t *time.Timer
+		t = time.AfterFunc(s.c.CollectionDiffWindow(), func() {
+			s.c.Enqueue(func() {
+				if s.diffTimer == t {
+					s.flushPending(s.flushDiff)
+				}
+			})
+		})
+		s.diffTimer = t
+	}
+	s.diffValues = nv
+}
+
+// awaitReference queues events until a referenced resource, added within a
+// diff or change window, is loaded. This lets the window be flushed without
+// waiting for it.
+func (s *Subscription) awaitReference(sub *Subscription) {
+	if sub.IsSent() {
+		return
+	}
+	s.queueEvents(queueReasonLoading)
+	sub.OnReady(func() {
+		if s.state == stateDisposed {
+			return
+		}
+		s.unqueueEvents(queueReasonLoading)
+	})
+}
+
+// flushPending calls the flush function once the window of a pending diff or
+// change has passed. If events are queued, the flush is instead queued as an
+// event, to keep the order of events.
+func (s *Subscription) flushPending(flush func()) {
+	if s.queueFlag != 0 {
+		s.eventQueue = append(s.eventQueue, flushPendingEvent)
+		return
+	}
+	flush()
+}
+
+// flushDiff sends a diff event with all changes made to the collection
+// since the diff window started. If any added reference is not yet sent to
+// the client, events will be queued until the references are ready.
+func (s *Subscription) flushDiff() {
+	if s.diffTimer == nil {
+		return
+	}
+	s.diffTimer.Stop()
+	s.diffTimer = nil
+	base := s.collection.Values
+	vals := s.diffValues
+	removed := s.diffRemoved
+	s.diffValues = nil
+	s.diffRemoved = nil
+	s.collection = &rescache.Collection{Values: vals}
+
+	for _, rid := range removed {
+		s.removeReference(rid)
+	}
+
+	splices := rescache.DiffCollection(base, vals)
+	if len(splices) == 0 {
+		return
+	}
+
+	ev := rpc.DiffEvent{Splices: make([]rpc.Splice, len(splices))}
+	var subs []*Subscription
+	for i, sp := range splices {
+		rs := rpc.Splice{Idx: sp.Idx, Remove: sp.Remove}
+		if len(sp.Values) > 0 {
+			rs.Values = make([]json.RawMessage, len(sp.Values))
+			for j, v := range sp.Values {
+				rs.Values[j] = v.RawMessage
+				if v.Type == codec.ValueTypeReference {
+					if sub := s.Ref(v.RID); sub != nil && !sub.IsSent() {
+						subs = append(subs, sub)
+					}
+				}
+			}
+		}
+		ev.Splices[i] = rs
+	}
+
+	// Quick exit if there are no new unsent subscriptions
+	if subs == nil {
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "diff", ev))
+		return
+	}
+
+	// Start queueing again
+	s.queueEvents(queueReasonLoading)
+	count := len(subs)
+	for _, sub := range subs {
+		sub.OnReady(func() {
+			// Assert client is not disposed
+			if s.state == stateDisposed {
+				return
+			}
+
+			count--
+			if count > 0 {
+				return
+			}
+
+			r := &rpc.Resources{}
+			for _, sub := range subs {
+				sub.populateResources(r)
+			}
+			ev.Resources = r
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "diff", ev))
+			for _, sub := range subs {
+				sub.ReleaseRPCResources()
+			}
+
+			s.unqueueEvents(queueReasonLoading)
+		})
+	}
+}
+
+// processFilteredCollectionEvent processes an event on a filtered collection,
+// translating add and remove events to the indexes of the filtered collection,
+// and discarding events on items not matching the filter.
+func (s *Subscription) processFilteredCollectionEvent(event *rescache.ResourceEvent) {
+	s.filterCollection()
+	f := s.filter
+
+	switch event.Event {
+	case "add":
+		v := event.Value
+		idx := event.Idx
+		if idx < 0 || idx > len(f.base) {
+			s.c.Errorf("Subscription %s: Add event index out of range: %d", s.rid, idx)
+			return
+		}
+
+		if v.Type == codec.ValueTypeReference {
+			sub, err := s.addReference(v.RID)
+			if err != nil {
+				s.c.Errorf("Subscription %s: Error subscribing to resource %s: %s", s.rid, v.RID, err)
+				return
+			}
+
+			// Wait for the referenced resource to be loaded to match it
+			if !sub.IsReady() {
+				s.queueEvents(queueReasonLoading)
+				sub.OnReady(func() {
+					if s.state == stateDisposed {
+						return
+					}
+					s.addFilteredValue(idx, v)
+					s.unqueueEvents(queueReasonLoading)
+				})
+				return
+			}
+		}
+		s.addFilteredValue(idx, v)
+
+	case "remove":
+		idx := event.Idx
+		if idx < 0 || idx >= len(f.base) {
+			s.c.Errorf("Subscription %s: Remove event index out of range: %d", s.rid, idx)
+			return
+		}
+
+		v := f.base[idx]
+		matched := f.matches[idx]
+		fidx := f.filteredIdx(idx)
+		f.base = removeValue(f.base, idx)
+		f.matches = append(f.matches[:idx:idx], f.matches[idx+1:]...)
+
+		if v.Type == codec.ValueTypeReference {
+			s.removeReference(v.RID)
+		}
+		if matched {
+			s.collection = &rescache.Collection{Values: removeValue(s.collection.Values, fidx)}
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, codec.EncodeRemoveEvent(&codec.RemoveEvent{Idx: fidx})))
+		}
+
+	case refilterEvent:
+		s.refilterReference(event.Value.RID)
+
+	case "delete":
+		s.state = stateDeleted
+		fallthrough
+	default:
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, event.Payload))
+	}
+}
+
+// addFilteredValue adds a value to the base collection of a filtered
+// collection, and sends an add event to the client if the value matches.
+func (s *Subscription) addFilteredValue(idx int, v codec.Value) {
+	f := s.filter
+	fidx := f.filteredIdx(idx)
+	m := f.match(s, v)
+	f.base = insertValue(f.base, idx, v)
+	f.matches = append(f.matches[:idx:idx], append([]bool{m}, f.matches[idx:]...)...)
+	if !m {
+		return
+	}
+
+	s.collection = &rescache.Collection{Values: insertValue(s.collection.Values, fidx, v)}
+	s.sendFilteredAdd(fidx, v)
+}
+
+// refilterReference matches any items referencing the resource against the
+// filter again, sending an add event for items starting to match, and a
+// remove event for items no longer matching.
+func (s *Subscription) refilterReference(rid string) {
+	f := s.filter
+	for idx, v := range f.base {
+		if v.Type != codec.ValueTypeReference || v.RID != rid {
+			continue
+		}
+		m := f.match(s, v)
+		if m == f.matches[idx] {
+			continue
+		}
+		f.matches[idx] = m
+		fidx := f.filteredIdx(idx)
+		if m {
+			s.collection = &rescache.Collection{Values: insertValue(s.collection.Values, fidx, v)}
+			s.sendFilteredAdd(fidx, v)
+		} else {
+			s.collection = &rescache.Collection{Values: removeValue(s.collection.Values, fidx)}
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "remove", codec.EncodeRemoveEvent(&codec.RemoveEvent{Idx: fidx})))
+		}
+	}
+}
+
+// sendFilteredAdd sends an add event for a value added to the filtered
+// collection, including the referenced resource if not yet sent.
+func (s *Subscription) sendFilteredAdd(fidx int, v codec.Value) {
+	switch v.Type {
+	case codec.ValueTypeReference:
+		sub := s.Ref(v.RID)
+		if sub.IsSent() {
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "add", rpc.AddEvent{Idx: fidx, Value: v.RawMessage}))
+			return
+		}
+		r := sub.GetRPCResources()
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "add", rpc.AddEvent{Idx: fidx, Value: v.RawMessage, Resources: r}))
+		sub.ReleaseRPCResources()
+	case codec.ValueTypeData:
+		if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
+			s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "add", rpc.AddEvent{Idx: fidx, Value: rescache.Legacy120Value(v)}))
+			return
+		}
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "add", rpc.AddEvent{Idx: fidx, Value: v.RawMessage}))
 	}
 }
 
@@ -638,6 +1314,21 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 	switch event.Event {
 	case "change":
 		ch := event.Changed
+		if s.c.OmitNullFields() {
+			if ch = s.omitNullChanges(ch); len(ch) == 0 {
+				return
+			}
+		}
+		if s.fields != nil {
+			if ch = s.filterChanges(ch); len(ch) == 0 {
+				return
+			}
+		}
+		if d := s.c.ChangeDebounce(s.resourceName); d > 0 {
+			s.debounceChangeEvent(ch, event.OldValues, d)
+			return
+		}
+
 		old := event.OldValues
 		var subs []*Subscription
 
@@ -668,12 +1359,8 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 
 		// Quick exit if there are no new unsent subscriptions
 		if subs == nil {
-			// Legacy behavior
-			if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(event.Changed)}))
-			} else {
-				s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: event.Changed}))
-			}
+			s.model = applyChanged(s.model, ch)
+			s.c.SendEvent(s.resourceName, s.changeEvent(ch, nil))
 			return
 		}
 
@@ -692,20 +1379,8 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 					return
 				}
 
-				r := &rpc.Resources{}
-
-				// Legacy behavior
-				if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
-					for _, sub := range subs {
-						sub.populateResourcesLegacy(r)
-					}
-					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(event.Changed), Resources: r}))
-				} else {
-					for _, sub := range subs {
-						sub.populateResources(r)
-					}
-					s.c.Send(rpc.NewEvent(s.rid, event.Event, rpc.ChangeEvent{Values: event.Changed, Resources: r}))
-				}
+				s.model = applyChanged(s.model, ch)
+				s.c.SendEvent(s.resourceName, s.changeEvent(ch, subs))
 				for _, sub := range subs {
 					sub.ReleaseRPCResources()
 				}
@@ -717,8 +1392,224 @@ func (s *Subscription) processModelEvent(event *rescache.ResourceEvent) {
 		s.state = stateDeleted
 		fallthrough
 	default:
-		s.c.Send(rpc.NewEvent(s.rid, event.Event, event.Payload))
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, event.Event, event.Payload))
+	}
+}
+
+// changeEvent returns an encoded change event with the changed values,
+// including the resources of any referenced subscriptions not yet sent.
+func (s *Subscription) changeEvent(ch map[string]codec.Value, subs []*Subscription) []byte {
+	var r *rpc.Resources
+	// Legacy behavior
+	if s.c.ProtocolVersion() < versionSoftResourceReferenceAndDataValue {
+		if subs != nil {
+			r = &rpc.Resources{}
+			for _, sub := range subs {
+				sub.populateResourcesLegacy(r)
+			}
+		}
+		return rpc.NewEvent(s.rid, "change", rpc.ChangeEvent{Values: rescache.Legacy120ValueMap(ch), Resources: r})
+	}
+	if subs != nil {
+		r = &rpc.Resources{}
+		for _, sub := range subs {
+			sub.populateResources(r)
+		}
+	}
+	return rpc.NewEvent(s.rid, "change", rpc.ChangeEvent{Values: ch, Resources: r})
+}
+
+// debounceChangeEvent applies a change event to the model without sending
+// it to the client. Once the debounce window has passed, the changes are
+// sent as a single change event by flushChange.
+func (s *Subscription) debounceChangeEvent(ch, old map[string]codec.Value, d time.Duration) {
+	for _, v := range ch {
+		if v.Type == codec.ValueTypeReference {
+			sub, err := s.addReference(v.RID)
+			if err != nil {
+				s.c.Errorf("Subscription %s: Error subscribing to resource %s: %s", s.rid, v.RID, err)
+				return
+			}
+			s.awaitReference(sub)
+		}
+	}
+	// Removing references is delayed until flush to avoid unsubscribing
+	// to a resource that is set again within the same window.
+	for k := range ch {
+		if ov, ok := old[k]; ok && ov.Type == codec.ValueTypeReference {
+			s.changeRemoved = append(s.changeRemoved, ov.RID)
+		}
+	}
+
+	if s.changeTimer == nil {
+		s.changeBase = s.model.Values
+		var t *time.Timer
+		t = time.AfterFunc(d, func() {
+			s.c.Enqueue(func() {
+				if s.changeTimer == t {
+					s.flushPending(s.flushChange)
+				}
+			})
+		})
+		s.changeTimer = t
 	}
+	s.model = applyChanged(s.model, ch)
+}
+
+// flushChange sends a change event with the latest values of all properties
+// changed since the debounce window started. Properties changed back to their
+// original value are left out. If any referenced resource is not yet sent to
+// the client, events will be queued until the references are ready.
+func (s *Subscription) flushChange() {
+	if s.changeTimer == nil {
+		return
+	}
+	s.changeTimer.Stop()
+	s.changeTimer = nil
+	base := s.changeBase
+	removed := s.changeRemoved
+	s.changeBase = nil
+	s.changeRemoved = nil
+
+	for _, rid := range removed {
+		s.removeReference(rid)
+	}
+
+	vals := s.model.Values
+	ch := make(map[string]codec.Value)
+	for k, v := range vals {
+		if bv, ok := base[k]; !ok || !bv.Equal(v) {
+			ch[k] = v
+		}
+	}
+	for k := range base {
+		if _, ok := vals[k]; !ok {
+			ch[k] = codec.DeleteValue
+		}
+	}
+	if len(ch) == 0 {
+		return
+	}
+
+	var subs []*Subscription
+	for _, v := range ch {
+		if v.Type == codec.ValueTypeReference {
+			if sub := s.Ref(v.RID); sub != nil && !sub.IsSent() {
+				subs = append(subs, sub)
+			}
+		}
+	}
+
+	// Quick exit if there are no new unsent subscriptions
+	if subs == nil {
+		s.c.SendEvent(s.resourceName, s.changeEvent(ch, nil))
+		return
+	}
+
+	// Start queueing again
+	s.queueEvents(queueReasonLoading)
+	count := len(subs)
+	for _, sub := range subs {
+		sub.OnReady(func() {
+			// Assert client is not disposed
+			if s.state == stateDisposed {
+				return
+			}
+
+			count--
+			if count > 0 {
+				return
+			}
+
+			s.c.SendEvent(s.resourceName, s.changeEvent(ch, subs))
+			for _, sub := range subs {
+				sub.ReleaseRPCResources()
+			}
+
+			s.unqueueEvents(queueReasonLoading)
+		})
+	}
+}
+
+// applyChanged returns a copy of the model with the changed values applied.
+// Values of type ValueTypeDelete are removed from the model.
+func applyChanged(m *rescache.Model, ch map[string]codec.Value) *rescache.Model {
+	vals := make(map[string]codec.Value, len(m.Values)+len(ch))
+	for k, v := range m.Values {
+		vals[k] = v
+	}
+	for k, v := range ch {
+		if v.Type == codec.ValueTypeDelete {
+			delete(vals, k)
+		} else {
+			vals[k] = v
+		}
+	}
+	return &rescache.Model{Values: vals, Keys: m.Keys}
+}
+
+// omitNullValues returns a model without any null values.
+func omitNullValues(m *rescache.Model) *rescache.Model {
+	for _, v := range m.Values {
+		if v.IsNull() {
+			goto Omit
+		}
+	}
+	return m
+
+Omit:
+	vals := make(map[string]codec.Value, len(m.Values))
+	for k, v := range m.Values {
+		if !v.IsNull() {
+			vals[k] = v
+		}
+	}
+	return &rescache.Model{Values: vals, Keys: m.Keys}
+}
+
+// omitNullChanges returns the changed values with null values replaced by
+// delete actions. Deleted properties not set in the model are left out, as
+// null values are already omitted.
+func (s *Subscription) omitNullChanges(ch map[string]codec.Value) map[string]codec.Value {
+	nch := make(map[string]codec.Value, len(ch))
+	for k, v := range ch {
+		if v.IsNull() {
+			v = codec.DeleteValue
+		}
+		if v.Type == codec.ValueTypeDelete {
+			if _, ok := s.model.Values[k]; !ok {
+				continue
+			}
+		}
+		nch[k] = v
+	}
+	return nch
+}
+
+// setFields sets the model fields of interest for change events. A nil
+// slice sets all fields to be of interest.
+func (s *Subscription) setFields(fields []string) {
+	if fields == nil {
+		s.fields = nil
+		return
+	}
+	s.fields = make(map[string]bool, len(fields))
+	for _, f := range fields {
+		s.fields[f] = true
+	}
+}
+
+// filterChanges returns the changed values of the fields of interest. Changes
+// to other fields are left out, and are neither applied to the model nor
+// sent to the client.
+func (s *Subscription) filterChanges(ch map[string]codec.Value) map[string]codec.Value {
+	fch := make(map[string]codec.Value, len(ch))
+	for k, v := range ch {
+		if s.fields[k] {
+			fch[k] = v
+		}
+	}
+	return fch
 }
 
 func (s *Subscription) handleReaccess() {
@@ -747,8 +1638,48 @@ func (s *Subscription) validateAccess(a *rescache.Access) {
 	err := a.CanGet()
 	if err != nil {
 		s.c.Unsubscribe(s, true, s.direct, true)
-		s.c.Send(rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: reserr.RESError(err)}))
+		s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: reserr.RESError(err)}))
+		s.c.AccessRevoked()
+	}
+}
+
+// touch marks the subscription as active, and starts the idle timer of a
+// direct subscription if the connection has a subscription idle timeout.
+func (s *Subscription) touch() {
+	d := s.c.SubscriptionIdleTimeout()
+	if d == 0 {
+		return
+	}
+	s.active = time.Now()
+	if s.idleTimer == nil && s.direct > 0 {
+		s.startIdleTimer(d)
+	}
+}
+
+// startIdleTimer starts a timer checking if the subscription is idle after the
+// duration d.
+func (s *Subscription) startIdleTimer(d time.Duration) {
+	s.idleTimer = time.AfterFunc(d, func() {
+		s.c.Enqueue(s.expireIdle)
+	})
+}
+
+// expireIdle unsubscribes all direct subscriptions, and sends an unsubscribe
+// event, if the subscription has been idle for the subscription idle timeout.
+// Otherwise the idle timer is restarted for the remaining time.
+func (s *Subscription) expireIdle() {
+	s.idleTimer = nil
+	if s.state == stateDisposed || s.direct == 0 {
+		return
+	}
+	d := s.c.SubscriptionIdleTimeout()
+	if idle := time.Since(s.active); idle < d {
+		s.startIdleTimer(d - idle)
+		return
 	}
+	s.c.Debugf("Subscription %s: Subscription expired after being idle for %s", s.rid, d)
+	s.c.Unsubscribe(s, true, s.direct, true)
+	s.c.SendEvent(s.resourceName, rpc.NewEvent(s.rid, "unsubscribe", rpc.UnsubscribeEvent{Reason: errSubscriptionExpired}))
 }
 
 // Dispose removes any resourceSubscription and sets
@@ -762,6 +1693,20 @@ func (s *Subscription) Dispose() {
 	s.state = stateDisposed
 	s.readyCallbacks = nil
 	s.eventQueue = nil
+	if s.diffTimer != nil {
+		s.diffTimer.Stop()
+		s.diffTimer = nil
+		s.diffValues = nil
+	}
+	if s.changeTimer != nil {
+		s.changeTimer.Stop()
+		s.changeTimer = nil
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	s.stopLoadTimer()
 
 	if s.resourceSub != nil {
 		s.unsubscribeRefs()
@@ -772,6 +1717,34 @@ func (s *Subscription) Dispose() {
 	}
 }
 
+// startLoadTimer starts a timer that marks the subscription as done loading
+// with a timeout error, if the resource is not loaded within the duration.
+func (s *Subscription) startLoadTimer(d time.Duration) {
+	s.loadTimer = time.AfterFunc(d, func() {
+		s.c.Enqueue(s.loadTimeout)
+	})
+}
+
+// stopLoadTimer stops any load timer.
+func (s *Subscription) stopLoadTimer() {
+	if s.loadTimer != nil {
+		s.loadTimer.Stop()
+		s.loadTimer = nil
+	}
+}
+
+// loadTimeout sets a timeout error on a subscription still loading, letting
+// any waiting ready callbacks proceed without it.
+func (s *Subscription) loadTimeout() {
+	if s.loadTimer == nil || s.state != stateLoading {
+		return
+	}
+	s.loadTimer = nil
+	s.c.Debugf("Subscription %s: Reference timeout", s.rid)
+	s.err = errReferenceTimeout
+	s.doneLoading()
+}
+
 // doneLoading will decrease all loading counters for
 // each readyCallback, and test if they reach 0.
 func (s *Subscription) doneLoading() {
@@ -792,6 +1765,7 @@ func (s *Subscription) Reaccess() {
 }
 
 func (s *Subscription) reaccess() {
+	s.c.ClearAccessCache()
 	if s.state == stateDisposed {
 		return
 	}
@@ -814,6 +1788,24 @@ func parseRID(rid string) (name string, query string) {
 }
 
 func (s *Subscription) loadAccess(cb func(*rescache.Access)) {
+	s.loadAccessFrom("", cb)
+}
+
+// loadSharedAccess loads access the same way as loadAccess, but with the
+// access request sent to the shared access resource, with the query of the
+// subscribed resource.
+func (s *Subscription) loadSharedAccess(rid string, cb func(*rescache.Access)) {
+	s.loadAccessFrom(rid, cb)
+}
+
+// loadAccessFrom loads access, requesting it from the shared access resource
+// if rid is set, or else from the subscribed resource.
+func (s *Subscription) loadAccessFrom(rid string, cb func(*rescache.Access)) {
+	// Deny access without requesting it, rather than injecting an incomplete query
+	if s.unresolved {
+		cb(&rescache.Access{Error: reserr.ErrAccessDenied})
+		return
+	}
 	if s.access != nil {
 		cb(s.access)
 		return
@@ -827,7 +1819,11 @@ func (s *Subscription) loadAccess(cb func(*rescache.Access)) {
 
 	s.flags |= flagAccessCalled
 
-	s.c.Access(s, func(access *rescache.Access) {
+	request := s.c.Access
+	if rid != "" {
+		request = func(s *Subscription, cb func(*rescache.Access)) { s.c.SharedAccess(s, rid, cb) }
+	}
+	request(s, func(access *rescache.Access) {
 		s.c.Enqueue(func() {
 			if s.state == stateDisposed {
 				return
@@ -848,6 +1844,18 @@ func (s *Subscription) loadAccess(cb func(*rescache.Access)) {
 	})
 }
 
+// setSharedAccess sets the access to one loaded by another subscription
+// sharing the same access resource. Any access already loaded, or being
+// loaded, by the subscription is kept.
+func (s *Subscription) setSharedAccess(a *rescache.Access) {
+	if s.state == stateDisposed || s.access != nil || s.flags&flagAccessCalled != 0 {
+		return
+	}
+	if a.Error == nil || a.Error.Code == reserr.CodeAccessDenied {
+		s.access = a
+	}
+}
+
 // CanGet checks asynchronously if the client connection has access to get (read)
 // the resource. If access is denied, the callback will be called with an error
 // describing the reason. If access is granted, the callback will be called with
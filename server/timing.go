@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTiming records the time spent on service requests made on behalf of
+// a single client request. Spans with the same name, such as the get requests
+// of referenced resources, are merged into a span from the earliest start to
+// the latest end. A nil *requestTiming records nothing.
+type requestTiming struct {
+	start time.Time
+	mu    sync.Mutex
+	spans []timingSpan
+}
+
+type timingSpan struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+func newRequestTiming() *requestTiming {
+	return &requestTiming{start: time.Now()}
+}
+
+// timingRequested reports whether the client asked for server-side timing,
+// using the Resgate-Timing header, or if useQuery is true, the timing query
+// parameter.
+func timingRequested(r *http.Request, useQuery bool) bool {
+	if r == nil {
+		return false
+	}
+	if r.Header.Get(HeaderTiming) != "" {
+		return true
+	}
+	return useQuery && r.URL != nil && r.URL.Query().Get("timing") != ""
+}
+
+// observe records a span with the name, from start until now.
+func (rt *requestTiming) observe(name string, start time.Time) {
+	if rt == nil {
+		return
+	}
+	now := time.Now()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for i := range rt.spans {
+		sp := &rt.spans[i]
+		if sp.name == name {
+			if start.Before(sp.start) {
+				sp.start = start
+			}
+			if now.After(sp.end) {
+				sp.end = now
+			}
+			return
+		}
+	}
+	rt.spans = append(rt.spans, timingSpan{name: name, start: start, end: now})
+}
+
+// durations returns the span names, in order of first observation, followed
+// by total, and their durations in milliseconds.
+func (rt *requestTiming) durations() ([]string, []float64) {
+	total := time.Since(rt.start)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	names := make([]string, 0, len(rt.spans)+1)
+	durs := make([]float64, 0, len(rt.spans)+1)
+	for _, sp := range rt.spans {
+		names = append(names, sp.name)
+		durs = append(durs, ms(sp.end.Sub(sp.start)))
+	}
+	return append(names, "total"), append(durs, ms(total))
+}
+
+// header returns the value of a Server-Timing header.
+// Eg. "access;dur=0.412, get;dur=1.538, total;dur=2.210"
+func (rt *requestTiming) header() string {
+	names, durs := rt.durations()
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(durs[i], 'f', 3, 64))
+	}
+	return b.String()
+}
+
+// meta returns the durations in milliseconds by name, for the timing meta
+// field of a WebSocket response.
+func (rt *requestTiming) meta() map[string]float64 {
+	names, durs := rt.durations()
+	m := make(map[string]float64, len(names))
+	for i, name := range names {
+		m[name] = durs[i]
+	}
+	return m
+}
+
+// ms returns the duration in milliseconds, rounded to microseconds.
+func ms(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// tokenField returns the JSON encoded value of the token field found by
+// following the dot separated path. If the token is not an object, or the path
+// is not found, false is returned.
+func tokenField(token json.RawMessage, path string) (json.RawMessage, bool) {
+	v := token
+	for _, key := range strings.Split(path, ".") {
+		var m map[string]json.RawMessage
+		if json.Unmarshal(v, &m) != nil {
+			return nil, false
+		}
+		var ok bool
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// tokenFieldString returns the token field found by following the dot
+// separated path, as a string. Strings are returned decoded, and other
+// primitives by their JSON encoding. If the path is not found, or the value is
+// not a primitive, false is returned.
+func tokenFieldString(token json.RawMessage, path string) (string, bool) {
+	v, ok := tokenField(token, path)
+	if !ok {
+		return "", false
+	}
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return "", false
+	}
+	switch v[0] {
+	case '"':
+		var str string
+		if json.Unmarshal(v, &str) != nil {
+			return "", false
+		}
+		return str, true
+	case '{', '[':
+		return "", false
+	case 'n':
+		return "", false
+	}
+	return string(v), true
+}
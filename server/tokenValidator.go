@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/resgateio/resgate/server/jwt"
+)
+
+// initJWT creates the JWT validator if a public key file or a JWKS URL is
+// configured.
+func (s *Service) initJWT() error {
+	if s.cfg.JWTPublicKeyFile == "" && s.cfg.JWKSURL == "" {
+		return nil
+	}
+	var key []byte
+	if s.cfg.JWTPublicKeyFile != "" {
+		var err error
+		if key, err = ioutil.ReadFile(s.cfg.JWTPublicKeyFile); err != nil {
+			return fmt.Errorf("invalid jwtPublicKeyFile setting (%s)\n\t%s", s.cfg.JWTPublicKeyFile, err)
+		}
+		if _, err = jwt.ParsePublicKey(key); err != nil {
+			return fmt.Errorf("invalid jwtPublicKeyFile setting (%s)\n\t%s", s.cfg.JWTPublicKeyFile, err)
+		}
+	}
+	v, err := jwt.NewValidator(key, s.cfg.JWKSURL, s.cfg.jwksCacheTTL)
+	if err != nil {
+		return fmt.Errorf("invalid jwksUrl setting (%s)\n\t%s", s.cfg.JWKSURL, err)
+	}
+	s.jwt = v
+	return nil
+}
+
+// tokenJWT returns the JWT contained in the connection token. If no
+// jwtTokenField is configured, the token itself must be a JSON string.
+func (s *Service) tokenJWT(token json.RawMessage) (string, bool) {
+	if s.cfg.JWTTokenField == "" {
+		var str string
+		if json.Unmarshal(token, &str) != nil {
+			return "", false
+		}
+		return str, true
+	}
+	return tokenFieldString(token, s.cfg.JWTTokenField)
+}
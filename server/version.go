@@ -2,11 +2,12 @@ package server
 
 // Protocol versions
 const (
-	versionLatest = 1002001 // MAJOR * 1000000 + MINOR * 1000 + PATCH
+	versionLatest = 1002002 // MAJOR * 1000000 + MINOR * 1000 + PATCH
 	versionLegacy = 1001001
 )
 
 const (
 	versionCallResourceResponse              = 1002000
 	versionSoftResourceReferenceAndDataValue = 1002001
+	versionCollectionDiff                    = 1002002
 )
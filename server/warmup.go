@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// warmupSubscriber is a subscriber holding a resource loaded into the cache
+// on startup, keeping the hot resource cached for the lifetime of the
+// service.
+type warmupSubscriber struct {
+	s     *Service
+	rid   string
+	rname string
+	query string
+}
+
+// startWarmup loads the resources of the warmupResources setting into the
+// cache. With warmupReject, client requests for a resource are rejected until
+// its initial load completes.
+func (s *Service) startWarmup() {
+	if len(s.cfg.WarmupResources) == 0 {
+		return
+	}
+	s.warmupMu.Lock()
+	s.warming = make(map[string]bool, len(s.cfg.WarmupResources))
+	for _, rid := range s.cfg.WarmupResources {
+		s.warming[rid] = true
+	}
+	s.warmupMu.Unlock()
+
+	s.Logf("Warming up %d resource(s)", len(s.cfg.WarmupResources))
+	for _, rid := range s.cfg.WarmupResources {
+		rname, query := parseRID(rid)
+		s.cache.Subscribe(&warmupSubscriber{s: s, rid: rid, rname: rname, query: query})
+	}
+}
+
+// warmupDone marks the initial load of the resource as completed.
+func (s *Service) warmupDone(rid string) {
+	s.warmupMu.Lock()
+	defer s.warmupMu.Unlock()
+	if !s.warming[rid] {
+		return
+	}
+	delete(s.warming, rid)
+	if len(s.warming) == 0 {
+		s.Logf("Warmup completed")
+	}
+}
+
+// warmupError returns an error if client requests for the resource are to be
+// rejected until its warmup completes, otherwise nil.
+func (s *Service) warmupError(rid string) error {
+	if !s.cfg.WarmupReject {
+		return nil
+	}
+	s.warmupMu.Lock()
+	warming := s.warming[rid]
+	s.warmupMu.Unlock()
+	if !warming {
+		return nil
+	}
+	return reserr.ErrServiceUnavailable.WithRetryAfter(WarmupRetryAfter)
+}
+
+func (ws *warmupSubscriber) CID() string                   { return "" }
+func (ws *warmupSubscriber) ForwardedHeader() http.Header  { return nil }
+func (ws *warmupSubscriber) Locale() string                { return "" }
+func (ws *warmupSubscriber) ResourceName() string          { return ws.rname }
+func (ws *warmupSubscriber) ResourceQuery() string         { return ws.query }
+func (ws *warmupSubscriber) Event(*rescache.ResourceEvent) {}
+func (ws *warmupSubscriber) Reaccess()                     {}
+
+// Loaded is called by the cache once the resource is loaded.
+func (ws *warmupSubscriber) Loaded(resourceSub *rescache.ResourceSubscription, err error) {
+	if err != nil {
+		ws.s.Errorf("Warmup of %s failed: %s", ws.rid, err)
+	} else {
+		ws.s.Debugf("Warmup of %s completed", ws.rid)
+	}
+	ws.s.warmupDone(ws.rid)
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resgateio/resgate/server/mq"
+	"github.com/resgateio/resgate/server/rescache"
+	"github.com/rs/xid"
+)
+
+var errWebhookQueueFull = errors.New("delivery queue full")
+
+// webhookRegistration is a registered webhook, as listed by the webhook API
+// and stored in the webhook file.
+type webhookRegistration struct {
+	ID      string `json:"id"`
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+}
+
+// webhookList is a list of webhook registrations, as listed by the webhook
+// API and stored in the webhook file.
+type webhookList struct {
+	Webhooks []webhookRegistration `json:"webhooks"`
+}
+
+// webhookEvent is an event to be delivered to a webhook.
+type webhookEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// webhookDeadLetter is an event that could not be delivered to a webhook.
+type webhookDeadLetter struct {
+	webhookEvent
+	Error string `json:"error"`
+}
+
+// webhook delivers events on resources matching the pattern, by sending
+// them in a HTTP POST request to the URL.
+type webhook struct {
+	webhookRegistration
+	pattern rescache.ResourcePattern
+	s       *Service
+
+	// Set while started
+	sub  mq.Unsubscriber
+	stop chan struct{}
+
+	mu   sync.Mutex
+	dead []webhookDeadLetter
+}
+
+// initWebhooks loads any webhook registrations from the webhook file.
+func (s *Service) initWebhooks() error {
+	if s.cfg.WebhookPath == "" {
+		return nil
+	}
+	s.webhooks = make(map[string]*webhook)
+	s.webhookClient = &http.Client{Timeout: WebhookTimeout}
+	if s.cfg.WebhookFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.cfg.WebhookFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("invalid webhookFile setting (%s)\n\t%s", s.cfg.WebhookFile, err)
+	}
+	var l webhookList
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("invalid webhookFile setting (%s)\n\t%s", s.cfg.WebhookFile, err)
+	}
+	for _, reg := range l.Webhooks {
+		wh, err := s.newWebhook(reg)
+		if err != nil || reg.ID == "" {
+			return fmt.Errorf("invalid webhookFile setting (%s)\n\tinvalid webhook %s", s.cfg.WebhookFile, reg.ID)
+		}
+		s.webhooks[reg.ID] = wh
+	}
+	return nil
+}
+
+// startWebhooks starts the delivery of events to all registered webhooks.
+func (s *Service) startWebhooks() error {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	if s.webhooks == nil {
+		return nil
+	}
+	for _, wh := range s.webhooks {
+		if err := wh.start(); err != nil {
+			return err
+		}
+	}
+	s.webhooksStarted = true
+	return nil
+}
+
+// stopWebhooks stops the delivery of events to all registered webhooks.
+// Queued events not yet delivered are discarded.
+func (s *Service) stopWebhooks() {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	for _, wh := range s.webhooks {
+		wh.close()
+	}
+	s.webhooksStarted = false
+}
+
+// newWebhook validates the registration, and creates a new webhook.
+func (s *Service) newWebhook(reg webhookRegistration) (*webhook, error) {
+	p := rescache.ParseResourcePattern(reg.Pattern)
+	if !p.IsValid() {
+		return nil, fmt.Errorf("invalid resource pattern: %s", reg.Pattern)
+	}
+	u, err := url.Parse(reg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid http or https URL: %s", reg.URL)
+	}
+	return &webhook{
+		webhookRegistration: reg,
+		pattern:             p,
+		s:                   s,
+	}, nil
+}
+
+// registerWebhook registers a new webhook, delivering events on resources
+// matching the pattern to the URL.
+func (s *Service) registerWebhook(pattern, u string) (webhookRegistration, error) {
+	wh, err := s.newWebhook(webhookRegistration{ID: xid.New().String(), Pattern: pattern, URL: u})
+	if err != nil {
+		return webhookRegistration{}, err
+	}
+
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	if s.webhooksStarted {
+		if err := wh.start(); err != nil {
+			return webhookRegistration{}, err
+		}
+	}
+	s.webhooks[wh.ID] = wh
+	s.saveWebhooks()
+	s.Debugf("Webhook %s registered for %s: %s", wh.ID, wh.Pattern, wh.URL)
+	return wh.webhookRegistration, nil
+}
+
+// removeWebhook removes a registered webhook. It returns false if no webhook
+// with the ID is registered.
+func (s *Service) removeWebhook(id string) bool {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	wh, ok := s.webhooks[id]
+	if !ok {
+		return false
+	}
+	wh.close()
+	delete(s.webhooks, id)
+	s.saveWebhooks()
+	s.Debugf("Webhook %s removed", id)
+	return true
+}
+
+// getWebhook returns the registered webhook with the ID, or nil if not found.
+func (s *Service) getWebhook(id string) *webhook {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	return s.webhooks[id]
+}
+
+// listWebhooks returns all webhook registrations, sorted by ID.
+func (s *Service) listWebhooks() webhookList {
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+	return s.webhookListLocked()
+}
+
+// webhookListLocked returns all webhook registrations, sorted by ID.
+// s.webhookMu is held when called.
+func (s *Service) webhookListLocked() webhookList {
+	l := webhookList{Webhooks: make([]webhookRegistration, 0, len(s.webhooks))}
+	for _, wh := range s.webhooks {
+		l.Webhooks = append(l.Webhooks, wh.webhookRegistration)
+	}
+	sort.Slice(l.Webhooks, func(i, j int) bool { return l.Webhooks[i].ID < l.Webhooks[j].ID })
+	return l
+}
+
+// saveWebhooks writes all webhook registrations to the webhook file, if one
+// is configured.
+// s.webhookMu is held when called.
+func (s *Service) saveWebhooks() {
+	if s.cfg.WebhookFile == "" {
+		return
+	}
+	data, _ := json.Marshal(s.webhookListLocked())
+	tmp := s.cfg.WebhookFile + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.cfg.WebhookFile)
+	}
+	if err != nil {
+		s.Errorf("Error writing webhook file %s: %s", s.cfg.WebhookFile, err)
+	}
+}
+
+// start subscribes to events on the webhook resource pattern, and starts the
+// delivery worker.
+func (wh *webhook) start() error {
+	ch := make(chan webhookEvent, WebhookQueueSize)
+	stop := make(chan struct{})
+	sub, err := wh.s.mq.Subscribe("event."+wh.Pattern, func(subj string, payload []byte, _ error) {
+		wh.handleEvent(ch, subj, payload)
+	})
+	if err != nil {
+		return err
+	}
+	wh.sub = sub
+	wh.stop = stop
+	go wh.run(ch, stop)
+	return nil
+}
+
+// close unsubscribes to events and stops the delivery worker.
+func (wh *webhook) close() {
+	if wh.sub == nil {
+		return
+	}
+	wh.sub.Unsubscribe()
+	close(wh.stop)
+	wh.sub = nil
+	wh.stop = nil
+}
+
+// handleEvent queues an event received on the webhook subscription for
+// delivery. If the queue is full, the event is moved to the dead letter list.
+func (wh *webhook) handleEvent(ch chan webhookEvent, subj string, payload []byte) {
+	ev := strings.TrimPrefix(subj, "event.")
+	idx := strings.LastIndexByte(ev, '.')
+	// A full wildcard subscription may match subjects not matching the
+	// pattern once the event name is removed.
+	if idx < 0 || !wh.pattern.Match(ev[:idx]) {
+		return
+	}
+	if len(payload) > 0 && !json.Valid(payload) {
+		wh.s.Errorf("Webhook %s: invalid event payload for %s: %s", wh.ID, ev, payload)
+		return
+	}
+	e := webhookEvent{Event: ev, Data: payload}
+	select {
+	case ch <- e:
+	default:
+		wh.deadLetter(e, errWebhookQueueFull)
+	}
+}
+
+// run delivers queued events until stopped.
+func (wh *webhook) run(ch chan webhookEvent, stop chan struct{}) {
+	for {
+		select {
+		case ev := <-ch:
+			wh.deliver(ev, stop)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deliver sends the event to the webhook URL. A failed delivery is retried,
+// doubling the delay for each retry. Once all retries have failed, the event
+// is moved to the dead letter list.
+func (wh *webhook) deliver(ev webhookEvent, stop chan struct{}) {
+	body, _ := json.Marshal(ev)
+	delay := wh.s.cfg.webhookRetryDelay
+	for i := 0; ; i++ {
+		err := wh.post(body)
+		if err == nil {
+			return
+		}
+		if i >= wh.s.cfg.webhookRetries {
+			wh.deadLetter(ev, err)
+			return
+		}
+		wh.s.Debugf("Webhook %s: delivery of %s failed, retrying in %s: %s", wh.ID, ev.Event, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+		if delay *= 2; delay > WebhookMaxRetryDelay {
+			delay = WebhookMaxRetryDelay
+		}
+	}
+}
+
+// post sends the body in a HTTP POST request to the webhook URL. Any response
+// status code other than 2xx is considered a failure.
+func (wh *webhook) post(body []byte) error {
+	resp, err := wh.s.webhookClient.Post(wh.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetter adds the event to the dead letter list, dropping the oldest
+// event if the list is full.
+func (wh *webhook) deadLetter(ev webhookEvent, err error) {
+	wh.s.Errorf("Webhook %s: failed to deliver %s: %s", wh.ID, ev.Event, err)
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if len(wh.dead) >= WebhookDeadLetterSize {
+		wh.dead = wh.dead[1:]
+	}
+	wh.dead = append(wh.dead, webhookDeadLetter{webhookEvent: ev, Error: err.Error()})
+}
+
+// deadLetters returns the events that could not be delivered.
+func (wh *webhook) deadLetters() []webhookDeadLetter {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	l := make([]webhookDeadLetter, len(wh.dead))
+	copy(l, wh.dead)
+	return l
+}
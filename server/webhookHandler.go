@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// webhookRequest represents the body of a webhook registration request.
+type webhookRequest struct {
+	Pattern string `json:"pattern"`
+	URL     string `json:"url"`
+}
+
+// webhookHandler handles the webhook API requests:
+//
+//	GET    <webhookPath>                   Lists all registered webhooks.
+//	POST   <webhookPath>                   Registers a webhook.
+//	GET    <webhookPath>/<id>              Gets a registered webhook.
+//	DELETE <webhookPath>/<id>              Removes a registered webhook.
+//	GET    <webhookPath>/<id>/deadletter   Lists events that failed delivery.
+//
+// All requests must have an Authorization header with the webhookToken as
+// bearer token.
+func (s *Service) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.validWebhookToken(r) {
+		httpError(w, reserr.ErrAccessDenied, s.enc)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path[len(s.cfg.WebhookPath):], "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "":
+		switch r.Method {
+		case "GET":
+			writeWebhookJSON(w, http.StatusOK, s.listWebhooks())
+		case "POST":
+			var req webhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, reserr.ErrInvalidParams, s.enc)
+				return
+			}
+			reg, err := s.registerWebhook(req.Pattern, req.URL)
+			if err != nil {
+				httpError(w, &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Invalid parameters: " + err.Error()}, s.enc)
+				return
+			}
+			writeWebhookJSON(w, http.StatusOK, reg)
+		default:
+			httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+		}
+	case len(parts) == 1:
+		switch r.Method {
+		case "GET":
+			wh := s.getWebhook(parts[0])
+			if wh == nil {
+				httpError(w, reserr.ErrNotFound, s.enc)
+				return
+			}
+			writeWebhookJSON(w, http.StatusOK, wh.webhookRegistration)
+		case "DELETE":
+			if !s.removeWebhook(parts[0]) {
+				httpError(w, reserr.ErrNotFound, s.enc)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+		}
+	case len(parts) == 2 && parts[1] == "deadletter":
+		if r.Method != "GET" {
+			httpError(w, reserr.ErrMethodNotAllowed, s.enc)
+			return
+		}
+		wh := s.getWebhook(parts[0])
+		if wh == nil {
+			httpError(w, reserr.ErrNotFound, s.enc)
+			return
+		}
+		writeWebhookJSON(w, http.StatusOK, struct {
+			Events []webhookDeadLetter `json:"events"`
+		}{wh.deadLetters()})
+	default:
+		notFoundHandler(w, r, s.enc)
+	}
+}
+
+// validWebhookToken reports whether the request has the webhookToken as
+// bearer token in the Authorization header.
+func (s *Service) validWebhookToken(r *http.Request) bool {
+	token := bearerToken(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.WebhookToken)) == 1
+}
+
+func writeWebhookJSON(w http.ResponseWriter, code int, v interface{}) {
+	out, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(out)
+}
@@ -4,13 +4,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/resgateio/resgate/server/codec"
+	"github.com/resgateio/resgate/server/jwt"
 	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/rescache"
 	"github.com/resgateio/resgate/server/reserr"
@@ -29,15 +36,71 @@ type wsConn struct {
 	mqSub       mq.Unsubscriber
 	connStr     string
 	protocolVer int
+	fwdHeader   http.Header
+	locale      string         // Normalized client locale, if locale is enabled
+	ifMatch     string         // If-Match header of a HTTP connection, if preconditions are forwarded
+	ifNoneMatch string         // If-None-Match header of a HTTP connection, if preconditions are forwarded
+	timing      *requestTiming // Timing of the request of a HTTP connection, if requested
+	httpStatus  int            // Status of a successful HTTP connection response, if not the default
+	wsTiming    bool           // Timing is requested for the responses of a WebSocket connection
+	msgpack     bool           // Messages are msgpack encoded
+	protoErrors int            // Number of consecutive malformed messages
+	tokenTimer  *time.Timer    // Timer for token expiration
 
-	queue []func()
-	work  chan struct{}
+	lifetimeTimer   *time.Timer // Timer for the maxConnectionLifetime
+	lifetimeExpired bool        // The maxConnectionLifetime is reached
+
+	quotaCounts []int // Direct subscriptions by subscriptionQuotas pattern
+
+	tenant string // Tenant metrics label, if tenants are configured
+
+	stats *connStats // Activity counters, if connectionSummarySubject is set
+
+	connected        time.Time // Time the connection was created
+	disconnectReason string    // Reason the connection was closed, protected by mu
+
+	accessCache    map[string]*cachedAccess
+	accessCacheGen int // Incremented each time the access cache is cleared
+
+	accessBatches map[string]*accessBatch // Pending access batches by batch resource
+
+	acks   []*pendingAck // Unacknowledged events, in order of ack ID
+	ackSeq uint64        // Last ack ID sent
+	ackKey string        // Key for redelivery of unacknowledged events
+	// Resources with unacknowledged events dropped from the buffer
+	ackResync map[string]bool
+
+	resumeToken string // Token for resuming the session on a new connection
+
+	resourceLimitExceeded bool // A subscription was rejected due to the maxHTTPResources limit
+
+	pendingRequests int  // Client requests not yet replied to
+	backpressured   bool // Backpressure event sent for pending requests
+
+	requestQueue []*queuedClientRequest // Client requests exceeding the maxConnRequests limit
+
+	authPending      bool          // An auth request is pending, if serializeAuth is enabled
+	authQueue        []*queuedAuth // Auth requests waiting for the pending auth request
+	reaccessDeferred bool          // Reaccess deferred until no auth request is pending
+
+	queue           []func()
+	queueDone       int       // Queued callbacks already called by the worker
+	highWaterLogged time.Time // Time of the last logged send queue high-water message
+	work            chan struct{}
 
 	mu sync.Mutex
 }
 
+// cachedAccess is an access result cached by the connection.
+type cachedAccess struct {
+	access  *rescache.Access
+	expires time.Time
+}
+
 var (
 	errInvalidNewResourceResponse = reserr.InternalError(errors.New("non-resource response on new request"))
+	errMessageTooBig              = errors.New("message size exceeds limit")
+	errResourceLimitExceeded      = &reserr.Error{Code: "system.resourceLimitExceeded", Message: "Resource limit exceeded"}
 )
 
 func (s *Service) newWSConn(ws *websocket.Conn, request *http.Request, protocol int) *wsConn {
@@ -60,12 +123,40 @@ func (s *Service) newWSConn(ws *websocket.Conn, request *http.Request, protocol
 		queue:       make([]func(), 0, WSConnWorkerQueueSize),
 		work:        make(chan struct{}, 1),
 		protocolVer: protocol,
+		fwdHeader:   s.cfg.forwardedHeader(request),
+		msgpack:     ws != nil && ws.Subprotocol() == MsgpackSubprotocol,
+		connected:   time.Now(),
 	}
 	conn.connStr = "[" + conn.cid + "]"
+	if s.cfg.Locale {
+		conn.locale = requestLocale(request, ws != nil)
+	}
+	if ws == nil && s.cfg.ForwardPreconditions && isConditionalMethod(request.Method) {
+		conn.ifMatch = request.Header.Get("If-Match")
+		conn.ifNoneMatch = request.Header.Get("If-None-Match")
+	}
+	if s.cfg.ServerTiming && timingRequested(request, ws != nil) {
+		if ws == nil {
+			conn.timing = newRequestTiming()
+		} else {
+			conn.wsTiming = true
+		}
+	}
+	if ws != nil && s.cfg.ConnectionSummarySubject != "" {
+		conn.stats = &connStats{}
+	}
+	if ws != nil && s.cfg.tenants != nil {
+		conn.tenant = s.cfg.tenantLabel(nil)
+		s.metrics.tenantConns.With(conn.tenant).Add(1)
+	}
 
 	s.conns[conn.cid] = conn
 	s.wg.Add(1)
 
+	if ws != nil && s.cfg.maxConnectionLifetime > 0 {
+		conn.startLifetimeTimer(s.cfg.maxConnectionLifetime)
+	}
+
 	// Start an output worker that handles calls to wsConn.Enqueue and wsConn.EnqueueSend
 	go conn.outputWorker()
 
@@ -91,27 +182,226 @@ func (c *wsConn) ProtocolVersion() int {
 	return c.protocolVer
 }
 
+// ForwardedHeader returns the safelisted HTTP headers of the request, captured
+// when the connection was created, or nil if there are none.
+func (c *wsConn) ForwardedHeader() http.Header {
+	return c.fwdHeader
+}
+
+// Locale returns the normalized locale of the client, captured when the
+// connection was created, or an empty string if there is none.
+func (c *wsConn) Locale() string {
+	return c.locale
+}
+
+// Precondition returns the If-Match and If-None-Match headers of a HTTP
+// PUT, DELETE, or PATCH request, if preconditions are forwarded.
+func (c *wsConn) Precondition() (string, string) {
+	return c.ifMatch, c.ifNoneMatch
+}
+
+// MaxParamsDepth returns the maximum nesting depth allowed for request params.
+// Zero means no limit.
+func (c *wsConn) MaxParamsDepth() int {
+	return c.serv.cfg.MaxParamsDepth
+}
+
+// CollectionFilter returns true if gateway-side collection filters are enabled.
+func (c *wsConn) CollectionFilter() bool {
+	return c.serv.cfg.CollectionFilter
+}
+
+// OmitNullFields returns true if model fields with null values should be
+// omitted.
+func (c *wsConn) OmitNullFields() bool {
+	return c.serv.cfg.OmitNullFields
+}
+
+// ConsistentSnapshots returns true if all resources sent in a response should
+// be marked as sent before sending any of their queued events.
+func (c *wsConn) ConsistentSnapshots() bool {
+	return c.serv.cfg.ConsistentSnapshots
+}
+
+// EventSequence returns true if the delivery order of subscription events
+// should be verified.
+func (c *wsConn) EventSequence() bool {
+	return c.serv.cfg.EventSequence
+}
+
+// EventOutOfOrder logs and counts an event of the subscription about to be
+// delivered after an event with a later sequence number.
+func (c *wsConn) EventOutOfOrder(rid, event string, seq, last uint64) {
+	c.serv.metrics.eventSeqAnomalies.Inc()
+	c.Errorf("Subscription %s: event %s delivered out of order (sequence %d after %d)", rid, event, seq, last)
+}
+
+// Timing returns the timing of the request of a HTTP connection, or nil if
+// timing is not requested.
+func (c *wsConn) Timing() *requestTiming {
+	return c.timing
+}
+
+// InjectQuery returns the query with any configured query for the resource
+// injected, populated with the connection's current token. For resources
+// matching localeResources, the client locale is injected as well, and for
+// resources matching normalizeQuery, the resulting query is normalized.
+// If any token placeholder cannot be resolved, false is returned.
+func (c *wsConn) InjectQuery(rname, query string) (string, bool) {
+	if tmpl, ok := c.serv.cfg.injectQuery.match(rname); ok {
+		injected, ok := expandQueryTemplate(tmpl, c.token)
+		if !ok {
+			return query, false
+		}
+		query = injectQuery(query, injected)
+	}
+	if c.locale != "" && c.serv.cfg.localized(rname) {
+		query = injectQuery(query, "locale="+url.QueryEscape(c.locale))
+	}
+	if c.serv.cfg.queryNormalized(rname) {
+		query = normalizeQuery(query)
+	}
+	return query, true
+}
+
+// CollectionDiffWindow returns the duration during which collection add and
+// remove events are coalesced into a single diff event. Zero means disabled.
+func (c *wsConn) CollectionDiffWindow() time.Duration {
+	return c.serv.cfg.collectionDiffWindow
+}
+
+// SubscriptionIdleTimeout returns the duration after which an idle direct
+// subscription is unsubscribed. Zero means disabled, and is always returned
+// for HTTP connections.
+func (c *wsConn) SubscriptionIdleTimeout() time.Duration {
+	if c.ws == nil {
+		return 0
+	}
+	return c.serv.cfg.subscriptionIdle
+}
+
+// ChangeDebounce returns the duration during which change events on the
+// resource are coalesced into a single change event. Zero means disabled.
+func (c *wsConn) ChangeDebounce(rname string) time.Duration {
+	d, _ := c.serv.cfg.changeDebounce.match(rname)
+	return d
+}
+
 func (c *wsConn) listen() {
 	var in []byte
 	var err error
 
 	// Loop until an error is returned when reading
 	for {
-		if _, in, err = c.ws.ReadMessage(); err != nil {
+		if in, err = c.readMessage(); err != nil {
 			break
 		}
+		c.stats.received(len(in))
+		if c.msgpack {
+			var merr error
+			if in, merr = codec.MsgpackToJSON(in); merr != nil {
+				c.Enqueue(func() {
+					c.handleProtocolError(merr)
+				})
+				continue
+			}
+		}
 
 		c.Tracef("--> %s", in)
 		in := in
 		c.Enqueue(func() {
-			rpc.HandleRequest(in, c)
+			if c.tenant != "" {
+				c.serv.metrics.tenantRequests.With(c.tenant).Inc()
+			}
+			c.handleRequest(in)
 		})
 	}
 
+	c.setDisconnectReason(readErrorReason(err))
 	c.Dispose()
 	c.Tracef("Disconnected: %s", err)
 }
 
+// readErrorReason returns the disconnect reason for an error returned when
+// reading from the websocket.
+func readErrorReason(err error) string {
+	if cerr, ok := err.(*websocket.CloseError); ok {
+		return fmt.Sprintf("client close (%d)", cerr.Code)
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return "timeout"
+	}
+	return fmt.Sprintf("connection error (%s)", err)
+}
+
+// setDisconnectReason sets the reason the connection is closed, unless a
+// reason is already set.
+func (c *wsConn) setDisconnectReason(reason string) {
+	c.mu.Lock()
+	if c.disconnectReason == "" {
+		c.disconnectReason = reason
+	}
+	c.mu.Unlock()
+}
+
+// logDisconnect logs the reason the websocket connection was closed, the
+// duration of the connection, and the number of subscriptions at the time,
+// at the level set by the disconnectLog setting.
+func (c *wsConn) logDisconnect(subs int) {
+	var log func(format string, v ...interface{})
+	switch c.serv.cfg.DisconnectLog {
+	case DisconnectLogInfo:
+		log = c.Logf
+	case DisconnectLogDebug:
+		log = c.Debugf
+	default:
+		return
+	}
+	c.mu.Lock()
+	reason := c.disconnectReason
+	c.mu.Unlock()
+	log("Disconnected: %s after %s with %d subscription(s)", reason, time.Since(c.connected).Round(time.Millisecond), subs)
+}
+
+// handleProtocolError counts consecutive malformed messages, and closes the
+// connection with a protocol error close code if the configured maximum is
+// exceeded. A nil error resets the count.
+func (c *wsConn) handleProtocolError(err error) {
+	if err == nil {
+		c.protoErrors = 0
+		return
+	}
+	c.Debugf("Malformed message: %s", err)
+	max := c.serv.cfg.MaxProtocolErrors
+	if max == 0 {
+		return
+	}
+	c.protoErrors++
+	if c.protoErrors > max {
+		c.DisconnectWithClose(websocket.CloseProtocolError, "Too many protocol errors", fmt.Sprintf("Exceeded %d consecutive malformed messages", max))
+	}
+}
+
+// readMessage reads the next inbound message. If the message exceeds the
+// maximum message size, the connection is closed with a policy violation
+// close code without reading the rest of the message.
+func (c *wsConn) readMessage() ([]byte, error) {
+	_, r, err := c.ws.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	max := c.serv.cfg.wsMaxMessageSize
+	in, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(in)) > max {
+		c.DisconnectWithClose(websocket.ClosePolicyViolation, "Message too big", fmt.Sprintf("Message size exceeds limit of %d bytes", max))
+		return nil, errMessageTooBig
+	}
+	return in, nil
+}
+
 // dispose closes the wsConn worker and disposes all subscription.
 // Returns false if dispose has already been called, otherwise true.
 func (c *wsConn) dispose() {
@@ -125,9 +415,19 @@ func (c *wsConn) dispose() {
 	c.mu.Unlock()
 
 	c.unsubscribeConn()
+	c.clearRequestQueue()
+	c.stopTokenTimer()
+	c.stopLifetimeTimer()
+	c.retainAcks()
+	c.retainSession()
 
 	subs := c.subs
 	c.subs = nil
+	if c.ws != nil {
+		c.logDisconnect(len(subs))
+		c.publishSummary(len(subs))
+	}
+	c.setTenant("")
 	for _, sub := range subs {
 		sub.Dispose()
 	}
@@ -181,10 +481,71 @@ func (c *wsConn) Tracef(format string, v ...interface{}) {
 func (c *wsConn) Disconnect(reason string) {
 	if c.ws != nil {
 		c.Tracef("Disconnecting - %s", reason)
+		c.setDisconnectReason(reason)
 		c.ws.Close()
 	}
 }
 
+// DisconnectWithClose sends a close message with the close code and text,
+// before closing the websocket connection.
+func (c *wsConn) DisconnectWithClose(code int, text string, reason string) {
+	if c.ws != nil {
+		c.Tracef("Disconnecting - %s", reason)
+		c.setDisconnectReason(reason)
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(WSTimeout))
+		c.ws.Close()
+	}
+}
+
+// redirect closes the websocket connection with a close text telling the
+// client to connect to the URL instead.
+func (c *wsConn) redirect(u string) {
+	c.DisconnectWithClose(websocket.CloseGoingAway, redirectCloseText(u), "redirected to "+u)
+}
+
+// disconnectWithResyncManifest sends a resync event, listing the resources
+// directly subscribed by the client, before closing the websocket connection
+// with the close code and text. The client may use the list to resubscribe
+// to the same resources on another gateway, in a single subscribe request.
+func (c *wsConn) disconnectWithResyncManifest(code int, text string, reason string) {
+	if !c.Enqueue(func() {
+		if c.ws == nil {
+			return
+		}
+		c.Send(rpc.NewConnEvent("resync", rpc.ResyncEvent{RIDs: c.directRIDs()}))
+		c.DisconnectWithClose(code, text, reason)
+	}) {
+		c.DisconnectWithClose(code, text, reason)
+	}
+}
+
+// directRIDs returns the sorted resource IDs of the direct subscriptions.
+func (c *wsConn) directRIDs() []string {
+	rids := make([]string, 0, len(c.subs))
+	for rid, sub := range c.subs {
+		if sub.direct > 0 {
+			rids = append(rids, rid)
+		}
+	}
+	sort.Strings(rids)
+	return rids
+}
+
+// AccessRevoked is called when a reaccess has revoked access to a direct
+// subscription. If configured, and no direct subscriptions remain, the
+// connection is closed with a reauthenticate close reason.
+func (c *wsConn) AccessRevoked() {
+	if !c.serv.cfg.CloseOnAccessRevoked || c.disposing {
+		return
+	}
+	for _, sub := range c.subs {
+		if sub.direct > 0 {
+			return
+		}
+	}
+	c.DisconnectWithClose(websocket.ClosePolicyViolation, ReauthenticateCloseText, "access to all subscriptions revoked")
+}
+
 // Enqueue puts the callback function in queue to be called
 // by the wsConn worker goroutine.
 // It returns false if the function was not queued due to
@@ -202,6 +563,15 @@ func (c *wsConn) Enqueue(f func()) bool {
 func (c *wsConn) enqueue(f func()) {
 	count := len(c.queue)
 	c.queue = append(c.queue, f)
+	if hw := c.serv.cfg.WSSendQueueHighWater; hw > 0 && c.ws != nil {
+		if depth := count + 1 - c.queueDone; depth >= hw {
+			now := time.Now()
+			if now.Sub(c.highWaterLogged) >= WSSendQueueHighWaterLogInterval {
+				c.highWaterLogged = now
+				c.Debugf("Send queue high-water mark (%d) reached with %d queued", hw, depth)
+			}
+		}
+	}
 	// If the queue was empty, the worker is idling
 	// Let's wake it up.
 	if count == 0 {
@@ -212,18 +582,53 @@ func (c *wsConn) enqueue(f func()) {
 func (c *wsConn) Send(data []byte) {
 	if c.ws != nil {
 		c.Tracef("<<- %s", data)
-		c.ws.WriteMessage(websocket.TextMessage, data)
+		c.writeMessage(data)
 	}
 }
 
 func (c *wsConn) Reply(data []byte) {
+	c.removePendingRequest()
+	if len(c.requestQueue) > 0 {
+		c.Enqueue(c.handleQueuedRequest)
+	}
 	if c.ws != nil {
 		c.Tracef("<-- %s", data)
+		c.writeMessage(data)
+		if c.lifetimeExpired {
+			c.closeExpiredLifetime()
+		}
+	}
+}
+
+// writeMessage writes the JSON encoded message to the websocket, converted
+// to a binary msgpack message if the msgpack subprotocol is used.
+func (c *wsConn) writeMessage(data []byte) {
+	if !c.msgpack {
+		c.stats.sent(len(data))
 		c.ws.WriteMessage(websocket.TextMessage, data)
+		return
 	}
+	out, err := codec.JSONToMsgpack(data)
+	if err != nil {
+		c.Errorf("Error encoding msgpack message: %s", err)
+		return
+	}
+	c.stats.sent(len(out))
+	c.ws.WriteMessage(websocket.BinaryMessage, out)
+}
+
+// shedLoad reports whether a get, subscribe, or call request on a WebSocket
+// connection should be rejected due to load shedding. HTTP requests are
+// checked by the API handler.
+func (c *wsConn) shedLoad() bool {
+	return c.ws != nil && c.serv.shedLoad()
 }
 
 func (c *wsConn) GetResource(rid string, cb func(data *rpc.Resources, err error)) {
+	if c.shedLoad() {
+		cb(nil, c.serv.errLoadShed())
+		return
+	}
 	sub, err := c.Subscribe(rid, true)
 	if err != nil {
 		cb(nil, err)
@@ -301,6 +706,10 @@ func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err erro
 				cb(nil, err)
 				return
 			}
+			if c.resourceLimitExceeded {
+				cb(nil, errResourceLimitExceeded)
+				return
+			}
 			cb(sub, nil)
 			sub.ReleaseRPCResources()
 			c.Unsubscribe(sub, true, 1, true)
@@ -308,7 +717,14 @@ func (c *wsConn) GetSubscription(rid string, cb func(sub *Subscription, err erro
 	})
 }
 
-func (c *wsConn) SubscribeResource(rid string, cb func(data *rpc.Resources, err error)) {
+// SubscribeResource subscribes directly to the resource. If fields is not
+// nil, only change events on the model fields are sent for the subscription,
+// replacing the fields set by any previous direct subscription.
+func (c *wsConn) SubscribeResource(rid string, fields []string, cb func(data *rpc.Resources, err error)) {
+	if c.shedLoad() {
+		cb(nil, c.serv.errLoadShed())
+		return
+	}
 	sub, err := c.Subscribe(rid, true)
 	if err != nil {
 		cb(nil, err)
@@ -330,42 +746,299 @@ func (c *wsConn) SubscribeResource(rid string, cb func(data *rpc.Resources, err
 				return
 			}
 
+			sub.setFields(fields)
+
+			// A duplicate direct subscription responds with the current state
+			// of the resource, even though it is already sent to the client.
+			if sub.direct > 1 && sub.IsSent() {
+				cb(sub.GetSentRPCResources(), nil)
+				return
+			}
+
 			cb(sub.GetRPCResources(), nil)
 			sub.ReleaseRPCResources()
 		})
 	})
 }
 
+// SubscribeResources subscribes to multiple resources, and responds with all
+// resources combined. Access is requested once for each group of resources
+// sharing the same access resource. If access is denied for any resource, none
+// will be subscribed. Resources that fail to load are not subscribed, and are
+// included among the errors of the response.
+func (c *wsConn) SubscribeResources(rids []string, cb func(data *rpc.Resources, err error)) {
+	if c.shedLoad() {
+		cb(nil, c.serv.errLoadShed())
+		return
+	}
+	c.subscribeResources(rids, cb)
+}
+
+// subscribeResources subscribes to multiple resources, the same as
+// SubscribeResources, without any load shedding.
+func (c *wsConn) subscribeResources(rids []string, cb func(data *rpc.Resources, err error)) {
+	var subs []*Subscription
+	unsubscribeAll := func() {
+		for _, sub := range subs {
+			c.Unsubscribe(sub, true, 1, true)
+		}
+	}
+
+	// Group subscriptions by access resource
+	groups := make(map[string][]*Subscription, len(rids))
+	seen := make(map[string]bool, len(rids))
+	var keys []string
+	for _, rid := range rids {
+		if seen[rid] {
+			continue
+		}
+		seen[rid] = true
+		sub, err := c.Subscribe(rid, true)
+		if err != nil {
+			unsubscribeAll()
+			cb(nil, err)
+			return
+		}
+		subs = append(subs, sub)
+		key := rid
+		if shared := c.serv.cfg.sharedAccessRID(sub.ResourceName()); shared != "" {
+			key = shared
+			if q := sub.ResourceQuery(); q != "" {
+				key += "?" + q
+			}
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], sub)
+	}
+
+	failed := false
+	count := len(keys)
+	for _, key := range keys {
+		group := groups[key]
+		group[0].loadSharedAccess(c.serv.cfg.sharedAccessRID(group[0].ResourceName()), func(a *rescache.Access) {
+			if failed {
+				return
+			}
+			if err := a.CanGet(); err != nil {
+				failed = true
+				unsubscribeAll()
+				cb(nil, err)
+				return
+			}
+			for _, sub := range group[1:] {
+				sub.setSharedAccess(a)
+			}
+			count--
+			if count > 0 {
+				return
+			}
+			c.sendSubscribedResources(subs, cb)
+		})
+	}
+}
+
+// ResourceVersions gets the current version of each resource, without
+// sending any resource data. Resources that cannot be retrieved are added to
+// the result errors.
+func (c *wsConn) ResourceVersions(rids []string, cb func(data *rpc.VersionsResult, err error)) {
+	var subs []*Subscription
+	seen := make(map[string]bool, len(rids))
+	for _, rid := range rids {
+		if seen[rid] {
+			continue
+		}
+		seen[rid] = true
+		sub, err := c.Subscribe(rid, true)
+		if err != nil {
+			for _, sub := range subs {
+				c.Unsubscribe(sub, true, 1, true)
+			}
+			cb(nil, err)
+			return
+		}
+		subs = append(subs, sub)
+	}
+
+	r := &rpc.VersionsResult{Versions: make(map[string]string, len(subs))}
+	setError := func(rid string, err error) {
+		if r.Errors == nil {
+			r.Errors = make(map[string]*reserr.Error)
+		}
+		r.Errors[rid] = reserr.RESError(err)
+	}
+	count := len(subs)
+	done := func(sub *Subscription) {
+		c.Unsubscribe(sub, true, 1, true)
+		count--
+		if count == 0 {
+			cb(r, nil)
+		}
+	}
+	for _, sub := range subs {
+		sub := sub
+		sub.CanGet(func(err error) {
+			if err != nil {
+				setError(sub.RID(), err)
+				done(sub)
+				return
+			}
+			sub.OnReady(func() {
+				if err := sub.Error(); err != nil {
+					setError(sub.RID(), err)
+				} else {
+					r.Versions[sub.RID()] = sub.Version()
+				}
+				done(sub)
+			})
+		})
+	}
+}
+
+// sendSubscribedResources waits for all subscriptions to be ready, and calls
+// the callback with the resources of all subscriptions combined.
+func (c *wsConn) sendSubscribedResources(subs []*Subscription, cb func(data *rpc.Resources, err error)) {
+	count := len(subs)
+	for _, sub := range subs {
+		sub.OnReady(func() {
+			count--
+			if count > 0 {
+				return
+			}
+
+			r := &rpc.Resources{}
+			for _, sub := range subs {
+				if err := sub.Error(); err != nil {
+					if r.Errors == nil {
+						r.Errors = make(map[string]*reserr.Error)
+					}
+					r.Errors[sub.RID()] = reserr.RESError(err)
+					c.Unsubscribe(sub, true, 1, true)
+					continue
+				}
+				if c.protocolVer < versionSoftResourceReferenceAndDataValue {
+					sub.populateResourcesLegacy(r)
+				} else {
+					sub.populateResources(r)
+				}
+			}
+
+			cb(r, nil)
+			if c.ConsistentSnapshots() {
+				releaseSnapshot(subs)
+				return
+			}
+			for _, sub := range subs {
+				sub.ReleaseRPCResources()
+			}
+		})
+	}
+}
+
 func (c *wsConn) CallResource(rid, action string, params interface{}, cb func(result interface{}, err error)) {
-	c.call(rid, action, params, func(result json.RawMessage, refRID string, err error) {
-		c.handleCallAuthResponse(result, refRID, err, cb)
+	if c.shedLoad() {
+		cb(nil, c.serv.errLoadShed())
+		return
+	}
+	rt := c.newRequestTiming()
+	c.call(rid, action, params, rt, func(result json.RawMessage, refRID string, err error) {
+		c.handleCallAuthResponse(result, refRID, err, withTimingMeta(rt, cb))
 	})
 }
 
-func (c *wsConn) CallHTTPResource(rid, prefix, action string, params interface{}, cb func(result json.RawMessage, href string, err error)) {
-	c.call(rid, action, params, func(result json.RawMessage, refRID string, err error) {
+func (c *wsConn) CallHTTPResource(rid, action string, params interface{}, cb func(result json.RawMessage, refRID string, err error)) {
+	c.call(rid, action, params, c.timing, func(result json.RawMessage, refRID string, err error) {
 		if err != nil {
 			cb(nil, "", err)
 		} else if refRID != "" {
-			cb(nil, RIDToPath(refRID, prefix), nil)
+			cb(nil, refRID, nil)
 		} else {
 			cb(result, "", nil)
 		}
 	})
 }
 
-func (c *wsConn) call(rid, action string, params interface{}, cb func(result json.RawMessage, refRID string, err error)) {
+// CallHTTPResourceRepresentation calls a method on a resource, and on success
+// gets the resulting state of the resource, or of the resource referenced by a
+// resource response. The access of the call is reused when getting the called
+// resource. If get access is not granted, or the get fails, sub is nil and the
+// call result is to be used. The sub is only valid during the callback.
+func (c *wsConn) CallHTTPResourceRepresentation(rid, action string, params interface{}, cb func(result json.RawMessage, sub *Subscription, refRID string, err error)) {
+	csub, ok := c.subs[rid]
+	if !ok {
+		csub = NewSubscription(c, rid)
+	}
+
+	c.callSubscription(csub, action, params, c.timing, func(result json.RawMessage, refRID string, err error) {
+		if err != nil {
+			cb(nil, nil, "", err)
+			return
+		}
+
+		getRID := rid
+		if refRID != "" {
+			getRID = refRID
+		}
+		sub, err := c.Subscribe(getRID, true)
+		if err != nil {
+			cb(result, nil, refRID, nil)
+			return
+		}
+		if refRID == "" && csub.access != nil {
+			sub.setSharedAccess(csub.access)
+		}
+
+		sub.CanGet(func(err error) {
+			if err != nil {
+				cb(result, nil, refRID, nil)
+				c.Unsubscribe(sub, true, 1, true)
+				return
+			}
+
+			sub.OnReady(func() {
+				if sub.Error() != nil || c.resourceLimitExceeded {
+					cb(result, nil, refRID, nil)
+				} else {
+					cb(result, sub, refRID, nil)
+				}
+				sub.ReleaseRPCResources()
+				c.Unsubscribe(sub, true, 1, true)
+			})
+		})
+	})
+}
+
+func (c *wsConn) call(rid, action string, params interface{}, rt *requestTiming, cb func(result json.RawMessage, refRID string, err error)) {
 	sub, ok := c.subs[rid]
 	if !ok {
 		sub = NewSubscription(c, rid)
 	}
+	c.callSubscription(sub, action, params, rt, cb)
+}
+
+// lazyParams is passed as call params to have them read only once call
+// access is granted, returning the params or an error to respond with.
+type lazyParams func() (interface{}, error)
 
+func (c *wsConn) callSubscription(sub *Subscription, action string, params interface{}, rt *requestTiming, cb func(result json.RawMessage, refRID string, err error)) {
+	sub.touch()
+	accessStart := time.Now()
 	sub.CanCall(action, func(err error) {
+		rt.observe("access", accessStart)
 		if err != nil {
 			cb(nil, "", err)
 			return
 		}
-		c.serv.cache.Call(c, sub.ResourceName(), sub.ResourceQuery(), action, c.token, params, func(result json.RawMessage, refRID string, err error) {
+		if lp, ok := params.(lazyParams); ok {
+			if params, err = lp(); err != nil {
+				cb(nil, "", err)
+				return
+			}
+		}
+		callStart := time.Now()
+		c.serv.cache.Call(c, sub.ResourceName(), sub.ResourceQuery(), action, c.token, params, c.callTimeout(sub), func(result json.RawMessage, refRID string, err error) {
+			rt.observe("call", callStart)
 			c.Enqueue(func() {
 				cb(result, refRID, err)
 			})
@@ -373,17 +1046,78 @@ func (c *wsConn) call(rid, action string, params interface{}, cb func(result jso
 	})
 }
 
+// callTimeout returns the timeout of a call request on the subscription, as
+// suggested by the access response and bounded by the maxCallTimeout setting.
+// Zero is returned if the default request timeout should be used.
+func (c *wsConn) callTimeout(sub *Subscription) time.Duration {
+	max := c.serv.cfg.maxCallTimeout
+	if max == 0 || sub.access == nil || sub.access.AccessResult == nil || sub.access.Timeout <= 0 {
+		return 0
+	}
+	if d := time.Duration(sub.access.Timeout) * time.Millisecond; d < max {
+		return d
+	}
+	return max
+}
+
 func (c *wsConn) AuthResource(rid, action string, params interface{}, cb func(result interface{}, err error)) {
+	if c.serializeAuth(rid, action, params, cb) {
+		return
+	}
+	c.sendAuth(rid, action, params, cb)
+}
+
+// sendAuth sends the auth request to the services, and responds to the client
+// once the response is received.
+func (c *wsConn) sendAuth(rid, action string, params interface{}, cb func(result interface{}, err error)) {
 	rname, query := parseRID(c.ExpandCID(rid))
+	rt := c.newRequestTiming()
+	authStart := time.Now()
 	c.serv.cache.Auth(c, rname, query, action, c.token, params, func(result json.RawMessage, refRID string, err error) {
+		rt.observe("auth", authStart)
 		c.Enqueue(func() {
+			if c.ws != nil {
+				cb = withTimingMeta(rt, cb)
+			}
 			c.handleCallAuthResponse(result, refRID, err, cb)
+			c.authDone()
 		})
 	})
 }
 
+// newRequestTiming returns the timing of a new client request, or nil if
+// timing is not requested. A HTTP connection has the timing of its single
+// request.
+func (c *wsConn) newRequestTiming() *requestTiming {
+	if c.ws == nil {
+		return c.timing
+	}
+	if !c.wsTiming {
+		return nil
+	}
+	return newRequestTiming()
+}
+
+// withTimingMeta returns a callback adding the timing as meta to a
+// successful result, or the callback itself if rt is nil.
+func withTimingMeta(rt *requestTiming, cb func(result interface{}, err error)) func(result interface{}, err error) {
+	if rt == nil {
+		return cb
+	}
+	return func(result interface{}, err error) {
+		if err == nil {
+			result = &rpc.MetaResult{Result: result, Meta: map[string]interface{}{"timing": rt.meta()}}
+		}
+		cb(result, err)
+	}
+}
+
 func (c *wsConn) NewResource(rid string, params interface{}, cb func(result interface{}, err error)) {
-	c.call(rid, "new", params, func(result json.RawMessage, refRID string, err error) {
+	if c.shedLoad() {
+		cb(nil, c.serv.errLoadShed())
+		return
+	}
+	c.call(rid, "new", params, c.newRequestTiming(), func(result json.RawMessage, refRID string, err error) {
 		if err != nil {
 			cb(nil, err)
 			return
@@ -473,24 +1207,91 @@ func (c *wsConn) subscribe(rid string, direct bool) (*Subscription, error) {
 		return sub, err
 	}
 
+	if max := c.serv.cfg.MaxHTTPResources; max > 0 && c.ws == nil && len(c.subs) >= max {
+		c.Debugf("Subscription %s: Resource limit exceeded (%d)", rid, max)
+		c.resourceLimitExceeded = true
+		return nil, errResourceLimitExceeded
+	}
+
 	sub = NewSubscription(c, rid)
 	_ = c.addCount(sub, direct)
-	c.serv.cache.Subscribe(sub)
+	if c.timing != nil {
+		sub.loadStart = time.Now()
+	}
+	if d := c.serv.cfg.referenceTimeout; d > 0 && !direct && c.ws == nil {
+		sub.startLoadTimer(d)
+	}
+	if sub.unresolved {
+		// Never get the resource with an incomplete injected query
+		sub.Loaded(nil, reserr.ErrAccessDenied)
+	} else {
+		c.serv.cache.Subscribe(sub)
+	}
 
 	c.subs[rid] = sub
 	return sub, nil
 }
 
 // subscribe gets existing subscription or creates a new one to cache
-// Will return error if number of allowed subscriptions for the resource is exceeded
+// Will return error if number of allowed subscriptions for the resource is exceeded,
+// or if the query of a direct subscription exceeds the maximum query length.
 func (c *wsConn) Subscribe(rid string, direct bool) (*Subscription, error) {
 	if c.disposing {
 		return nil, reserr.ErrDisposing
 	}
+	if direct && c.serv.cfg.MaxQueryLength > 0 {
+		if _, q := parseRID(rid); len(q) > c.serv.cfg.MaxQueryLength {
+			return nil, reserr.ErrInvalidQuery
+		}
+	}
+
+	if direct {
+		if err := c.serv.warmupError(rid); err != nil {
+			return nil, err
+		}
+	}
+
+	if direct && c.serv.cfg.subscriptionQuotas != nil {
+		if sub, ok := c.subs[rid]; !ok || sub.direct == 0 {
+			if err := c.checkQuotas(rid); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	return c.subscribe(rid, direct)
 }
 
+// checkQuotas returns an error if a new direct subscription to the resource
+// would exceed the quota of any matching subscriptionQuotas pattern.
+func (c *wsConn) checkQuotas(rid string) error {
+	rname, _ := parseRID(rid)
+	for i, q := range c.serv.cfg.subscriptionQuotas {
+		if q.pattern.Match(rname) && c.quotaCounts != nil && c.quotaCounts[i] >= q.value {
+			c.Debugf("Subscription %s: Subscription quota exceeded (%d)", rid, q.value)
+			return errSubscriptionLimitExceeded
+		}
+	}
+	return nil
+}
+
+// countQuotas adds n to the direct subscription count of each
+// subscriptionQuotas pattern matching the resource name.
+func (c *wsConn) countQuotas(rname string, n int) {
+	quotas := c.serv.cfg.subscriptionQuotas
+	if quotas == nil {
+		return
+	}
+	if c.quotaCounts == nil {
+		c.quotaCounts = make([]int, len(quotas))
+	}
+	for i, q := range quotas {
+		if q.pattern.Match(rname) {
+			c.quotaCounts[i] += n
+		}
+	}
+}
+
 // unsubscribe counts down the subscription counter
 // and deletes the subscription if the count reached 0.
 func (c *wsConn) Unsubscribe(sub *Subscription, direct bool, count int, tryDelete bool) {
@@ -522,7 +1323,11 @@ func (c *wsConn) addCount(s *Subscription, direct bool) error {
 			return errSubscriptionLimitExceeded
 		}
 
+		if s.direct == 0 {
+			c.countQuotas(s.ResourceName(), 1)
+		}
 		s.direct++
+		s.touch()
 	} else {
 		s.indirect++
 	}
@@ -538,6 +1343,9 @@ func (c *wsConn) removeCount(s *Subscription, direct bool, count int, tryDelete
 	}
 
 	if direct {
+		if s.direct > 0 && s.direct <= count {
+			c.countQuotas(s.ResourceName(), -1)
+		}
 		s.direct -= count
 	} else {
 		s.indirect -= count
@@ -549,6 +1357,17 @@ func (c *wsConn) removeCount(s *Subscription, direct bool, count int, tryDelete
 }
 
 func (c *wsConn) setToken(token json.RawMessage) {
+	token = c.validateToken(token)
+	c.ClearAccessCache()
+	if c.tenant != "" {
+		c.setTenant(c.serv.cfg.tenantLabel(token))
+	}
+	if c.ws != nil && len(c.serv.cfg.WSRedirects) > 0 {
+		if u := c.serv.cfg.tokenRedirect(token); u != "" {
+			// Redirect once any pending response is sent
+			c.Enqueue(func() { c.redirect(u) })
+		}
+	}
 	if c.token == nil {
 		// No need to revalidate nil token access
 		c.token = token
@@ -556,15 +1375,211 @@ func (c *wsConn) setToken(token json.RawMessage) {
 	}
 
 	c.token = token
+	if c.authPending {
+		// Reaccess once with the final token of the pending auth requests
+		c.reaccessDeferred = true
+		return
+	}
+	c.reaccessAll()
+}
+
+// setCookieToken sets any token of the token cookie as connection token, and
+// calls any headerAuth method, with or without a token. A missing or invalid
+// token leaves the connection without a token.
+func (c *wsConn) setCookieToken(token json.RawMessage) {
+	if token != nil {
+		c.setToken(token)
+	}
+	if c.serv.cfg.HeaderAuth == nil {
+		return
+	}
+	c.AuthResource(c.serv.cfg.headerAuthRID, c.serv.cfg.headerAuthAction, nil, func(_ interface{}, err error) {
+		if err != nil {
+			c.Debugf("Header auth on connect failed: %s", err)
+		}
+	})
+}
+
+// reaccessAll makes all subscriptions check their access again.
+func (c *wsConn) reaccessAll() {
 	for _, sub := range c.subs {
 		sub.reaccess()
 	}
 }
 
+// setTenant moves the connection between tenant connection metrics, if the
+// tenant label has changed. An empty label removes the connection from the
+// metrics.
+func (c *wsConn) setTenant(tenant string) {
+	if c.tenant == tenant {
+		return
+	}
+	if c.tenant != "" {
+		c.serv.metrics.tenantConns.With(c.tenant).Add(-1)
+	}
+	if tenant != "" {
+		c.serv.metrics.tenantConns.With(tenant).Add(1)
+	}
+	c.tenant = tenant
+}
+
+// validateToken validates the expiration time of a JWT token, if a JWT
+// validator is configured. It returns nil if the token is expired or cannot be
+// verified, otherwise the token itself. A token with an expiration time starts
+// a timer that expires the token.
+func (c *wsConn) validateToken(token json.RawMessage) json.RawMessage {
+	c.stopTokenTimer()
+	v := c.serv.jwt
+	if v == nil || token == nil || string(token) == "null" {
+		return token
+	}
+
+	str, ok := c.serv.tokenJWT(token)
+	if !ok {
+		c.Debugf("Token without JWT ignored")
+		return nil
+	}
+	exp, err := v.Validate(str)
+	if err != nil {
+		c.Debugf("Token ignored: %s", err)
+		if err == jwt.ErrExpired && c.serv.cfg.JWTExpiredClose {
+			c.DisconnectWithClose(websocket.ClosePolicyViolation, "Token expired", "token expired")
+		}
+		return nil
+	}
+
+	if !exp.IsZero() {
+		var t *time.Timer
+		t = time.AfterFunc(time.Until(exp), func() {
+			c.Enqueue(func() {
+				if c.tokenTimer == t {
+					c.tokenTimer = nil
+					c.expireToken()
+				}
+			})
+		})
+		c.tokenTimer = t
+	}
+	return token
+}
+
+// expireToken either closes the connection, or clears the token, when the
+// token's expiration time is reached.
+func (c *wsConn) expireToken() {
+	c.Debugf("Token expired")
+	if c.serv.cfg.JWTExpiredClose {
+		c.DisconnectWithClose(websocket.ClosePolicyViolation, "Token expired", "token expired")
+		return
+	}
+	c.setToken(nil)
+}
+
+func (c *wsConn) stopTokenTimer() {
+	if c.tokenTimer != nil {
+		c.tokenTimer.Stop()
+		c.tokenTimer = nil
+	}
+}
+
+// Access requests access for the subscribed resource.
 func (c *wsConn) Access(s *Subscription, cb func(*rescache.Access)) {
+	c.access(s, "", cb)
+}
+
+// SharedAccess requests access for the subscribed resource from a shared
+// access resource, with the query of the subscribed resource. It is used by
+// multiple subscribe requests for resources matching a sharedAccess pattern.
+func (c *wsConn) SharedAccess(s *Subscription, rid string, cb func(*rescache.Access)) {
+	c.access(s, rid, cb)
+}
+
+// access requests access for the subscribed resource, from the shared access
+// resource if rid is set, using any cached access result.
+func (c *wsConn) access(s *Subscription, rid string, cb func(*rescache.Access)) {
+	if rt := c.timing; rt != nil {
+		accessStart := time.Now()
+		ocb := cb
+		cb = func(a *rescache.Access) {
+			rt.observe("access", accessStart)
+			ocb(a)
+		}
+	}
+	if c.serv.cfg.accessTimeoutFallback != nil {
+		cb = c.withAccessTimeoutFallback(s.ResourceName(), cb)
+	}
+	key := rid
+	if key == "" {
+		key = s.ResourceName()
+	}
+	if q := s.ResourceQuery(); q != "" {
+		key += "?" + q
+	}
+
+	// Use any cached access result
+	ttl, ok := c.serv.cfg.accessCacheTTL.match(s.ResourceName())
+	if ok {
+		if ca, ok := c.accessCache[key]; ok {
+			if time.Now().Before(ca.expires) {
+				c.Tracef("Access cache hit for %s", key)
+				cb(ca.access)
+				return
+			}
+			delete(c.accessCache, key)
+		}
+		gen := c.accessCacheGen
+		ocb := cb
+		cb = func(a *rescache.Access) {
+			// Only cache an actual result or system.accessDenied error
+			if a.Error == nil || a.Error.Code == reserr.CodeAccessDenied {
+				c.Enqueue(func() {
+					if gen == c.accessCacheGen {
+						if c.accessCache == nil {
+							c.accessCache = make(map[string]*cachedAccess)
+						}
+						c.accessCache[key] = &cachedAccess{access: a, expires: time.Now().Add(ttl)}
+					}
+				})
+			}
+			ocb(a)
+		}
+	}
+
+	if rid != "" {
+		c.serv.cache.AccessResource(s, rid, s.ResourceQuery(), c.token, cb)
+		return
+	}
+	if name, ok := c.serv.cfg.accessBatch.match(s.ResourceName()); ok {
+		c.batchAccess(name, key, cb)
+		return
+	}
 	c.serv.cache.Access(s, c.token, cb)
 }
 
+// withAccessTimeoutFallback returns an access callback replacing a
+// system.timeout error with the access result of any accessTimeoutFallback
+// pattern matching the resource name. Other errors, including
+// system.accessDenied, are never replaced. The fallback access result is not
+// cached.
+func (c *wsConn) withAccessTimeoutFallback(rname string, cb func(*rescache.Access)) func(*rescache.Access) {
+	return func(a *rescache.Access) {
+		if a.Error != nil && a.Error.Code == reserr.CodeTimeout {
+			if v, ok := c.serv.cfg.accessTimeoutFallback.match(rname); ok {
+				var ar codec.AccessResult
+				_ = json.Unmarshal([]byte(v), &ar)
+				c.Debugf("Access request for %s timed out: using fallback access %s", rname, v)
+				a = &rescache.Access{AccessResult: &ar}
+			}
+		}
+		cb(a)
+	}
+}
+
+// ClearAccessCache removes all access results cached by the connection.
+func (c *wsConn) ClearAccessCache() {
+	c.accessCache = nil
+	c.accessCacheGen++
+}
+
 func (c *wsConn) outputWorker() {
 	for range c.work {
 		idx := 0
@@ -576,7 +1591,9 @@ func (c *wsConn) outputWorker() {
 			f()
 			idx++
 			c.mu.Lock()
+			c.queueDone = idx
 		}
+		c.queueDone = 0
 
 		if cap(c.queue) > WSConnWorkerQueueSize {
 			c.queue = make([]func(), 0, WSConnWorkerQueueSize)
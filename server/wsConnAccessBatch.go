@@ -0,0 +1,55 @@
+package server
+
+import (
+	"time"
+
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+// accessBatch is a set of access requests collected for a batch resource,
+// awaiting to be sent as a single access batch request.
+type accessBatch struct {
+	rids []string
+	cbs  map[string][]func(*rescache.Access)
+}
+
+// batchAccess adds the access request for the resource ID to the batch of
+// the batch resource. The batch is sent when the accessBatchWindow has
+// passed since the first request was added.
+func (c *wsConn) batchAccess(name string, rid string, cb func(*rescache.Access)) {
+	b, ok := c.accessBatches[name]
+	if !ok {
+		b = &accessBatch{cbs: make(map[string][]func(*rescache.Access))}
+		if c.accessBatches == nil {
+			c.accessBatches = make(map[string]*accessBatch)
+		}
+		c.accessBatches[name] = b
+		time.AfterFunc(c.serv.cfg.accessBatchWindow, func() {
+			c.Enqueue(func() {
+				c.sendAccessBatch(name)
+			})
+		})
+	}
+	if _, ok := b.cbs[rid]; !ok {
+		b.rids = append(b.rids, rid)
+	}
+	b.cbs[rid] = append(b.cbs[rid], cb)
+}
+
+// sendAccessBatch sends the collected access requests of the batch resource
+// as a single access batch request.
+func (c *wsConn) sendAccessBatch(name string) {
+	b, ok := c.accessBatches[name]
+	if !ok {
+		return
+	}
+	delete(c.accessBatches, name)
+	c.Debugf("Sending access batch for %s with %d resources", name, len(b.rids))
+	c.serv.cache.AccessBatch(c, name, b.rids, c.token, func(access map[string]*rescache.Access) {
+		for _, rid := range b.rids {
+			for _, cb := range b.cbs[rid] {
+				cb(access[rid])
+			}
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// pendingAck is an event sent to the client, awaiting acknowledgement.
+type pendingAck struct {
+	id           uint64
+	rname        string    // Resource name of the event
+	data         []byte    // Encoded event without ack ID
+	sent         time.Time // Time the event was first sent
+	redeliveries int
+}
+
+// unackedEvents are the unacknowledged events of a disconnected client, kept
+// for redelivery to a new connection with the same token.
+type unackedEvents struct {
+	token  json.RawMessage
+	events []*pendingAck
+	resync map[string]bool // Resources with events dropped from the buffer
+	timer  *time.Timer
+}
+
+// SendEvent sends an encoded resource event to the client. If the resource
+// matches an ackEvents pattern, the event is sent with an ack ID, and kept
+// until acknowledged by the client.
+func (c *wsConn) SendEvent(rname string, data []byte) {
+	if c.ws == nil || c.serv.cfg.ackEvents == nil || !c.serv.cfg.requiresAck(rname) {
+		c.Send(data)
+		return
+	}
+	c.sendAck(&pendingAck{rname: rname, data: data, sent: time.Now()})
+}
+
+// sendAck assigns a new ack ID to the pending event, and sends it to the
+// client. If the pending limit is exceeded, the oldest event is dropped.
+// Events of the resource exceeding any ackBufferSize or ackBufferTTL limit
+// are also dropped, and the resource is marked for resync.
+func (c *wsConn) sendAck(pa *pendingAck) {
+	c.ackSeq++
+	pa.id = c.ackSeq
+	if len(c.acks) >= AckPendingLimit {
+		c.Debugf("Pending ack limit exceeded. Dropping event %d", c.acks[0].id)
+		c.dropAck(c.acks[0])
+		c.acks[0] = nil
+		c.acks = c.acks[1:]
+	}
+	c.acks = append(c.acks, pa)
+	c.trimAcks(pa.rname, time.Now())
+	c.Send(rpc.WithAck(pa.data, pa.id))
+}
+
+// trimAcks drops the oldest unacknowledged events of the resource that have
+// either fallen off the ackBufferSize limit, or are older than the
+// ackBufferTTL duration of the resource.
+func (c *wsConn) trimAcks(rname string, now time.Time) {
+	size, hasSize := c.serv.cfg.ackBufferSize.match(rname)
+	if !hasSize && c.serv.cfg.ackBufferTTL == nil {
+		return
+	}
+	count := 0
+	if hasSize {
+		for _, pa := range c.acks {
+			if pa.rname == rname {
+				count++
+			}
+		}
+	}
+	acks := c.acks[:0]
+	for _, pa := range c.acks {
+		if pa.rname == rname && ((hasSize && count > size) || c.ackExpired(pa, now)) {
+			c.Debugf("Ack buffer limit exceeded for %s. Dropping event %d", rname, pa.id)
+			c.dropAck(pa)
+			count--
+			continue
+		}
+		acks = append(acks, pa)
+	}
+	for i := len(acks); i < len(c.acks); i++ {
+		c.acks[i] = nil
+	}
+	c.acks = acks
+}
+
+// ackExpired returns true if the unacknowledged event is older than the
+// ackBufferTTL duration of its resource.
+func (c *wsConn) ackExpired(pa *pendingAck, now time.Time) bool {
+	ttl, ok := c.serv.cfg.ackBufferTTL.match(pa.rname)
+	return ok && now.Sub(pa.sent) > ttl
+}
+
+// dropAck marks the resource of a dropped unacknowledged event for resync,
+// as the client will no longer get all of its events redelivered.
+func (c *wsConn) dropAck(pa *pendingAck) {
+	if c.ackResync == nil {
+		c.ackResync = make(map[string]bool)
+	}
+	c.ackResync[pa.rname] = true
+}
+
+// Ack acknowledges the delivery of events by their ack IDs. Unknown IDs are
+// ignored.
+func (c *wsConn) Ack(ids []uint64) {
+	for _, id := range ids {
+		i := sort.Search(len(c.acks), func(i int) bool { return c.acks[i].id >= id })
+		if i < len(c.acks) && c.acks[i].id == id {
+			copy(c.acks[i:], c.acks[i+1:])
+			c.acks[len(c.acks)-1] = nil
+			c.acks = c.acks[:len(c.acks)-1]
+		}
+	}
+}
+
+// Redeliver calls the callback with the ack key of the connection, and
+// redelivers any unacknowledged events of a disconnected client identified by
+// the key, provided it had the same token. Events already redelivered the
+// maximum number of times are dropped.
+//
+// Instead of a partial redelivery, resources with events dropped from the
+// buffer, or older than the ackBufferTTL duration, are listed in a resync
+// event, and none of their events are redelivered.
+func (c *wsConn) Redeliver(key string, cb func(key string)) {
+	if c.ackKey == "" {
+		c.ackKey = newAckKey()
+	}
+	cb(c.ackKey)
+
+	if key == "" || key == c.ackKey {
+		return
+	}
+	ue := c.serv.takeUnackedEvents(key, c.token)
+	if ue == nil {
+		return
+	}
+	resync := ue.resync
+	if resync == nil {
+		resync = make(map[string]bool)
+	}
+	now := time.Now()
+	for _, pa := range ue.events {
+		if c.ackExpired(pa, now) {
+			resync[pa.rname] = true
+		}
+	}
+	if len(resync) > 0 {
+		rids := make([]string, 0, len(resync))
+		for rname := range resync {
+			rids = append(rids, rname)
+		}
+		sort.Strings(rids)
+		c.Debugf("Ack buffer exceeded. Resyncing %v", rids)
+		c.Send(rpc.NewConnEvent("resync", rpc.ResyncEvent{RIDs: rids}))
+	}
+
+	max := c.serv.cfg.ackRedeliveries
+	for _, pa := range ue.events {
+		if resync[pa.rname] {
+			continue
+		}
+		if pa.redeliveries >= max {
+			c.Debugf("Ack redelivery limit reached. Dropping event %d", pa.id)
+			continue
+		}
+		pa.redeliveries++
+		c.sendAck(pa)
+	}
+}
+
+// retainAcks hands any unacknowledged events, and any resources marked for
+// resync, over to the service, for redelivery to a new connection. Events are
+// only retained if the client has requested an ack key.
+func (c *wsConn) retainAcks() {
+	if c.ackKey == "" || (len(c.acks) == 0 && len(c.ackResync) == 0) {
+		return
+	}
+	c.serv.retainUnackedEvents(c.ackKey, &unackedEvents{
+		token:  c.token,
+		events: c.acks,
+		resync: c.ackResync,
+	})
+	c.acks = nil
+	c.ackResync = nil
+}
+
+// retainUnackedEvents stores the unacknowledged events by ack key, until
+// taken by a new connection or the retention duration has passed.
+func (s *Service) retainUnackedEvents(key string, ue *unackedEvents) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unacked == nil {
+		s.unacked = make(map[string]*unackedEvents)
+	}
+	s.unacked[key] = ue
+	ue.timer = time.AfterFunc(AckRetention, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.unacked[key] == ue {
+			delete(s.unacked, key)
+		}
+	})
+}
+
+// takeUnackedEvents removes and returns the unacknowledged events stored by
+// ack key. Nil is returned if no events are stored, or if they were stored for
+// a different token.
+func (s *Service) takeUnackedEvents(key string, token json.RawMessage) *unackedEvents {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ue, ok := s.unacked[key]
+	if !ok || !bytes.Equal(ue.token, token) {
+		return nil
+	}
+	ue.timer.Stop()
+	delete(s.unacked, key)
+	return ue
+}
+
+// clearUnackedEvents drops all stored unacknowledged events.
+func (s *Service) clearUnackedEvents() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ue := range s.unacked {
+		ue.timer.Stop()
+	}
+	s.unacked = nil
+}
+
+// newAckKey returns a random key, used by a client to have unacknowledged
+// events redelivered on reconnect.
+func newAckKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,47 @@
+package server
+
+// queuedAuth is a client auth request waiting for a pending auth request on
+// the connection to complete.
+type queuedAuth struct {
+	rid    string
+	action string
+	params interface{}
+	cb     func(result interface{}, err error)
+}
+
+// serializeAuth reports whether the auth request should be queued behind a
+// pending auth request, marking the request as pending otherwise. Only
+// WebSocket connections with serializeAuth enabled are serialized.
+func (c *wsConn) serializeAuth(rid, action string, params interface{}, cb func(result interface{}, err error)) bool {
+	if !c.serv.cfg.SerializeAuth || c.ws == nil {
+		return false
+	}
+	if c.authPending {
+		c.Tracef("Auth request queued with a pending auth request")
+		c.authQueue = append(c.authQueue, &queuedAuth{rid: rid, action: action, params: params, cb: cb})
+		return true
+	}
+	c.authPending = true
+	return false
+}
+
+// authDone sends the next queued auth request, if any. Once no auth request
+// is pending, a reaccess deferred while the auth requests were pending is
+// made with the final token.
+func (c *wsConn) authDone() {
+	if !c.authPending {
+		return
+	}
+	if len(c.authQueue) > 0 {
+		qa := c.authQueue[0]
+		c.authQueue[0] = nil
+		c.authQueue = c.authQueue[1:]
+		c.sendAuth(qa.rid, qa.action, qa.params, qa.cb)
+		return
+	}
+	c.authPending = false
+	if c.reaccessDeferred {
+		c.reaccessDeferred = false
+		c.reaccessAll()
+	}
+}
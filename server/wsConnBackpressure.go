@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// Backpressure event reasons.
+const (
+	backpressureRequests = "requests"
+	backpressureLoadShed = "loadShed"
+)
+
+// addPendingRequest counts a client request awaiting a reply. If the
+// backpressureRequests limit is reached, a backpressure event is sent to the
+// client, once until the pending requests have dropped to half the limit.
+func (c *wsConn) addPendingRequest() {
+	c.pendingRequests++
+	max := c.serv.cfg.BackpressureRequests
+	if max == 0 || c.backpressured || c.pendingRequests < max {
+		return
+	}
+	c.backpressured = true
+	c.Debugf("Pending request limit %d reached. Sending backpressure event", max)
+	c.sendBackpressure(rpc.BackpressureEvent{Reason: backpressureRequests})
+}
+
+// removePendingRequest counts a client request as replied to.
+func (c *wsConn) removePendingRequest() {
+	if c.pendingRequests == 0 {
+		return
+	}
+	c.pendingRequests--
+	if c.backpressured && c.pendingRequests <= c.serv.cfg.BackpressureRequests/2 {
+		c.backpressured = false
+	}
+}
+
+// sendBackpressure sends a backpressure event to the client.
+func (c *wsConn) sendBackpressure(ev rpc.BackpressureEvent) {
+	c.Send(rpc.NewConnEvent("backpressure", ev))
+}
+
+// broadcastLoadShedBackpressure sends a backpressure event to all WebSocket
+// connections, advising them to retry after the load shed window.
+func (s *Service) broadcastLoadShedBackpressure() {
+	ev := rpc.BackpressureEvent{Reason: backpressureLoadShed, RetryAfter: s.errLoadShed().RetryAfter()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		if c.ws == nil {
+			continue
+		}
+		c := c
+		c.Enqueue(func() {
+			c.sendBackpressure(ev)
+		})
+	}
+}
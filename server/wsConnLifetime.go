@@ -0,0 +1,47 @@
+package server
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startLifetimeTimer starts a timer expiring the connection once it has
+// reached the maximum lifetime.
+func (c *wsConn) startLifetimeTimer(d time.Duration) {
+	c.lifetimeTimer = time.AfterFunc(d, func() {
+		c.Enqueue(c.expireLifetime)
+	})
+}
+
+// stopLifetimeTimer stops any lifetime timer.
+func (c *wsConn) stopLifetimeTimer() {
+	if c.lifetimeTimer != nil {
+		c.lifetimeTimer.Stop()
+		c.lifetimeTimer = nil
+	}
+}
+
+// expireLifetime closes the connection with a reconnect close reason, once
+// any pending client requests are replied to.
+func (c *wsConn) expireLifetime() {
+	if c.lifetimeTimer == nil {
+		return
+	}
+	c.lifetimeTimer = nil
+	c.lifetimeExpired = true
+	if c.pendingRequests > 0 || len(c.requestQueue) > 0 {
+		c.Debugf("Max connection lifetime reached. Closing once %d pending request(s) are replied to", c.pendingRequests+len(c.requestQueue))
+		return
+	}
+	c.closeExpiredLifetime()
+}
+
+// closeExpiredLifetime closes a connection past its lifetime, if it has no
+// pending client requests.
+func (c *wsConn) closeExpiredLifetime() {
+	if !c.lifetimeExpired || c.pendingRequests > 0 || len(c.requestQueue) > 0 {
+		return
+	}
+	c.DisconnectWithClose(websocket.CloseGoingAway, ReconnectCloseText, "max connection lifetime reached")
+}
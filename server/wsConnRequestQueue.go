@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// queuedClientRequest is a client request waiting for the number of pending
+// requests on the connection to drop below the maxConnRequests limit.
+type queuedClientRequest struct {
+	data  []byte
+	timer *time.Timer
+}
+
+// handleRequest handles the client request, or queues it if the number of
+// pending requests has reached the maxConnRequests limit. If the queue is
+// full, the request is rejected.
+func (c *wsConn) handleRequest(data []byte) {
+	if max := c.serv.cfg.MaxConnRequests; max > 0 && (c.pendingRequests >= max || len(c.requestQueue) > 0) {
+		if len(c.requestQueue) >= ConnRequestQueueSize {
+			c.rejectRequest(data, errRequestQueueFull)
+			return
+		}
+		r := &queuedClientRequest{data: data}
+		r.timer = time.AfterFunc(c.serv.mq.Timeout(), func() {
+			c.Enqueue(func() {
+				c.expireQueuedRequest(r)
+			})
+		})
+		c.requestQueue = append(c.requestQueue, r)
+		c.Tracef("Request queued with %d pending requests", c.pendingRequests)
+		return
+	}
+
+	c.dispatchRequest(data)
+}
+
+// handleQueuedRequest handles the first queued client request, if the number
+// of pending requests is below the maxConnRequests limit.
+func (c *wsConn) handleQueuedRequest() {
+	if len(c.requestQueue) == 0 || c.pendingRequests >= c.serv.cfg.MaxConnRequests {
+		return
+	}
+	r := c.requestQueue[0]
+	c.requestQueue[0] = nil
+	c.requestQueue = c.requestQueue[1:]
+	r.timer.Stop()
+	c.dispatchRequest(r.data)
+}
+
+// dispatchRequest counts the client request as pending, and dispatches it.
+func (c *wsConn) dispatchRequest(data []byte) {
+	c.addPendingRequest()
+	err := rpc.HandleRequest(data, c)
+	if err != nil {
+		c.removePendingRequest()
+		if len(c.requestQueue) > 0 {
+			c.Enqueue(c.handleQueuedRequest)
+		}
+	}
+	c.handleProtocolError(err)
+}
+
+// expireQueuedRequest removes the client request from the queue, replying
+// with a system.timeout error.
+func (c *wsConn) expireQueuedRequest(r *queuedClientRequest) {
+	for i, qr := range c.requestQueue {
+		if qr != r {
+			continue
+		}
+		c.requestQueue = append(c.requestQueue[:i], c.requestQueue[i+1:]...)
+		c.rejectRequest(r.data, reserr.ErrTimeout)
+		return
+	}
+}
+
+// rejectRequest replies to the client request with the error, without
+// handling it.
+func (c *wsConn) rejectRequest(data []byte, err error) {
+	// Let malformed requests fail as if handled
+	var req rpc.Request
+	if json.Unmarshal(data, &req) != nil || req.ID == nil {
+		c.handleProtocolError(rpc.HandleRequest(data, c))
+		return
+	}
+	c.addPendingRequest()
+	c.Reply(req.ErrorResponse(err))
+}
+
+// clearRequestQueue drops all queued client requests, stopping their timers.
+func (c *wsConn) clearRequestQueue() {
+	for _, r := range c.requestQueue {
+		r.timer.Stop()
+	}
+	c.requestQueue = nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/resgateio/resgate/server/reserr"
+	"github.com/resgateio/resgate/server/rpc"
+)
+
+// resumeSession is the session of a disconnected client, kept for a new
+// connection to resume using the resume token.
+type resumeSession struct {
+	token json.RawMessage
+	rids  []string // Directly subscribed resource IDs
+	timer *time.Timer
+}
+
+// Resume calls the callback with the resume token of the connection. If the
+// token of a session of a disconnected client is provided, the session's
+// access token is restored, and its direct subscriptions are subscribed to,
+// with the resources included in the result. A session may only be resumed
+// once. Unknown or expired tokens are not resumed.
+func (c *wsConn) Resume(token string, cb func(data *rpc.ResumeResult, err error)) {
+	if c.serv.cfg.resumeTTL == 0 {
+		cb(nil, reserr.ErrInvalidRequest)
+		return
+	}
+	if c.resumeToken == "" {
+		c.resumeToken = newResumeToken()
+	}
+
+	if token == "" || token == c.resumeToken {
+		cb(&rpc.ResumeResult{Token: c.resumeToken}, nil)
+		return
+	}
+	rs := c.serv.takeResumeSession(token)
+	if rs == nil {
+		c.Debugf("Resume token unknown or expired")
+		cb(&rpc.ResumeResult{Token: c.resumeToken}, nil)
+		return
+	}
+
+	c.setToken(rs.token)
+	if len(rs.rids) == 0 {
+		cb(&rpc.ResumeResult{Token: c.resumeToken, Resumed: true}, nil)
+		return
+	}
+	c.subscribeResources(rs.rids, func(data *rpc.Resources, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+		cb(&rpc.ResumeResult{Token: c.resumeToken, Resumed: true, Resources: data}, nil)
+	})
+}
+
+// retainSession hands the token and direct subscriptions of the connection
+// over to the service, to be resumed by a new connection. The session is only
+// retained if the client has requested a resume token.
+func (c *wsConn) retainSession() {
+	if c.resumeToken == "" {
+		return
+	}
+	c.serv.retainResumeSession(c.resumeToken, &resumeSession{
+		token: c.token,
+		rids:  c.directRIDs(),
+	})
+}
+
+// retainResumeSession stores the session by resume token, until taken by a
+// new connection or the resumeTTL duration has passed.
+func (s *Service) retainResumeSession(key string, rs *resumeSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resumes == nil {
+		s.resumes = make(map[string]*resumeSession)
+	}
+	s.resumes[key] = rs
+	rs.timer = time.AfterFunc(s.cfg.resumeTTL, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.resumes[key] == rs {
+			delete(s.resumes, key)
+		}
+	})
+}
+
+// takeResumeSession removes and returns the session stored by resume token.
+// Nil is returned if no session is stored.
+func (s *Service) takeResumeSession(key string) *resumeSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rs, ok := s.resumes[key]
+	if !ok {
+		return nil
+	}
+	rs.timer.Stop()
+	delete(s.resumes, key)
+	return rs
+}
+
+// clearResumeSessions drops all stored sessions.
+func (s *Service) clearResumeSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rs := range s.resumes {
+		rs.timer.Stop()
+	}
+	s.resumes = nil
+}
+
+// newResumeToken returns a random, unguessable token, used by a client to
+// resume its session on reconnect.
+func newResumeToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
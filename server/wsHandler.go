@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -30,6 +31,7 @@ func (s *Service) initWSHandler() {
 		WriteBufferSize:   1024,
 		CheckOrigin:       co,
 		EnableCompression: s.cfg.WSCompression,
+		Subprotocols:      []string{MsgpackSubprotocol},
 	}
 	s.conns = make(map[string]*wsConn)
 }
@@ -41,23 +43,74 @@ func (s *Service) GetWSHandlerFunc() http.Handler {
 }
 
 func (s *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.shedLoad() {
+		s.Debugf("Rejected connection from %s due to load shedding", r.RemoteAddr)
+		w.Header().Set("Retry-After", strconv.Itoa(s.errLoadShed().RetryAfter()))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.reserveWSConn() {
+		s.Debugf("Rejected connection from %s due to max connections limit", r.RemoteAddr)
+		w.Header().Set("Retry-After", strconv.Itoa(MaxConnectionsRetryAfter))
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+
 	// Upgrade to gorilla websocket
 	ws, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		s.releaseWSConn()
 		s.Debugf("Failed to upgrade connection from %s: %s", r.RemoteAddr, err.Error())
 		return
 	}
 
 	conn := s.newWSConn(ws, r, versionLegacy)
+	s.releaseWSConn()
 	if conn == nil {
 		return
 	}
 
 	conn.Tracef("Connected: %s", ws.RemoteAddr())
 
+	if s.cfg.TokenCookie != "" {
+		token := cookieToken(r, s.cfg.TokenCookie)
+		conn.Enqueue(func() { conn.setCookieToken(token) })
+	}
+
+	if len(s.cfg.WSRedirects) > 0 {
+		s.mu.Lock()
+		u := s.cfg.connectionsRedirect(len(s.conns))
+		s.mu.Unlock()
+		if u != "" {
+			conn.redirect(u)
+		}
+	}
+
 	conn.listen()
 }
 
+// reserveWSConn reserves a connection slot for a WebSocket upgrade. It
+// returns false if the maxConnections limit is reached. A reserved slot must
+// be released with releaseWSConn once the connection is created, or the
+// upgrade has failed.
+func (s *Service) reserveWSConn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max := s.cfg.MaxConnections; max > 0 && len(s.conns)+s.reserved >= max {
+		return false
+	}
+	s.reserved++
+	return true
+}
+
+// releaseWSConn releases a connection slot reserved with reserveWSConn.
+func (s *Service) releaseWSConn() {
+	s.mu.Lock()
+	s.reserved--
+	s.mu.Unlock()
+}
+
 // stopWSHandler disconnects all ws connections.
 func (s *Service) stopWSHandler() {
 	s.mu.Lock()
@@ -69,7 +122,11 @@ func (s *Service) stopWSHandler() {
 	s.Debugf("Closing %d WebSocket connection(s)...", len(s.conns))
 	// Disconnecting all ws connections
 	for _, conn := range s.conns {
-		conn.Disconnect("Server is shutting down")
+		if s.cfg.ResyncManifest {
+			conn.disconnectWithResyncManifest(websocket.CloseGoingAway, s.cfg.shutdownCloseText, "Server is shutting down")
+		} else {
+			conn.DisconnectWithClose(websocket.CloseGoingAway, s.cfg.shutdownCloseText, "Server is shutting down")
+		}
 	}
 	s.mu.Unlock()
 
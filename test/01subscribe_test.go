@@ -3,6 +3,7 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/resgateio/resgate/server/mq"
@@ -416,3 +417,31 @@ func TestSubscribe_MultipleClientsSubscribingResource_FetchedFromCache(t *testin
 		})
 	}
 }
+
+// Test that a malformed get response is logged with the reason, while the
+// client gets a generic internal error
+func TestSubscribe_WithMalformedGetResponse_LogsReason(t *testing.T) {
+	tbl := []struct {
+		GetResponse json.RawMessage
+		Reason      string
+	}{
+		{json.RawMessage(`{"model":{"foo":"bar"},"collection":[1,2,3]}`), "both model and collection are set"},
+		{json.RawMessage(`{"foo":"bar"}`), "neither model nor collection is set"},
+		{json.RawMessage(`{"model":{"prop":{"action":"delete"}}}`), `model property "prop": delete action not allowed`},
+		{json.RawMessage(`{"collection":["prop",[1,2]]}`), "collection index 1: array values must be wrapped in a data value object"},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(l.GetResponse)
+			creq.GetResponse(t).AssertError(t, &reserr.Error{Code: reserr.CodeInternalError, Message: "Internal error: invalid service response"})
+			if !strings.Contains(s.String(), "Invalid get response - "+l.Reason) {
+				t.Fatalf("expected log to contain reason %#v", l.Reason)
+			}
+		})
+	}
+}
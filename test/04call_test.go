@@ -3,8 +3,10 @@ package test
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/resgateio/resgate/server"
 	"github.com/resgateio/resgate/server/mq"
 	"github.com/resgateio/resgate/server/reserr"
 )
@@ -508,3 +510,41 @@ func TestCall_WithCIDPlaceholder_ReplacesCID(t *testing.T) {
 			AssertResult(t, json.RawMessage(`{"payload":"zoo"}`))
 	})
 }
+
+// Test call request with params exceeding max params depth returns invalid params error
+func TestCall_WithParamsExceedingMaxParamsDepth_ReturnsInvalidParams(t *testing.T) {
+	tbl := []struct {
+		Params        string // Raw JSON params
+		ExpectedError bool
+	}{
+		{`{"foo":[{"bar":1}]}`, false},
+		{`{"foo":"[[[[[["}`, false},
+		{`{"foo":[{"bar":[1]}]}`, true},
+		{`{"foo":` + strings.Repeat("[", 1000) + strings.Repeat("]", 1000) + `}`, true},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", json.RawMessage(l.Params))
+
+			if l.ExpectedError {
+				creq.GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+				return
+			}
+
+			// Handle access request
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			// Handle call request
+			s.GetRequest(t).
+				AssertSubject(t, "call.test.model.method").
+				AssertPathPayload(t, "params", json.RawMessage(l.Params)).
+				RespondSuccess(nil)
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		}, func(cfg *server.Config) {
+			cfg.MaxParamsDepth = 3
+		})
+	}
+}
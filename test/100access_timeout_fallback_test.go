@@ -0,0 +1,73 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withAccessTimeoutFallback(cfg *server.Config) {
+	cfg.AccessTimeoutFallback = map[string]json.RawMessage{
+		"test.model": json.RawMessage(`{"get":true}`),
+	}
+}
+
+// Test that a timed out access request uses the fallback access of a
+// matching accessTimeoutFallback pattern, granting get access
+func TestAccessTimeoutFallback_AccessTimeout_GrantsFallbackAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").Timeout()
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		// The fallback grants no call access
+		c.Request("call.test.model.method", nil).GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withAccessTimeoutFallback)
+}
+
+// Test that the fallback access is not used when the access request
+// explicitly denies access, or for resources not matching any pattern
+func TestAccessTimeoutFallback_DeniedOrNotMatching_NoFallback(t *testing.T) {
+	tbl := []struct {
+		Name     string
+		RID      string
+		Timeout  bool
+		Expected *reserr.Error
+	}{
+		{"denied", "test.model", false, reserr.ErrAccessDenied},
+		{"not matching", "test.collection", true, reserr.ErrTimeout},
+	}
+	for _, l := range tbl {
+		runNamedTest(t, l.Name, func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/"+strings.Replace(l.RID, ".", "/", -1), nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			req := mreqs.GetRequest(t, "access."+l.RID)
+			if l.Timeout {
+				req.Timeout()
+			} else {
+				req.RespondError(reserr.ErrAccessDenied)
+			}
+			mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"model":{}}`))
+			hreq.GetResponse(t).AssertError(t, l.Expected)
+		}, withAccessTimeoutFallback)
+	}
+}
+
+// Test that a timed out access request fails by default
+func TestAccessTimeoutFallback_Default_NoFallback(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").Timeout()
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNotFound).AssertError(t, reserr.ErrTimeout)
+	})
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+func withMaxConnectionLifetime(cfg *server.Config) {
+	cfg.MaxConnectionLifetime = 50
+}
+
+// Test that a connection past its maximum lifetime is closed with a
+// reconnect close reason
+func TestMaxConnectionLifetime_LifetimeReached_ClosesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"reconnect"}`)
+	}, withMaxConnectionLifetime)
+}
+
+// Test that a connection past its maximum lifetime is closed only once its
+// pending requests are replied to
+func TestMaxConnectionLifetime_PendingRequest_ClosesAfterReply(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		time.Sleep(100 * time.Millisecond)
+		req.RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"reconnect"}`)
+	}, withMaxConnectionLifetime)
+}
+
+// Test that connections have no maximum lifetime by default
+func TestMaxConnectionLifetime_Default_KeepsConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		time.Sleep(100 * time.Millisecond)
+		c.Request("version", nil).GetResponse(t)
+	})
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withSerializeAuth(cfg *server.Config) {
+	cfg.SerializeAuth = true
+}
+
+// Test that concurrent auth requests are sent one at a time with
+// serializeAuth, and that subscriptions are reaccessed once with the token of
+// the last auth request
+func TestSerializeAuth_ConcurrentAuth_LastTokenWins(t *testing.T) {
+	token0 := `{"user":"foo","token":0}`
+	tokenA := `{"user":"foo","token":1}`
+	tokenB := `{"user":"foo","token":2}`
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := subscribeToTestModel(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token0+`}`))
+
+		creq1 := c.Request("auth.test.model.login", json.RawMessage(`{"n":1}`))
+		creq2 := c.Request("auth.test.model.login", json.RawMessage(`{"n":2}`))
+
+		// Only the first auth request is sent until responded to
+		req := s.GetRequest(t).
+			AssertSubject(t, "auth.test.model.login").
+			AssertPathPayload(t, "params.n", float64(1))
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+tokenA+`}`))
+		req.RespondSuccess(nil)
+		creq1.GetResponse(t)
+
+		// The second auth request is sent with the token set by the first
+		req = s.GetRequest(t).
+			AssertSubject(t, "auth.test.model.login").
+			AssertPathPayload(t, "params.n", float64(2)).
+			AssertPathPayload(t, "token", json.RawMessage(tokenA))
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+tokenB+`}`))
+		req.RespondSuccess(nil)
+		creq2.GetResponse(t)
+
+		// A single reaccess is made with the final token
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", json.RawMessage(tokenB)).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.AssertNoNATSRequest(t, "test.model")
+	}, withSerializeAuth)
+}
+
+// Test that concurrent auth requests are sent in parallel by default
+func TestSerializeAuth_Default_SendsAuthInParallel(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq1 := c.Request("auth.test.model.login", json.RawMessage(`{"n":1}`))
+		creq2 := c.Request("auth.test.model.login", json.RawMessage(`{"n":2}`))
+		for _, req := range s.GetParallelRequests(t, 2) {
+			req.AssertSubject(t, "auth.test.model.login").RespondSuccess(nil)
+		}
+		creq1.GetResponse(t)
+		creq2.GetResponse(t)
+	})
+}
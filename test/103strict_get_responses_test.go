@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that malformed get responses are rejected with strictGetResponses,
+// logging the reason and responding to the client with an internal error
+func TestStrictGetResponses_MalformedResponse_RespondsInternalError(t *testing.T) {
+	tbl := []struct {
+		GetResponse string
+		Reason      string
+	}{
+		{`{"result":{"collection":["foo"],"models":{"foo":"bar"}}}`, "unexpected fields: result.models"},
+		{`{"result":{"collection":["foo"],"model":null}}`, "model is null"},
+		{`{"result":{"model":{"foo":"bar"},"collection":null}}`, "collection is null"},
+		{`{"result":{"model":{"foo":"bar"},"query":null}}`, "query is null"},
+		{`{"result":{"model":{"foo":"bar"}},"meta":{}}`, "unexpected fields: meta"},
+		{`{"result":{"model":{"foo":"bar"}},"error":{"code":"system.notFound","message":"Not found"}}`, "both result and error are set"},
+		{`{"result":{"model":{"foo":"bar"},"collection":["foo"]}}`, "both model and collection are set"},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.Reason, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondRaw([]byte(l.GetResponse))
+			creq.GetResponse(t).AssertError(t, &reserr.Error{Code: reserr.CodeInternalError, Message: "Internal error: invalid service response"})
+			if log := s.CountLogger.String(); !strings.Contains(log, "Invalid get response - "+l.Reason) {
+				t.Fatalf("expected log to contain reason %#v, but got:\n%s", l.Reason, log)
+			}
+		}, func(cfg *server.Config) {
+			cfg.StrictGetResponses = true
+		})
+	}
+}
+
+// Test that get responses with ignorable malformations are accepted by
+// default
+func TestStrictGetResponses_Default_IgnoresMalformation(t *testing.T) {
+	model := resourceData("test.model")
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondRaw([]byte(`{"result":{"model":` + model + `,"collection":null,"models":{}}}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+	})
+}
+
+// Test that valid get responses are accepted with strictGetResponses
+func TestStrictGetResponses_ValidResponse_Accepted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		s.AssertErrorsLogged(t, 0)
+	}, func(cfg *server.Config) {
+		cfg.StrictGetResponses = true
+	})
+}
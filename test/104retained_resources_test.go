@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withRetainedTestModel(cfg *server.Config) {
+	cfg.RetainedResources = []string{"test.model"}
+}
+
+// Test that a new subscriber to a retained resource without subscribers gets
+// the cached state, updated by events, before the background refresh
+// completes, and that differences from the refresh are sent as events
+func TestRetainedResources_NewSubscriber_GetsRetainedStateBeforeRefresh(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		// Events update the retained resource without subscribers
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"string":"bar","int":42,"bool":true,"null":null}}}`))
+
+		// The background refresh differences are sent as events
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			RespondSuccess(json.RawMessage(`{"model":{"string":"baz","int":42,"bool":true,"null":null}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+	}, withRetainedTestModel)
+}
+
+// Test that subscribing to a retained resource with other subscribers does
+// not refresh the resource
+func TestRetainedResources_WithSubscribers_DoesNotRefresh(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		subscribeToTestModel(t, s, c1)
+
+		c2 := s.Connect()
+		creq := c2.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+		c2.AssertNoNATSRequest(t, "test.model")
+	}, withRetainedTestModel)
+}
+
+// Test that resubscribing to a cached resource not retained does not refresh
+// the resource
+func TestRetainedResources_NotRetained_DoesNotRefresh(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+		c.AssertNoNATSRequest(t, "test.model")
+	})
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withCollectionRange(cfg *server.Config) {
+	cfg.CollectionRange = true
+}
+
+// withRange returns a HTTP request option setting the Range header.
+func withRange(v string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Range", v)
+	}
+}
+
+// Test that a HTTP GET request for a collection with a valid items range gets
+// a partial content response with the slice of the collection
+func TestCollectionRange_ValidRange_RespondsPartialContent(t *testing.T) {
+	tbl := []struct {
+		Range        string
+		Expected     string
+		ContentRange string
+	}{
+		{"items=0-1", `["foo",42]`, "items 0-1/4"},
+		{"items=1-1", `[42]`, "items 1-1/4"},
+		{"items=2-", `[true,null]`, "items 2-3/4"},
+		{"items=1-10", `[42,true,null]`, "items 1-3/4"},
+		{"items=-1", `[null]`, "items 3-3/4"},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.Range, func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/collection", nil, withRange(l.Range))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			hreq.GetResponse(t).
+				Equals(t, http.StatusPartialContent, json.RawMessage(l.Expected)).
+				AssertHeaders(t, map[string]string{"Content-Range": l.ContentRange, "Accept-Ranges": "items"})
+		}, withCollectionRange)
+	}
+}
+
+// Test that references within the range are resolved
+func TestCollectionRange_RangeWithReference_ResolvesReference(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/collection/parent", nil, withRange("items=1-1"))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection.parent").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusPartialContent, json.RawMessage(`[{"href":"/api/test/collection","collection":`+resourceData("test.collection")+`}]`)).
+			AssertHeaders(t, map[string]string{"Content-Range": "items 1-1/2"})
+	}, withCollectionRange)
+}
+
+// Test that a HTTP GET request with a range starting outside of the
+// collection gets a range not satisfiable response
+func TestCollectionRange_OutOfRange_RespondsRangeNotSatisfiable(t *testing.T) {
+	for _, rng := range []string{"items=4-5", "items=10-", "items=-0"} {
+		runNamedTest(t, rng, func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/collection", nil, withRange(rng))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+			hreq.GetResponse(t).
+				AssertStatusCode(t, http.StatusRequestedRangeNotSatisfiable).
+				AssertHeaders(t, map[string]string{"Content-Range": "items */4"})
+		}, withCollectionRange)
+	}
+}
+
+// Test that the Range header is ignored for invalid ranges, models, or
+// when collectionRange is not set
+func TestCollectionRange_IgnoredRange_RespondsFullResource(t *testing.T) {
+	tbl := []struct {
+		Name     string
+		RID      string
+		Range    string
+		Expected string
+		Cfg      func(cfg *server.Config)
+	}{
+		{"other unit", "test.collection", "bytes=0-1", resourceData("test.collection"), withCollectionRange},
+		{"invalid range", "test.collection", "items=2-1", resourceData("test.collection"), withCollectionRange},
+		{"multiple ranges", "test.collection", "items=0-0,2-2", resourceData("test.collection"), withCollectionRange},
+		{"model", "test.model", "items=0-1", resourceData("test.model"), withCollectionRange},
+		{"default", "test.collection", "items=0-1", resourceData("test.collection"), func(cfg *server.Config) {}},
+	}
+
+	for _, l := range tbl {
+		runNamedTest(t, l.Name, func(s *Session) {
+			typ := "collection"
+			if l.RID == "test.model" {
+				typ = "model"
+			}
+			hreq := s.HTTPRequest("GET", "/api/"+l.RID[:4]+"/"+l.RID[5:], nil, withRange(l.Range))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"` + typ + `":` + l.Expected + `}`))
+			hreq.GetResponse(t).
+				Equals(t, http.StatusOK, json.RawMessage(l.Expected)).
+				AssertMissingHeaders(t, []string{"Content-Range"})
+		}, l.Cfg)
+	}
+}
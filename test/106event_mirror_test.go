@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withEventMirror(cfg *server.Config) {
+	cfg.EventMirrorSubject = "resgate.mirror"
+}
+
+// Test that events applied to cached resources are mirrored to the
+// eventMirrorSubject in the order applied
+func TestEventMirror_AppliedEvents_AreMirrored(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar"}`))
+		s.ResourceEvent("test.model", "delete", nil)
+		c.GetEvent(t).Equals(t, "test.model.delete", nil)
+
+		s.GetRequest(t).AssertSubject(t, "resgate.mirror").AssertPayload(t, json.RawMessage(`{"rid":"test.model","event":"change","data":{"values":{"string":"bar"}}}`))
+		s.GetRequest(t).AssertSubject(t, "resgate.mirror").AssertPayload(t, json.RawMessage(`{"rid":"test.model","event":"custom","data":{"foo":"bar"}}`))
+		s.GetRequest(t).AssertSubject(t, "resgate.mirror").AssertPayload(t, json.RawMessage(`{"rid":"test.model","event":"delete"}`))
+	}, withEventMirror)
+}
+
+// Test that events not changing the cached resource are not mirrored
+func TestEventMirror_EventWithoutChange_IsNotMirrored(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"foo"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`))
+
+		s.GetRequest(t).AssertSubject(t, "resgate.mirror").AssertPayload(t, json.RawMessage(`{"rid":"test.model","event":"change","data":{"values":{"int":12}}}`))
+	}, withEventMirror)
+}
+
+// Test that only events for resources matching eventMirrorResources are
+// mirrored
+func TestEventMirror_WithEventMirrorResources_MirrorsMatchingResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToResource(t, s, c, "test.collection")
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"value":"bar","idx":1}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"value":"bar","idx":1}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		s.GetRequest(t).AssertSubject(t, "resgate.mirror").AssertPayload(t, json.RawMessage(`{"rid":"test.model","event":"change","data":{"values":{"string":"bar"}}}`))
+	}, func(cfg *server.Config) {
+		cfg.EventMirrorSubject = "resgate.mirror"
+		cfg.EventMirrorResources = []string{"test.model"}
+	})
+}
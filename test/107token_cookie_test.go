@@ -0,0 +1,275 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withTokenCookie(cfg *server.Config) {
+	cfg.TokenCookie = "session"
+}
+
+// cookieHeader returns an HTTP header with the Cookie header set, or an empty
+// header if the value is empty.
+func cookieHeader(v string) http.Header {
+	if v == "" {
+		return http.Header{}
+	}
+	return http.Header{"Cookie": {v}}
+}
+
+// Test that the token from the token cookie of the WebSocket upgrade request
+// is used as connection token, and that no token is set if the cookie is
+// missing
+func TestTokenCookie_WebSocketConnect_SetsConnectionToken(t *testing.T) {
+	tbl := []struct {
+		Cookie        string // Cookie header value. Empty means no header.
+		ExpectedToken interface{}
+	}{
+		{"session=foo", "foo"},
+		{"other=bar; session=foo", "foo"},
+		{"other=bar", nil},
+		{"session=", nil},
+		{"", nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.ConnectWithHeader(cookieHeader(l.Cookie))
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).
+				AssertSubject(t, "call.test.model.method").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(nil)
+			creq.GetResponse(t)
+		}, withTokenCookie)
+	}
+}
+
+// Test that a token set by a token event replaces the cookie token
+func TestTokenCookie_TokenEvent_ReplacesCookieToken(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(cookieHeader("session=foo"))
+		creq := c.Request("auth.test.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.method").AssertPathPayload(t, "token", "foo")
+		cid := req.PathPayload(t, "cid").(string)
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"bar"}}`))
+		creq = c.Request("auth.test.method", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.method").AssertPathPayload(t, "token", json.RawMessage(`{"user":"bar"}`)).RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, withTokenCookie)
+}
+
+// Test that the token cookie is used as token for HTTP requests, unless a
+// token header is set
+func TestTokenCookie_HTTPRequest_PassesTokenInAccessRequest(t *testing.T) {
+	tbl := []struct {
+		Cookie        string
+		Authorization string
+		ExpectedToken interface{}
+	}{
+		{"session=foo", "", "foo"},
+		{"session=foo", "Bearer bar", "bar"},
+		{"", "", nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(req *http.Request) {
+				if l.Cookie != "" {
+					req.Header.Set("Cookie", l.Cookie)
+				}
+				if l.Authorization != "" {
+					req.Header.Set("Authorization", l.Authorization)
+				}
+			})
+			s.GetRequest(t).AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(json.RawMessage(`{"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").
+				RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+		}, withTokenCookie, withTokenHeader)
+	}
+}
+
+// Test that an invalid JWT in the token cookie is ignored, without logging
+// the cookie value, when a JWT validator is configured
+func TestTokenCookie_WithJWT_IgnoresInvalidToken(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	valid := createJWT(t, jwtTestKey, unixTime(time.Hour))
+	tbl := []struct {
+		Token         string
+		ExpectedToken interface{}
+	}{
+		{valid, valid},
+		{createJWT(t, jwtTestKey, unixTime(-time.Hour)), nil},
+		{createJWT(t, otherKey, unixTime(time.Hour)), nil},
+		{"foo", nil},
+	}
+
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.ConnectWithHeader(cookieHeader("session=" + l.Token))
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(json.RawMessage(`{"get":false}`))
+			creq.GetResponse(t)
+			if l.ExpectedToken == nil && strings.Contains(s.CountLogger.String(), l.Token) {
+				t.Fatalf("expected invalid cookie token not to be logged, but found it in log:\n%s", s.CountLogger.String())
+			}
+		}, withTokenCookie, func(cfg *server.Config) {
+			cfg.JWTPublicKeyFile = keyFile
+		})
+	}
+}
+
+func withCookieHeaderAuth(cfg *server.Config) {
+	cfg.TokenCookie = "session"
+	headerAuth := "test.header.login"
+	cfg.HeaderAuth = &headerAuth
+}
+
+// Test that the headerAuth method is called with the cookie token when a
+// client connects over WebSocket
+func TestTokenCookie_WithHeaderAuth_CallsHeaderAuthOnConnect(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(cookieHeader("session=foo"))
+		req := s.GetRequest(t).
+			AssertSubject(t, "auth.test.header.login").
+			AssertPathPayload(t, "token", "foo")
+		cid := req.PathPayload(t, "cid").(string)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+		req.RespondSuccess(nil)
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", json.RawMessage(`{"user":"foo"}`)).
+			RespondSuccess(json.RawMessage(`{"get":false}`))
+		creq.GetResponse(t)
+	}, withCookieHeaderAuth)
+}
+
+// Test that the headerAuth method is called without a token on WebSocket
+// connect when the token cookie is missing
+func TestTokenCookie_WithHeaderAuthAndMissingCookie_CallsHeaderAuthWithoutToken(t *testing.T) {
+	for i, cookie := range []string{"", "other=bar", "session="} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.ConnectWithHeader(cookieHeader(cookie))
+			s.GetRequest(t).
+				AssertSubject(t, "auth.test.header.login").
+				AssertPathPayload(t, "token", nil).
+				RespondSuccess(nil)
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", nil).
+				RespondSuccess(json.RawMessage(`{"get":false}`))
+			creq.GetResponse(t)
+		}, withCookieHeaderAuth)
+	}
+}
+
+// Test that the headerAuth method is called without a token on WebSocket
+// connect when the cookie token is rejected by the JWT validator
+func TestTokenCookie_WithHeaderAuthAndInvalidJWT_CallsHeaderAuthWithoutToken(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	valid := createJWT(t, jwtTestKey, unixTime(time.Hour))
+	for i, token := range []string{createJWT(t, jwtTestKey, unixTime(-time.Hour)), createJWT(t, otherKey, unixTime(time.Hour)), "foo"} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.ConnectWithHeader(cookieHeader("session=" + token))
+			s.GetRequest(t).
+				AssertSubject(t, "auth.test.header.login").
+				AssertPathPayload(t, "token", nil).
+				RespondSuccess(nil)
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).
+				AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", nil).
+				RespondSuccess(json.RawMessage(`{"get":false}`))
+			creq.GetResponse(t)
+		}, withCookieHeaderAuth, func(cfg *server.Config) {
+			cfg.JWTPublicKeyFile = keyFile
+		})
+	}
+
+	// Validate a valid JWT calls the headerAuth method
+	runTest(t, func(s *Session) {
+		s.ConnectWithHeader(cookieHeader("session=" + valid))
+		s.GetRequest(t).
+			AssertSubject(t, "auth.test.header.login").
+			AssertPathPayload(t, "token", valid).
+			RespondSuccess(nil)
+	}, withCookieHeaderAuth, func(cfg *server.Config) {
+		cfg.JWTPublicKeyFile = keyFile
+	})
+}
+
+// Test that the value of the token cookie is never included in the log output
+// with debug logging enabled
+func TestTokenCookie_WithDebugLogging_DoesNotLogCookieValue(t *testing.T) {
+	l := NewCountLogger(true, false)
+	c := NewNATSTestClient(l)
+	serv, err := server.NewService(c, DefaultConfig(withCookieHeaderAuth))
+	if err != nil {
+		t.Fatalf("error creating new service: %s", err)
+	}
+	serv.SetLogger(l)
+	s := &Session{
+		t:              t,
+		NATSTestClient: c,
+		s:              serv,
+		conns:          make(map[*Conn]struct{}),
+		CountLogger:    l,
+	}
+	if err := serv.Start(); err != nil {
+		t.Fatalf("error starting service: %s", err)
+	}
+
+	conn := s.ConnectWithHeader(cookieHeader("session=secretCookieValue"))
+	s.GetRequest(t).
+		AssertSubject(t, "auth.test.header.login").
+		AssertPathPayload(t, "token", "secretCookieValue").
+		RespondError(reserr.ErrAccessDenied)
+	creq := conn.Request("call.test.model.method", nil)
+	s.GetRequest(t).
+		AssertSubject(t, "access.test.model").
+		AssertPathPayload(t, "token", "secretCookieValue").
+		RespondSuccess(json.RawMessage(`{"get":false}`))
+	creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	teardown(s)
+
+	out := l.String()
+	if !strings.Contains(out, "[DBG]") {
+		t.Fatal("expected debug entries to be logged, but found none")
+	}
+	if strings.Contains(out, "secretCookieValue") {
+		t.Errorf("expected cookie value not to be logged, but found it in log output:\n%s", out)
+	}
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a slow reference of a HTTP GET request is encoded with a timeout
+// error once the referenceTimeout expires, while the rest of the resource is
+// returned without waiting for the overall request timeout
+func TestReferenceTimeout_SlowChild_ReturnsTimeoutError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		// Leave the child get request unanswered
+		s.GetRequest(t).AssertSubject(t, "get.test.model")
+
+		start := time.Now()
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"parent","child":{"href":"/api/test/model","error":{"code":"system.timeout","message":"Reference timeout"}}}`))
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("expected response within the referenceTimeout, but got it after %s", d)
+		}
+	}, func(cfg *server.Config) {
+		cfg.ReferenceTimeout = 50
+	})
+}
+
+// Test that references loaded within the referenceTimeout are encoded as
+// usual
+func TestReferenceTimeout_FastChild_ReturnsChild(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"parent","child":{"href":"/api/test/model","model":`+resourceData("test.model")+`}}`))
+	}, func(cfg *server.Config) {
+		cfg.ReferenceTimeout = 1000
+	})
+}
+
+// Test that a child responding after the referenceTimeout does not affect
+// the already sent response
+func TestReferenceTimeout_LateChild_IsIgnored(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		req := s.GetRequest(t).AssertSubject(t, "get.test.model")
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"parent","child":{"href":"/api/test/model","error":{"code":"system.timeout","message":"Reference timeout"}}}`))
+
+		req.RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		// The child is cached and served on the next request
+		hreq = s.HTTPRequest("GET", "/api/test/model/parent", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"parent","child":{"href":"/api/test/model","model":`+resourceData("test.model")+`}}`))
+	}, func(cfg *server.Config) {
+		cfg.ReferenceTimeout = 50
+	})
+}
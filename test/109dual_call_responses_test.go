@@ -0,0 +1,44 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const dualCallResponse = `{"result":{"foo":"bar"},"resource":{"rid":"test.model"}}`
+
+// Test that a call response with both a result and a resource results in an
+// internal error by default, logging the response
+func TestDualCallResponses_Default_ReturnsInternalError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.collection.create", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.collection.create").RespondRaw([]byte(dualCallResponse))
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeInternalError)
+		c.AssertNoNATSRequest(t, "test.model")
+		s.AssertErrorsLogged(t, 1)
+	})
+}
+
+// Test that a call response with both a result and a resource uses the
+// resource when dualCallResponses is set to resource, logging a warning
+func TestDualCallResponses_Resource_ReturnsResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("call.test.collection.create", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.collection.create").RespondRaw([]byte(dualCallResponse))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"rid":"test.model","models":{"test.model":`+model+`}}`))
+		s.AssertErrorsLogged(t, 1)
+	}, func(cfg *server.Config) {
+		cfg.DualCallResponses = server.DualCallResponsesResource
+	})
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a summary of the activity of a closed WebSocket connection is
+// published to the connectionSummarySubject
+func TestConnectionSummary_Disconnect_PublishesSummary(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		cid := getCID(t, s, c)
+		c.Disconnect()
+
+		req := s.GetRequest(t).AssertSubject(t, "resgate.connection.summary")
+		var summary map[string]interface{}
+		if err := json.Unmarshal(req.RawPayload, &summary); err != nil {
+			t.Fatalf("error unmarshaling summary: %s", err)
+		}
+		for _, k := range []string{"cid", "duration", "requests", "bytesReceived", "bytesSent", "subscriptions"} {
+			if _, ok := summary[k]; !ok {
+				t.Errorf("expected summary to contain %#v, but got: %s", k, req.RawPayload)
+			}
+		}
+		req.AssertPathPayload(t, "cid", cid)
+		// Version, subscribe, and auth requests
+		req.AssertPathPayload(t, "requests", 3)
+		req.AssertPathPayload(t, "subscriptions", 1)
+		for _, k := range []string{"bytesReceived", "bytesSent"} {
+			if n, _ := summary[k].(float64); n <= 0 {
+				t.Errorf("expected %s to be greater than zero, but got: %s", k, req.RawPayload)
+			}
+		}
+		if d, _ := summary["duration"].(float64); d < 0 {
+			t.Errorf("expected duration to be zero or positive, but got: %s", req.RawPayload)
+		}
+	}, func(cfg *server.Config) {
+		cfg.ConnectionSummarySubject = "resgate.connection.summary"
+	})
+}
+
+// Test that no connection summary is published by default
+func TestConnectionSummary_Default_PublishesNoSummary(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.Disconnect()
+		assertDisconnectLogged(t, s, `Disconnected: `)
+
+		c2 := s.Connect()
+		c2.AssertNoNATSRequest(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.DisconnectLog = server.DisconnectLogInfo
+	})
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withForwardPreconditions(cfg *server.Config) {
+	method := "update"
+	cfg.PATCHMethod = &method
+	cfg.DELETEMethod = &method
+	cfg.ForwardPreconditions = true
+}
+
+// ifMatch returns a HTTP request option setting the If-Match header.
+func ifMatch(v string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("If-Match", v)
+	}
+}
+
+// Test that a matching If-Match precondition is forwarded in the call
+// request, and the call result is returned
+func TestPreconditions_MatchingIfMatch_ReturnsResult(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PATCH", "/api/test/model", json.RawMessage(`{"foo":"bar"}`), ifMatch(`"v1"`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.update").
+			AssertPathPayload(t, "ifMatch", `"v1"`).
+			RespondSuccess(json.RawMessage(`{"version":"v2"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"version":"v2"}`))
+	}, withForwardPreconditions)
+}
+
+// Test that a system.preconditionFailed error from the service, for a non
+// matching If-Match precondition, results in a 412 Precondition Failed
+// response
+func TestPreconditions_NonMatchingIfMatch_ReturnsPreconditionFailed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("DELETE", "/api/test/model", nil, ifMatch(`"v0"`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.update").
+			AssertPathPayload(t, "ifMatch", `"v0"`).
+			RespondError(reserr.ErrPreconditionFailed)
+		hreq.GetResponse(t).Equals(t, http.StatusPreconditionFailed, reserr.ErrPreconditionFailed)
+	}, withForwardPreconditions)
+}
+
+// Test that the If-None-Match header is forwarded in the call request
+func TestPreconditions_IfNoneMatch_IsForwarded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PATCH", "/api/test/model", nil, func(r *http.Request) {
+			r.Header.Set("If-None-Match", "*")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.update").
+			AssertPathPayload(t, "ifNoneMatch", "*").
+			RespondSuccess(nil)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+	}, withForwardPreconditions)
+}
+
+// Test that preconditions are not forwarded by default, or for POST requests
+func TestPreconditions_DisabledOrPOST_ForwardsNoPrecondition(t *testing.T) {
+	tbl := []struct {
+		Name   string
+		Method string
+		Path   string
+		Cfg    func(cfg *server.Config)
+	}{
+		{"default", "PATCH", "/api/test/model", func(cfg *server.Config) {
+			method := "update"
+			cfg.PATCHMethod = &method
+		}},
+		{"post", "POST", "/api/test/model/update", withForwardPreconditions},
+	}
+	for _, l := range tbl {
+		runNamedTest(t, l.Name, func(s *Session) {
+			hreq := s.HTTPRequest(l.Method, l.Path, nil, ifMatch(`"v1"`))
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			req := s.GetRequest(t).AssertSubject(t, "call.test.model.update")
+			if _, ok := req.Payload.(map[string]interface{})["ifMatch"]; ok {
+				t.Fatalf("expected no ifMatch, but got payload: %s", req.RawPayload)
+			}
+			req.RespondSuccess(nil)
+			hreq.GetResponse(t)
+		}, l.Cfg)
+	}
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that equivalent queries on resources matching normalizeQuery share
+// one cached resource and upstream get request, and that the normalized
+// query is forwarded to the service
+func TestNormalizeQuery_EquivalentQueries_ShareSubscription(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model?b=2&a=1", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "query", "a=1&b=2").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "a=1&b=2").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"a=1&b=2"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?b=2&a=1":`+model+`}}`))
+
+		// Equivalent query is served from the cache
+		creq = c.Request("subscribe.test.model?a=1&b=2&a=1", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "query", "a=1&b=2").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?a=1&b=2&a=1":`+model+`}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.NormalizeQuery = []string{"test.>"}
+	})
+}
+
+// Test that queries are forwarded as is for resources not matching
+// normalizeQuery
+func TestNormalizeQuery_NonMatchingResource_ForwardsQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model?b=2&a=1", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "query", "b=2&a=1").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "b=2&a=1").
+			RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `,"query":"a=1&b=2"}`))
+		creq.GetResponse(t)
+	}, func(cfg *server.Config) {
+		cfg.NormalizeQuery = []string{"test.other"}
+	})
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a HTTP POST body with trailing data after the JSON params is
+// rejected with system.invalidParams by default
+func TestTrailingBodyData_Default_RejectsTrailingData(t *testing.T) {
+	tbl := []string{
+		`{"foo":"bar"}garbage`,
+		`{"foo":"bar"} {"foo":"baz"}`,
+		`{"foo":"bar"}}`,
+		`42 43`,
+	}
+	for i, body := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(body))
+			hreq.GetResponse(t).Equals(t, http.StatusBadRequest, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that trailing whitespace after the JSON params is accepted
+func TestTrailingBodyData_TrailingWhitespace_IsAccepted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte("{\"foo\":\"bar\"} \r\n\t"))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "params", json.RawMessage(`{"foo":"bar"}`)).
+			RespondSuccess(nil)
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+	})
+}
+
+// Test that trailing data after the JSON params is ignored when
+// trailingBodyData is set to ignore
+func TestTrailingBodyData_Ignore_UsesParams(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(`{"foo":"bar"}garbage`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "params", json.RawMessage(`{"foo":"bar"}`)).
+			RespondSuccess(json.RawMessage(`{"zoo":"baz"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"zoo":"baz"}`))
+	}, func(cfg *server.Config) {
+		cfg.TrailingBodyData = server.TrailingBodyDataIgnore
+	})
+}
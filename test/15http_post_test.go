@@ -311,3 +311,79 @@ func TestHTTPPost_AllowOrigin_ExpectedResponse(t *testing.T) {
 		})
 	}
 }
+
+// Test HTTP POST request with a body exceeding max params depth returns invalid params error
+func TestHTTPPost_WithBodyExceedingMaxParamsDepth_ReturnsInvalidParams(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(`{"foo":[{"bar":[1]}]}`))
+
+		hresp := hreq.GetResponse(t)
+		hresp.AssertStatusCode(t, http.StatusBadRequest)
+		hresp.AssertError(t, reserr.ErrInvalidParams)
+	}, func(cfg *server.Config) {
+		cfg.MaxParamsDepth = 3
+	})
+}
+
+// Test HTTP POST request with return=representation responds with the state of the called resource
+func TestHTTPPost_WithReturnRepresentation_RespondsWithResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		hreq := s.HTTPRequest("POST", "/api/test/model/method?q=foo&return=representation", nil)
+
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "query", "q=foo").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"method"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "query", "q=foo").
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			AssertPathPayload(t, "query", "q=foo").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=foo"}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+	})
+}
+
+// Test HTTP POST request with return=representation, without get access, responds with the call result
+func TestHTTPPost_WithReturnRepresentationWithoutGetAccess_RespondsWithCallResult(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method?return=representation", nil)
+
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"call":"method"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	})
+}
+
+// Test HTTP POST request with return=representation responds with the state of a resource in a resource response
+func TestHTTPPost_WithReturnRepresentationOnResourceResponse_RespondsWithReferencedResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		hreq := s.HTTPRequest("POST", "/api/test/collection/add?return=representation", nil)
+
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.collection").
+			RespondSuccess(json.RawMessage(`{"call":"add"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.collection.add").
+			RespondRaw([]byte(`{"resource":{"rid":"test.model"}}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(model)).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model"})
+	})
+}
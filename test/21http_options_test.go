@@ -42,3 +42,46 @@ func TestHTTPOptions_AllowOrigin_ExpectedResponseHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPOptions_OptionsAllow_ExpectedResponse(t *testing.T) {
+	method := "method"
+	tbl := []struct {
+		OptionsAllow           bool                 // OptionsAllow config
+		Config                 func(*server.Config) // Additional config
+		ExpectedCode           int                  // Expected response status code
+		ExpectedHeaders        map[string]string    // Expected response Headers
+		ExpectedMissingHeaders []string             // Expected response headers not to be included
+	}{
+		{false, nil, http.StatusOK, nil, []string{"Allow"}},
+		{true, nil, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST"}, nil},
+		{true, func(cfg *server.Config) { cfg.PUTMethod = &method }, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST, PUT"}, nil},
+		{true, func(cfg *server.Config) { cfg.DELETEMethod = &method }, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST, DELETE"}, nil},
+		{true, func(cfg *server.Config) { cfg.PATCHMethod = &method }, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST, PATCH"}, nil},
+		{true, func(cfg *server.Config) {
+			cfg.PUTMethod = &method
+			cfg.DELETEMethod = &method
+			cfg.PATCHMethod = &method
+		}, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST, PUT, DELETE, PATCH"}, nil},
+		// Allow header is not affected by allowMethods
+		{true, func(cfg *server.Config) { cfg.AllowMethods = []string{"GET"} }, http.StatusNoContent, map[string]string{"Allow": "GET, HEAD, OPTIONS, POST", "Access-Control-Allow-Methods": "GET"}, nil},
+		// CORS headers are still included
+		{true, nil, http.StatusNoContent, map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST"}, nil},
+	}
+
+	for i, l := range tbl {
+		l := l
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("OPTIONS", "/api/test/model", nil)
+			// Validate http response
+			hreq.GetResponse(t).
+				Equals(t, l.ExpectedCode, nil).
+				AssertHeaders(t, l.ExpectedHeaders).
+				AssertMissingHeaders(t, l.ExpectedMissingHeaders)
+		}, func(cfg *server.Config) {
+			cfg.OptionsAllow = l.OptionsAllow
+			if l.Config != nil {
+				l.Config(cfg)
+			}
+		})
+	}
+}
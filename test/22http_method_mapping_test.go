@@ -82,3 +82,41 @@ func TestHTTPMethod_MappedMethod_ExpectedResponse(t *testing.T) {
 		}, l.Config)
 	}
 }
+
+func TestHTTPMethod_MappedPOSTMethod_CallsMethodOnPathResource(t *testing.T) {
+	params := json.RawMessage(`{"foo":"bar"}`)
+	result := json.RawMessage(`"zoo"`)
+	method := "new"
+
+	tbl := []struct {
+		Path             string   // Path of the POST request
+		Resources        []string // postMethodResources setting
+		ExpectedResource string   // Expected resource of the call request
+		ExpectedMethod   string   // Expected method of the call request
+	}{
+		{"/api/test/collection", []string{"test.collection"}, "test.collection", "new"},
+		{"/api/test/collection?q=foo", []string{"test.*"}, "test.collection", "new"},
+		{"/api/test/collection/method", []string{"test.collection"}, "test.collection", "method"},
+		{"/api/test/model", []string{"test.collection"}, "test", "model"},
+	}
+
+	for i, l := range tbl {
+		l := l
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", l.Path, params)
+
+			s.GetRequest(t).
+				AssertSubject(t, "access."+l.ExpectedResource).
+				RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			s.GetRequest(t).
+				AssertSubject(t, "call."+l.ExpectedResource+"."+l.ExpectedMethod).
+				AssertPathPayload(t, "params", params).
+				RespondSuccess(result)
+
+			hreq.GetResponse(t).Equals(t, http.StatusOK, result)
+		}, func(cfg *server.Config) {
+			cfg.POSTMethod = &method
+			cfg.POSTMethodResources = l.Resources
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withCollectionDiffWindow(cfg *server.Config) {
+	cfg.CollectionDiffWindow = 50
+}
+
+// Test that a burst of add and remove events results in a single diff event
+func TestCollectionDiff_AddAndRemoveEvents_SendsSingleDiffEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":2}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":4,"value":"baz"}`))
+		c.GetEvent(t).Equals(t, "test.collection.diff", json.RawMessage(`{"splices":[{"idx":1,"remove":1},{"idx":0,"values":["bar"]},{"idx":4,"values":["baz"]}]}`))
+		c.AssertNoEvent(t, "test.collection")
+
+		// Validate the collection is updated in the cache
+		c2 := s.Connect()
+		creq := c2.Request("subscribe.test.collection", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":["bar","foo",true,null,"baz"]}}`))
+	}, withCollectionDiffWindow)
+}
+
+// Test that add and remove events that cancel each other out results in no event
+func TestCollectionDiff_AddAndRemoveSameValue_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":1,"value":"bar"}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":1}`))
+		c.AssertNoEvent(t, "test.collection")
+	}, withCollectionDiffWindow)
+}
+
+// Test that a diff event includes any added resource references
+func TestCollectionDiff_AddReference_IncludesResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":{"rid":"test.model"}}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resources["test.model"].data + `}`))
+		c.GetEvent(t).Equals(t, "test.collection.diff", json.RawMessage(`{"splices":[{"idx":0,"values":[{"rid":"test.model"}]}],"models":{"test.model":`+resources["test.model"].data+`}}`))
+	}, withCollectionDiffWindow)
+}
+
+// Test that other events flush any pending diff before being sent
+func TestCollectionDiff_CustomEvent_FlushesPendingDiff(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		s.ResourceEvent("test.collection", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.collection.diff", json.RawMessage(`{"splices":[{"idx":0,"values":["bar"]}]}`))
+		c.GetEvent(t).Equals(t, "test.collection.custom", json.RawMessage(`{"foo":"bar"}`))
+	}, withCollectionDiffWindow)
+}
+
+// Test that legacy clients get add and remove events even with diffs enabled
+func TestCollectionDiff_LegacyClient_SendsAddAndRemoveEvents(t *testing.T) {
+	for _, version := range []string{"1.2.0", "1.2.1"} {
+		runNamedTest(t, version, func(s *Session) {
+			c := s.ConnectWithVersion(version)
+			subscribeToTestCollection(t, s, c)
+
+			s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+			s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":2}`))
+			c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+			c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":2}`))
+		}, withCollectionDiffWindow)
+	}
+}
+
+// Test that a client setting protocol version 1.2.2 opts in to diff events
+func TestCollectionDiff_ClientVersion122_SendsDiffEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithVersion("1.2.2")
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":2}`))
+		c.GetEvent(t).Equals(t, "test.collection.diff", json.RawMessage(`{"splices":[{"idx":1,"remove":1},{"idx":0,"values":["bar"]}]}`))
+		c.AssertNoEvent(t, "test.collection")
+	}, withCollectionDiffWindow)
+}
+
+// Test that a duplicate subscribe within a pending diff window responds with
+// the sent state, followed by the diff event
+func TestCollectionDiff_DuplicateSubscribeDuringDiffWindow_RespondsWithSentState(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"bar"}`))
+		c.Request("subscribe.test.collection", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":["foo",42,true,null]}}`))
+		c.GetEvent(t).Equals(t, "test.collection.diff", json.RawMessage(`{"splices":[{"idx":0,"values":["bar"]}]}`))
+		c.AssertNoEvent(t, "test.collection")
+	}, withCollectionDiffWindow)
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withSharedTestAccess(cfg *server.Config) {
+	cfg.SharedAccess = map[string]string{"test.>": "test.access"}
+}
+
+// Test subscribing to multiple resources with shared access sends a single access request
+func TestSubscribeMultiple_WithSharedAccess_SendsSingleAccessRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		collection := resourceData("test.collection")
+
+		c := s.Connect()
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model","test.collection"]}`))
+
+		mreqs := s.GetParallelRequests(t, 3)
+		mreqs.GetRequest(t, "access.test.access").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + collection + `}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`},"collections":{"test.collection":`+collection+`}}`))
+
+		// Validate both resources are subscribed
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar"}`))
+		s.ResourceEvent("test.collection", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.collection.custom", json.RawMessage(`{"foo":"bar"}`))
+	}, withSharedTestAccess)
+}
+
+// Test subscribing to multiple resources with shared access denied subscribes to none
+func TestSubscribeMultiple_WithSharedAccessDenied_SubscribesToNone(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model","test.collection"]}`))
+
+		mreqs := s.GetParallelRequests(t, 3)
+		mreqs.GetRequest(t, "access.test.access").RespondSuccess(json.RawMessage(`{"get":false}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+
+		// Validate the resources are not subscribed
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.AssertNoEvent(t, "test.model")
+	}, withSharedTestAccess)
+}
+
+// Test subscribing to multiple resources where one resource fails to load
+func TestSubscribeMultiple_WithGetError_RespondsWithErrorResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		c := s.Connect()
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model","test.err.notFound"]}`))
+
+		mreqs := s.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "access.test.err.notFound").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "get.test.err.notFound").RespondError(reserr.ErrNotFound)
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`},"errors":{"test.err.notFound":{"code":"system.notFound","message":"Not found"}}}`))
+
+		// Validate only the loaded resource is subscribed
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertResult(t, nil)
+		c.Request("unsubscribe.test.err.notFound", nil).GetResponse(t).AssertError(t, reserr.ErrNoSubscription)
+	})
+}
+
+// Test subscribing to multiple resources with invalid params
+func TestSubscribeMultiple_WithInvalidParams_ReturnsInvalidParams(t *testing.T) {
+	tbl := []string{
+		`null`,
+		`{}`,
+		`{"rids":[]}`,
+		`{"rids":["test..model"]}`,
+		`{"rids":"test.model"}`,
+	}
+
+	for _, params := range tbl {
+		runNamedTest(t, params, func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe", json.RawMessage(params)).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
+
+// Test that shared access is only used by multiple subscribe requests, and
+// not by subscribe or call requests for a single resource
+func TestSubscribeMultiple_WithSharedAccess_SingleRequestsUseResourceAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+
+		creq = c.Request("call.test.collection.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withSharedTestAccess)
+}
+
+// Test that the shared access request of a multiple subscribe request
+// includes the query of the resources, and that resources with different
+// queries get separate access requests
+func TestSubscribeMultiple_WithSharedAccessAndQuery_PassesQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model?q=foo","test.model.parent?q=foo","test.model?q=bar"]}`))
+
+		var access []*Request
+		for _, req := range s.GetParallelRequests(t, 5) {
+			switch req.Subject {
+			case "access.test.access":
+				access = append(access, req)
+			case "get.test.model":
+				q := req.PathPayload(t, "query").(string)
+				req.RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"` + q + `"}`))
+			case "get.test.model.parent":
+				req.RespondSuccess(json.RawMessage(`{"model":{},"query":"q=foo"}`))
+			default:
+				t.Fatalf("unexpected request %s", req.Subject)
+			}
+		}
+		if len(access) != 2 {
+			t.Fatalf("expected 2 shared access requests, but got %d", len(access))
+		}
+		for _, req := range access {
+			q := req.PathPayload(t, "query").(string)
+			if q != "q=foo" && q != "q=bar" {
+				t.Fatalf("expected shared access query to be q=foo or q=bar, but got %#v", q)
+			}
+			req.RespondSuccess(json.RawMessage(`{"get":` + strconv.FormatBool(q == "q=foo") + `}`))
+		}
+
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withSharedTestAccess)
+}
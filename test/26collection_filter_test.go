@@ -0,0 +1,167 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withCollectionFilter(cfg *server.Config) {
+	cfg.CollectionFilter = true
+}
+
+// Test subscribing to a filtered collection of data values, and that add and
+// remove events are translated to the filtered collection
+func TestCollectionFilter_DataValues_FiltersItemsAndEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		rid := "test.collection?filter=role:admin"
+		c := s.Connect()
+		creq := c.Request("subscribe."+rid, nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":[{"data":{"role":"admin","id":1}},{"data":{"role":"user","id":2}},"foo"]}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"`+rid+`":[{"data":{"role":"admin","id":1}}]}}`))
+
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":1,"value":{"data":{"role":"admin","id":3}}}`))
+		c.GetEvent(t).Equals(t, rid+".add", json.RawMessage(`{"idx":1,"value":{"data":{"role":"admin","id":3}}}`))
+
+		// Events on non-matching items are discarded
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":{"data":{"role":"user","id":4}}}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":2}`))
+		c.GetEvent(t).Equals(t, rid+".remove", json.RawMessage(`{"idx":1}`))
+		s.ResourceEvent("test.collection", "remove", json.RawMessage(`{"idx":0}`))
+		s.ResourceEvent("test.collection", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, rid+".custom", json.RawMessage(`{"foo":"bar"}`))
+	}, withCollectionFilter)
+}
+
+// Test subscribing to a filtered collection of references only includes
+// matching referenced resources
+func TestCollectionFilter_References_IncludesMatchingResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		rid := "test.collection?filter=string:foo"
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe."+rid, nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":[{"rid":"test.model.parent"},{"rid":"test.model"}]}`))
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"`+rid+`":[{"rid":"test.model"}]},"models":{"test.model":`+model+`}}`))
+
+		// Add a matching reference to an unsent resource
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":{"rid":"test.model.soft"}}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model.soft").RespondSuccess(json.RawMessage(`{"model":{"string":"foo"}}`))
+		c.GetEvent(t).Equals(t, rid+".add", json.RawMessage(`{"idx":0,"value":{"rid":"test.model.soft"},"models":{"test.model.soft":{"string":"foo"}}}`))
+	}, withCollectionFilter)
+}
+
+// Test subscribing to a model with a filter returns an invalid query error
+func TestCollectionFilter_OnModel_ReturnsInvalidQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model?filter=string:foo", nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+
+		creq.GetResponse(t).AssertErrorCode(t, "system.invalidQuery")
+	}, withCollectionFilter)
+}
+
+// Test that the filter query is passed on to the service when collection
+// filters are disabled
+func TestCollectionFilter_Disabled_PassesQueryToService(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.collection?filter=role:admin", nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").
+			AssertPathPayload(t, "query", "filter=role:admin").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").
+			AssertPathPayload(t, "query", "filter=role:admin").
+			RespondSuccess(json.RawMessage(`{"collection":[],"query":"filter=role:admin"}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection?filter=role:admin":[]}}`))
+	})
+}
+
+// Test that a change event on a referenced model updates whether it matches
+// the filter, sending add and remove events on the filtered collection
+func TestCollectionFilter_ReferencedModelChange_UpdatesMatches(t *testing.T) {
+	runTest(t, func(s *Session) {
+		rid := "test.collection?filter=string:foo"
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe."+rid, nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":[{"rid":"test.model.parent"},{"rid":"test.model"}]}`))
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"`+rid+`":[{"rid":"test.model"}]},"models":{"test.model":`+model+`}}`))
+
+		// A change on a non-matching model making it match adds the item,
+		// including the changed model
+		s.ResourceEvent("test.model.parent", "change", json.RawMessage(`{"values":{"string":"foo"}}`))
+		c.GetEvent(t).Equals(t, rid+".add", json.RawMessage(`{"idx":0,"value":{"rid":"test.model.parent"},"models":{"test.model.parent":{"name":"parent","child":{"rid":"test.model"},"string":"foo"}}}`))
+
+		// A change on a matching model making it no longer match removes the item
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, rid+".remove", json.RawMessage(`{"idx":1}`))
+
+		// A change not affecting the match sends no collection event
+		s.ResourceEvent("test.model.parent", "change", json.RawMessage(`{"values":{"name":"changed"}}`))
+		c.GetEvent(t).Equals(t, "test.model.parent.change", json.RawMessage(`{"values":{"name":"changed"}}`))
+		c.AssertNoEvent(t, "test.collection")
+
+		// Validate the filtered collection is updated for a new subscription
+		c2 := s.Connect()
+		creq = c2.Request("subscribe."+rid, nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"`+rid+`":[{"rid":"test.model.parent"}]},"models":{"test.model.parent":{"name":"changed","child":{"rid":"test.model"},"string":"foo"},"test.model":{"string":"bar","int":42,"bool":true,"null":null}}}`))
+	}, withCollectionFilter)
+}
+
+// Test that changes on a model excluded by the filter are applied without
+// being queued, so that the item, once matching, is added with the latest
+// state and without any stale change events
+func TestCollectionFilter_ExcludedModelChanges_AddsLatestStateOnMatch(t *testing.T) {
+	runTest(t, func(s *Session) {
+		rid := "test.collection?filter=string:bar"
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe."+rid, nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":[{"rid":"test.model"}]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"`+rid+`":[]}}`))
+
+		for i := 0; i < 10; i++ {
+			s.ResourceEvent("test.model", "change", json.RawMessage(fmt.Sprintf(`{"values":{"int":%d}}`, i)))
+		}
+		c.AssertNoEvent(t, "test.model")
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, rid+".add", json.RawMessage(`{"idx":0,"value":{"rid":"test.model"},"models":{"test.model":{"string":"bar","int":9,"bool":true,"null":null}}}`))
+		c.AssertNoEvent(t, "test.model")
+		c.AssertNoEvent(t, "test.collection")
+	}, withCollectionFilter)
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withInjectQuery(cfg *server.Config) {
+	cfg.InjectQuery = map[string]string{"test.>": "tenant={token.org.id}"}
+}
+
+// Test that an injected query with token field substitution reaches the service
+// on access and get requests, and that it cannot be overridden by the client
+func TestInjectQuery_WithToken_InjectsQueryOnAccessAndGet(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":"acme co"}}}`))
+
+		creq := c.Request("subscribe.test.model?q=foo&tenant=other", nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "query", "q=foo&tenant=acme+co").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "q=foo&tenant=acme+co").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo&tenant=other":`+model+`}}`))
+	}, withInjectQuery)
+}
+
+// Test that an injected query is sent on call requests
+func TestInjectQuery_OnCall_InjectsQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":42}}}`))
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "query", "tenant=42").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "query", "tenant=42").
+			RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, withInjectQuery)
+}
+
+// Test that a subscribe without token is denied access without any access or
+// get request being sent
+func TestInjectQuery_WithoutToken_DeniesAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("subscribe.test.model", nil).
+			GetResponse(t).
+			AssertError(t, reserr.ErrAccessDenied)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, withInjectQuery)
+}
+
+// Test that a subscribe and call with a token missing the placeholder field
+// is denied access without any request being sent
+func TestInjectQuery_WithTokenMissingField_DeniesAccess(t *testing.T) {
+	for _, token := range []string{`{"user":"foo"}`, `{"org":{"name":"acme"}}`, `{"org":{"id":null}}`, `{"org":{"id":{"a":1}}}`} {
+		runNamedTest(t, token, func(s *Session) {
+			c := s.Connect()
+			cid := getCID(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+
+			c.Request("subscribe.test.model", nil).
+				GetResponse(t).
+				AssertError(t, reserr.ErrAccessDenied)
+			c.Request("call.test.model.method", nil).
+				GetResponse(t).
+				AssertError(t, reserr.ErrAccessDenied)
+			c.AssertNoNATSRequest(t, "test.model")
+		}, withInjectQuery)
+	}
+}
+
+// Test that a resource referenced by a model is not loaded with a token
+// missing the placeholder field, and is included as an access denied error
+func TestInjectQuery_ReferenceWithTokenMissingField_ReturnsError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"user":"foo"}}`))
+
+		creq := c.Request("subscribe.other.parent", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.other.parent").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.other.parent").
+			RespondSuccess(json.RawMessage(`{"model":{"ref":{"rid":"test.model"}}}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"other.parent":{"ref":{"rid":"test.model"}}},"errors":{"test.model":{"code":"system.accessDenied","message":"Access denied"}}}`))
+		c.AssertNoNATSRequest(t, "test.model")
+	}, withInjectQuery)
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that HTTP requests are rejected while service request latency exceeds
+// the load shed latency, and accepted again once latency recovers
+func TestLoadShedding_WithHighLatency_RejectsRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+
+		// Respond slowly to requests
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		time.Sleep(30 * time.Millisecond)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		s.AssertMetric(t, "resgate_load_shedding", "0")
+
+		// Wait for the window to pass
+		time.Sleep(60 * time.Millisecond)
+		hresp := s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t)
+		hresp.AssertStatusCode(t, http.StatusServiceUnavailable)
+		hresp.AssertError(t, reserr.ErrServiceUnavailable.WithRetryAfter(50*time.Millisecond))
+		hresp.AssertHeaders(t, map[string]string{"Retry-After": "1"})
+		s.AssertMetric(t, "resgate_load_shed_total", "1")
+		s.AssertMetric(t, "resgate_load_shedding", "1")
+
+		// Wait for a window without slow requests to pass
+		time.Sleep(60 * time.Millisecond)
+		hreq = s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		s.AssertMetric(t, "resgate_load_shed_total", "1")
+		s.AssertMetric(t, "resgate_load_shedding", "0")
+	}, func(cfg *server.Config) {
+		cfg.LoadShedLatency = 10
+		cfg.LoadShedWindow = 50
+		cfg.LoadShedFraction = 1
+	})
+}
+
+// Test that get, subscribe, and call requests on an existing WebSocket
+// connection are rejected while load shedding, but not auth and unsubscribe
+// requests
+func TestLoadShedding_WithHighLatency_RejectsWebSocketRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		// Respond slowly to a request
+		creq := c.Request("call.test.model.method", nil)
+		time.Sleep(30 * time.Millisecond)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		// Wait for the window to pass
+		time.Sleep(60 * time.Millisecond)
+		for _, method := range []string{"get.test.model", "subscribe.test.model", "call.test.model.method", "new.test.collection"} {
+			c.Request(method, nil).GetResponse(t).AssertErrorCode(t, reserr.CodeServiceUnavailable)
+		}
+		c.Request("subscribe", json.RawMessage(`{"rids":["test.model"]}`)).GetResponse(t).AssertErrorCode(t, reserr.CodeServiceUnavailable)
+		s.AssertMetric(t, "resgate_load_shed_total", "5")
+
+		creq = c.Request("auth.test.model.login", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.login").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		c.Request("unsubscribe.test.model", nil).GetResponse(t).AssertErrorCode(t, reserr.CodeNoSubscription)
+		s.AssertMetric(t, "resgate_load_shed_total", "5")
+	}, func(cfg *server.Config) {
+		cfg.LoadShedLatency = 10
+		cfg.LoadShedWindow = 50
+		cfg.LoadShedFraction = 1
+	})
+}
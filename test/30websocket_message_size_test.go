@@ -0,0 +1,35 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a client request within the message size limit is handled
+func TestWebSocketMessageSize_WithinLimit_HandlesRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", strings.Repeat("a", 32))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, func(cfg *server.Config) {
+		cfg.WSMaxMessageSize = 128
+	})
+}
+
+// Test that a client sending a message exceeding the message size limit is
+// disconnected with a policy violation close code
+func TestWebSocketMessageSize_ExceedingLimit_ClosesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Request("call.test.model.method", strings.Repeat("a", 128))
+		c.AssertClosedWithCode(t, websocket.ClosePolicyViolation)
+	}, func(cfg *server.Config) {
+		cfg.WSMaxMessageSize = 128
+	})
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+var forwardHeadersConfig = func(cfg *server.Config) {
+	cfg.ForwardHeaders = []string{"accept-language", "X-Correlation-ID"}
+}
+
+// Test that configured headers of a HTTP POST request are forwarded in the
+// access and call requests, while other headers are not
+func TestForwardHeaders_OnHTTPPost_ForwardsConfiguredHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		expected := map[string][]string{
+			"Accept-Language":  {"sv-SE"},
+			"X-Correlation-Id": {"abc123"},
+		}
+
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("Accept-Language", "sv-SE")
+			r.Header.Set("X-Correlation-ID", "abc123")
+			r.Header.Set("Authorization", "Bearer secret")
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "header", expected).
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "header", expected).
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, forwardHeadersConfig)
+}
+
+// Test that configured headers of a WebSocket connection request are
+// forwarded in the access and call requests
+func TestForwardHeaders_OnWebSocket_ForwardsHeadersCapturedOnConnect(t *testing.T) {
+	runTest(t, func(s *Session) {
+		expected := map[string][]string{
+			"Accept-Language": {"sv-SE"},
+		}
+
+		c := s.ConnectWithHeader(http.Header{
+			"Accept-Language": {"sv-SE"},
+			"Cookie":          {"session=secret"},
+		})
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "header", expected).
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "header", expected).
+			RespondSuccess(nil)
+
+		creq.GetResponse(t)
+	}, forwardHeadersConfig)
+}
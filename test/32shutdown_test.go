@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that clients are sent a going away close message on shutdown
+func TestShutdown_WithoutReconnectHint_SendsGoingAwayClose(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		s.Stop()
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"shutdown"}`)
+	})
+}
+
+// Test that clients are sent the configured reconnect hint on shutdown
+func TestShutdown_WithReconnectHint_SendsHintInClose(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		s.Stop()
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"shutdown","reconnectDelay":1000,"reconnectJitter":5000}`)
+	}, func(cfg *server.Config) {
+		cfg.ReconnectDelay = 1000
+		cfg.ReconnectJitter = 5000
+	})
+}
+
+// Test that clients are sent a resync event with the directly subscribed
+// resources on shutdown, if resyncManifest is set
+func TestShutdown_WithResyncManifest_SendsResyncEventBeforeClose(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelParent(t, s, c, false)
+		subscribeToTestCollection(t, s, c)
+		s.Stop()
+		c.GetEvent(t).Equals(t, "resync", json.RawMessage(`{"rids":["test.collection","test.model.parent"]}`))
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"shutdown"}`)
+	}, func(cfg *server.Config) {
+		cfg.ResyncManifest = true
+	})
+}
+
+// Test that the resync event has an empty list of resources if the client
+// has no direct subscriptions
+func TestShutdown_WithResyncManifestAndNoSubscriptions_SendsEmptyResyncEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		s.Stop()
+		c.GetEvent(t).Equals(t, "resync", json.RawMessage(`{"rids":[]}`))
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"shutdown"}`)
+	}, func(cfg *server.Config) {
+		cfg.ResyncManifest = true
+	})
+}
+
+// Test that the resync event lists resources that can be resubscribed to
+// using a single subscribe request
+func TestShutdown_WithResyncManifest_ResourcesCanBeResubscribed(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+		s.Stop()
+		ev := c.GetEvent(t).AssertEventName(t, "resync")
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"shutdown"}`)
+
+		manifest := ev.Data.(map[string]interface{})
+
+		s2 := setup(t)
+		defer teardown(s2)
+		c2 := s2.Connect()
+		creq := c2.Request("subscribe", map[string]interface{}{"rids": manifest["rids"]})
+		mreqs := s2.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`},"collections":{"test.collection":`+resourceData("test.collection")+`}}`))
+	}, func(cfg *server.Config) {
+		cfg.ResyncManifest = true
+	})
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func accessCacheConfig(ttl int) func(*server.Config) {
+	return func(cfg *server.Config) {
+		cfg.AccessCacheTTL = map[string]int{"test.>": ttl}
+	}
+}
+
+// resubscribeWithCachedAccess unsubscribes and subscribes to a cached
+// resource, expecting no access request to be made.
+func resubscribeWithCachedAccess(t *testing.T, s *Session, c *Conn, rid string) {
+	c.Request("unsubscribe."+rid, nil).GetResponse(t)
+	c.Request("subscribe."+rid, nil).GetResponse(t)
+	c.AssertNoNATSRequest(t, rid)
+}
+
+// resubscribeWithoutCachedAccess unsubscribes and subscribes to a cached
+// resource, expecting an access request to be made.
+func resubscribeWithoutCachedAccess(t *testing.T, s *Session, c *Conn, rid string) {
+	c.Request("unsubscribe."+rid, nil).GetResponse(t)
+	creq := c.Request("subscribe."+rid, nil)
+	s.GetRequest(t).AssertSubject(t, "access."+rid).RespondSuccess(json.RawMessage(`{"get":true}`))
+	creq.GetResponse(t)
+}
+
+// Test that a cached access result is used on a repeated subscribe
+func TestAccessCache_OnRepeatedSubscribe_UsesCachedAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		resubscribeWithCachedAccess(t, s, c, "test.model")
+	}, accessCacheConfig(10000))
+}
+
+// Test that access is not cached for resources not matching any pattern
+func TestAccessCache_OnNonMatchingResource_RequestsAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		resubscribeWithoutCachedAccess(t, s, c, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.AccessCacheTTL = map[string]int{"other.>": 10000}
+	})
+}
+
+// Test that a cached access result expires after the TTL
+func TestAccessCache_AfterTTL_RequestsAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		time.Sleep(60 * time.Millisecond)
+		resubscribeWithoutCachedAccess(t, s, c, "test.model")
+	}, accessCacheConfig(50))
+}
+
+// Test that a reaccess event clears all cached access results of the connection
+func TestAccessCache_OnReaccessEvent_ClearsCache(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+
+		resubscribeWithoutCachedAccess(t, s, c, "test.collection")
+		// The access results fetched after the reaccess are cached
+		resubscribeWithCachedAccess(t, s, c, "test.model")
+		resubscribeWithCachedAccess(t, s, c, "test.collection")
+	}, accessCacheConfig(10000))
+}
+
+// Test that a token change clears all cached access results of the connection
+func TestAccessCache_OnTokenChange_ClearsCache(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := subscribeToTestModel(t, s, c)
+		c.Request("unsubscribe.test.model", nil).GetResponse(t)
+
+		s.ConnEvent(cid, "token", struct {
+			Token interface{} `json:"token"`
+		}{json.RawMessage(`{"user":"foo"}`)})
+
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", json.RawMessage(`{"user":"foo"}`)).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+	}, accessCacheConfig(10000))
+}
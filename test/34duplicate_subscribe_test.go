@@ -0,0 +1,97 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a duplicate subscribe responds with the current state of the
+// resource without making a new get request
+func TestDuplicateSubscribe_AfterEvents_RespondsWithCurrentState(t *testing.T) {
+	tbl := []struct {
+		RID      string
+		Events   []string
+		Payloads []string
+		Expected string
+	}{
+		{"test.model", []string{"change"}, []string{`{"values":{"string":"bar","int":-12}}`}, `{"models":{"test.model":{"bool":true,"int":-12,"null":null,"string":"bar"}}}`},
+		{"test.model", []string{"change"}, []string{`{"values":{"string":"bar","null":{"action":"delete"}}}`}, `{"models":{"test.model":{"bool":true,"int":42,"string":"bar"}}}`},
+		{"test.model", []string{"change", "change"}, []string{`{"values":{"string":"bar"}}`, `{"values":{"new":"baz"}}`}, `{"models":{"test.model":{"bool":true,"int":42,"new":"baz","null":null,"string":"bar"}}}`},
+		{"test.collection", []string{"add"}, []string{`{"idx":1,"value":"bar"}`}, `{"collections":{"test.collection":["foo","bar",42,true,null]}}`},
+		{"test.collection", []string{"remove"}, []string{`{"idx":0}`}, `{"collections":{"test.collection":[42,true,null]}}`},
+		{"test.collection", []string{"add", "remove"}, []string{`{"idx":4,"value":"bar"}`, `{"idx":1}`}, `{"collections":{"test.collection":["foo",true,null,"bar"]}}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToResource(t, s, c, l.RID)
+
+			for j, ev := range l.Events {
+				s.ResourceEvent(l.RID, ev, json.RawMessage(l.Payloads[j]))
+				c.GetEvent(t).AssertEventName(t, l.RID+"."+ev)
+			}
+
+			creq := c.Request("subscribe."+l.RID, nil)
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(l.Expected))
+			c.AssertNoNATSRequest(t, l.RID)
+		})
+	}
+}
+
+// Test that a duplicate subscribe to a resource with references only
+// responds with the resource itself
+func TestDuplicateSubscribe_ParentModel_RespondsWithoutReferences(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModelParent(t, s, c, false)
+
+		creq := c.Request("subscribe.test.model.parent", nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model.parent":`+resourceData("test.model.parent")+`}}`))
+	})
+}
+
+// Test that a duplicate subscribe requires a matching unsubscribe count
+func TestDuplicateSubscribe_UnsubscribeWithCount(t *testing.T) {
+	event := json.RawMessage(`{"foo":"bar"}`)
+	tbl := []struct {
+		Counts         []int
+		ExpectedErrors []*reserr.Error
+		Subscribed     bool
+	}{
+		{[]int{1}, []*reserr.Error{nil}, true},
+		{[]int{1, 1}, []*reserr.Error{nil, nil}, false},
+		{[]int{2}, []*reserr.Error{nil}, false},
+		{[]int{3}, []*reserr.Error{reserr.ErrNoSubscription}, true},
+		{[]int{2, 1}, []*reserr.Error{nil, reserr.ErrNoSubscription}, false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+
+			creq := c.Request("subscribe.test.model", nil)
+			creq.GetResponse(t)
+
+			for j, count := range l.Counts {
+				cresp := c.Request("unsubscribe.test.model", json.RawMessage(fmt.Sprintf(`{"count":%d}`, count))).GetResponse(t)
+				if l.ExpectedErrors[j] == nil {
+					cresp.AssertResult(t, nil)
+				} else {
+					cresp.AssertError(t, l.ExpectedErrors[j])
+				}
+			}
+
+			s.ResourceEvent("test.model", "custom", event)
+			if l.Subscribed {
+				c.GetEvent(t).Equals(t, "test.model.custom", event)
+			} else {
+				c.AssertNoEvent(t, "test.model")
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/mq"
+)
+
+// Test that service requests are counted by request type and matching
+// resource pattern, with non-matching resources in the other bucket
+func TestPatternMetrics_ServiceRequests_CountedByPattern(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		// Call method on a model
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="access",pattern="test.model"}`, "1")
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="call",pattern="test.model"}`, "1")
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="access",pattern="other"}`, "1")
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="get",pattern="other"}`, "1")
+	}, func(cfg *server.Config) {
+		cfg.MetricsPatterns = []string{"test.model"}
+	})
+}
+
+// Test that the patterns of the sharedAccess setting are used as metrics patterns
+func TestPatternMetrics_WithSharedAccess_UsesSharedAccessPatterns(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model"]}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.access").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t)
+
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="access",pattern="test.*"}`, "1")
+		s.AssertMetric(t, `resgate_mq_pattern_request_duration_seconds_count{type="get",pattern="test.*"}`, "1")
+	}, func(cfg *server.Config) {
+		cfg.SharedAccess = map[string]string{"test.*": "test.access"}
+	})
+}
+
+// Test that service requests timing out are counted by request type and
+// matching resource pattern
+func TestPatternMetrics_RequestTimeout_CountedByPattern(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			Timeout()
+		creq.GetResponse(t).AssertError(t, mq.ErrRequestTimeout)
+
+		creq = c.Request("auth.test.other.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "auth.test.other.method").
+			Timeout()
+		creq.GetResponse(t).AssertError(t, mq.ErrRequestTimeout)
+
+		s.AssertMetric(t, `resgate_mq_pattern_request_timeouts_total{type="call",pattern="test.model"}`, "1")
+		s.AssertMetric(t, `resgate_mq_pattern_request_timeouts_total{type="auth",pattern="other"}`, "1")
+	}, func(cfg *server.Config) {
+		cfg.MetricsPatterns = []string{"test.model"}
+	})
+}
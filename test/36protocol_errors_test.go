@@ -0,0 +1,77 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a client sending more malformed messages than allowed is
+// disconnected with a protocol error close code
+func TestProtocolErrors_ExceedingLimit_ClosesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 4; i++ {
+			c.SendRaw([]byte(`{"method":`))
+		}
+		c.AssertClosedWithCode(t, websocket.CloseProtocolError)
+	}, func(cfg *server.Config) {
+		cfg.MaxProtocolErrors = 3
+	})
+}
+
+// Test that a client sending malformed messages within the limit is not
+// disconnected
+func TestProtocolErrors_WithinLimit_HandlesRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 3; i++ {
+			c.SendRaw([]byte(`{"method":"subscribe.test.model"}`))
+		}
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxProtocolErrors = 3
+	})
+}
+
+// Test that the count of malformed messages is reset on a valid message
+func TestProtocolErrors_ValidMessage_ResetsCount(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 3; i++ {
+			c.SendRaw([]byte(`invalid`))
+		}
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+		for i := 0; i < 3; i++ {
+			c.SendRaw([]byte(`invalid`))
+		}
+		creq = c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxProtocolErrors = 3
+	})
+}
+
+// Test that malformed messages do not close the connection when no limit is set
+func TestProtocolErrors_WithoutLimit_DoesNotCloseConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 10; i++ {
+			c.SendRaw([]byte(`invalid`))
+		}
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	})
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a system.gone error on an HTTP get request responds with
+// status 410 Gone
+func TestGone_HTTPGet_RespondsWithStatusGone(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrGone)
+
+		hreq.GetResponse(t).Equals(t, http.StatusGone, reserr.ErrGone)
+	})
+}
+
+// Test that a system.gone error on an access request for an HTTP call request
+// responds with status 410 Gone
+func TestGone_HTTPPostWithGoneAccess_RespondsWithStatusGone(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondError(reserr.ErrGone)
+
+		hreq.GetResponse(t).Equals(t, http.StatusGone, reserr.ErrGone)
+	})
+}
+
+// Test that a system.gone error on a subscribe request is sent to the client
+func TestGone_Subscribe_RespondsWithGoneError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrGone)
+
+		creq.GetResponse(t).AssertError(t, reserr.ErrGone)
+	})
+}
+
+// Test that a system.gone error on a get request after a system reset
+// generates a delete event
+func TestGone_SystemReset_GeneratesDeleteEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.SystemEvent("reset", json.RawMessage(`{"resources":["test.>"]}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondError(reserr.ErrGone)
+
+		c.GetEvent(t).AssertEventName(t, "test.model.delete").AssertData(t, nil)
+	})
+}
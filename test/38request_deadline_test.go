@@ -0,0 +1,57 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// assertDeadline asserts that the request payload has a deadline within the
+// request timeout.
+func assertDeadline(t *testing.T, req *Request) {
+	d, ok := req.PathPayload(t, "deadline").(float64)
+	if !ok || d <= 0 || d > float64(RequestTimeout.Milliseconds()) {
+		t.Fatalf("expected deadline to be within 0 and %d, but got %#v", RequestTimeout.Milliseconds(), req.PathPayload(t, "deadline"))
+	}
+}
+
+// Test that get and call requests include a deadline when enabled
+func TestRequestDeadline_Enabled_IncludesDeadline(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		req := mreqs.GetRequest(t, "get.test.model")
+		assertDeadline(t, req)
+		req.RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t)
+
+		creq = c.Request("call.test.model.method", nil)
+		req = s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+		assertDeadline(t, req)
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, func(cfg *server.Config) {
+		cfg.RequestDeadline = true
+	})
+}
+
+// Test that requests have no deadline when not enabled
+func TestRequestDeadline_Disabled_NoDeadline(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+		if _, ok := req.Payload.(map[string]interface{})["deadline"]; ok {
+			t.Fatalf("expected no deadline, but got payload: %s", req.RawPayload)
+		}
+		req.RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+var jwtTestKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+// createJWT returns a JWT signed with the RS256 algorithm and the key. The
+// exp claim is set to the Unix time in seconds.
+func createJWT(t *testing.T, key *rsa.PrivateKey, exp float64) string {
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString([]byte(fmt.Sprintf(`{"sub":"foo","exp":%f}`, exp)))
+	h := crypto.SHA256.New()
+	h.Write([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + enc.EncodeToString(sig)
+}
+
+// writeJWTKeyFile writes the PEM encoded public key of the test key to a
+// temporary file. Returns the file name.
+func writeJWTKeyFile(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&jwtTestKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "resgate-jwt-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PUBLIC KEY", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func unixTime(d time.Duration) float64 {
+	return float64(time.Now().Add(d).UnixNano()) / 1e9
+}
+
+// Test that a token event with a JWT token is only passed on in access
+// requests if the token is valid and not expired
+func TestJWT_TokenEvent_SetsTokenIfValid(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tbl := []struct {
+		Token string
+		Valid bool
+	}{
+		{`"` + createJWT(t, jwtTestKey, unixTime(time.Hour)) + `"`, true},
+		{`"` + createJWT(t, jwtTestKey, unixTime(-time.Hour)) + `"`, false},
+		{`"` + createJWT(t, otherKey, unixTime(time.Hour)) + `"`, false},
+		{`"foo"`, false},
+		{`{"user":"foo"}`, false},
+	}
+
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			cid := getCID(t, s, c)
+
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+l.Token+`}`))
+
+			creq := c.Request("call.test.model.method", nil)
+			req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+			if l.Valid {
+				req.AssertPathPayload(t, "token", json.RawMessage(l.Token))
+			} else {
+				req.AssertPathPayload(t, "token", nil)
+			}
+			req.RespondSuccess(json.RawMessage(`{"get":false}`))
+			creq.GetResponse(t)
+		}, func(cfg *server.Config) {
+			cfg.JWTPublicKeyFile = keyFile
+		})
+	}
+}
+
+// Test that the JWT is read from the token field set by the jwtTokenField
+// setting
+func TestJWT_WithTokenField_ValidatesTokenField(t *testing.T) {
+	tbl := []struct {
+		Token string
+		Valid bool
+	}{
+		{`{"auth":{"jwt":"` + createJWT(t, jwtTestKey, unixTime(time.Hour)) + `"},"user":"foo"}`, true},
+		{`{"auth":{"jwt":"` + createJWT(t, jwtTestKey, unixTime(-time.Hour)) + `"},"user":"foo"}`, false},
+		{`{"user":"foo"}`, false},
+		{`"` + createJWT(t, jwtTestKey, unixTime(time.Hour)) + `"`, false},
+	}
+
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			cid := getCID(t, s, c)
+
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+l.Token+`}`))
+
+			creq := c.Request("call.test.model.method", nil)
+			req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+			if l.Valid {
+				req.AssertPathPayload(t, "token", json.RawMessage(l.Token))
+			} else {
+				req.AssertPathPayload(t, "token", nil)
+			}
+			req.RespondSuccess(json.RawMessage(`{"get":false}`))
+			creq.GetResponse(t)
+		}, func(cfg *server.Config) {
+			cfg.JWTPublicKeyFile = keyFile
+			cfg.JWTTokenField = "auth.jwt"
+		})
+	}
+}
+
+// Test that an expired JWT token closes the connection when jwtExpiredClose
+// is set
+func TestJWT_WithExpiredClose_ClosesConnectionOnExpiredToken(t *testing.T) {
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":"`+createJWT(t, jwtTestKey, unixTime(-time.Hour))+`"}`))
+		c.AssertClosedWithCode(t, websocket.ClosePolicyViolation)
+	}, func(cfg *server.Config) {
+		cfg.JWTPublicKeyFile = keyFile
+		cfg.JWTExpiredClose = true
+	})
+}
+
+// Test that a JWT token reaching its expiration time clears the token and
+// triggers a re-access call on subscribed resources
+func TestJWT_TokenExpires_TriggersAccessCallWithoutToken(t *testing.T) {
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	runTest(t, func(s *Session) {
+		token := `"` + createJWT(t, jwtTestKey, unixTime(200*time.Millisecond)) + `"`
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "token", json.RawMessage(token)).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", nil).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+	}, func(cfg *server.Config) {
+		cfg.JWTPublicKeyFile = keyFile
+	})
+}
+
+// Test that a JWT token reaching its expiration time closes the connection
+// when jwtExpiredClose is set
+func TestJWT_TokenExpiresWithExpiredClose_ClosesConnection(t *testing.T) {
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":"`+createJWT(t, jwtTestKey, unixTime(200*time.Millisecond))+`"}`))
+		c.AssertClosedWithCode(t, websocket.ClosePolicyViolation)
+	}, func(cfg *server.Config) {
+		cfg.JWTPublicKeyFile = keyFile
+		cfg.JWTExpiredClose = true
+	})
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// versionOf returns the expected version of the JSON encoded resource data.
+func versionOf(data string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		panic("test: error unmarshaling resource data: " + err.Error())
+	}
+	b, _ := json.Marshal(v)
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Test that a versions request for subscribed resources responds with the
+// versions without making any service requests
+func TestVersions_SubscribedResources_RespondsWithVersions(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		creq := c.Request("versions", json.RawMessage(`{"rids":["test.model","test.collection"]}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(fmt.Sprintf(`{"versions":{"test.model":%q,"test.collection":%q}}`,
+			versionOf(resourceData("test.model")),
+			versionOf(resourceData("test.collection")),
+		)))
+		c.AssertNoNATSRequest(t, "test.model")
+		c.AssertNoNATSRequest(t, "test.collection")
+	})
+}
+
+// Test that a versions request for non-subscribed resources responds with
+// the versions without subscribing to the resources
+func TestVersions_NonSubscribedResource_RespondsWithVersionWithoutSubscribing(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("versions", json.RawMessage(`{"rids":["test.model"]}`))
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(fmt.Sprintf(`{"versions":{"test.model":%q}}`, versionOf(model))))
+
+		// Validate the resource is not subscribed
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.AssertNoEvent(t, "test.model")
+	})
+}
+
+// Test that the version of a resource changes on a change event
+func TestVersions_AfterChangeEvent_RespondsWithNewVersion(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.change")
+
+		creq := c.Request("versions", json.RawMessage(`{"rids":["test.model"]}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(fmt.Sprintf(`{"versions":{"test.model":%q}}`,
+			versionOf(`{"string":"bar","int":42,"bool":true,"null":null}`),
+		)))
+	})
+}
+
+// Test that resources that cannot be retrieved are added to the errors of a
+// versions request response
+func TestVersions_WithFailingResources_RespondsWithErrors(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("versions", json.RawMessage(`{"rids":["test.model","test.denied","test.notfound"]}`))
+		mreqs := s.GetParallelRequests(t, 6)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.denied").RespondSuccess(json.RawMessage(`{"get":false}`))
+		mreqs.GetRequest(t, "get.test.denied").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.notfound").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.notfound").RespondError(reserr.ErrNotFound)
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(fmt.Sprintf(`{"versions":{"test.model":%q},"errors":{"test.denied":{"code":"system.accessDenied","message":"Access denied"},"test.notfound":{"code":"system.notFound","message":"Not found"}}}`, versionOf(model))))
+	})
+}
+
+// Test that a versions request with invalid params responds with an invalid
+// params error
+func TestVersions_WithInvalidParams_RespondsWithInvalidParamsError(t *testing.T) {
+	tbl := []string{
+		``,
+		`null`,
+		`{}`,
+		`{"rids":[]}`,
+		`{"rids":"test.model"}`,
+		`{"rids":["test..model"]}`,
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			var params interface{}
+			if l != "" {
+				params = json.RawMessage(l)
+			}
+			c.Request("versions", params).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that requests exceeding maxConcurrentRequests are queued until an
+// in-flight request completes, and are sent in FIFO order by default
+func TestRequestPriority_WithMaxConcurrentRequests_QueuesRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq1 := c.Request("call.test.model.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		creq2 := c.Request("call.test.collection.method", nil)
+		s.WaitMetric(t, "resgate_mq_request_queue_length", "1")
+
+		req.RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq2.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq1.GetResponse(t)
+
+		s.AssertMetric(t, `resgate_mq_request_queue_wait_seconds_count{priority="0"}`, "3")
+	}, func(cfg *server.Config) {
+		cfg.MaxConcurrentRequests = 1
+	})
+}
+
+// Test that queued requests are sent in order of priority by request type
+func TestRequestPriority_WithRequestPriority_SendsHigherPriorityFirst(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("call.test.collection.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.collection")
+		sreq := c.Request("subscribe.test.model", nil)
+		// Wait for both the get and access requests to be queued
+		s.WaitMetric(t, "resgate_mq_request_queue_length", "2")
+
+		req.RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		sreq.GetResponse(t)
+		s.GetRequest(t).AssertSubject(t, "call.test.collection.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+
+		s.AssertMetric(t, `resgate_mq_request_queue_wait_seconds_count{priority="1"}`, "1")
+		s.AssertMetric(t, `resgate_mq_request_queue_wait_seconds_count{priority="0"}`, "3")
+	}, func(cfg *server.Config) {
+		cfg.MaxConcurrentRequests = 1
+		cfg.RequestPriority = map[string]int{"get": 1}
+	})
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that an HTTP error response has a Retry-After header if the error
+// data contains a retryAfter field
+func TestRetryAfter_HTTPErrorResponse_SetsRetryAfterHeader(t *testing.T) {
+	tbl := []struct {
+		Error    *reserr.Error
+		Expected string
+	}{
+		{&reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: json.RawMessage(`{"retryAfter":5}`)}, "5"},
+		{&reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: json.RawMessage(`{"retryAfter":0.5}`)}, "1"},
+		{&reserr.Error{Code: "custom.rateLimited", Message: "Rate limited", Data: json.RawMessage(`{"retryAfter":30}`)}, "30"},
+		{&reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: json.RawMessage(`{"retryAfter":0}`)}, ""},
+		{&reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: json.RawMessage(`{"retryAfter":"5"}`)}, ""},
+		{&reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: json.RawMessage(`[5]`)}, ""},
+		{reserr.ErrServiceUnavailable, ""},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondError(l.Error)
+			hresp := hreq.GetResponse(t).AssertError(t, l.Error)
+			if l.Expected == "" {
+				hresp.AssertMissingHeaders(t, []string{"Retry-After"})
+			} else {
+				hresp.AssertHeaders(t, map[string]string{"Retry-After": l.Expected})
+			}
+		})
+	}
+}
+
+// Test that the retryAfter field of the error data is included in the
+// WebSocket error response
+func TestRetryAfter_WebSocketErrorResponse_IncludesRetryAfterData(t *testing.T) {
+	runTest(t, func(s *Session) {
+		rerr := &reserr.Error{Code: reserr.CodeServiceUnavailable, Message: "Maintenance", Data: map[string]interface{}{"retryAfter": 5.0}}
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(rerr)
+		creq.GetResponse(t).AssertError(t, rerr)
+	})
+}
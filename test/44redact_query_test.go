@@ -0,0 +1,49 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that the values of query parameters set in redactQueryParams are
+// redacted in the log, but are still sent to the service
+func TestRedactQuery_SubscribeQueryResource_RedactsLoggedQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		cid := getCID(t, s, c)
+
+		creq := c.Request("subscribe.test.model?foo=bar&token=secret", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "query", "foo=bar&token=secret").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "foo=bar&token=secret").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"foo=bar&token=secret"}`))
+		creq.GetResponse(t)
+
+		// Only validate lines logged by the connection, as the test NATS
+		// client logs without redaction.
+		redacted := false
+		for _, line := range strings.Split(s.String(), "\n") {
+			if !strings.Contains(line, "["+cid+"]") {
+				continue
+			}
+			if strings.Contains(line, "secret") {
+				t.Fatalf("expected redacted log, but got line:\n%s", line)
+			}
+			if strings.Contains(line, "token=[REDACTED]") {
+				redacted = true
+			}
+		}
+		if !redacted {
+			t.Fatalf("expected log to contain redacted query, but got:\n%s", s.String())
+		}
+	}, func(cfg *server.Config) {
+		cfg.RedactQueryParams = []string{"token"}
+	})
+}
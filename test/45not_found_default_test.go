@@ -0,0 +1,76 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func notFoundDefaultConfig(cfg *server.Config) {
+	cfg.NotFoundDefault = map[string]json.RawMessage{
+		"test.optional.model":        json.RawMessage(`{"enabled":false}`),
+		"test.optional.collection.*": json.RawMessage(`[]`),
+	}
+}
+
+// Test that an HTTP get request for a not found resource matching a
+// notFoundDefault pattern responds with the default resource
+func TestNotFoundDefault_HTTPGet_RespondsWithDefault(t *testing.T) {
+	tbl := []struct {
+		RID          string
+		URL          string
+		Error        *reserr.Error
+		ExpectedCode int
+		Expected     interface{}
+	}{
+		{"test.optional.model", "/api/test/optional/model", reserr.ErrNotFound, http.StatusOK, json.RawMessage(`{"enabled":false}`)},
+		{"test.optional.collection.foo", "/api/test/optional/collection/foo", reserr.ErrNotFound, http.StatusOK, json.RawMessage(`[]`)},
+		{"test.optional.collection", "/api/test/optional/collection", reserr.ErrNotFound, http.StatusNotFound, reserr.ErrNotFound},
+		{"test.model", "/api/test/model", reserr.ErrNotFound, http.StatusNotFound, reserr.ErrNotFound},
+		{"test.optional.model", "/api/test/optional/model", reserr.ErrTimeout, http.StatusNotFound, reserr.ErrTimeout},
+		{"test.optional.model", "/api/test/optional/model", reserr.ErrInternalError, http.StatusInternalServerError, reserr.ErrInternalError},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", l.URL, nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get."+l.RID).RespondError(l.Error)
+			hreq.GetResponse(t).Equals(t, l.ExpectedCode, l.Expected)
+		}, notFoundDefaultConfig)
+	}
+}
+
+// Test that a subscription on a not found resource matching a
+// notFoundDefault pattern gets the default resource, and its events
+func TestNotFoundDefault_Subscribe_RespondsWithDefault(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.optional.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.optional.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.optional.model").RespondError(reserr.ErrNotFound)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.optional.model":{"enabled":false}}}`))
+
+		s.ResourceEvent("test.optional.model", "change", json.RawMessage(`{"values":{"enabled":true}}`))
+		c.GetEvent(t).Equals(t, "test.optional.model.change", json.RawMessage(`{"values":{"enabled":true}}`))
+	}, notFoundDefaultConfig)
+}
+
+// Test that a query resource matching a notFoundDefault pattern gets the
+// default resource with the requested query
+func TestNotFoundDefault_QueryResource_RespondsWithDefault(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.optional.collection.foo?q=1", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.optional.collection.foo").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.optional.collection.foo").RespondError(reserr.ErrNotFound)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.optional.collection.foo?q=1":[]}}`))
+	}, notFoundDefaultConfig)
+}
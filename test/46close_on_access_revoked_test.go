@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a connection losing access to all its subscriptions on reaccess
+// is closed with a reauthenticate close reason
+func TestCloseOnAccessRevoked_AllSubscriptionsRevoked_ClosesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+
+		s.ResourceEvent("test.collection", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).AssertEventName(t, "test.collection.unsubscribe")
+
+		c.AssertClosedWithText(t, websocket.ClosePolicyViolation, `{"reason":"reauthenticate"}`)
+	}, func(cfg *server.Config) {
+		cfg.CloseOnAccessRevoked = true
+	})
+}
+
+// Test that a connection keeping access to some of its subscriptions on
+// reaccess is not closed
+func TestCloseOnAccessRevoked_SomeSubscriptionsRevoked_KeepsConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+
+		event := json.RawMessage(`{"foo":"bar"}`)
+		s.ResourceEvent("test.collection", "custom", event)
+		c.GetEvent(t).Equals(t, "test.collection.custom", event)
+	}, func(cfg *server.Config) {
+		cfg.CloseOnAccessRevoked = true
+	})
+}
+
+// Test that a connection losing access to all its subscriptions on reaccess
+// is not closed when the option is disabled
+func TestCloseOnAccessRevoked_Disabled_KeepsConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "reaccess", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":null}`))
+	})
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a subscribe request with a query at the maximum length is handled
+func TestMaxQueryLength_SubscribeAtLimit_RespondsWithResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model?q=foo", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertPathPayload(t, "query", "q=foo").
+			RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo":`+model+`}}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxQueryLength = 5
+	})
+}
+
+// Test that subscribe and get requests with a query past the maximum length
+// are rejected without any request sent to the services
+func TestMaxQueryLength_PastLimit_RespondsWithInvalidQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		c.Request("subscribe.test.model?q=foob", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidQuery)
+		c.Request("get.test.model?q=foob", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidQuery)
+		s.HTTPRequest("GET", "/api/test/model?q=foob", nil).GetResponse(t).Equals(t, 400, reserr.ErrInvalidQuery)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.MaxQueryLength = 5
+	})
+}
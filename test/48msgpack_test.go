@@ -0,0 +1,131 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/resgateio/resgate/server/codec"
+)
+
+// Test that a client using the msgpack subprotocol gets msgpack encoded
+// responses and events
+func TestMsgpack_WebSocketSubscribe_RespondsWithResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		event := json.RawMessage(`{"foo":"bar"}`)
+		c := s.ConnectWithMsgpack()
+
+		subscribeToTestModelParent(t, s, c, false)
+
+		s.ResourceEvent("test.model", "custom", event)
+		c.GetEvent(t).Equals(t, "test.model.custom", event)
+	})
+}
+
+// Test that call params sent by a client using the msgpack subprotocol are
+// sent as JSON to the service, and that the result is passed back
+func TestMsgpack_WebSocketCall_TranslatesParamsToJSON(t *testing.T) {
+	runTest(t, func(s *Session) {
+		params := json.RawMessage(`{"value":42,"name":"foo","list":[true,null,1.5]}`)
+		c := s.ConnectWithMsgpack()
+
+		creq := c.Request("call.test.model.method", params)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "params", params).
+			RespondSuccess(json.RawMessage(`{"zoo":"baz"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"zoo":"baz"}}`))
+	})
+}
+
+// Test that a HTTP GET request accepting msgpack gets a msgpack encoded
+// response
+func TestMsgpack_HTTPGet_RespondsWithMsgpack(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+			r.Header.Set("Accept", "application/json;q=0.5, application/msgpack")
+		})
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		hresp := hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusOK).
+			AssertHeaders(t, map[string]string{"Content-Type": "application/msgpack"})
+		assertMsgpackBody(t, hresp, model)
+	})
+}
+
+// Test that a HTTP request with msgpack not accepted gets a JSON response
+func TestMsgpack_HTTPGetWithZeroQuality_RespondsWithJSON(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+			r.Header.Set("Accept", "application/msgpack;q=0")
+		})
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(model)).
+			AssertHeaders(t, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	})
+}
+
+// Test that a HTTP POST request with a msgpack body has its params sent as
+// JSON to the service, and gets a msgpack encoded response
+func TestMsgpack_HTTPPost_TranslatesParamsToJSON(t *testing.T) {
+	runTest(t, func(s *Session) {
+		params := `{"value":42,"name":"foo"}`
+		body, err := codec.JSONToMsgpack([]byte(params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", body, func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/msgpack")
+			r.Header.Set("Accept", "application/msgpack")
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "params", json.RawMessage(params)).
+			RespondSuccess(json.RawMessage(`{"zoo":"baz"}`))
+
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		assertMsgpackBody(t, hresp, `{"zoo":"baz"}`)
+	})
+}
+
+// Test that a HTTP POST request with a malformed msgpack body gets a bad
+// request response without any request sent to the services
+func TestMsgpack_HTTPPostWithMalformedBody_RespondsWithBadRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte{0x92, 0x01}, func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/msgpack")
+		})
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusBadRequest).AssertErrorCode(t, "system.badRequest")
+		c.AssertNoNATSRequest(t, "test.model")
+	})
+}
+
+func assertMsgpackBody(t *testing.T, hresp *HTTPResponse, expected string) {
+	out, err := codec.MsgpackToJSON(hresp.Body.Bytes())
+	if err != nil {
+		t.Fatalf("expected a msgpack body, but got error: %s", err)
+	}
+	var a, b interface{}
+	json.Unmarshal(out, &a)
+	json.Unmarshal([]byte(expected), &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("expected body to be:\n%s\nbut got:\n%s", expected, out)
+	}
+}
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that a HTTP GET request with a single trailing slash is routed as
+// without the slash, when stripping trailing slashes
+func TestTrailingSlash_StripEnabled_RoutesWithoutSlash(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model/", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+	}, func(cfg *server.Config) {
+		cfg.StripTrailingSlash = true
+	})
+}
+
+// Test that a HTTP POST request with a single trailing slash is routed as
+// without the slash, when stripping trailing slashes
+func TestTrailingSlash_StripEnabledOnPost_RoutesWithoutSlash(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method/", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, func(cfg *server.Config) {
+		cfg.StripTrailingSlash = true
+	})
+}
+
+// Test that HTTP requests to paths not resolving to a resource after
+// stripping a single trailing slash are not found
+func TestTrailingSlash_StripEnabled_NotFound(t *testing.T) {
+	tbl := []struct {
+		Method string
+		URL    string
+	}{
+		{"GET", "/api/test/model//"},
+		{"GET", "/api//"},
+		{"GET", "/api/test//"},
+		{"POST", "/api/test/model/method//"},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			s.HTTPRequest(l.Method, l.URL, nil).GetResponse(t).Equals(t, http.StatusNotFound, reserr.ErrNotFound)
+		}, func(cfg *server.Config) {
+			cfg.StripTrailingSlash = true
+		})
+	}
+}
+
+// Test that HTTP requests with a trailing slash are not found when
+// stripping trailing slashes is disabled
+func TestTrailingSlash_StripDisabled_NotFound(t *testing.T) {
+	tbl := []struct {
+		Method string
+		URL    string
+	}{
+		{"GET", "/api/test/model/"},
+		{"POST", "/api/test/model/method/"},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			s.HTTPRequest(l.Method, l.URL, nil).GetResponse(t).Equals(t, http.StatusNotFound, reserr.ErrNotFound)
+		})
+	}
+}
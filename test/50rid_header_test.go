@@ -0,0 +1,64 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Test that a HTTP GET response includes the resource ID in the X-RID header
+func TestRIDHeader_HTTPGet_IncludesRID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model?q=foo", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=foo"}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(model)).
+			AssertHeaders(t, map[string]string{"X-RID": "test.model?q=foo"})
+	})
+}
+
+// Test that a HTTP POST response with a payload includes the called resource
+// ID in the X-RID header
+func TestRIDHeader_HTTPPostWithPayload_IncludesCalledRID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`)).
+			AssertHeaders(t, map[string]string{"X-RID": "test.model"})
+	})
+}
+
+// Test that a HTTP POST response with a resource response includes the
+// referenced resource ID in the X-RID header
+func TestRIDHeader_HTTPPostWithResource_IncludesReferencedRID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondResource("test.collection")
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusOK).
+			AssertHeaders(t, map[string]string{"X-RID": "test.collection", "Location": "/api/test/collection"})
+	})
+}
+
+// Test that a HTTP POST response with return=representation and a resource
+// response includes the referenced resource ID in the X-RID header
+func TestRIDHeader_HTTPPostWithReturnRepresentation_IncludesReferencedRID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		collection := resourceData("test.collection")
+		hreq := s.HTTPRequest("POST", "/api/test/model/method?return=representation", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondResource("test.collection")
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + collection + `}`))
+		hreq.GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(collection)).
+			AssertHeaders(t, map[string]string{"X-RID": "test.collection"})
+	})
+}
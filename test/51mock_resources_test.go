@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func mockResourcesConfig(cfg *server.Config) {
+	cfg.DangerouslyEnableMockResources = true
+	cfg.MockResources = map[string]json.RawMessage{
+		"test.mock.model":      json.RawMessage(`{"message":"Hello"}`),
+		"test.mock.collection": json.RawMessage(`["foo",42]`),
+	}
+}
+
+// Test that subscribing to a mocked resource responds with the mocked
+// resource without any request sent to the services
+func TestMockResources_Subscribe_RespondsWithMock(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		c.Request("subscribe.test.mock.model", nil).GetResponse(t).
+			AssertResult(t, json.RawMessage(`{"models":{"test.mock.model":{"message":"Hello"}}}`))
+		c.Request("subscribe.test.mock.collection", nil).GetResponse(t).
+			AssertResult(t, json.RawMessage(`{"collections":{"test.mock.collection":["foo",42]}}`))
+		c.AssertNoNATSRequest(t, "test.mock.model")
+	}, mockResourcesConfig)
+}
+
+// Test that a HTTP GET request for a mocked resource responds with the
+// mocked resource
+func TestMockResources_HTTPGet_RespondsWithMock(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/api/test/mock/model", nil).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"message":"Hello"}`))
+	}, mockResourcesConfig)
+}
+
+// Test that requests for resources not mocked are sent to the services
+func TestMockResources_NotMocked_SendsRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+	}, mockResourcesConfig)
+}
+
+// Test that call requests on a mocked resource are denied, as the mocked
+// access only grants get access
+func TestMockResources_CallOnMock_RespondsWithAccessDenied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+
+		creq := c.Request("call.test.mock.model.method", nil)
+		creq.GetResponse(t).AssertErrorCode(t, "system.accessDenied")
+	}, mockResourcesConfig)
+}
+
+// Test that a warning is logged on start when mock resources are enabled
+func TestMockResources_Enabled_LogsWarning(t *testing.T) {
+	runTest(t, func(s *Session) {
+		if !strings.Contains(s.String(), "Mock resources enabled") {
+			t.Fatalf("expected a mock resources warning in the log, but got:\n%s", s.String())
+		}
+	}, mockResourcesConfig)
+}
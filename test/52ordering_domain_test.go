@@ -0,0 +1,52 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that interleaved events on resources within the same ordering domain
+// are sent to the client in the order they were received
+func TestOrderingDomain_InterleavedEvents_SentInOrder(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		const n = 50
+		rids := [2]string{"test.model", "test.collection"}
+		for i := 0; i < n; i++ {
+			s.ResourceEvent(rids[i%2], "custom", json.RawMessage(fmt.Sprintf(`{"seq":%d}`, i)))
+		}
+		for i := 0; i < n; i++ {
+			c.GetEvent(t).Equals(t, rids[i%2]+".custom", json.RawMessage(fmt.Sprintf(`{"seq":%d}`, i)))
+		}
+	}, func(cfg *server.Config) {
+		cfg.OrderingDomains = [][]string{{"test.model", "test.collection"}}
+	})
+}
+
+// Test that interleaved change and add events on resources within the same
+// ordering domain are sent to the client in the order they were received
+func TestOrderingDomain_InterleavedChangeAndAddEvents_SentInOrder(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":0,"value":"first"}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		s.ResourceEvent("test.collection", "add", json.RawMessage(`{"idx":1,"value":"second"}`))
+
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":0,"value":"first"}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":1,"value":"second"}`))
+	}, func(cfg *server.Config) {
+		cfg.OrderingDomains = [][]string{{"test.>"}}
+	})
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withChangeDebounce(cfg *server.Config) {
+	cfg.ChangeDebounce = map[string]int{"test.model": 50}
+}
+
+// Test that a burst of change events results in a single change event with
+// the latest values
+func TestChangeDebounce_ChangeEvents_SendsSingleChangeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz","int":12}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"bool":{"action":"delete"}}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":13}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz","int":13,"bool":{"action":"delete"}}}`))
+		c.AssertNoEvent(t, "test.model")
+
+		// Validate the model is updated in the cache
+		c2 := s.Connect()
+		creq := c2.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"string":"baz","int":13,"null":null}}}`))
+	}, withChangeDebounce)
+}
+
+// Test that change events setting a property back to its original value
+// results in no event
+func TestChangeDebounce_ChangeBackToOriginal_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar","new":true}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"foo","new":{"action":"delete"}}}`))
+		c.AssertNoEvent(t, "test.model")
+	}, withChangeDebounce)
+}
+
+// Test that a change event includes any resource references set during the
+// debounce window
+func TestChangeDebounce_ChangeReference_IncludesResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"ref":{"rid":"test.collection"}}}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar","ref":{"rid":"test.collection"}},"collections":{"test.collection":`+resourceData("test.collection")+`}}`))
+	}, withChangeDebounce)
+}
+
+// Test that other events flush any pending change before being sent
+func TestChangeDebounce_CustomEvent_FlushesPendingChange(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.custom", json.RawMessage(`{"foo":"bar"}`))
+	}, withChangeDebounce)
+}
+
+// Test that change events on models not matching any pattern are sent
+// without delay
+func TestChangeDebounce_NotMatchingPattern_SendsEachChangeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"baz"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"baz"}}`))
+	}, func(cfg *server.Config) {
+		cfg.ChangeDebounce = map[string]int{"test.other": 50}
+	})
+}
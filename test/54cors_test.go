@@ -0,0 +1,59 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that preflight responses advertise the allowed methods and headers
+func TestCORS_Preflight_AdvertisesAllowedMethodsAndHeaders(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tbl := []struct {
+		Config          func(cfg *server.Config)
+		ExpectedHeaders map[string]string
+		MissingHeaders  []string
+	}{
+		// Defaults
+		{nil, map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST", "Access-Control-Allow-Headers": "content-type, authorization"}, nil},
+		{func(cfg *server.Config) {
+			cfg.PUTMethod = str("set")
+			cfg.DELETEMethod = str("delete")
+			cfg.PATCHMethod = str("patch")
+		}, map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST, PUT, DELETE, PATCH"}, nil},
+		// Configured
+		{func(cfg *server.Config) {
+			cfg.AllowMethods = []string{"GET", "OPTIONS"}
+		}, map[string]string{"Access-Control-Allow-Methods": "GET, OPTIONS", "Access-Control-Allow-Headers": "content-type, authorization"}, nil},
+		{func(cfg *server.Config) {
+			cfg.AllowHeaders = []string{"content-type", "x-correlation-id"}
+		}, map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST", "Access-Control-Allow-Headers": "content-type, x-correlation-id"}, nil},
+		{func(cfg *server.Config) {
+			cfg.AllowHeaders = []string{}
+		}, map[string]string{"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS, POST"}, []string{"Access-Control-Allow-Headers"}},
+		// Configured with allowed origins
+		{func(cfg *server.Config) {
+			cfg.AllowOrigin = str("http://localhost")
+			cfg.AllowMethods = []string{"GET", "POST", "OPTIONS"}
+			cfg.AllowHeaders = []string{"authorization"}
+		}, map[string]string{"Access-Control-Allow-Methods": "GET, POST, OPTIONS", "Access-Control-Allow-Headers": "authorization", "Access-Control-Allow-Origin": "http://localhost"}, nil},
+	}
+
+	for i, l := range tbl {
+		var cfgs []func(*server.Config)
+		if l.Config != nil {
+			cfgs = append(cfgs, l.Config)
+		}
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("OPTIONS", "/api/test/model", nil, func(req *http.Request) {
+				req.Header.Set("Origin", "http://localhost")
+			})
+			hreq.GetResponse(t).
+				AssertStatusCode(t, http.StatusOK).
+				AssertHeaders(t, l.ExpectedHeaders).
+				AssertMissingHeaders(t, l.MissingHeaders)
+		}, cfgs...)
+	}
+}
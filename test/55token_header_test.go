@@ -0,0 +1,120 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withTokenHeader(cfg *server.Config) {
+	cfg.TokenHeader = "Authorization"
+}
+
+// Test that the token from the token header is passed in the access request
+// of a HTTP request
+func TestTokenHeader_HTTPGet_PassesTokenInAccessRequest(t *testing.T) {
+	tbl := []struct {
+		Header        string // Authorization header value. Empty means no header.
+		ExpectedToken interface{}
+	}{
+		{"Bearer foo", "foo"},
+		{"bearer foo", "foo"},
+		{"foo", "foo"},
+		{"Bearer {\"user\":42}", "{\"user\":42}"},
+		{"", nil},
+		{"Bearer ", nil},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model", nil, func(req *http.Request) {
+				if l.Header != "" {
+					req.Header.Set("Authorization", l.Header)
+				}
+			})
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			mreqs.GetRequest(t, "access.test.model").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(json.RawMessage(`{"get":true}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(resourceData("test.model")))
+		}, withTokenHeader)
+	}
+}
+
+// Test that the token from the token header only applies to the single HTTP
+// request it was sent with
+func TestTokenHeader_MultipleHTTPRequests_TokenAppliesToSingleRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer foo")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", "foo").
+			RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "token", "foo").
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+
+		hreq = s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", nil).
+			RespondSuccess(json.RawMessage(`{"get":false}`))
+		hreq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withTokenHeader)
+}
+
+// Test that the token header is ignored when no tokenHeader is configured
+func TestTokenHeader_NotConfigured_IgnoresHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer foo")
+		})
+		s.GetRequest(t).AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", nil).
+			RespondSuccess(json.RawMessage(`{"get":false}`))
+		hreq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	})
+}
+
+// Test that an invalid JWT in the token header is not passed on in the
+// access request, when a JWT validator is configured
+func TestTokenHeader_WithJWT_PassesTokenIfValid(t *testing.T) {
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	valid := createJWT(t, jwtTestKey, unixTime(time.Hour))
+	tbl := []struct {
+		Token         string
+		ExpectedToken interface{}
+	}{
+		{valid, valid},
+		{createJWT(t, jwtTestKey, unixTime(-time.Hour)), nil},
+		{createJWT(t, otherKey, unixTime(time.Hour)), nil},
+		{"foo", nil},
+	}
+
+	keyFile := writeJWTKeyFile(t)
+	defer os.Remove(keyFile)
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+l.Token)
+			})
+			s.GetRequest(t).AssertSubject(t, "access.test.model").
+				AssertPathPayload(t, "token", l.ExpectedToken).
+				RespondSuccess(json.RawMessage(`{"get":false}`))
+			hreq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+		}, withTokenHeader, func(cfg *server.Config) {
+			cfg.JWTPublicKeyFile = keyFile
+		})
+	}
+}
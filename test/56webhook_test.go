@@ -0,0 +1,241 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+const webhookTestToken = "secret"
+
+func withWebhooks(cfg *server.Config) {
+	cfg.WebhookPath = "/webhooks"
+	cfg.WebhookToken = webhookTestToken
+	cfg.WebhookRetryDelay = 10
+}
+
+// webhookReceiver is a HTTP server receiving webhook requests. The status
+// function returns the status code to respond with for the nth request.
+type webhookReceiver struct {
+	*httptest.Server
+	bodies chan string
+}
+
+func newWebhookReceiver(status func(n int) int) *webhookReceiver {
+	wr := &webhookReceiver{bodies: make(chan string, 16)}
+	n := 0
+	wr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		n++
+		code := status(n)
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			wr.bodies <- string(body)
+		}
+	}))
+	return wr
+}
+
+// AssertBody asserts that the next successfully received request has the
+// expected JSON body.
+func (wr *webhookReceiver) AssertBody(t *testing.T, expected string) {
+	select {
+	case body := <-wr.bodies:
+		var a, b interface{}
+		if err := json.Unmarshal([]byte(body), &a); err != nil {
+			t.Fatalf("expected webhook body to be:\n%s\nbut got:\n%s", expected, body)
+		}
+		json.Unmarshal([]byte(expected), &b)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("expected webhook body to be:\n%s\nbut got:\n%s", expected, body)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected a webhook request but found none")
+	}
+}
+
+func withWebhookAuth(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+webhookTestToken)
+}
+
+// registerWebhook registers a webhook and returns its ID.
+func registerWebhook(t *testing.T, s *Session, pattern, url string) string {
+	body, _ := json.Marshal(map[string]string{"pattern": pattern, "url": url})
+	hr := s.HTTPRequest("POST", "/webhooks", body, withWebhookAuth).GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	var reg struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(hr.Body.Bytes(), &reg); err != nil || reg.ID == "" {
+		t.Fatalf("expected registration response with id, but got: %s", hr.Body.String())
+	}
+	return reg.ID
+}
+
+// Test that webhook API requests without a valid token are denied
+func TestWebhook_WithoutValidToken_AccessDenied(t *testing.T) {
+	tbl := []struct {
+		Header string // Authorization header value. Empty means no header.
+	}{
+		{""},
+		{"Bearer"},
+		{"Bearer wrong"},
+		{webhookTestToken + "x"},
+	}
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			s.HTTPRequest("GET", "/webhooks", nil, func(req *http.Request) {
+				if l.Header != "" {
+					req.Header.Set("Authorization", l.Header)
+				}
+			}).GetResponse(t).AssertStatusCode(t, http.StatusUnauthorized).AssertError(t, reserr.ErrAccessDenied)
+		}, withWebhooks)
+	}
+}
+
+// Test that events on resources matching a registered webhook pattern are
+// posted to the webhook URL
+func TestWebhook_ResourceEvents_PostedToURL(t *testing.T) {
+	wr := newWebhookReceiver(func(int) int { return http.StatusOK })
+	defer wr.Close()
+
+	runTest(t, func(s *Session) {
+		registerWebhook(t, s, "test.hook.*", wr.URL)
+		s.ResourceEvent("test.hook.model", "change", json.RawMessage(`{"values":{"foo":"bar"}}`))
+		wr.AssertBody(t, `{"event":"test.hook.model.change","data":{"values":{"foo":"bar"}}}`)
+		s.ResourceEvent("test.hook.collection", "add", json.RawMessage(`{"idx":0,"value":"foo"}`))
+		wr.AssertBody(t, `{"event":"test.hook.collection.add","data":{"idx":0,"value":"foo"}}`)
+	}, withWebhooks)
+}
+
+// Test that events are posted to a webhook with a full wildcard pattern
+func TestWebhook_FullWildcardPattern_PostsMatchingEvents(t *testing.T) {
+	wr := newWebhookReceiver(func(int) int { return http.StatusOK })
+	defer wr.Close()
+
+	runTest(t, func(s *Session) {
+		registerWebhook(t, s, "test.hook.>", wr.URL)
+		s.ResourceEvent("test.hook.deep.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		wr.AssertBody(t, `{"event":"test.hook.deep.model.custom","data":{"foo":"bar"}}`)
+	}, withWebhooks)
+}
+
+// Test that registered webhooks are listed, and can be removed
+func TestWebhook_ListAndRemove_UpdatesRegistrations(t *testing.T) {
+	runTest(t, func(s *Session) {
+		id := registerWebhook(t, s, "test.hook.*", "http://localhost/hook")
+		s.HTTPRequest("GET", "/webhooks", nil, withWebhookAuth).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"webhooks":[{"id":"`+id+`","pattern":"test.hook.*","url":"http://localhost/hook"}]}`))
+		s.HTTPRequest("GET", "/webhooks/"+id, nil, withWebhookAuth).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"id":"`+id+`","pattern":"test.hook.*","url":"http://localhost/hook"}`))
+
+		s.HTTPRequest("DELETE", "/webhooks/"+id, nil, withWebhookAuth).GetResponse(t).AssertStatusCode(t, http.StatusNoContent)
+		s.HTTPRequest("GET", "/webhooks", nil, withWebhookAuth).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"webhooks":[]}`))
+		s.HTTPRequest("DELETE", "/webhooks/"+id, nil, withWebhookAuth).GetResponse(t).AssertError(t, reserr.ErrNotFound)
+	}, withWebhooks)
+}
+
+// Test that registering a webhook with invalid parameters responds with an
+// error
+func TestWebhook_RegisterWithInvalidParams_RespondsWithError(t *testing.T) {
+	tbl := []struct {
+		Body string
+	}{
+		{`{"pattern":"test..model","url":"http://localhost/hook"}`},
+		{`{"pattern":"test.model","url":"ftp://localhost/hook"}`},
+		{`{"pattern":"test.model"}`},
+		{`{"url":"http://localhost/hook"}`},
+		{`[]`},
+	}
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			s.HTTPRequest("POST", "/webhooks", []byte(l.Body), withWebhookAuth).GetResponse(t).
+				AssertStatusCode(t, http.StatusBadRequest).
+				AssertErrorCode(t, reserr.CodeInvalidParams)
+		}, withWebhooks)
+	}
+}
+
+// Test that failed webhook deliveries are retried
+func TestWebhook_FailedDelivery_IsRetried(t *testing.T) {
+	wr := newWebhookReceiver(func(n int) int {
+		if n <= 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	defer wr.Close()
+
+	runTest(t, func(s *Session) {
+		id := registerWebhook(t, s, "test.hook.*", wr.URL)
+		s.ResourceEvent("test.hook.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		wr.AssertBody(t, `{"event":"test.hook.model.custom","data":{"foo":"bar"}}`)
+		s.HTTPRequest("GET", "/webhooks/"+id+"/deadletter", nil, withWebhookAuth).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"events":[]}`))
+	}, withWebhooks)
+}
+
+// Test that events failing delivery after all retries are added to the dead
+// letter list
+func TestWebhook_PersistentFailure_AddsToDeadLetter(t *testing.T) {
+	wr := newWebhookReceiver(func(int) int { return http.StatusServiceUnavailable })
+	defer wr.Close()
+
+	runTest(t, func(s *Session) {
+		id := registerWebhook(t, s, "test.hook.*", wr.URL)
+		s.ResourceEvent("test.hook.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+
+		expected := json.RawMessage(`{"events":[{"event":"test.hook.model.custom","data":{"foo":"bar"},"error":"unexpected status code 503"}]}`)
+		var hr *HTTPResponse
+		for i := 0; i < 100; i++ {
+			hr = s.HTTPRequest("GET", "/webhooks/"+id+"/deadletter", nil, withWebhookAuth).GetResponse(t)
+			if hr.Body.String() != `{"events":[]}` {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		hr.Equals(t, http.StatusOK, expected)
+		s.AssertErrorsLogged(t, 1)
+	}, withWebhooks, func(cfg *server.Config) {
+		cfg.WebhookRetries = 2
+	})
+}
+
+// Test that webhook registrations stored in the webhook file are kept when
+// the service is restarted
+func TestWebhook_WithWebhookFile_KeepsRegistrationsOnRestart(t *testing.T) {
+	f, err := ioutil.TempFile("", "resgate-webhooks-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	defer os.Remove(f.Name())
+
+	wr := newWebhookReceiver(func(int) int { return http.StatusOK })
+	defer wr.Close()
+
+	cfgs := []func(*server.Config){withWebhooks, func(cfg *server.Config) {
+		cfg.WebhookFile = f.Name()
+	}}
+
+	var id string
+	runTest(t, func(s *Session) {
+		id = registerWebhook(t, s, "test.hook.*", wr.URL)
+	}, cfgs...)
+
+	runTest(t, func(s *Session) {
+		s.HTTPRequest("GET", "/webhooks", nil, withWebhookAuth).GetResponse(t).
+			Equals(t, http.StatusOK, json.RawMessage(`{"webhooks":[{"id":"`+id+`","pattern":"test.hook.*","url":"`+wr.URL+`"}]}`))
+		s.ResourceEvent("test.hook.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		wr.AssertBody(t, `{"event":"test.hook.model.custom","data":{"foo":"bar"}}`)
+	}, cfgs...)
+}
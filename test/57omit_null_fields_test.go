@@ -0,0 +1,125 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that HTTP GET responses serialize or omit null model fields,
+// depending on the omitNullFields setting, for all API encodings
+func TestOmitNullFields_HTTPGet_ExpectedResponse(t *testing.T) {
+	tbl := []struct {
+		APIEncoding    string
+		OmitNullFields bool
+		RID            string
+		Expected       string
+	}{
+		{"json", false, "test.model", `{"string":"foo","int":42,"bool":true,"null":null}`},
+		{"json", true, "test.model", `{"string":"foo","int":42,"bool":true}`},
+		{"json", false, "test.model.parent", `{"name":"parent","child":{"href":"/api/test/model","model":{"string":"foo","int":42,"bool":true,"null":null}}}`},
+		{"json", true, "test.model.parent", `{"name":"parent","child":{"href":"/api/test/model","model":{"string":"foo","int":42,"bool":true}}}`},
+		{"jsonFlat", false, "test.model", `{"string":"foo","int":42,"bool":true,"null":null}`},
+		{"jsonFlat", true, "test.model", `{"string":"foo","int":42,"bool":true}`},
+		{"jsonFlat", false, "test.model.parent", `{"name":"parent","child":{"string":"foo","int":42,"bool":true,"null":null}}`},
+		{"jsonFlat", true, "test.model.parent", `{"name":"parent","child":{"string":"foo","int":42,"bool":true}}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", server.RIDToPath(l.RID, "/api/"), nil)
+			// Handle get and access request
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"model":` + resourceData(l.RID) + `}`))
+			if l.RID != "test.model" {
+				s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			}
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(l.Expected))
+		}, func(c *server.Config) {
+			c.APIEncoding = l.APIEncoding
+			c.OmitNullFields = l.OmitNullFields
+		})
+	}
+}
+
+// Test that subscribing to a model omits null fields when omitNullFields is
+// set
+func TestOmitNullFields_Subscribe_OmitsNullFields(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"string":"foo","int":42,"bool":true}}}`))
+	}, func(c *server.Config) {
+		c.OmitNullFields = true
+	})
+}
+
+// Test that null values in change events round trip to the client, or are
+// sent as delete actions when omitNullFields is set
+func TestOmitNullFields_ChangeEvent_ExpectedEventAndModel(t *testing.T) {
+	tbl := []struct {
+		OmitNullFields      bool
+		ChangeEvent         string // Change event to send (raw JSON)
+		ExpectedChangeEvent string // Expected event sent to client (raw JSON. Empty means none)
+		ExpectedModel       string // Expected model on new subscription after event (raw JSON)
+	}{
+		{false, `{"values":{"string":null}}`, `{"values":{"string":null}}`, `{"string":null,"int":42,"bool":true,"null":null}`},
+		{false, `{"values":{"string":{"data":null}}}`, `{"values":{"string":null}}`, `{"string":null,"int":42,"bool":true,"null":null}`},
+		{false, `{"values":{"null":"bar"}}`, `{"values":{"null":"bar"}}`, `{"string":"foo","int":42,"bool":true,"null":"bar"}`},
+		{false, `{"values":{"new":null}}`, `{"values":{"new":null}}`, `{"string":"foo","int":42,"bool":true,"null":null,"new":null}`},
+		{false, `{"values":{"null":{"action":"delete"}}}`, `{"values":{"null":{"action":"delete"}}}`, `{"string":"foo","int":42,"bool":true}`},
+		{true, `{"values":{"string":null}}`, `{"values":{"string":{"action":"delete"}}}`, `{"int":42,"bool":true}`},
+		{true, `{"values":{"string":{"data":null}}}`, `{"values":{"string":{"action":"delete"}}}`, `{"int":42,"bool":true}`},
+		{true, `{"values":{"string":null,"int":12}}`, `{"values":{"string":{"action":"delete"},"int":12}}`, `{"int":12,"bool":true}`},
+		{true, `{"values":{"null":"bar"}}`, `{"values":{"null":"bar"}}`, `{"string":"foo","int":42,"bool":true,"null":"bar"}`},
+		{true, `{"values":{"new":null}}`, "", `{"string":"foo","int":42,"bool":true}`},
+		{true, `{"values":{"null":{"action":"delete"}}}`, "", `{"string":"foo","int":42,"bool":true}`},
+		{true, `{"values":{"new":null,"int":12}}`, `{"values":{"int":12}}`, `{"string":"foo","int":12,"bool":true}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+
+			// Send event on model and validate client event
+			s.ResourceEvent("test.model", "change", json.RawMessage(l.ChangeEvent))
+			if l.ExpectedChangeEvent == "" {
+				c.AssertNoEvent(t, "test.model")
+			} else {
+				c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(l.ExpectedChangeEvent))
+			}
+
+			// Subscribe with a second client to validate the cached model
+			c2 := s.Connect()
+			creq := c2.Request("subscribe.test.model", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+l.ExpectedModel+`}}`))
+		}, func(c *server.Config) {
+			c.OmitNullFields = l.OmitNullFields
+		})
+	}
+}
+
+// Test that a field set to null, and back to its original value, within a
+// change debounce window sends no change event when omitNullFields is set
+func TestOmitNullFields_WithChangeDebounce_SendsNoEventForUnchangedField(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":null}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"foo","int":12}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`))
+	}, func(c *server.Config) {
+		c.OmitNullFields = true
+		c.ChangeDebounce = map[string]int{"test.model": 50}
+	})
+}
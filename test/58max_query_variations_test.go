@@ -0,0 +1,95 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/rescache"
+)
+
+func subscribeToQueryVariation(t *testing.T, s *Session, c *Conn, q string) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model?"+q, nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").
+		AssertPathPayload(t, "query", q).
+		RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"` + q + `"}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?`+q+`":`+model+`}}`))
+}
+
+// Test that subscribing to more query variations than the limit responds
+// with system.queryLimitExceeded without any get request sent
+func TestMaxQueryVariations_SubscribePastLimit_RespondsWithQueryLimitExceeded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 1; i <= 3; i++ {
+			subscribeToQueryVariation(t, s, c, fmt.Sprintf("q=%d", i))
+		}
+
+		creq := c.Request("subscribe.test.model?q=4", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, rescache.ErrQueryLimitExceeded)
+		c.AssertNoNATSRequest(t, "test.model")
+
+		// Already cached query variations are not limited
+		c2 := s.Connect()
+		creq = c2.Request("subscribe.test.model?q=2", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=2":`+resourceData("test.model")+`}}`))
+
+		// HTTP requests are limited as well
+		hreq := s.HTTPRequest("GET", "/api/test/model?q=4", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, 400, rescache.ErrQueryLimitExceeded)
+	}, func(cfg *server.Config) {
+		cfg.MaxQueryVariations = 3
+	})
+}
+
+// Test that unsubscribing to a query variation frees it from the limit
+func TestMaxQueryVariations_AfterUnsubscribe_AllowsNewVariation(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToQueryVariation(t, s, c, "q=1")
+		subscribeToQueryVariation(t, s, c, "q=2")
+
+		c.Request("unsubscribe.test.model?q=1", nil).GetResponse(t)
+		subscribeToQueryVariation(t, s, c, "q=3")
+	}, func(cfg *server.Config) {
+		cfg.MaxQueryVariations = 2
+	})
+}
+
+// Test that query variations linked to a normalized query are included in
+// the limit
+func TestMaxQueryVariations_WithNormalizedQuery_CountsLinkedVariations(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+
+		creq := c.Request("subscribe.test.model?q=1&x=a", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `,"query":"q=1"}`))
+		creq.GetResponse(t)
+
+		creq = c.Request("subscribe.test.model?q=2", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertError(t, rescache.ErrQueryLimitExceeded)
+	}, func(cfg *server.Config) {
+		cfg.MaxQueryVariations = 2
+	})
+}
+
+// Test that a zero limit allows any number of query variations
+func TestMaxQueryVariations_WithZeroLimit_AllowsAnyVariations(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 1; i <= 10; i++ {
+			subscribeToQueryVariation(t, s, c, fmt.Sprintf("q=%d", i))
+		}
+	})
+}
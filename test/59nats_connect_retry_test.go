@@ -0,0 +1,164 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// setupWithFailedConnects creates a session where the first n attempts to
+// connect to NATS fail. It returns the error from starting the server.
+func setupWithFailedConnects(t *testing.T, n int, cfgs ...func(*server.Config)) (*Session, error) {
+	s := newSessionWithFailedConnects(t, n, cfgs...)
+	return s, s.s.Start()
+}
+
+// newSessionWithFailedConnects creates a session, without starting the
+// server, where the first n attempts to connect to NATS fail.
+func newSessionWithFailedConnects(t *testing.T, n int, cfgs ...func(*server.Config)) *Session {
+	l := NewCountLogger(true, true)
+	c := NewNATSTestClient(l)
+	c.FailConnects(n)
+	serv, err := server.NewService(c, DefaultConfig(cfgs...))
+	if err != nil {
+		t.Fatalf("error creating new service: %s", err)
+	}
+	serv.SetLogger(l)
+
+	s := &Session{
+		t:              t,
+		NATSTestClient: c,
+		s:              serv,
+		conns:          make(map[*Conn]struct{}),
+		CountLogger:    l,
+	}
+	return s
+}
+
+// assertReadyStatus asserts that the readiness endpoint responds with the
+// status code.
+func assertReadyStatus(t *testing.T, s *Session, code int) {
+	rr := httptest.NewRecorder()
+	s.s.GetReadyHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+	if rr.Code != code {
+		t.Errorf("expected readiness status code %d, but got %d", code, rr.Code)
+	}
+}
+
+// Test that the server retries connecting to NATS on startup, and is started
+// once NATS is available
+func TestNATSConnectRetries_DelayedAvailability_StartsServer(t *testing.T) {
+	s, err := setupWithFailedConnects(t, 2, func(cfg *server.Config) {
+		cfg.NATSConnectRetries = 3
+		cfg.NATSConnectRetryDelay = 1
+	})
+	if err != nil {
+		t.Fatalf("expected server to start, but got error: %s", err)
+	}
+	defer teardown(s)
+
+	if n := s.ConnectAttempts(); n != 3 {
+		t.Errorf("expected 3 connect attempts, but got %d", n)
+	}
+
+	c := s.Connect()
+	creq := c.Request("get.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+}
+
+// Test that the server fails to start once all NATS connect retries are
+// exhausted
+func TestNATSConnectRetries_RetriesExhausted_ReturnsError(t *testing.T) {
+	s, err := setupWithFailedConnects(t, 3, func(cfg *server.Config) {
+		cfg.NATSConnectRetries = 2
+		cfg.NATSConnectRetryDelay = 1
+	})
+	if err == nil {
+		teardown(s)
+		t.Fatal("expected server to fail starting, but got no error")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected error to mention the number of attempts, but got: %s", err)
+	}
+	if n := s.ConnectAttempts(); n != 3 {
+		t.Errorf("expected 3 connect attempts, but got %d", n)
+	}
+	s.AssertErrorsLogged(t, 1)
+}
+
+// Test that the server fails to start without retrying by default
+func TestNATSConnectRetries_Default_FailsWithoutRetry(t *testing.T) {
+	s, err := setupWithFailedConnects(t, 1)
+	if err == nil {
+		teardown(s)
+		t.Fatal("expected server to fail starting, but got no error")
+	}
+	if n := s.ConnectAttempts(); n != 1 {
+		t.Errorf("expected 1 connect attempt, but got %d", n)
+	}
+	s.AssertErrorsLogged(t, 1)
+}
+
+// Test that the readiness endpoint reports not ready while waiting to retry
+// connecting to NATS, and ready once connected
+func TestNATSConnectRetries_WaitingToRetry_ReportsNotReady(t *testing.T) {
+	s := newSessionWithFailedConnects(t, 1, func(cfg *server.Config) {
+		cfg.NATSConnectRetries = 1
+		cfg.NATSConnectRetryDelay = 50
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.s.Start() }()
+
+	assertReadyStatus(t, s, http.StatusServiceUnavailable)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected server to start, but got error: %s", err)
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected server to start, but it did not")
+	}
+	defer teardown(s)
+
+	assertReadyStatus(t, s, http.StatusOK)
+}
+
+// Test that stopping the server while waiting to retry connecting to NATS
+// cancels the wait
+func TestNATSConnectRetries_StopWhileWaitingToRetry_CancelsStart(t *testing.T) {
+	s := newSessionWithFailedConnects(t, 1, func(cfg *server.Config) {
+		cfg.NATSConnectRetries = 1
+		cfg.NATSConnectRetryDelay = 60000
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.s.Start() }()
+
+	for i := 0; s.ConnectAttempts() == 0; i++ {
+		if i >= 1000 {
+			t.Fatal("expected a connect attempt, but got none")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.s.Stop(nil)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected server to fail starting, but got no error")
+		}
+	case <-time.After(timeoutSeconds * time.Second):
+		t.Fatal("expected stop to cancel the connect retry wait, but it did not")
+	}
+	if n := s.ConnectAttempts(); n != 1 {
+		t.Errorf("expected 1 connect attempt, but got %d", n)
+	}
+	assertReadyStatus(t, s, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a drifted cached model converges once the max cache age is
+// reached, sending change events for the differences
+func TestMaxCacheAge_DriftedModel_SendsChangeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		// Respond to the get request with a drifted model
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"new":12}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar","null":{"action":"delete"},"new":12}}`))
+
+		// Respond to the next get request without any differences
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"new":12}}`))
+		c.AssertNoEvent(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.MaxCacheAge = map[string]int{"test.model": 50}
+	})
+}
+
+// Test that a drifted cached collection converges once the max cache age is
+// reached, sending add and remove events for the differences
+func TestMaxCacheAge_DriftedCollection_SendsAddRemoveEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestCollection(t, s, c)
+
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.collection").
+			RespondSuccess(json.RawMessage(`{"collection":["foo",42,true,"bar"]}`))
+		c.GetEvent(t).Equals(t, "test.collection.remove", json.RawMessage(`{"idx":3}`))
+		c.GetEvent(t).Equals(t, "test.collection.add", json.RawMessage(`{"idx":3,"value":"bar"}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxCacheAge = map[string]int{"test.collection": 50}
+	})
+}
+
+// Test that cached query resources are fetched again once the max cache age
+// is reached
+func TestMaxCacheAge_QueryModel_SendsChangeEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestQueryModel(t, s, c, "q=foo", "q=foo")
+
+		s.GetRequest(t).
+			AssertSubject(t, "get.test.model").
+			AssertPathPayload(t, "query", "q=foo").
+			RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null},"query":"q=foo"}`))
+		c.GetEvent(t).Equals(t, "test.model?q=foo.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxCacheAge = map[string]int{"test.model": 50}
+	})
+}
+
+// Test that resources not matching any max cache age pattern are not fetched
+// again
+func TestMaxCacheAge_NonMatchingResource_NotFetchedAgain(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		time.Sleep(100 * time.Millisecond)
+		c.AssertNoNATSRequest(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.MaxCacheAge = map[string]int{"test.collection": 50}
+	})
+}
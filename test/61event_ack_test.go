@@ -0,0 +1,158 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withAckEvents(cfg *server.Config) {
+	cfg.AckEvents = []string{"test.model"}
+}
+
+// connectWithToken makes a new connection, sets the token, and requests an
+// ack key for redelivery of unacknowledged events from the previous key.
+// Returns the ack key of the new connection.
+func connectWithToken(t *testing.T, s *Session, token string, key string) (*Conn, string) {
+	c := s.Connect()
+	cid := getCID(t, s, c)
+	s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+token+`}`))
+	var params interface{}
+	if key != "" {
+		params = json.RawMessage(`{"key":"` + key + `"}`)
+	}
+	result := c.Request("redeliver", params).GetResponse(t).Result.(map[string]interface{})
+	k, _ := result["key"].(string)
+	if k == "" || k == key {
+		t.Fatalf("expected a new ack key, but got: %#v", result)
+	}
+	return c, k
+}
+
+// subscribeWithToken makes a successful subscription to test.model, asserting
+// the token is sent on the access request.
+func subscribeWithToken(t *testing.T, s *Session, c *Conn, token string) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").
+		AssertPathPayload(t, "token", json.RawMessage(token)).
+		RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+}
+
+// Test that events on resources matching an ackEvents pattern are sent with
+// an ack ID, while other events are not
+func TestAckEvents_MatchingResource_SendsAckID(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`)).AssertAck(t, 1)
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent()).AssertAck(t, 2)
+		s.ResourceEvent("test.collection", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.collection.custom", common.CustomEvent()).AssertAck(t, 0)
+
+		c.Request("ack", json.RawMessage(`{"ids":[1,2]}`)).GetResponse(t).AssertResult(t, nil)
+	}, withAckEvents)
+}
+
+// Test that an ack request without ids responds with an invalid params error
+func TestAckEvents_AckWithoutIDs_RespondsWithInvalidParams(t *testing.T) {
+	tbl := []struct {
+		Params json.RawMessage
+	}{
+		{nil},
+		{json.RawMessage(`{}`)},
+		{json.RawMessage(`{"ids":[]}`)},
+		{json.RawMessage(`{"ids":["foo"]}`)},
+	}
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			var params interface{}
+			if l.Params != nil {
+				params = l.Params
+			}
+			c.Request("ack", params).GetResponse(t).AssertError(t, reserr.ErrInvalidParams)
+		}, withAckEvents)
+	}
+}
+
+// Test that unacknowledged events are redelivered on reconnect, while
+// acknowledged events are not
+func TestAckEvents_Reconnect_RedeliversUnackedEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, key := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).AssertAck(t, 1)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		c.GetEvent(t).AssertAck(t, 2)
+		c.Request("ack", json.RawMessage(`{"ids":[1]}`)).GetResponse(t)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, key2 := connectWithToken(t, s, token, key)
+		c2.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`)).AssertAck(t, 1)
+		c2.AssertNoEvent(t, "test.model")
+
+		// Redelivered events are tracked by the new connection
+		c2.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+		c3, _ := connectWithToken(t, s, token, key2)
+		c3.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`)).AssertAck(t, 1)
+		c3.Request("ack", json.RawMessage(`{"ids":[1]}`)).GetResponse(t)
+	}, withAckEvents)
+}
+
+// Test that unacknowledged events are not redelivered to a connection with
+// a different token
+func TestAckEvents_ReconnectWithDifferentToken_DoesNotRedeliver(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c, key := connectWithToken(t, s, `{"user":"foo"}`, "")
+		subscribeWithToken(t, s, c, `{"user":"foo"}`)
+
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).AssertAck(t, 1)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, _ := connectWithToken(t, s, `{"user":"bar"}`, key)
+		c2.AssertNoEvent(t, "test.model")
+	}, withAckEvents)
+}
+
+// Test that unacknowledged events are dropped once redelivered the maximum
+// number of times
+func TestAckEvents_RedeliveryLimitReached_DropsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, key := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).AssertAck(t, 1)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, key2 := connectWithToken(t, s, token, key)
+		c2.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent()).AssertAck(t, 1)
+		c2.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c3, _ := connectWithToken(t, s, token, key2)
+		c3.AssertNoEvent(t, "test.model")
+	}, withAckEvents, func(cfg *server.Config) {
+		cfg.AckRedeliveries = 1
+	})
+}
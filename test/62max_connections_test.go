@@ -0,0 +1,75 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/posener/wstest"
+	"github.com/resgateio/resgate/server"
+)
+
+// assertConnectRejected asserts that a WebSocket upgrade is rejected with
+// 503 Service Unavailable and a Retry-After header.
+func assertConnectRejected(t *testing.T, s *Session) {
+	d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+	ws, resp, err := d.Dial("ws://example.org/", nil)
+	if err == nil {
+		ws.Close()
+		t.Fatal("expected connection to be rejected, but it was upgraded")
+	}
+	if resp == nil {
+		t.Fatalf("expected a HTTP response, but got error: %s", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status code %d, but got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "5" {
+		t.Errorf("expected Retry-After header to be %#v, but got %#v", "5", v)
+	}
+}
+
+// tryConnect makes a new connection, returning nil if it was rejected.
+func tryConnect(s *Session) *Conn {
+	d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+	ws, _, err := d.Dial("ws://example.org/", nil)
+	if err != nil {
+		return nil
+	}
+	conn := NewConn(s, d, ws, make(chan *ClientEvent, 256))
+	s.conns[conn] = struct{}{}
+	return conn
+}
+
+// Test that WebSocket connections exceeding the max connections limit are
+// rejected, and that closed connections free up their slot
+func TestMaxConnections_LimitReached_RejectsConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		s.Connect()
+		assertConnectRejected(t, s)
+
+		c.Disconnect()
+		c.AssertClosed(t)
+		var c3 *Conn
+		for i := 0; i < 100 && c3 == nil; i++ {
+			time.Sleep(10 * time.Millisecond)
+			c3 = tryConnect(s)
+		}
+		if c3 == nil {
+			t.Fatal("expected connection to be accepted after a connection was closed")
+		}
+		assertConnectRejected(t, s)
+	}, func(cfg *server.Config) {
+		cfg.MaxConnections = 2
+	})
+}
+
+// Test that a zero max connections limit accepts any number of connections
+func TestMaxConnections_ZeroLimit_AcceptsConnections(t *testing.T) {
+	runTest(t, func(s *Session) {
+		for i := 0; i < 10; i++ {
+			s.Connect()
+		}
+	})
+}
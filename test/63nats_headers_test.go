@@ -0,0 +1,121 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withNATSHeaders(cfg *server.Config) {
+	cfg.NATSHeaders = true
+}
+
+// Test that requests are sent with headers for the connection ID and token
+// sub claim, without altering the payload
+func TestNATSHeaders_CallRequest_SetsHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"sub":"user42","role":"admin"}}`))
+
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertHeader(t, server.HeaderCID, []string{cid}).
+			AssertHeader(t, server.HeaderTokenSub, []string{"user42"}).
+			AssertPathPayload(t, "cid", cid).
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertHeader(t, server.HeaderCID, []string{cid}).
+			AssertHeader(t, server.HeaderTokenSub, []string{"user42"}).
+			AssertPathPayload(t, "token", json.RawMessage(`{"sub":"user42","role":"admin"}`)).
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, withNATSHeaders)
+}
+
+// Test that the token sub header is not set for tokens without a string sub
+// claim
+func TestNATSHeaders_TokenWithoutSub_OmitsTokenSubHeader(t *testing.T) {
+	tbl := []struct {
+		Token string
+	}{
+		{`null`},
+		{`{"user":"foo"}`},
+		{`{"sub":42}`},
+		{`"foo"`},
+	}
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			cid := getCID(t, s, c)
+			s.ConnEvent(cid, "token", json.RawMessage(`{"token":`+l.Token+`}`))
+
+			creq := c.Request("auth.test.model.method", nil)
+			s.GetRequest(t).
+				AssertSubject(t, "auth.test.model.method").
+				AssertHeader(t, server.HeaderCID, []string{cid}).
+				AssertHeader(t, server.HeaderTokenSub, nil).
+				RespondSuccess(nil)
+			creq.GetResponse(t)
+		}, withNATSHeaders)
+	}
+}
+
+// Test that forwarded HTTP headers are set as NATS headers, while other HTTP
+// headers are not
+func TestNATSHeaders_WithForwardHeaders_SetsForwardedHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("X-Correlation-ID", "abc123")
+			r.Header.Set("Authorization", "Bearer secret")
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertHeader(t, "X-Correlation-Id", []string{"abc123"}).
+			AssertHeader(t, "Authorization", nil).
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertHeader(t, "X-Correlation-Id", []string{"abc123"}).
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, withNATSHeaders, func(cfg *server.Config) {
+		cfg.ForwardHeaders = []string{"X-Correlation-ID"}
+	})
+}
+
+// Test that get requests, without any request metadata, are sent without
+// headers
+func TestNATSHeaders_GetRequest_SetsNoHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		req := mreqs.GetRequest(t, "get.test.model")
+		if req.Header != nil {
+			t.Errorf("expected get request to have no headers, but got %#v", req.Header)
+		}
+		req.RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+	}, withNATSHeaders)
+}
+
+// Test that no headers are set by default
+func TestNATSHeaders_Default_SetsNoHeaders(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		req := s.GetRequest(t).AssertSubject(t, "access.test.model")
+		if req.Header != nil {
+			t.Errorf("expected access request to have no headers, but got %#v", req.Header)
+		}
+		req.RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that resource events received while the get request is pending are
+// discarded, or buffered and applied on top of the get response, depending on
+// the pendingGetEvents setting
+func TestPendingGetEvents_EventDuringGet_ExpectedResource(t *testing.T) {
+	tbl := []struct {
+		PendingGetEvents string
+		RID              string
+		Events           []string // Events sent mid-get, as event name and raw JSON payload pairs
+		Expected         string   // Expected resource in subscribe response (raw JSON)
+	}{
+		// Discarded by default
+		{"", "test.model", []string{"change", `{"values":{"string":"bar"}}`}, `{"string":"foo","int":42,"bool":true,"null":null}`},
+		{"discard", "test.model", []string{"change", `{"values":{"string":"bar"}}`}, `{"string":"foo","int":42,"bool":true,"null":null}`},
+		{"discard", "test.collection", []string{"add", `{"idx":1,"value":"bar"}`}, `["foo",42,true,null]`},
+		// Buffered and applied in order
+		{"buffer", "test.model", []string{"change", `{"values":{"string":"bar"}}`}, `{"string":"bar","int":42,"bool":true,"null":null}`},
+		{"buffer", "test.model", []string{"change", `{"values":{"string":"foo"}}`}, `{"string":"foo","int":42,"bool":true,"null":null}`},
+		{"buffer", "test.model", []string{"change", `{"values":{"string":"bar"}}`, "change", `{"values":{"string":"baz","int":12}}`}, `{"string":"baz","int":12,"bool":true,"null":null}`},
+		{"buffer", "test.model", []string{"change", `{"values":{"null":{"action":"delete"}}}`}, `{"string":"foo","int":42,"bool":true}`},
+		{"buffer", "test.collection", []string{"add", `{"idx":1,"value":"bar"}`}, `["foo","bar",42,true,null]`},
+		{"buffer", "test.collection", []string{"add", `{"idx":1,"value":"bar"}`, "remove", `{"idx":0}`}, `["bar",42,true,null]`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe."+l.RID, nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+			req := mreqs.GetRequest(t, "get."+l.RID)
+
+			// Send events while the get request is pending
+			for j := 0; j < len(l.Events); j += 2 {
+				s.ResourceEvent(l.RID, l.Events[j], json.RawMessage(l.Events[j+1]))
+			}
+
+			key := "models"
+			if l.RID == "test.collection" {
+				key = "collections"
+				req.RespondSuccess(json.RawMessage(`{"collection":` + resourceData(l.RID) + `}`))
+			} else {
+				req.RespondSuccess(json.RawMessage(`{"model":` + resourceData(l.RID) + `}`))
+			}
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"`+key+`":{"`+l.RID+`":`+l.Expected+`}}`))
+			c.AssertNoEvent(t, l.RID)
+		}, func(cfg *server.Config) {
+			cfg.PendingGetEvents = l.PendingGetEvents
+		})
+	}
+}
+
+// Test that events received after the get response, with buffered events,
+// are sent to the client
+func TestPendingGetEvents_BufferEventAfterGet_SendsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		req := mreqs.GetRequest(t, "get.test.model")
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		req.RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":{"string":"bar","int":42,"bool":true,"null":null}}}`))
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`))
+	}, func(cfg *server.Config) {
+		cfg.PendingGetEvents = "buffer"
+	})
+}
+
+// Test that buffered events are discarded if the get request fails
+func TestPendingGetEvents_BufferWithGetError_DiscardsEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		req := mreqs.GetRequest(t, "get.test.model")
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		req.RespondError(reserr.ErrNotFound)
+		creq.GetResponse(t).AssertError(t, reserr.ErrNotFound)
+		c.AssertNoEvent(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.PendingGetEvents = "buffer"
+	})
+}
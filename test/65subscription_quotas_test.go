@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withSubscriptionQuotas(cfg *server.Config) {
+	cfg.SubscriptionQuotas = map[string]int{
+		"test.report.>":       2,
+		"test.report.large.>": 1,
+	}
+}
+
+// subscribeToModel makes a successful direct subscription to a model with
+// the test model data.
+func subscribeToModel(t *testing.T, s *Session, c *Conn, rid string) {
+	model := resourceData("test.model")
+	creq := c.Request("subscribe."+rid, nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access."+rid).RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get."+rid).RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"`+rid+`":`+model+`}}`))
+}
+
+// Test that subscriptions exceeding a pattern quota fail, while resources
+// not matching any quota pattern are unlimited
+func TestSubscriptionQuotas_QuotaExceeded_RespondsWithLimitExceeded(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToModel(t, s, c, "test.report.a")
+		subscribeToModel(t, s, c, "test.report.b")
+		c.Request("subscribe.test.report.c", nil).GetResponse(t).AssertErrorCode(t, "system.subscriptionLimitExceeded")
+
+		for _, rid := range []string{"test.status.a", "test.status.b", "test.status.c"} {
+			subscribeToModel(t, s, c, rid)
+		}
+
+		// Subscribing again to an already subscribed resource is not limited
+		c.Request("subscribe.test.report.a", nil).GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.report.a":`+resourceData("test.model")+`}}`))
+	}, withSubscriptionQuotas)
+}
+
+// Test that each matching quota pattern is applied independently
+func TestSubscriptionQuotas_OverlappingPatterns_AppliesQuotasIndependently(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToModel(t, s, c, "test.report.large.a")
+		// Exceeds test.report.large.> but not test.report.>
+		c.Request("subscribe.test.report.large.b", nil).GetResponse(t).AssertErrorCode(t, "system.subscriptionLimitExceeded")
+		subscribeToModel(t, s, c, "test.report.a")
+		// Exceeds test.report.> but not test.report.large.>
+		c.Request("subscribe.test.report.b", nil).GetResponse(t).AssertErrorCode(t, "system.subscriptionLimitExceeded")
+	}, withSubscriptionQuotas)
+}
+
+// Test that quotas are counted per connection
+func TestSubscriptionQuotas_MultipleConnections_CountsPerConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		subscribeToModel(t, s, c1, "test.report.large.a")
+		c2 := s.Connect()
+		creq := c2.Request("subscribe.test.report.large.b", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.report.large.b").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.report.large.b").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.report.large.b":`+resourceData("test.model")+`}}`))
+	}, withSubscriptionQuotas)
+}
+
+// Test that unsubscribing frees up a slot of the quota
+func TestSubscriptionQuotas_Unsubscribe_FreesQuota(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToModel(t, s, c, "test.report.large.a")
+		c.Request("unsubscribe.test.report.large.a", nil).GetResponse(t)
+
+		creq := c.Request("subscribe.test.report.large.b", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.report.large.b").RespondSuccess(json.RawMessage(`{"get":true}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.report.large.b").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.report.large.b":`+resourceData("test.model")+`}}`))
+	}, withSubscriptionQuotas)
+}
+
+// Test that a failed subscription does not count against the quota
+func TestSubscriptionQuotas_FailedSubscribe_DoesNotCount(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.report.large.a", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.report.large.a").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.report.large.a").RespondError(reserr.ErrNotFound)
+		creq.GetResponse(t).AssertError(t, reserr.ErrNotFound)
+
+		subscribeToModel(t, s, c, "test.report.large.b")
+	}, withSubscriptionQuotas)
+}
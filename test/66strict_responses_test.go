@@ -0,0 +1,56 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withStrictResponses(cfg *server.Config) {
+	cfg.StrictResponses = true
+}
+
+// Test that a warning is logged for responses with fields not defined by the
+// RES-service protocol, while the response is handled as usual
+func TestStrictResponses_UnexpectedField_LogsWarning(t *testing.T) {
+	model := resourceData("test.model")
+	tbl := []struct {
+		StrictResponses bool
+		AccessResponse  string
+		GetResponse     string
+		ExpectedErrors  int
+	}{
+		{true, `{"result":{"get":true}}`, `{"result":{"model":` + model + `}}`, 0},
+		{true, `{"result":{"get":true,"cal":"*"}}`, `{"result":{"model":` + model + `}}`, 1},
+		{true, `{"result":{"get":true}}`, `{"result":{"model":` + model + `,"foo":"bar"}}`, 1},
+		{true, `{"result":{"get":true},"meta":{}}`, `{"result":{"model":` + model + `},"meta":{}}`, 2},
+		{false, `{"result":{"get":true,"cal":"*"}}`, `{"result":{"model":` + model + `,"foo":"bar"}}`, 0},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondRaw([]byte(l.AccessResponse))
+			mreqs.GetRequest(t, "get.test.model").RespondRaw([]byte(l.GetResponse))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+			s.AssertErrorsLogged(t, l.ExpectedErrors)
+		}, func(cfg *server.Config) {
+			cfg.StrictResponses = l.StrictResponses
+		})
+	}
+}
+
+// Test that a warning is logged for a call response with an unexpected field
+func TestStrictResponses_CallResponseWithUnexpectedField_LogsWarning(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(`{"result":{"foo":"bar"},"resrouce":{"rid":"test.model"}}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		s.AssertErrorsLogged(t, 1)
+	}, withStrictResponses)
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withResourceAliases(cfg *server.Config) {
+	cfg.ResourceAliases = map[string]string{
+		"test.old.*.*":  "test.new.*.item.*",
+		"test.legacy.>": "test.>",
+	}
+}
+
+// Test that requests for an alias resource are sent for the target
+// resource, with wildcards substituted, while the client gets the resource
+// by the requested resource ID
+func TestResourceAliases_Subscribe_GetsTargetResource(t *testing.T) {
+	model := resourceData("test.model")
+	tbl := []struct {
+		RID    string
+		Target string
+	}{
+		{"test.old.foo.bar", "test.new.foo.item.bar"},
+		{"test.legacy.model", "test.model"},
+		{"test.legacy.model.deep", "test.model.deep"},
+		{"test.model", "test.model"},
+		{"test.old.foo", "test.old.foo"},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe."+l.RID, nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.Target).RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get."+l.Target).RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"`+l.RID+`":`+model+`}}`))
+
+			// Events on the target resource are sent as events on the alias
+			s.ResourceEvent(l.Target, "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+			c.GetEvent(t).Equals(t, l.RID+".change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		}, withResourceAliases)
+	}
+}
+
+// Test that call requests for an alias resource are sent for the target
+// resource
+func TestResourceAliases_Call_SendsTargetCallRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.old.foo.bar.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.new.foo.item.bar").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.new.foo.item.bar.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+	}, withResourceAliases)
+}
+
+// Test that HTTP requests for an alias resource are sent for the target
+// resource
+func TestResourceAliases_HTTPGet_GetsTargetResource(t *testing.T) {
+	model := resourceData("test.model")
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/legacy/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hreq.GetResponse(t).Equals(t, 200, json.RawMessage(model))
+	}, withResourceAliases)
+}
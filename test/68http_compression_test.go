@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// testModelSize is the size in bytes of test.model's HTTP response body.
+var testModelSize = len(resourceData("test.model"))
+
+// httpGetTestModel makes a HTTP GET request for test.model, with the
+// Accept-Encoding header set unless empty, and returns the response.
+func httpGetTestModel(t *testing.T, s *Session, acceptEncoding string) *HTTPResponse {
+	hreq := s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+	})
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	return hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+}
+
+// assertGzipBody asserts that the response is gzip encoded, and that the
+// decompressed body is the expected JSON value.
+func assertGzipBody(t *testing.T, hr *HTTPResponse, expected json.RawMessage) {
+	if v := hr.Header().Get("Content-Encoding"); v != "gzip" {
+		t.Fatalf("expected Content-Encoding header to be %#v, but got %#v", "gzip", v)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(hr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("expected gzip encoded body, but got error: %s", err)
+	}
+	b, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("expected gzip encoded body, but got error: %s", err)
+	}
+	var ev, bv interface{}
+	if err := json.Unmarshal(expected, &ev); err != nil {
+		panic("test: error unmarshaling expected body: " + err.Error())
+	}
+	if err := json.Unmarshal(b, &bv); err != nil || !reflect.DeepEqual(ev, bv) {
+		t.Fatalf("expected decompressed body to be:\n%s\nbut got:\n%s", expected, b)
+	}
+}
+
+// Test that responses are compressed only if no smaller than the minimum
+// size, for both the default setting and any pattern override
+func TestHTTPCompression_MinSizeBoundary_CompressesAtMinSize(t *testing.T) {
+	tbl := []struct {
+		MinSize         int
+		OverrideMinSize int
+		Compressed      bool
+	}{
+		{testModelSize - 1, 0, true},
+		{testModelSize, 0, true},
+		{testModelSize + 1, 0, false},
+		{0, 0, false}, // Default min size of 1024 bytes
+		{testModelSize + 1, testModelSize, true},
+		{testModelSize, testModelSize + 1, false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hr := httpGetTestModel(t, s, "gzip, deflate")
+			if v := hr.Header().Get("Vary"); v != "Accept-Encoding" {
+				t.Errorf("expected Vary header to be %#v, but got %#v", "Accept-Encoding", v)
+			}
+			if l.Compressed {
+				assertGzipBody(t, hr, json.RawMessage(resourceData("test.model")))
+			} else {
+				hr.AssertBody(t, json.RawMessage(resourceData("test.model")))
+				hr.AssertMissingHeaders(t, []string{"Content-Encoding"})
+			}
+		}, func(cfg *server.Config) {
+			cfg.HTTPCompression = true
+			cfg.HTTPCompressionMinSize = l.MinSize
+			if l.OverrideMinSize != 0 {
+				cfg.HTTPCompressionOverrides = map[string]server.HTTPCompressionOverride{
+					"test.>": {MinSize: l.OverrideMinSize},
+				}
+			}
+		})
+	}
+}
+
+// Test that responses are compressed only if the request accepts gzip
+// encoding, and if compression is enabled
+func TestHTTPCompression_AcceptEncoding_CompressesIfAccepted(t *testing.T) {
+	tbl := []struct {
+		HTTPCompression bool
+		AcceptEncoding  string
+		Compressed      bool
+	}{
+		{true, "gzip", true},
+		{true, "deflate, GZIP;q=0.5", true},
+		{true, "*", true},
+		{true, "", false},
+		{true, "deflate", false},
+		{true, "gzip;q=0", false},
+		{false, "gzip", false},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hr := httpGetTestModel(t, s, l.AcceptEncoding)
+			if l.Compressed {
+				assertGzipBody(t, hr, json.RawMessage(resourceData("test.model")))
+			} else {
+				hr.AssertBody(t, json.RawMessage(resourceData("test.model")))
+				hr.AssertMissingHeaders(t, []string{"Content-Encoding"})
+			}
+		}, func(cfg *server.Config) {
+			cfg.HTTPCompression = l.HTTPCompression
+			cfg.HTTPCompressionMinSize = 1
+		})
+	}
+}
+
+// Test that the configured compression level, or a pattern override, is used
+func TestHTTPCompression_Level_UsesConfiguredLevel(t *testing.T) {
+	for _, level := range []int{1, 9} {
+		runTest(t, func(s *Session) {
+			hr := httpGetTestModel(t, s, "gzip")
+			assertGzipBody(t, hr, json.RawMessage(resourceData("test.model")))
+			// The gzip header's XFL byte is 2 for best compression and 4 for
+			// best speed
+			xfl := map[int]byte{1: 4, 9: 2}[level]
+			if b := hr.Body.Bytes(); len(b) < 10 || b[8] != xfl {
+				t.Errorf("expected gzip header to indicate compression level %d", level)
+			}
+		}, func(cfg *server.Config) {
+			cfg.HTTPCompression = true
+			cfg.HTTPCompressionMinSize = 1
+			cfg.HTTPCompressionOverrides = map[string]server.HTTPCompressionOverride{
+				"test.model": {Level: level},
+			}
+		})
+	}
+}
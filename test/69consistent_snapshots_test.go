@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a child event received during the initial subscribe of the
+// parent is buffered, and sent only after the snapshot of both resources
+func TestConsistentSnapshots_ChildEventDuringSubscribe_BuffersEvent(t *testing.T) {
+	tbl := []struct {
+		ConsistentSnapshots bool
+		RID                 string
+		ChildRID            string
+		Event               string
+		Payload             string
+	}{
+		{false, "test.model.parent", "test.model", "change", `{"values":{"string":"bar"}}`},
+		{true, "test.model.parent", "test.model", "change", `{"values":{"string":"bar"}}`},
+		{true, "test.collection.parent", "test.collection", "add", `{"idx":1,"value":"bar"}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			key := "models"
+			get := func(rid string) json.RawMessage {
+				return json.RawMessage(`{"model":` + resourceData(rid) + `}`)
+			}
+			if l.ChildRID == "test.collection" {
+				key = "collections"
+				get = func(rid string) json.RawMessage {
+					return json.RawMessage(`{"collection":` + resourceData(rid) + `}`)
+				}
+			}
+
+			c := s.Connect()
+			creq := c.Request("subscribe."+l.RID, nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(get(l.RID))
+			s.GetRequest(t).AssertSubject(t, "get."+l.ChildRID).RespondSuccess(get(l.ChildRID))
+
+			// Send a child event, after the child snapshot is taken, with the
+			// parent's access request pending
+			time.Sleep(20 * time.Millisecond)
+			s.ResourceEvent(l.ChildRID, l.Event, json.RawMessage(l.Payload))
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(`{"get":true}`))
+
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"`+key+`":{"`+l.RID+`":`+resourceData(l.RID)+`,"`+l.ChildRID+`":`+resourceData(l.ChildRID)+`}}`))
+			c.GetEvent(t).Equals(t, l.ChildRID+"."+l.Event, json.RawMessage(l.Payload))
+		}, func(cfg *server.Config) {
+			cfg.ConsistentSnapshots = l.ConsistentSnapshots
+		})
+	}
+}
+
+// Test that child events received during a batch subscribe are sent only
+// after the snapshot of all subscribed resources
+func TestConsistentSnapshots_ChildEventDuringBatchSubscribe_BuffersEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		c := s.Connect()
+		creq := c.Request("subscribe", json.RawMessage(`{"rids":["test.model.parent","test.model.secondparent"]}`))
+		mreqs := s.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "get.test.model.parent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.parent") + `}`))
+		mreqs.GetRequest(t, "get.test.model.secondparent").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.secondparent") + `}`))
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		time.Sleep(20 * time.Millisecond)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		mreqs.GetRequest(t, "access.test.model.parent").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "access.test.model.secondparent").RespondSuccess(json.RawMessage(`{"get":true}`))
+
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model.parent":`+resourceData("test.model.parent")+`,"test.model.secondparent":`+resourceData("test.model.secondparent")+`,"test.model":`+model+`}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.AssertNoEvent(t, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.ConsistentSnapshots = true
+	})
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// assertDisconnectLogged asserts that a disconnect log entry matching the
+// pattern is logged within a second.
+func assertDisconnectLogged(t *testing.T, s *Session, pattern string) {
+	re := regexp.MustCompile(pattern)
+	for i := 0; i < 100; i++ {
+		if re.MatchString(s.String()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected log to match %#v, but got:\n%s", pattern, s.String())
+}
+
+// Test that closed connections are logged with the disconnect reason,
+// duration, and subscription count, at the configured level
+func TestDisconnectLog_ClientClose_LogsReason(t *testing.T) {
+	tbl := []struct {
+		DisconnectLog string
+		Level         string
+	}{
+		{"info", "INF"},
+		{"debug", "DBG"},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			subscribeToTestModel(t, s, c)
+			c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			c.AssertClosed(t)
+			assertDisconnectLogged(t, s, `\[`+l.Level+`\] \[\w+\] Disconnected: client close \(1000\) after \S+ with 1 subscription\(s\)`)
+		}, func(cfg *server.Config) {
+			cfg.DisconnectLog = l.DisconnectLog
+		})
+	}
+}
+
+// Test that a connection closed by the server is logged with the reason for
+// closing it
+func TestDisconnectLog_ServerClose_LogsReason(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		for i := 0; i < 2; i++ {
+			c.SendRaw([]byte(`{"method":`))
+		}
+		c.AssertClosedWithCode(t, websocket.CloseProtocolError)
+		assertDisconnectLogged(t, s, `Disconnected: Exceeded 1 consecutive malformed messages after \S+ with 0 subscription\(s\)`)
+	}, func(cfg *server.Config) {
+		cfg.DisconnectLog = "info"
+		cfg.MaxProtocolErrors = 1
+	})
+}
+
+// Test that closed connections are not logged by default
+func TestDisconnectLog_Default_LogsNothing(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c.Disconnect()
+		c.AssertClosed(t)
+		time.Sleep(50 * time.Millisecond)
+		if regexp.MustCompile(`\[(INF|DBG)\] \[\w+\] Disconnected:`).MatchString(s.String()) {
+			t.Fatalf("expected no disconnect log entry, but got:\n%s", s.String())
+		}
+	})
+}
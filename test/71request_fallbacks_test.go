@@ -0,0 +1,71 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withRequestFallbacks(cfg *server.Config) {
+	cfg.RequestFallbacks = map[string]string{
+		"test.>": "test.fallback.>",
+	}
+}
+
+// Test that an auth request timing out on the primary resource is resent
+// for the fallback resource
+func TestRequestFallbacks_AuthPrimaryTimeout_FallbackResponds(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("auth.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.method").Timeout()
+		s.GetRequest(t).AssertSubject(t, "auth.test.fallback.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+	}, withRequestFallbacks)
+}
+
+// Test that an access request timing out on the primary resource is resent
+// for the fallback resource
+func TestRequestFallbacks_AccessPrimaryTimeout_FallbackResponds(t *testing.T) {
+	model := resourceData("test.model")
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		mreqs.GetRequest(t, "access.test.model").Timeout()
+		s.GetRequest(t).AssertSubject(t, "access.test.fallback.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+	}, withRequestFallbacks)
+}
+
+// Test that a timeout of both the primary and fallback request responds with
+// a timeout error
+func TestRequestFallbacks_FallbackTimeout_RespondsWithTimeout(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("auth.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.method").Timeout()
+		s.GetRequest(t).AssertSubject(t, "auth.test.fallback.model.method").Timeout()
+		creq.GetResponse(t).AssertError(t, reserr.ErrTimeout)
+	}, withRequestFallbacks)
+}
+
+// Test that requests are not resent for the fallback resource on errors other
+// than timeouts, or for other request types
+func TestRequestFallbacks_NonTimeoutOrOtherRequest_NoFallback(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("auth.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "auth.test.model.method").RespondError(reserr.ErrAccessDenied)
+		creq.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+
+		creq = c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").Timeout()
+		creq.GetResponse(t).AssertError(t, reserr.ErrTimeout)
+		c.AssertNoNATSRequest(t, "test.fallback.model")
+	}, withRequestFallbacks)
+}
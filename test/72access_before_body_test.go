@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// unreadBody is a request body that fails the test if read.
+type unreadBody struct {
+	t *testing.T
+}
+
+func (b unreadBody) Read(p []byte) (int, error) {
+	b.t.Error("expected request body not to be read, but it was")
+	return 0, errors.New("body read")
+}
+
+func (b unreadBody) Close() error { return nil }
+
+func withAccessBeforeBody(cfg *server.Config) {
+	cfg.AccessBeforeBody = true
+}
+
+// Test that a HTTP POST denied call access responds with access denied
+// without reading the request body
+func TestAccessBeforeBody_CallDenied_DoesNotReadBody(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Body = unreadBody{t}
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusUnauthorized, reserr.ErrAccessDenied)
+	}, withAccessBeforeBody)
+}
+
+// Test that a HTTP POST granted call access reads the request body and sends
+// it as call params, with only a single access request
+func TestAccessBeforeBody_CallGranted_SendsParams(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(`{"foo":"bar"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "params", json.RawMessage(`{"foo":"bar"}`)).
+			RespondSuccess(json.RawMessage(`{"zoo":"baz"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"zoo":"baz"}`))
+	}, withAccessBeforeBody)
+}
+
+// Test that a HTTP POST granted call access with an invalid body responds with
+// an error without sending the call request
+func TestAccessBeforeBody_CallGrantedWithInvalidBody_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(`{"foo":`))
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusBadRequest).
+			AssertErrorCode(t, reserr.CodeBadRequest)
+	}, withAccessBeforeBody)
+}
+
+// Test that the request body is read before the access check by default
+func TestAccessBeforeBody_Default_ReadsBodyFirst(t *testing.T) {
+	runTest(t, func(s *Session) {
+		read := false
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Body = ioutil.NopCloser(readFunc(func(p []byte) (int, error) {
+				read = true
+				return 0, io.EOF
+			}))
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusUnauthorized, reserr.ErrAccessDenied)
+		if !read {
+			t.Error("expected request body to be read, but it was not")
+		}
+	})
+}
+
+// readFunc is a function implementing io.Reader.
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
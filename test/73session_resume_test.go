@@ -0,0 +1,232 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withResumeTTL(cfg *server.Config) {
+	cfg.ResumeTTL = 200
+}
+
+// requestResumeToken sends a resume request with the token of a previous
+// session, and returns the result with the resume token of the connection.
+func requestResumeToken(t *testing.T, c *Conn, token string) map[string]interface{} {
+	var params interface{}
+	if token != "" {
+		params = json.RawMessage(`{"token":"` + token + `"}`)
+	}
+	result := c.Request("resume", params).GetResponse(t).Result.(map[string]interface{})
+	if k, _ := result["token"].(string); len(k) != 64 || k == token {
+		t.Fatalf("expected a new resume token, but got: %#v", result)
+	}
+	return result
+}
+
+// Test that a resume request without a token responds with a new resume
+// token, unique for each connection
+func TestSessionResume_NoToken_RespondsWithToken(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		r1 := requestResumeToken(t, c, "")
+		r2 := requestResumeToken(t, c, "")
+		if r1["token"] != r2["token"] {
+			t.Errorf("expected the same resume token for the connection, but got %#v and %#v", r1["token"], r2["token"])
+		}
+		if r1["resumed"] != false {
+			t.Errorf("expected resumed to be false, but got: %#v", r1["resumed"])
+		}
+		r3 := requestResumeToken(t, s.Connect(), "")
+		if r1["token"] == r3["token"] {
+			t.Errorf("expected a unique resume token for each connection, but got %#v twice", r1["token"])
+		}
+	}, withResumeTTL)
+}
+
+// Test that resuming a session restores the token and resubscribes to the
+// direct subscriptions of the previous connection
+func TestSessionResume_WithToken_RestoresSession(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, _ := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+		key := requestResumeToken(t, c, "")["token"].(string)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2 := s.Connect()
+		creq := c2.Request("resume", json.RawMessage(`{"token":"`+key+`"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "token", json.RawMessage(token)).
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		result := creq.GetResponse(t).Result.(map[string]interface{})
+		if result["resumed"] != true {
+			t.Fatalf("expected resumed to be true, but got: %#v", result)
+		}
+		if _, ok := result["models"].(map[string]interface{})["test.model"]; !ok {
+			t.Errorf("expected test.model in result, but got: %#v", result)
+		}
+
+		// Subscription is restored
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c2.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+
+		// Token is restored
+		c2.Request("call.test.foo.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.foo").
+			AssertPathPayload(t, "token", json.RawMessage(token))
+	}, withResumeTTL)
+}
+
+// Test that events missed while disconnected are not replayed on resume, but
+// that the resumed subscriptions are resynchronized with the current state
+func TestSessionResume_MissedEvents_ResyncsResources(t *testing.T) {
+	runTest(t, func(s *Session) {
+		// Keep the resource cached with another connection
+		c3 := s.Connect()
+		subscribeToTestModel(t, s, c3)
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+		key := requestResumeToken(t, c, "")["token"].(string)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c3.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		c2 := s.Connect()
+		creq = c2.Request("resume", json.RawMessage(`{"token":"`+key+`"}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		cresp := creq.GetResponse(t)
+		cresp.AssertResult(t, json.RawMessage(`{"token":"`+cresp.Result.(map[string]interface{})["token"].(string)+`","resumed":true,"models":{"test.model":{"string":"bar","int":42,"bool":true,"null":null}}}`))
+		c2.AssertNoEvent(t, "test.model")
+	}, withResumeTTL)
+}
+
+// Test that a session may only be resumed once
+func TestSessionResume_ResumedTwice_DoesNotResume(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		key := requestResumeToken(t, c, "")["token"].(string)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		if r := requestResumeToken(t, s.Connect(), key); r["resumed"] != true {
+			t.Errorf("expected resumed to be true, but got: %#v", r)
+		}
+		if r := requestResumeToken(t, s.Connect(), key); r["resumed"] != false {
+			t.Errorf("expected resumed to be false, but got: %#v", r)
+		}
+	}, withResumeTTL)
+}
+
+// Test that a session is not resumed after the resumeTTL has passed
+func TestSessionResume_Expired_DoesNotResume(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		key := requestResumeToken(t, c, "")["token"].(string)
+		c.Disconnect()
+		time.Sleep(300 * time.Millisecond)
+
+		c2 := s.Connect()
+		if r := requestResumeToken(t, c2, key); r["resumed"] != false {
+			t.Errorf("expected resumed to be false, but got: %#v", r)
+		}
+		c2.AssertNoNATSRequest(t, "test.model")
+	}, withResumeTTL)
+}
+
+// Test that an unknown resume token is not resumed
+func TestSessionResume_UnknownToken_DoesNotResume(t *testing.T) {
+	runTest(t, func(s *Session) {
+		r := requestResumeToken(t, s.Connect(), "0123456789abcdef")
+		if r["resumed"] != false {
+			t.Errorf("expected resumed to be false, but got: %#v", r)
+		}
+	}, withResumeTTL)
+}
+
+// Test that resume requests are rejected by default
+func TestSessionResume_Default_RespondsWithInvalidRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.Connect().Request("resume", nil).GetResponse(t).AssertError(t, reserr.ErrInvalidRequest)
+	})
+}
+
+// Test that a reconnecting client whose unacknowledged events have fallen
+// off the ack buffer gets a resync event instead of a partial redelivery
+func TestSessionResume_AckBufferExceeded_SendsResync(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, key := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+
+		for i := 1; i <= 3; i++ {
+			s.ResourceEvent("test.model", "custom", common.CustomEvent())
+			c.GetEvent(t).AssertAck(t, uint64(i))
+		}
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, _ := connectWithToken(t, s, token, key)
+		c2.GetEvent(t).Equals(t, "resync", json.RawMessage(`{"rids":["test.model"]}`))
+		c2.AssertNoEvent(t, "test.model")
+	}, withAckEvents, func(cfg *server.Config) {
+		cfg.AckBufferSize = map[string]int{"test.model": 2}
+	})
+}
+
+// Test that a reconnecting client whose unacknowledged events are older than
+// the ack buffer TTL gets a resync event instead of a redelivery
+func TestSessionResume_AckBufferTTLExceeded_SendsResync(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, key := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).AssertAck(t, 1)
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, _ := connectWithToken(t, s, token, key)
+		c2.GetEvent(t).Equals(t, "resync", json.RawMessage(`{"rids":["test.model"]}`))
+		c2.AssertNoEvent(t, "test.model")
+	}, withAckEvents, func(cfg *server.Config) {
+		cfg.AckBufferTTL = map[string]int{"test.>": 20}
+	})
+}
+
+// Test that a reconnecting client whose unacknowledged events are within the
+// ack buffer limits gets the events redelivered without any resync
+func TestSessionResume_WithinAckBuffer_RedeliversEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		token := `{"user":"foo"}`
+		c, key := connectWithToken(t, s, token, "")
+		subscribeWithToken(t, s, c, token)
+
+		for i := 1; i <= 2; i++ {
+			s.ResourceEvent("test.model", "custom", common.CustomEvent())
+			c.GetEvent(t).AssertAck(t, uint64(i))
+		}
+		c.Disconnect()
+		time.Sleep(50 * time.Millisecond)
+
+		c2, _ := connectWithToken(t, s, token, key)
+		c2.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent()).AssertAck(t, 1)
+		c2.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent()).AssertAck(t, 2)
+		c2.AssertNoEvent(t, "test.model")
+	}, withAckEvents, func(cfg *server.Config) {
+		cfg.AckBufferSize = map[string]int{"test.model": 2}
+		cfg.AckBufferTTL = map[string]int{"test.>": 60000}
+	})
+}
@@ -0,0 +1,94 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withMaxCallTimeout(cfg *server.Config) {
+	cfg.MaxCallTimeout = 10000
+}
+
+// Test that a call request uses the timeout suggested by the access response,
+// bounded by the maxCallTimeout setting
+func TestCallTimeout_AccessWithTimeout_UsesTimeout(t *testing.T) {
+	tbl := []struct {
+		MaxCallTimeout int
+		Access         string
+		Expected       time.Duration
+	}{
+		{10000, `{"call":"*","timeout":5000}`, 5000 * time.Millisecond},
+		{10000, `{"call":"*","timeout":20000}`, 10000 * time.Millisecond},
+		{10000, `{"call":"*","timeout":0}`, 0},
+		{10000, `{"call":"*","timeout":-1}`, 0},
+		{10000, `{"call":"*"}`, 0},
+		{0, `{"call":"*","timeout":5000}`, 0},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(l.Access))
+			s.GetRequest(t).
+				AssertSubject(t, "call.test.model.method").
+				AssertTimeout(t, l.Expected).
+				RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+		}, func(cfg *server.Config) {
+			cfg.MaxCallTimeout = l.MaxCallTimeout
+		})
+	}
+}
+
+// Test that a slow call, responding after the default request timeout,
+// succeeds within the raised timeout
+func TestCallTimeout_SlowCallWithinTimeout_Succeeds(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*","timeout":8000}`))
+		req := s.GetRequest(t).AssertSubject(t, "call.test.model.method")
+		if req.TimeoutDuration <= RequestTimeout {
+			t.Fatalf("expected timeout to be raised above %s, but got %s", RequestTimeout, req.TimeoutDuration)
+		}
+		time.Sleep(50 * time.Millisecond)
+		req.RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"payload":{"foo":"bar"}}`))
+	}, withMaxCallTimeout)
+}
+
+// Test that the deadline of a call request reflects the raised timeout
+func TestCallTimeout_WithRequestDeadline_DeadlineReflectsTimeout(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*","timeout":8000}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "deadline", 8000).
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, withMaxCallTimeout, func(cfg *server.Config) {
+		cfg.RequestDeadline = true
+	})
+}
+
+// Test that a HTTP POST call request uses the timeout suggested by the access
+// response
+func TestCallTimeout_HTTPPost_UsesTimeout(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*","timeout":5000}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertTimeout(t, 5000*time.Millisecond).
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, withMaxCallTimeout)
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// fieldErrorData is structured error data with field level details.
+var fieldErrorData = json.RawMessage(`{"fields":{"age":{"code":"range","max":150,"min":0},"name":{"code":"required","message":"Name is required"}}}`)
+
+// fieldError is a validation error with field level details.
+var fieldError = &reserr.Error{Code: reserr.CodeInvalidParams, Message: "Validation failed", Data: fieldErrorData}
+
+// fieldErrorBody is the expected HTTP response body of fieldError.
+var fieldErrorBody = json.RawMessage(`{"code":"system.invalidParams","message":"Validation failed","data":` + string(fieldErrorData) + `}`)
+
+// Test that a call error with structured data is responded with the data
+// preserved in the HTTP error response body
+func TestHTTPErrorData_CallErrorWithData_PreservesData(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", []byte(`{"name":""}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(fieldError)
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, fieldErrorBody)
+	})
+}
+
+// Test that a call error with structured data, on a mapped HTTP method, is
+// responded with the data preserved in the HTTP error response body
+func TestHTTPErrorData_MappedMethodErrorWithData_PreservesData(t *testing.T) {
+	method := "set"
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("PATCH", "/api/test/model", []byte(`{"name":""}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.set").RespondError(fieldError)
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, fieldErrorBody)
+	}, func(cfg *server.Config) {
+		cfg.PATCHMethod = &method
+	})
+}
+
+// Test that an access error with structured data is responded with the data
+// preserved in the HTTP error response body
+func TestHTTPErrorData_AccessErrorWithData_PreservesData(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondError(fieldError)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, fieldErrorBody)
+	})
+}
+
+// Test that a get error with structured data is responded with the data
+// preserved in the HTTP error response body
+func TestHTTPErrorData_GetErrorWithData_PreservesData(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(fieldError)
+		hreq.GetResponse(t).Equals(t, http.StatusBadRequest, fieldErrorBody)
+	})
+}
+
+// Test that a call error with structured data is sent with the data
+// preserved over WebSocket, consistent with HTTP
+func TestHTTPErrorData_WebSocketCallErrorWithData_PreservesData(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", json.RawMessage(`{"name":""}`))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(fieldError)
+		var data interface{}
+		json.Unmarshal(fieldErrorData, &data)
+		creq.GetResponse(t).AssertError(t, &reserr.Error{Code: fieldError.Code, Message: fieldError.Message, Data: data})
+	})
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withTenants(cfg *server.Config) {
+	cfg.TenantClaim = "org.id"
+	cfg.Tenants = []string{"acme", "globex"}
+}
+
+// Test that connections are counted by the tenant derived from the token,
+// with connections without a known tenant counted as other
+func TestTenantMetrics_TokenWithTenant_CountsConnectionByTenant(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="other"}`, "1")
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":"acme"}}}`))
+		c.Request("version", nil).GetResponse(t)
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="acme"}`, "1")
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="other"}`, "0")
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":"initech"}}}`))
+		c.Request("version", nil).GetResponse(t)
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="acme"}`, "0")
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="other"}`, "1")
+	}, withTenants)
+}
+
+// Test that client requests are counted by the tenant of the connection
+func TestTenantMetrics_Requests_CountedByTenant(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":"globex"}}}`))
+		c.Request("version", nil).GetResponse(t)
+		c.Request("version", nil).GetResponse(t)
+		s.AssertMetric(t, `resgate_tenant_requests_total{tenant="globex"}`, "2")
+	}, withTenants)
+}
+
+// Test that disconnected connections are no longer counted
+func TestTenantMetrics_Disconnect_RemovesConnection(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"org":{"id":"acme"}}}`))
+		s.Connect()
+		c.Request("version", nil).GetResponse(t)
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="acme"}`, "1")
+
+		c.Disconnect()
+		c.AssertClosed(t)
+		for i := 0; i < 100; i++ {
+			if strings.Contains(s.Metrics(), `resgate_tenant_connections{tenant="acme"} 0`) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="acme"}`, "0")
+		s.AssertMetric(t, `resgate_tenant_connections{tenant="other"}`, "1")
+	}, withTenants)
+}
+
+// Test that tenant metrics are not included by default
+func TestTenantMetrics_Default_NoTenantMetrics(t *testing.T) {
+	runTest(t, func(s *Session) {
+		s.Connect()
+		if strings.Contains(s.Metrics(), "resgate_tenant_") {
+			t.Fatalf("expected no tenant metrics, but got:\n%s", s.Metrics())
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withMetadataSuffix(cfg *server.Config) {
+	cfg.MetadataSuffix = "_meta"
+}
+
+// Test that a GET on the metadata suffix of a resource responds with the
+// resource type and callable methods, instead of the resource data
+func TestResourceMetadata_GetMetadata_RespondsWithMetadata(t *testing.T) {
+	tbl := []struct {
+		RID      string
+		Path     string
+		Access   string
+		Expected string
+	}{
+		{"test.model", "/api/test/model/_meta", `{"get":true,"call":"set,foo"}`, `{"rid":"test.model","type":"model","methods":["set","foo"]}`},
+		{"test.model", "/api/test/model/_meta", `{"get":true,"call":"*"}`, `{"rid":"test.model","type":"model","methods":["*"]}`},
+		{"test.model", "/api/test/model/_meta", `{"get":true}`, `{"rid":"test.model","type":"model","methods":[]}`},
+		{"test.collection", "/api/test/collection/_meta", `{"get":true,"call":"add,remove"}`, `{"rid":"test.collection","type":"collection","methods":["add","remove"]}`},
+		{"test.collection", "/api/test/collection/_meta", `{"get":true}`, `{"rid":"test.collection","type":"collection","methods":[]}`},
+	}
+
+	for _, l := range tbl {
+		runTest(t, func(s *Session) {
+			hreq := s.HTTPRequest("GET", l.Path, nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access."+l.RID).RespondSuccess(json.RawMessage(l.Access))
+			if l.RID == "test.collection" {
+				mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"collection":` + resourceData(l.RID) + `}`))
+			} else {
+				mreqs.GetRequest(t, "get."+l.RID).RespondSuccess(json.RawMessage(`{"model":` + resourceData(l.RID) + `}`))
+			}
+			hreq.GetResponse(t).
+				Equals(t, http.StatusOK, json.RawMessage(l.Expected)).
+				AssertHeaders(t, map[string]string{"X-RID": l.RID})
+		}, withMetadataSuffix)
+	}
+}
+
+// Test that resource metadata includes the schema matching the resource
+func TestResourceMetadata_WithSchema_IncludesSchema(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/_meta", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"set"}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"rid":"test.model","type":"model","methods":["set"],"schema":{"type":"object","properties":{"string":{"type":"string"}}}}`))
+	}, withMetadataSuffix, func(cfg *server.Config) {
+		cfg.ResourceSchemas = map[string]json.RawMessage{
+			"test.*":   json.RawMessage(`{"type":"object","properties":{"string":{"type":"string"}}}`),
+			"test.foo": json.RawMessage(`{"type":"array"}`),
+		}
+	})
+}
+
+// Test that resource metadata requires get access
+func TestResourceMetadata_NoGetAccess_RespondsWithAccessDenied(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/_meta", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"call":"*"}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).Equals(t, http.StatusUnauthorized, reserr.ErrAccessDenied)
+	}, withMetadataSuffix)
+}
+
+// Test that the metadata suffix is treated as part of the resource ID by
+// default
+func TestResourceMetadata_Default_GetsResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model/_meta", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model._meta").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model._meta").RespondSuccess(json.RawMessage(`{"model":{"foo":"bar"}}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	})
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// sendAuthRequests sends n auth requests on test.model, returning the client
+// requests and the pending service requests, in order.
+func sendAuthRequests(t *testing.T, s *Session, c *Conn, n int) ([]*ClientRequest, []*Request) {
+	creqs := make([]*ClientRequest, n)
+	for i := range creqs {
+		creqs[i] = c.Request(fmt.Sprintf("auth.test.model.method%d", i), nil)
+	}
+	mreqs := s.GetParallelRequests(t, n)
+	reqs := make([]*Request, n)
+	for i := range reqs {
+		reqs[i] = mreqs.GetRequest(t, fmt.Sprintf("auth.test.model.method%d", i))
+	}
+	return creqs, reqs
+}
+
+// respondAuthRequests responds to the service requests, and awaits the client
+// responses.
+func respondAuthRequests(t *testing.T, creqs []*ClientRequest, reqs []*Request) {
+	for i, req := range reqs {
+		req.RespondSuccess(nil)
+		creqs[i].GetResponse(t)
+	}
+}
+
+// Test that a backpressure event is sent when the number of pending client
+// requests reaches the backpressureRequests limit, and not before
+func TestBackpressure_PendingRequestsReachLimit_SendsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c, 2)
+		c.AssertNoEvent(t, "test.model")
+
+		creqs2, reqs2 := sendAuthRequests(t, s, c, 2)
+		c.GetEvent(t).Equals(t, "backpressure", json.RawMessage(`{"reason":"requests"}`))
+		respondAuthRequests(t, append(creqs, creqs2...), append(reqs, reqs2...))
+	}, func(cfg *server.Config) {
+		cfg.BackpressureRequests = 4
+	})
+}
+
+// Test that the backpressure event is sent again only after the pending
+// client requests have dropped to half the limit
+func TestBackpressure_PendingRequestsDropToHalf_SendsEventAgain(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c, 4)
+		c.GetEvent(t).Equals(t, "backpressure", json.RawMessage(`{"reason":"requests"}`))
+
+		// Dropping to three pending requests does not reset the event
+		respondAuthRequests(t, creqs[:1], reqs[:1])
+		creq := c.Request("auth.test.model.foo", nil)
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.model.foo")
+		c.AssertNoEvent(t, "test.model")
+		respondAuthRequests(t, []*ClientRequest{creq}, []*Request{req})
+
+		// Dropping to two pending requests resets the event
+		respondAuthRequests(t, creqs[1:2], reqs[1:2])
+		creqs2, reqs2 := sendAuthRequests(t, s, c, 2)
+		c.GetEvent(t).Equals(t, "backpressure", json.RawMessage(`{"reason":"requests"}`))
+		respondAuthRequests(t, append(creqs[2:], creqs2...), append(reqs[2:], reqs2...))
+	}, func(cfg *server.Config) {
+		cfg.BackpressureRequests = 4
+	})
+}
+
+// Test that no backpressure event is sent by default
+func TestBackpressure_Default_SendsNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c, 10)
+		c.AssertNoEvent(t, "test.model")
+		respondAuthRequests(t, creqs, reqs)
+	})
+}
+
+// Test that a backpressure event is sent to WebSocket clients when load
+// shedding starts, if backpressureLoadShed is set
+func TestBackpressure_LoadShedding_SendsEvent(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		runNamedTest(t, fmt.Sprintf("backpressureLoadShed=%v", enabled), func(s *Session) {
+			c := s.Connect()
+
+			// Respond slowly to requests
+			hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			time.Sleep(30 * time.Millisecond)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+
+			// Wait for the window to pass
+			time.Sleep(60 * time.Millisecond)
+			s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t).AssertStatusCode(t, http.StatusServiceUnavailable)
+			if enabled {
+				c.GetEvent(t).Equals(t, "backpressure", json.RawMessage(`{"reason":"loadShed","retryAfter":1}`))
+			} else {
+				c.AssertNoEvent(t, "test.model")
+			}
+		}, func(cfg *server.Config) {
+			cfg.LoadShedLatency = 10
+			cfg.LoadShedWindow = 50
+			cfg.LoadShedFraction = 1
+			cfg.BackpressureLoadShed = enabled
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withTrustedProxy(cfg *server.Config) {
+	cfg.AbsoluteURLs = true
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+}
+
+// forwardedSchemeTests are requests from a remote address, with headers, and
+// the expected scheme of absolute URLs.
+var forwardedSchemeTests = []struct {
+	RemoteAddr string
+	Header     map[string]string
+	Expected   string
+}{
+	// Trusted proxy
+	{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "https"}, "https"},
+	{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "HTTPS, http"}, "https"},
+	{"10.0.0.1:1234", map[string]string{"Forwarded": "for=1.2.3.4;proto=https"}, "https"},
+	{"10.0.0.1:1234", map[string]string{"Forwarded": `for=1.2.3.4; Proto="https", proto=http`}, "https"},
+	{"10.0.0.1:1234", map[string]string{"Forwarded": "proto=http", "X-Forwarded-Proto": "https"}, "http"},
+	{"10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "ftp"}, "http"},
+	{"10.0.0.1:1234", nil, "http"},
+	// Untrusted peer
+	{"11.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "https"}, "http"},
+	{"11.0.0.1:1234", map[string]string{"Forwarded": "proto=https"}, "http"},
+	{"", map[string]string{"X-Forwarded-Proto": "https"}, "http"},
+}
+
+// forwardedRequest returns a request option setting the host, remote
+// address, and headers of the request.
+func forwardedRequest(remoteAddr string, header map[string]string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Host = "example.org"
+		r.RemoteAddr = remoteAddr
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+	}
+}
+
+// Test that the Location header of a call responding with a resource uses
+// the scheme forwarded by trusted proxies
+func TestForwardedScheme_CallWithResourceResponse_SetsLocationScheme(t *testing.T) {
+	for i, l := range forwardedSchemeTests {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, forwardedRequest(l.RemoteAddr, l.Header))
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(`{"resource":{"rid":"test.model"}}`))
+			hreq.GetResponse(t).
+				AssertStatusCode(t, http.StatusOK).
+				AssertHeaders(t, map[string]string{"Location": l.Expected + "://example.org/api/test/model"})
+		}, withTrustedProxy)
+	}
+}
+
+// Test that the href values of a get response use the scheme forwarded by
+// trusted proxies
+func TestForwardedScheme_GetWithReference_SetsHrefScheme(t *testing.T) {
+	for i, l := range forwardedSchemeTests {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model/soft", nil, forwardedRequest(l.RemoteAddr, l.Header))
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model.soft").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model.soft").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model.soft") + `}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"name":"soft","child":{"href":"`+l.Expected+`://example.org/api/test/model"}}`))
+		}, withTrustedProxy)
+	}
+}
+
+// Test that forwarded headers are ignored, and relative URLs are used, if
+// absoluteURLs is not set
+func TestForwardedScheme_WithoutAbsoluteURLs_UsesRelativeURLs(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, forwardedRequest("10.0.0.1:1234", map[string]string{"X-Forwarded-Proto": "https"}))
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondRaw([]byte(`{"resource":{"rid":"test.model"}}`))
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusOK).
+			AssertHeaders(t, map[string]string{"Location": "/api/test/model"})
+	}, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"10.0.0.0/8"}
+	})
+}
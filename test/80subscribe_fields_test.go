@@ -0,0 +1,85 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// subscribeWithFields subscribes to test.model with the fields parameter,
+// responding to the access and get requests.
+func subscribeWithFields(t *testing.T, s *Session, c *Conn, fields string) {
+	creq := c.Request("subscribe.test.model", json.RawMessage(`{"fields":`+fields+`}`))
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+}
+
+// Test that change events on a subscription with fields only contain changes
+// to the fields, and that events without such changes are suppressed
+func TestSubscribeFields_ChangeEvent_FiltersFields(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeWithFields(t, s, c, `["string","bool"]`)
+
+		// Unrelated field change is suppressed
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		c.AssertNoEvent(t, "test.model")
+
+		// Changes to other fields are left out
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar","int":13}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"bool":false,"null":{"action":"delete"}}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"bool":false}}`))
+	})
+}
+
+// Test that other events are sent on a subscription with fields
+func TestSubscribeFields_CustomEvent_SendsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeWithFields(t, s, c, `["string"]`)
+
+		s.ResourceEvent("test.model", "custom", common.CustomEvent())
+		c.GetEvent(t).Equals(t, "test.model.custom", common.CustomEvent())
+	})
+}
+
+// Test that a direct subscription without fields replaces the fields of a
+// previous direct subscription
+func TestSubscribeFields_SubscribeWithoutFields_SendsAllChanges(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeWithFields(t, s, c, `["string"]`)
+
+		c.Request("subscribe.test.model", nil).GetResponse(t)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"int":12}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"int":12}}`))
+	})
+}
+
+// Test that a subscribe request with invalid fields responds with an invalid
+// params error
+func TestSubscribeFields_InvalidFields_RespondsWithInvalidParams(t *testing.T) {
+	tbl := []struct {
+		Params string
+	}{
+		{`{"fields":[]}`},
+		{`{"fields":[""]}`},
+		{`{"fields":"string"}`},
+		{`{"fields":[42]}`},
+		{`"string"`},
+	}
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			c.Request("subscribe.test.model", json.RawMessage(l.Params)).
+				GetResponse(t).
+				AssertError(t, reserr.ErrInvalidParams)
+			c.AssertNoNATSRequest(t, "test.model")
+		})
+	}
+}
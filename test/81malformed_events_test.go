@@ -0,0 +1,64 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a malformed event on a subscribed resource causes the resource
+// to be fetched again, with the difference sent to the client as events,
+// when malformedEvents is set to resync
+func TestMalformedEvents_Resync_GetsResource(t *testing.T) {
+	tbl := []struct {
+		RID           string
+		Event         string
+		Payload       string // Malformed event payload
+		GetResponse   string // Raw JSON get response
+		ExpectedEvent string // Expected client event name
+		ExpectedData  string // Expected client event data
+	}{
+		{"test.model", "change", `{"values":`, `{"model":{"string":"bar","int":42,"bool":true,"null":null}}`, "test.model.change", `{"values":{"string":"bar"}}`},
+		{"test.model", "change", `{"values":{"int":{"rid":42}}}`, `{"model":{"string":"foo","int":12,"bool":true,"null":null}}`, "test.model.change", `{"values":{"int":12}}`},
+		{"test.model", "custom", `{"foo":`, `{"model":{"string":"foo","int":42,"bool":true}}`, "test.model.change", `{"values":{"null":{"action":"delete"}}}`},
+		{"test.collection", "add", `{"idx":"1","value":"bar"}`, `{"collection":["foo",42,true,null,"bar"]}`, "test.collection.add", `{"idx":4,"value":"bar"}`},
+		{"test.collection", "add", `{"idx":5,"value":"bar"}`, `{"collection":["foo",42,true,null,"bar"]}`, "test.collection.add", `{"idx":4,"value":"bar"}`},
+		{"test.collection", "remove", `{"idx":4}`, `{"collection":["foo",42,true]}`, "test.collection.remove", `{"idx":3}`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			c := s.Connect()
+			subscribeToResource(t, s, c, l.RID)
+
+			s.ResourceEvent(l.RID, l.Event, []byte(l.Payload))
+			s.GetRequest(t).
+				AssertSubject(t, "get."+l.RID).
+				RespondSuccess(json.RawMessage(l.GetResponse))
+			c.GetEvent(t).Equals(t, l.ExpectedEvent, json.RawMessage(l.ExpectedData))
+			s.AssertErrorsLogged(t, 1)
+		}, func(cfg *server.Config) {
+			cfg.MalformedEvents = "resync"
+		})
+	}
+}
+
+// Test that malformed events are discarded, without fetching the resource
+// again, by default
+func TestMalformedEvents_Default_DiscardsEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.ResourceEvent("test.model", "change", []byte(`{"values":`))
+		c.AssertNoEvent(t, "test.model")
+		c.AssertNoNATSRequest(t, "test.model")
+
+		// Valid events are still sent
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		s.AssertErrorsLogged(t, 1)
+	})
+}
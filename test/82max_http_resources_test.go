@@ -0,0 +1,68 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// wideModel is a model referencing five other models.
+const wideModel = `{"a":{"rid":"test.a"},"b":{"rid":"test.b"},"c":{"rid":"test.c"},"d":{"rid":"test.d"},"e":{"rid":"test.e"}}`
+
+// Test that a HTTP GET request resolving more resources than the
+// maxHTTPResources limit responds with a resource limit exceeded error
+func TestMaxHTTPResources_WideFanOutPastLimit_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/wide", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.wide").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.wide").RespondSuccess(json.RawMessage(`{"model":` + wideModel + `}`))
+		hreq.GetResponse(t).
+			AssertStatusCode(t, http.StatusBadRequest).
+			AssertErrorCode(t, "system.resourceLimitExceeded")
+	}, func(cfg *server.Config) {
+		cfg.MaxHTTPResources = 5
+	})
+}
+
+// Test that a HTTP GET request resolving resources within the
+// maxHTTPResources limit responds with the resource
+func TestMaxHTTPResources_WideFanOutWithinLimit_RespondsWithResource(t *testing.T) {
+	for _, limit := range []int{0, 6} {
+		runNamedTest(t, fmt.Sprintf("limit=%d", limit), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/wide", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.wide").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.wide").RespondSuccess(json.RawMessage(`{"model":` + wideModel + `}`))
+			mreqs = s.GetParallelRequests(t, 5)
+			for _, n := range []string{"a", "b", "c", "d", "e"} {
+				mreqs.GetRequest(t, "get.test."+n).RespondSuccess(json.RawMessage(`{"model":{"name":"` + n + `"}}`))
+			}
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"a":{"href":"/api/test/a","model":{"name":"a"}},"b":{"href":"/api/test/b","model":{"name":"b"}},"c":{"href":"/api/test/c","model":{"name":"c"}},"d":{"href":"/api/test/d","model":{"name":"d"}},"e":{"href":"/api/test/e","model":{"name":"e"}}}`))
+		}, func(cfg *server.Config) {
+			cfg.MaxHTTPResources = limit
+		})
+	}
+}
+
+// Test that a WebSocket subscription is not affected by the maxHTTPResources
+// limit
+func TestMaxHTTPResources_WebSocketSubscribe_IgnoresLimit(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.wide", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.wide").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.wide").RespondSuccess(json.RawMessage(`{"model":` + wideModel + `}`))
+		mreqs = s.GetParallelRequests(t, 5)
+		for _, n := range []string{"a", "b", "c", "d", "e"} {
+			mreqs.GetRequest(t, "get.test."+n).RespondSuccess(json.RawMessage(`{"model":{"name":"` + n + `"}}`))
+		}
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.wide":`+wideModel+`,"test.a":{"name":"a"},"test.b":{"name":"b"},"test.c":{"name":"c"},"test.d":{"name":"d"},"test.e":{"name":"e"}}}`))
+	}, func(cfg *server.Config) {
+		cfg.MaxHTTPResources = 2
+	})
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withNATSReconnect(cfg *server.Config) {
+	cfg.NATSReconnect = true
+}
+
+// Test that a NATS reconnect fetches subscribed resources again, sending
+// change events for any difference to the client
+func TestNATSReconnect_WithChangedResource_SendsEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		subscribeToTestCollection(t, s, c)
+
+		s.Reconnect()
+		mreqs := s.GetParallelRequests(t, 4)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"string":"bar","int":42,"bool":true,"null":null}}`))
+		mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":["foo",42,true,null,"bar"]}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "access.test.collection").RespondSuccess(json.RawMessage(`{"get":true}`))
+
+		evs := c.GetParallelEvents(t, 2)
+		evs.GetEvent(t, "test.model.change").Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		evs.GetEvent(t, "test.collection.add").Equals(t, "test.collection.add", json.RawMessage(`{"idx":4,"value":"bar"}`))
+	}, withNATSReconnect)
+}
+
+// Test that a NATS reconnect with unchanged resources sends no events
+func TestNATSReconnect_WithUnchangedResource_SendsNoEvents(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.Reconnect()
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		c.AssertNoEvent(t, "test.model")
+	}, withNATSReconnect)
+}
+
+// Test that a NATS reconnect after which access is denied unsubscribes the
+// resource
+func TestNATSReconnect_WithDeniedAccess_UnsubscribesResource(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		s.Reconnect()
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":false}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.unsubscribe")
+	}, withNATSReconnect)
+}
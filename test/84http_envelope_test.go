@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// assertEnvelope asserts that the response body is an envelope with the
+// expected value under key, and meta with the resource ID and a timestamp.
+func assertEnvelope(t *testing.T, hresp *HTTPResponse, key string, expected string, rid string) {
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(hresp.Body.Bytes(), &env); err != nil {
+		t.Fatalf("expected response body to be a JSON object, but got:\n%s", hresp.Body.String())
+	}
+	var a, b interface{}
+	if err := json.Unmarshal(env[key], &a); err != nil {
+		t.Fatalf("expected envelope to have %#v, but got:\n%s", key, hresp.Body.String())
+	}
+	_ = json.Unmarshal([]byte(expected), &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("expected envelope %#v to be:\n%s\nbut got:\n%s", key, expected, env[key])
+	}
+	var meta struct {
+		RID       string `json:"rid"`
+		Timestamp string `json:"timestamp"`
+	}
+	if err := json.Unmarshal(env["meta"], &meta); err != nil {
+		t.Fatalf("expected envelope to have meta, but got:\n%s", hresp.Body.String())
+	}
+	if meta.RID != rid {
+		t.Fatalf("expected meta rid to be %#v, but got %#v", rid, meta.RID)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, meta.Timestamp); err != nil {
+		t.Fatalf("expected meta timestamp to be RFC3339, but got %#v", meta.Timestamp)
+	}
+}
+
+// Test that a HTTP GET response for a resource matching an httpEnvelope
+// pattern is wrapped in an envelope
+func TestHTTPEnvelope_GetMatchingResource_RespondsWithEnvelope(t *testing.T) {
+	for _, pattern := range []string{">", "test.model", "test.*"} {
+		runNamedTest(t, pattern, func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+			assertEnvelope(t, hresp, "data", resourceData("test.model"), "test.model")
+		}, func(cfg *server.Config) {
+			cfg.HTTPEnvelope = []string{pattern}
+		})
+	}
+}
+
+// Test that a HTTP GET error response for a resource matching an
+// httpEnvelope pattern is wrapped in an envelope
+func TestHTTPEnvelope_GetError_RespondsWithErrorEnvelope(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondError(reserr.ErrNotFound)
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusNotFound)
+		assertEnvelope(t, hresp, "error", `{"code":"system.notFound","message":"Not found"}`, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.HTTPEnvelope = []string{"test.>"}
+	})
+}
+
+// Test that a HTTP POST response for a resource matching an httpEnvelope
+// pattern is wrapped in an envelope
+func TestHTTPEnvelope_PostMatchingResource_RespondsWithEnvelope(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		assertEnvelope(t, hresp, "data", `{"foo":"bar"}`, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.HTTPEnvelope = []string{"test.>"}
+	})
+}
+
+// Test that a HTTP POST error response for a resource matching an
+// httpEnvelope pattern is wrapped in an envelope
+func TestHTTPEnvelope_PostError_RespondsWithErrorEnvelope(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(reserr.ErrInvalidParams)
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusBadRequest)
+		assertEnvelope(t, hresp, "error", `{"code":"system.invalidParams","message":"Invalid parameters"}`, "test.model")
+	}, func(cfg *server.Config) {
+		cfg.HTTPEnvelope = []string{"test.>"}
+	})
+}
+
+// Test that HTTP responses are not wrapped in an envelope by default, or for
+// resources not matching an httpEnvelope pattern
+func TestHTTPEnvelope_NonMatchingResource_RespondsWithoutEnvelope(t *testing.T) {
+	for i, patterns := range [][]string{nil, {"test.other"}} {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(resourceData("test.model")))
+		}, func(cfg *server.Config) {
+			cfg.HTTPEnvelope = patterns
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+func withTestAccessBatch(cfg *server.Config) {
+	cfg.AccessBatch = map[string]string{"test.*": "test.batch"}
+}
+
+// subscribeBatched sends subscribe requests for test.model and
+// test.collection, responding to the get requests, and returns the client
+// requests and the access batch request.
+func subscribeBatched(t *testing.T, s *Session, c *Conn) (*ClientRequest, *ClientRequest, *Request) {
+	creq1 := c.Request("subscribe.test.model", nil)
+	creq2 := c.Request("subscribe.test.collection", nil)
+	mreqs := s.GetParallelRequests(t, 3)
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+	mreqs.GetRequest(t, "get.test.collection").RespondSuccess(json.RawMessage(`{"collection":` + resourceData("test.collection") + `}`))
+	req := mreqs.GetRequest(t, "accessbatch.test.batch").
+		AssertPathPayload(t, "version", 1).
+		AssertPathPayload(t, "rids", []string{"test.model", "test.collection"})
+	return creq1, creq2, req
+}
+
+// Test that access requests for multiple resources matching an accessBatch
+// pattern are sent as a single access batch request
+func TestAccessBatch_SubscribeMultiple_SendsSingleAccessBatchRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq1, creq2, req := subscribeBatched(t, s, c)
+		req.RespondSuccess(json.RawMessage(`{"access":{"test.model":{"get":true},"test.collection":{"get":true}}}`))
+		creq1.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+		creq2.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.collection":`+resourceData("test.collection")+`}}`))
+	}, withTestAccessBatch)
+}
+
+// Test that a resource missing in the access batch response is denied access
+func TestAccessBatch_MissingResource_DeniesAccess(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq1, creq2, req := subscribeBatched(t, s, c)
+		req.RespondSuccess(json.RawMessage(`{"access":{"test.model":{"get":true}}}`))
+		creq1.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+		creq2.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withTestAccessBatch)
+}
+
+// Test that an access batch error response is the access error of all
+// resources in the batch
+func TestAccessBatch_ErrorResponse_RespondsWithError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq1, creq2, req := subscribeBatched(t, s, c)
+		req.RespondError(reserr.ErrAccessDenied)
+		creq1.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+		creq2.GetResponse(t).AssertError(t, reserr.ErrAccessDenied)
+	}, withTestAccessBatch)
+}
+
+// Test that access requests for resources not matching an accessBatch
+// pattern are sent as single access requests
+func TestAccessBatch_NonMatchingResource_SendsAccessRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+	}, func(cfg *server.Config) {
+		cfg.AccessBatch = map[string]string{"test.other.*": "test.batch"}
+	})
+}
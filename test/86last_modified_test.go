@@ -0,0 +1,93 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withLastModified(cfg *server.Config) {
+	cfg.LastModified = true
+}
+
+// getCachedTestModel makes a HTTP GET request for the cached test.model,
+// with any If-Modified-Since header, and returns the response.
+func getCachedTestModel(t *testing.T, s *Session, ims string) *HTTPResponse {
+	hreq := s.HTTPRequest("GET", "/api/test/model", nil, func(r *http.Request) {
+		if ims != "" {
+			r.Header.Set("If-Modified-Since", ims)
+		}
+	})
+	s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	return hreq.GetResponse(t)
+}
+
+// Test that a HTTP GET response has a Last-Modified header with the time
+// the resource was fetched, if lastModified is set
+func TestLastModified_HTTPGet_SetsLastModifiedHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		before := time.Now().Truncate(time.Second)
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hresp := hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(resourceData("test.model")))
+		lm, err := http.ParseTime(hresp.Header().Get("Last-Modified"))
+		if err != nil {
+			t.Fatalf("expected a valid Last-Modified header, but got %#v", hresp.Header().Get("Last-Modified"))
+		}
+		if lm.Before(before) || lm.After(time.Now()) {
+			t.Fatalf("expected Last-Modified to be the time of the get request, but got %s", lm)
+		}
+	}, withLastModified)
+}
+
+// Test that a HTTP GET request with an If-Modified-Since header at or after
+// the Last-Modified time responds with 304 Not Modified
+func TestLastModified_IfModifiedSinceUnmodified_RespondsNotModified(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		lm := getCachedTestModel(t, s, "").AssertStatusCode(t, http.StatusOK).Header().Get("Last-Modified")
+		hresp := getCachedTestModel(t, s, lm).AssertStatusCode(t, http.StatusNotModified)
+		if hresp.Body.Len() != 0 {
+			t.Fatalf("expected empty body, but got:\n%s", hresp.Body.String())
+		}
+		getCachedTestModel(t, s, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)).AssertStatusCode(t, http.StatusNotModified)
+	}, withLastModified)
+}
+
+// Test that a change event updates the Last-Modified time, responding with
+// the modified resource to a request with a prior If-Modified-Since header
+func TestLastModified_ChangeEvent_UpdatesLastModified(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		lm := getCachedTestModel(t, s, "").AssertStatusCode(t, http.StatusOK).Header().Get("Last-Modified")
+
+		// Wait for the Last-Modified time, in whole seconds, to change
+		time.Sleep(time.Second)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"string":"bar"}}`))
+		c.GetEvent(t).Equals(t, "test.model.change", json.RawMessage(`{"values":{"string":"bar"}}`))
+
+		hresp := getCachedTestModel(t, s, lm).Equals(t, http.StatusOK, json.RawMessage(`{"string":"bar","int":42,"bool":true,"null":null}`))
+		if hresp.Header().Get("Last-Modified") == lm {
+			t.Fatalf("expected Last-Modified to be updated, but got %#v", lm)
+		}
+	}, withLastModified)
+}
+
+// Test that HTTP GET responses have no Last-Modified header by default
+func TestLastModified_Default_NoLastModifiedHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		getCachedTestModel(t, s, time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)).
+			AssertStatusCode(t, http.StatusOK).
+			AssertMissingHeaders(t, []string{"Last-Modified"})
+	})
+}
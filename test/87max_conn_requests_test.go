@@ -0,0 +1,82 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+// Test that client requests exceeding the maxConnRequests limit are queued
+// until a pending request on the connection is replied to
+func TestMaxConnRequests_ExceedingLimit_QueuesRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		// Connection used to assert no requests are sent
+		other := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c, 2)
+
+		// Requests exceeding the limit are not sent
+		creq1 := c.Request("auth.test.model.queued1", nil)
+		creq2 := c.Request("auth.test.model.queued2", nil)
+		other.AssertNoNATSRequest(t, "test.model")
+
+		// Replying to a pending request sends the next queued request
+		reqs[0].RespondSuccess(nil)
+		creqs[0].GetResponse(t)
+		req1 := s.GetRequest(t).AssertSubject(t, "auth.test.model.queued1")
+		other.AssertNoNATSRequest(t, "test.model")
+
+		reqs[1].RespondSuccess(nil)
+		creqs[1].GetResponse(t)
+		req2 := s.GetRequest(t).AssertSubject(t, "auth.test.model.queued2")
+
+		respondAuthRequests(t, []*ClientRequest{creq1, creq2}, []*Request{req1, req2})
+	}, func(cfg *server.Config) {
+		cfg.MaxConnRequests = 2
+	})
+}
+
+// Test that the maxConnRequests limit of one connection does not queue the
+// requests of another connection
+func TestMaxConnRequests_OtherConnection_SendsRequests(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		c2 := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c1, 2)
+		creq := c1.Request("auth.test.model.queued", nil)
+		c2.AssertNoNATSRequest(t, "test.model")
+
+		creqs2, reqs2 := sendAuthRequests(t, s, c2, 2)
+		respondAuthRequests(t, creqs2, reqs2)
+
+		respondAuthRequests(t, creqs[:1], reqs[:1])
+		req := s.GetRequest(t).AssertSubject(t, "auth.test.model.queued")
+		respondAuthRequests(t, append(creqs[1:], creq), []*Request{reqs[1], req})
+	}, func(cfg *server.Config) {
+		cfg.MaxConnRequests = 2
+	})
+}
+
+// Test that client requests exceeding the size of the request queue are
+// rejected without being queued
+func TestMaxConnRequests_QueueFull_RejectsRequest(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creqs, reqs := sendAuthRequests(t, s, c, 1)
+		queued := make([]*ClientRequest, server.ConnRequestQueueSize)
+		for i := range queued {
+			queued[i] = c.Request("auth.test.model.queued", nil)
+		}
+		creq := c.Request("auth.test.model.rejected", nil)
+		creq.GetResponse(t).AssertErrorCode(t, reserr.CodeServiceUnavailable)
+
+		respondAuthRequests(t, creqs, reqs)
+		for _, creq := range queued {
+			s.GetRequest(t).AssertSubject(t, "auth.test.model.queued").RespondSuccess(nil)
+			creq.GetResponse(t)
+		}
+	}, func(cfg *server.Config) {
+		cfg.MaxConnRequests = 1
+	})
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withSubscriberThresholds(cfg *server.Config) {
+	cfg.SubscriberThresholds = []int{0, 1}
+	cfg.SubscriberThresholdSubject = "resgate.subscribers"
+}
+
+// Test that subscriber threshold events are published when the subscriber
+// count of a resource rises above a threshold
+func TestSubscriberThresholds_CountRisesAboveThreshold_PublishesEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c1 := s.Connect()
+		creq := c1.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 3)
+		mreqs.GetRequest(t, "resgate.subscribers").AssertPayload(t, json.RawMessage(`{"rid":"test.model","count":1,"threshold":0,"direction":"up"}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+
+		c2 := s.Connect()
+		creq = c2.Request("subscribe.test.model", nil)
+		mreqs = s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "resgate.subscribers").AssertPayload(t, json.RawMessage(`{"rid":"test.model","count":2,"threshold":1,"direction":"up"}`))
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+	}, withSubscriberThresholds)
+}
+
+// Test that subscriber threshold events are published when the subscriber
+// count of a resource drops back to a threshold
+func TestSubscriberThresholds_CountDropsToThreshold_PublishesEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 3)
+		mreqs.GetRequest(t, "resgate.subscribers")
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+
+		creq = c.Request("unsubscribe.test.model", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "resgate.subscribers").
+			AssertPayload(t, json.RawMessage(`{"rid":"test.model","count":0,"threshold":0,"direction":"down"}`))
+		creq.GetResponse(t)
+	}, withSubscriberThresholds)
+}
+
+// Test that no subscriber threshold events are published by default
+func TestSubscriberThresholds_Default_PublishesNoEvent(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+		c.AssertNoNATSRequest(t, "test.model")
+	})
+}
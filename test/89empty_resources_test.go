@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that empty models and collections are encoded as {} and [] in HTTP
+// responses for all API encodings, or as null if emptyResourceNull is set
+func TestEmptyResources_HTTPGet_RespondsWithEmptyResource(t *testing.T) {
+	tbl := []struct {
+		APIEncoding       string
+		EmptyResourceNull bool
+		Result            string // Get result (raw JSON)
+		Expected          string // Expected response body (raw JSON)
+	}{
+		{"json", false, `{"model":{}}`, `{}`},
+		{"json", false, `{"collection":[]}`, `[]`},
+		{"jsonflat", false, `{"model":{}}`, `{}`},
+		{"jsonflat", false, `{"collection":[]}`, `[]`},
+		{"json", true, `{"model":{}}`, `null`},
+		{"json", true, `{"collection":[]}`, `null`},
+		{"jsonflat", true, `{"model":{}}`, `null`},
+		{"jsonflat", true, `{"collection":[]}`, `null`},
+	}
+
+	for i, l := range tbl {
+		runNamedTest(t, fmt.Sprintf("#%d", i+1), func(s *Session) {
+			hreq := s.HTTPRequest("GET", "/api/test/empty", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.empty").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.empty").RespondSuccess(json.RawMessage(l.Result))
+			hreq.GetResponse(t).Equals(t, http.StatusOK, []byte(l.Expected))
+		}, func(cfg *server.Config) {
+			cfg.APIEncoding = l.APIEncoding
+			cfg.EmptyResourceNull = l.EmptyResourceNull
+		})
+	}
+}
+
+// Test that a collection emptied by a remove event is encoded as [] in
+// WebSocket and HTTP responses
+func TestEmptyResources_CollectionEmptiedByEvent_RespondsWithEmptyArray(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.single", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.single").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.single").RespondSuccess(json.RawMessage(`{"collection":["foo"]}`))
+		creq.GetResponse(t)
+		s.ResourceEvent("test.single", "remove", json.RawMessage(`{"idx":0}`))
+		c.GetEvent(t).Equals(t, "test.single.remove", json.RawMessage(`{"idx":0}`))
+
+		// Get the resource on a new connection
+		c2 := s.Connect()
+		creq = c2.Request("get.test.single", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.single").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"collections":{"test.single":[]}}`))
+
+		// Get the resource over HTTP
+		hreq := s.HTTPRequest("GET", "/api/test/single", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.single").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, []byte(`[]`))
+	})
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withLocale(cfg *server.Config) {
+	cfg.Locale = true
+}
+
+// acceptLanguage returns an HTTP header with the Accept-Language header set.
+func acceptLanguage(v string) http.Header {
+	return http.Header{"Accept-Language": {v}}
+}
+
+// Test that the normalized locale of a WebSocket connection reaches the
+// service on access and call requests
+func TestLocale_WebSocketCall_ForwardsLocale(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(acceptLanguage("en;q=0.5, sv-se"))
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "locale", "sv-SE").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "locale", "sv-SE").
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, withLocale)
+}
+
+// Test that the normalized locale of a WebSocket connection reaches the
+// service on auth requests
+func TestLocale_WebSocketAuth_ForwardsLocale(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(acceptLanguage("zh_hant_tw"))
+		creq := c.Request("auth.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "auth.test.model.method").
+			AssertPathPayload(t, "locale", "zh-Hant-TW").
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	}, withLocale)
+}
+
+// Test that the normalized locale of an HTTP request reaches the service on
+// access and call requests
+func TestLocale_HTTPPost_ForwardsLocale(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, func(r *http.Request) {
+			r.Header.Set("Accept-Language", "da, en-gb;q=0.8")
+		})
+		s.GetRequest(t).
+			AssertSubject(t, "access.test.model").
+			AssertPathPayload(t, "locale", "da").
+			RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).
+			AssertSubject(t, "call.test.model.method").
+			AssertPathPayload(t, "locale", "da").
+			RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(`{"foo":"bar"}`))
+	}, withLocale)
+}
+
+// Test that no locale is forwarded by default, or if the client has no
+// acceptable locale
+func TestLocale_DisabledOrMissing_ForwardsNoLocale(t *testing.T) {
+	tbl := []struct {
+		Name   string
+		Header string
+		Cfg    func(cfg *server.Config)
+	}{
+		{"default", "sv-SE", func(cfg *server.Config) {}},
+		{"wildcard", "*", withLocale},
+		{"missing", "", withLocale},
+	}
+	for _, l := range tbl {
+		runNamedTest(t, l.Name, func(s *Session) {
+			c := s.ConnectWithHeader(acceptLanguage(l.Header))
+			creq := c.Request("call.test.model.method", nil)
+			for _, subj := range []string{"access.test.model", "call.test.model.method"} {
+				req := s.GetRequest(t).AssertSubject(t, subj)
+				if _, ok := req.Payload.(map[string]interface{})["locale"]; ok {
+					t.Fatalf("expected no locale, but got payload: %s", req.RawPayload)
+				}
+				req.RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			}
+			creq.GetResponse(t)
+		}, l.Cfg)
+	}
+}
+
+// Test that the locale is injected in the query of access and get requests
+// for resources matching localeResources, and that the resource is fetched
+// separately for each locale
+func TestLocale_LocaleResources_InjectsLocaleQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		for _, locale := range []string{"en-US", "sv-SE"} {
+			c := s.ConnectWithHeader(acceptLanguage(locale))
+			creq := c.Request("subscribe.test.model?q=foo", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").
+				AssertPathPayload(t, "query", "q=foo&locale="+locale).
+				RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").
+				AssertPathPayload(t, "query", "q=foo&locale="+locale).
+				RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model?q=foo":`+model+`}}`))
+		}
+	}, func(cfg *server.Config) {
+		cfg.Locale = true
+		cfg.LocaleResources = []string{"test.>"}
+	})
+}
+
+// Test that the locale is not injected in the query of resources not
+// matching localeResources
+func TestLocale_NonMatchingResource_InjectsNoLocaleQuery(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(acceptLanguage("en-US"))
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertPathPayload(t, "locale", "en-US").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		req := mreqs.GetRequest(t, "get.test.model")
+		if _, ok := req.Payload.(map[string]interface{})["query"]; ok {
+			t.Fatalf("expected no query, but got payload: %s", req.RawPayload)
+		}
+		req.RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+	}, func(cfg *server.Config) {
+		cfg.Locale = true
+		cfg.LocaleResources = []string{"test.other"}
+	})
+}
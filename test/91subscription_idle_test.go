@@ -0,0 +1,84 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withSubscriptionIdleTimeout(cfg *server.Config) {
+	cfg.SubscriptionIdleTimeout = 50
+}
+
+// Test that a subscription without events or client requests expires after
+// the subscriptionIdleTimeout, sending an unsubscribe event
+func TestSubscriptionIdle_Untouched_Expires(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.subscriptionExpired","message":"Subscription expired"}}`))
+
+		// Events are no longer sent to the client
+		s.ResourceEvent("test.model", "custom", json.RawMessage(`{"foo":"bar"}`))
+		c.AssertNoEvent(t, "test.model")
+
+		// The client may subscribe again
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t)
+	}, withSubscriptionIdleTimeout)
+}
+
+// Test that events on the resource keep the subscription from expiring
+func TestSubscriptionIdle_WithEvents_DoesNotExpire(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		event := json.RawMessage(`{"foo":"bar"}`)
+		for i := 0; i < 4; i++ {
+			time.Sleep(30 * time.Millisecond)
+			s.ResourceEvent("test.model", "custom", event)
+			c.GetEvent(t).Equals(t, "test.model.custom", event)
+		}
+
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.subscriptionExpired","message":"Subscription expired"}}`))
+	}, withSubscriptionIdleTimeout)
+}
+
+// Test that call requests on the resource keep the subscription from expiring
+func TestSubscriptionIdle_WithCallRequests_DoesNotExpire(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		creq.GetResponse(t)
+
+		for i := 0; i < 4; i++ {
+			time.Sleep(30 * time.Millisecond)
+			creq := c.Request("call.test.model.method", nil)
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+			creq.GetResponse(t)
+		}
+
+		c.GetEvent(t).Equals(t, "test.model.unsubscribe", json.RawMessage(`{"reason":{"code":"system.subscriptionExpired","message":"Subscription expired"}}`))
+	}, withSubscriptionIdleTimeout)
+}
+
+// Test that subscriptions do not expire by default
+func TestSubscriptionIdle_Default_DoesNotExpire(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		time.Sleep(100 * time.Millisecond)
+		event := json.RawMessage(`{"foo":"bar"}`)
+		s.ResourceEvent("test.model", "custom", event)
+		c.GetEvent(t).Equals(t, "test.model.custom", event)
+	})
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that requests use reply inboxes with the configured natsInboxPrefix
+func TestNATSInboxPrefix_WithPrefix_UsesPrefixedInbox(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").
+			AssertReplyPrefix(t, "_INBOX.resgate").
+			RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").
+			AssertReplyPrefix(t, "_INBOX.resgate").
+			RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+	}, func(cfg *server.Config) {
+		cfg.NATSInboxPrefix = "_INBOX.resgate"
+	})
+}
+
+// Test that requests use the default _INBOX prefix by default
+func TestNATSInboxPrefix_Default_UsesDefaultInbox(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("auth.test.model.method", nil)
+		s.GetRequest(t).
+			AssertSubject(t, "auth.test.model.method").
+			AssertReplyPrefix(t, "_INBOX").
+			RespondSuccess(nil)
+		creq.GetResponse(t)
+	})
+}
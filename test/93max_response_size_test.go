@@ -0,0 +1,53 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withMaxResponseSize(cfg *server.Config) {
+	cfg.MaxResponseSize = 100
+}
+
+// Test that an oversized get response is rejected with an internal error
+func TestMaxResponseSize_OversizedGetResponse_RespondsWithInternalError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":{"foo":"` + strings.Repeat("a", 100) + `"}}`))
+		creq.GetResponse(t).AssertErrorCode(t, "system.internalError")
+		s.AssertErrorsLogged(t, 1)
+	}, withMaxResponseSize)
+}
+
+// Test that an oversized call response is rejected with an internal error
+func TestMaxResponseSize_OversizedCallResponse_RespondsWithInternalError(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`"` + strings.Repeat("a", 100) + `"`))
+		hreq.GetResponse(t).AssertStatusCode(t, http.StatusInternalServerError)
+		s.AssertErrorsLogged(t, 1)
+	}, withMaxResponseSize)
+}
+
+// Test that responses within the maxResponseSize limit, or any response by
+// default, are processed
+func TestMaxResponseSize_WithinLimitOrDefault_RespondsWithResult(t *testing.T) {
+	for _, cfg := range []func(cfg *server.Config){withMaxResponseSize, func(cfg *server.Config) {}} {
+		runTest(t, func(s *Session) {
+			c := s.Connect()
+			creq := c.Request("subscribe.test.model", nil)
+			mreqs := s.GetParallelRequests(t, 2)
+			mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+			mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + resourceData("test.model") + `}`))
+			creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+resourceData("test.model")+`}}`))
+		}, cfg)
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// unorderedModel is a test.model with fields neither sorted nor reverse
+// sorted.
+const unorderedModel = `{"zeta":1,"alpha":"foo","mid":true,"beta":null,"omega":{"data":[1,2]}}`
+
+// subscribeToUnorderedModel subscribes to test.model, responding with the
+// unorderedModel, to have it cached.
+func subscribeToUnorderedModel(t *testing.T, s *Session, c *Conn) {
+	creq := c.Request("subscribe.test.model", nil)
+	mreqs := s.GetParallelRequests(t, 2)
+	mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+	mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + unorderedModel + `}`))
+	creq.GetResponse(t)
+}
+
+// assertStableHTTPBody makes repeated HTTP GET requests for the cached
+// test.model, asserting the body is exactly the expected string each time.
+func assertStableHTTPBody(t *testing.T, s *Session, expected string) {
+	for i := 0; i < 10; i++ {
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hresp := hreq.GetResponse(t).AssertStatusCode(t, http.StatusOK)
+		if hresp.Body.String() != expected {
+			t.Fatalf("expected response body #%d to be:\n%s\nbut got:\n%s", i+1, expected, hresp.Body.String())
+		}
+	}
+}
+
+// Test that HTTP GET responses have model fields sorted by key, by default or
+// with fieldOrder set to sorted
+func TestFieldOrder_SortedOrDefault_EncodesSortedFields(t *testing.T) {
+	for _, order := range []string{"", server.FieldOrderSorted} {
+		for _, encoding := range []string{"json", "jsonflat"} {
+			runNamedTest(t, fmt.Sprintf("fieldOrder=%#v, apiEncoding=%s", order, encoding), func(s *Session) {
+				c := s.Connect()
+				subscribeToUnorderedModel(t, s, c)
+				assertStableHTTPBody(t, s, `{"alpha":"foo","beta":null,"mid":true,"omega":[1,2],"zeta":1}`)
+			}, func(cfg *server.Config) {
+				cfg.FieldOrder = order
+				cfg.APIEncoding = encoding
+			})
+		}
+	}
+}
+
+// Test that HTTP GET responses have model fields in the order of the get
+// response, with fieldOrder set to preserve
+func TestFieldOrder_Preserve_EncodesFieldsInReceivedOrder(t *testing.T) {
+	for _, encoding := range []string{"json", "jsonflat"} {
+		runNamedTest(t, "apiEncoding="+encoding, func(s *Session) {
+			c := s.Connect()
+			subscribeToUnorderedModel(t, s, c)
+			assertStableHTTPBody(t, s, `{"zeta":1,"alpha":"foo","mid":true,"beta":null,"omega":[1,2]}`)
+		}, func(cfg *server.Config) {
+			cfg.FieldOrder = server.FieldOrderPreserve
+			cfg.APIEncoding = encoding
+		})
+	}
+}
+
+// Test that fields added by a change event are encoded after the received
+// fields, sorted by key, with fieldOrder set to preserve
+func TestFieldOrder_PreserveWithChangeEvent_EncodesAddedFieldsSorted(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToUnorderedModel(t, s, c)
+		s.ResourceEvent("test.model", "change", json.RawMessage(`{"values":{"mid":{"action":"delete"},"new":2,"added":3,"zeta":4}}`))
+		c.GetEvent(t).AssertEventName(t, "test.model.change")
+		assertStableHTTPBody(t, s, `{"zeta":4,"alpha":"foo","beta":null,"omega":[1,2],"added":3,"new":2}`)
+	}, func(cfg *server.Config) {
+		cfg.FieldOrder = server.FieldOrderPreserve
+	})
+}
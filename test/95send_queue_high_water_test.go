@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// sendEventsToSlowConsumer subscribes to test.model on a connection with a
+// small event channel, and sends more events than the channel holds before
+// reading them, blocking the connection worker while events are queued. It
+// returns the connection ID.
+func sendEventsToSlowConsumer(t *testing.T, s *Session, count int) string {
+	evs := make(chan *ClientEvent, 16)
+	c := s.ConnectWithChannel(evs)
+	c.Request("version", versionRequest).GetResponse(t).AssertResult(t, versionResult)
+	cid := subscribeToTestModel(t, s, c)
+
+	event := json.RawMessage(`{"foo":"bar"}`)
+	for i := 0; i < count; i++ {
+		s.ResourceEvent("test.model", "custom", event)
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case ev := <-evs:
+			ev.Equals(t, "test.model.custom", event)
+		case <-time.After(timeoutSeconds * time.Second):
+			t.Fatal("expected a client event but found none")
+		}
+	}
+	return cid
+}
+
+// Test that a slow consumer with more queued messages than the
+// wsSendQueueHighWater setting logs the high-water message once, with the
+// connection ID
+func TestSendQueueHighWater_SlowConsumer_LogsHighWater(t *testing.T) {
+	runTest(t, func(s *Session) {
+		cid := sendEventsToSlowConsumer(t, s, 600)
+
+		msg := "Send queue high-water mark (100) reached"
+		log := s.CountLogger.String()
+		if !strings.Contains(log, "["+cid+"] "+msg) {
+			t.Fatalf("expected log to contain high-water message for %s, but got:\n%s", cid, log)
+		}
+		if n := strings.Count(log, msg); n != 1 {
+			t.Fatalf("expected high-water message to be logged once, but got %d times", n)
+		}
+	}, func(cfg *server.Config) {
+		cfg.WSSendQueueHighWater = 100
+	})
+}
+
+// Test that no high-water message is logged by default
+func TestSendQueueHighWater_Default_LogsNothing(t *testing.T) {
+	runTest(t, func(s *Session) {
+		sendEventsToSlowConsumer(t, s, 600)
+
+		if log := s.CountLogger.String(); strings.Contains(log, "Send queue high-water mark") {
+			t.Fatalf("expected no high-water message, but got:\n%s", log)
+		}
+	})
+}
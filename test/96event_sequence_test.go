@@ -0,0 +1,41 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that events delivered in order with eventSequence enabled are not
+// counted as anomalies
+func TestEventSequence_EventsInOrder_NoAnomalies(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		subscribeToTestModel(t, s, c)
+
+		for i := 0; i < 10; i++ {
+			change := json.RawMessage(fmt.Sprintf(`{"values":{"int":%d}}`, i))
+			custom := json.RawMessage(fmt.Sprintf(`{"i":%d}`, i))
+			s.ResourceEvent("test.model", "change", change)
+			s.ResourceEvent("test.model", "custom", custom)
+			c.GetEvent(t).Equals(t, "test.model.change", change)
+			c.GetEvent(t).Equals(t, "test.model.custom", custom)
+		}
+
+		s.AssertMetric(t, "resgate_event_sequence_anomalies_total", "0")
+	}, func(cfg *server.Config) {
+		cfg.EventSequence = true
+	})
+}
+
+// Test that the anomaly metric is not registered by default
+func TestEventSequence_Default_NoMetric(t *testing.T) {
+	runTest(t, func(s *Session) {
+		if strings.Contains(s.Metrics(), "resgate_event_sequence_") {
+			t.Fatalf("expected no event sequence metric, but got:\n%s", s.Metrics())
+		}
+	})
+}
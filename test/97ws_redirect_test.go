@@ -0,0 +1,50 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+)
+
+// Test that a connection exceeding the connections limit of a wsRedirects
+// rule is redirected on connect
+func TestWSRedirect_ConnectionsExceeded_RedirectsOnConnect(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		c2 := s.ConnectWithoutVersion()
+		c2.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"redirect","url":"wss://other.example.com/ws"}`)
+
+		// The first connection is not redirected
+		c.Request("version", nil).GetResponse(t)
+	}, func(cfg *server.Config) {
+		cfg.WSRedirects = []server.WSRedirect{{URL: "wss://other.example.com/ws", Connections: 1}}
+	})
+}
+
+// Test that a connection is redirected once its token has a claim matching a
+// wsRedirects rule, and not for other claim values
+func TestWSRedirect_TokenClaim_RedirectsOnMatch(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		cid := getCID(t, s, c)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"region":"us"}}`))
+		c.Request("version", nil).GetResponse(t)
+
+		s.ConnEvent(cid, "token", json.RawMessage(`{"token":{"region":"eu"}}`))
+		c.AssertClosedWithText(t, websocket.CloseGoingAway, `{"reason":"redirect","url":"wss://eu.example.com"}`)
+	}, func(cfg *server.Config) {
+		cfg.WSRedirects = []server.WSRedirect{{URL: "wss://eu.example.com", Claim: "region", Value: "eu"}}
+	})
+}
+
+// Test that connections are not redirected by default
+func TestWSRedirect_Default_NoRedirect(t *testing.T) {
+	runTest(t, func(s *Session) {
+		for i := 0; i < 3; i++ {
+			s.Connect()
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/reserr"
+)
+
+var serverTimingEntryPattern = regexp.MustCompile(`^([a-z]+);dur=\d+\.\d{3}$`)
+
+func withServerTiming(cfg *server.Config) {
+	cfg.ServerTiming = true
+}
+
+// timingRequested sets the Resgate-Timing header on a HTTP request.
+func timingRequested(r *http.Request) {
+	r.Header.Set("Resgate-Timing", "1")
+}
+
+// assertServerTiming asserts that the Server-Timing header has an entry for
+// each of the names, in the name;dur=milliseconds format, ending with total.
+func assertServerTiming(t *testing.T, hresp *HTTPResponse, names ...string) {
+	v := hresp.Header().Get("Server-Timing")
+	entries := strings.Split(v, ", ")
+	got := make([]string, 0, len(entries))
+	for _, e := range entries {
+		m := serverTimingEntryPattern.FindStringSubmatch(e)
+		if m == nil {
+			t.Fatalf("expected Server-Timing entries of format name;dur=0.000, but got %#v", v)
+		}
+		got = append(got, m[1])
+	}
+	if got[len(got)-1] != "total" {
+		t.Fatalf("expected Server-Timing to end with total, but got %#v", v)
+	}
+	names = append(names, "total")
+	sort.Strings(names)
+	sort.Strings(got)
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Fatalf("expected Server-Timing entries %v, but got %#v", names, v)
+	}
+}
+
+// Test that a HTTP get request asking for timing gets a Server-Timing header
+// with the access, get, and total durations
+func TestServerTiming_HTTPGet_IncludesServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil, timingRequested)
+		mreqs := s.GetParallelRequests(t, 2)
+		mreqs.GetRequest(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		mreqs.GetRequest(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+		hresp := hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+		assertServerTiming(t, hresp, "access", "get")
+	}, withServerTiming)
+}
+
+// Test that a HTTP call request asking for timing gets a Server-Timing header
+// with the access, call, and total durations, also on error
+func TestServerTiming_HTTPPost_IncludesServerTimingHeader(t *testing.T) {
+	runTest(t, func(s *Session) {
+		hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, timingRequested)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondError(&reserr.Error{Code: "test.failed", Message: "Failed"})
+		hresp := hreq.GetResponse(t)
+		assertServerTiming(t, hresp, "access", "call")
+	}, withServerTiming)
+}
+
+// Test that no Server-Timing header is included by default, or if not asked
+// for
+func TestServerTiming_DisabledOrNotRequested_NoServerTimingHeader(t *testing.T) {
+	tbl := []struct {
+		Name    string
+		Cfg     func(cfg *server.Config)
+		Options []func(r *http.Request)
+	}{
+		{"default", func(cfg *server.Config) {}, []func(r *http.Request){timingRequested}},
+		{"not requested", withServerTiming, nil},
+	}
+	for _, l := range tbl {
+		runNamedTest(t, l.Name, func(s *Session) {
+			hreq := s.HTTPRequest("POST", "/api/test/model/method", nil, l.Options...)
+			s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+			s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+			hreq.GetResponse(t).AssertMissingHeaders(t, []string{"Server-Timing"})
+		}, l.Cfg)
+	}
+}
+
+// Test that a call response on a WebSocket connection asking for timing has
+// a timing meta object
+func TestServerTiming_WebSocketCall_IncludesTimingMeta(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.ConnectWithHeader(http.Header{"Resgate-Timing": {"1"}})
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(json.RawMessage(`{"foo":"bar"}`))
+		cresp := creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"foo":"bar"}`))
+
+		meta, _ := cresp.Meta.(map[string]interface{})
+		timing, _ := meta["timing"].(map[string]interface{})
+		for _, name := range []string{"access", "call", "total"} {
+			if _, ok := timing[name].(float64); !ok {
+				t.Fatalf("expected timing meta to have a %s duration, but got %#v", name, cresp.Meta)
+			}
+		}
+	}, withServerTiming)
+}
+
+// Test that a call response on a WebSocket connection not asking for timing
+// has no meta object
+func TestServerTiming_WebSocketCallNotRequested_NoMeta(t *testing.T) {
+	runTest(t, func(s *Session) {
+		c := s.Connect()
+		creq := c.Request("call.test.model.method", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true,"call":"*"}`))
+		s.GetRequest(t).AssertSubject(t, "call.test.model.method").RespondSuccess(nil)
+		if cresp := creq.GetResponse(t); cresp.Meta != nil {
+			t.Fatalf("expected no meta, but got %#v", cresp.Meta)
+		}
+	}, withServerTiming)
+}
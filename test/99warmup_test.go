@@ -0,0 +1,59 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resgateio/resgate/server"
+)
+
+func withWarmup(reject bool) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		cfg.WarmupResources = []string{"test.model"}
+		cfg.WarmupReject = reject
+	}
+}
+
+// Test that subscribe and get requests for a warmup resource are rejected
+// until the warmup completes, and that the resource is then served from the
+// cache
+func TestWarmup_WithReject_RejectsRequestsUntilWarmupCompletes(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		mreq := s.GetRequest(t).AssertSubject(t, "get.test.model")
+
+		c := s.Connect()
+		c.Request("subscribe.test.model", nil).GetResponse(t).AssertErrorCode(t, "system.serviceUnavailable")
+		hresp := s.HTTPRequest("GET", "/api/test/model", nil).GetResponse(t)
+		hresp.AssertStatusCode(t, http.StatusServiceUnavailable)
+		hresp.AssertHeaders(t, map[string]string{"Retry-After": "1"})
+
+		mreq.RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		// Wait for the warmup to complete
+		for i := 0; i < 100 && !strings.Contains(s.CountLogger.String(), "Warmup completed"); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		hreq := s.HTTPRequest("GET", "/api/test/model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		hreq.GetResponse(t).Equals(t, http.StatusOK, json.RawMessage(model))
+	}, withWarmup(true))
+}
+
+// Test that a warmup resource is loaded on startup, and served from the cache
+// without rejecting requests made before the warmup completes, if
+// warmupReject is not set
+func TestWarmup_WithoutReject_ServesFromCache(t *testing.T) {
+	runTest(t, func(s *Session) {
+		model := resourceData("test.model")
+		s.GetRequest(t).AssertSubject(t, "get.test.model").RespondSuccess(json.RawMessage(`{"model":` + model + `}`))
+
+		c := s.Connect()
+		creq := c.Request("subscribe.test.model", nil)
+		s.GetRequest(t).AssertSubject(t, "access.test.model").RespondSuccess(json.RawMessage(`{"get":true}`))
+		creq.GetResponse(t).AssertResult(t, json.RawMessage(`{"models":{"test.model":`+model+`}}`))
+	}, withWarmup(false))
+}
@@ -2,10 +2,12 @@ package test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -16,6 +18,9 @@ import (
 	"github.com/resgateio/resgate/server/reserr"
 )
 
+// RequestTimeout is the request timeout reported by the NATSTestClient.
+const RequestTimeout = 3000 * time.Millisecond
+
 // Subscription implements the mq.Unsubscriber interface.
 type Subscription struct {
 	c  *NATSTestClient
@@ -25,11 +30,14 @@ type Subscription struct {
 
 // Request represent a request to NATS
 type Request struct {
-	Subject    string
-	RawPayload []byte
-	Payload    interface{}
-	c          *NATSTestClient
-	cb         mq.Response
+	Subject         string
+	RawPayload      []byte
+	Payload         interface{}
+	Header          map[string][]string
+	Reply           string        // Reply inbox subject, or empty for published messages
+	TimeoutDuration time.Duration // Timeout set for the request, or zero for the default
+	c               *NATSTestClient
+	cb              mq.Response
 }
 
 // NATSTestClient holds a client connection to a nats server.
@@ -38,6 +46,11 @@ type NATSTestClient struct {
 	subs      map[string]*Subscription
 	reqs      chan *Request
 	connected bool
+	failCount int
+	connects  int
+	reconnect func()
+	inbox     string
+	inboxes   int
 	mu        sync.Mutex
 }
 
@@ -77,10 +90,30 @@ func (c *NATSTestClient) Tracef(format string, v ...interface{}) {
 	}
 }
 
+// FailConnects makes the next n calls to Connect fail, simulating a NATS
+// server not yet available.
+func (c *NATSTestClient) FailConnects(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failCount = n
+}
+
+// ConnectAttempts returns the number of calls made to Connect.
+func (c *NATSTestClient) ConnectAttempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
 // Connect establishes a connection to the MQ
 func (c *NATSTestClient) Connect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.connects++
+	if c.failCount > 0 {
+		c.failCount--
+		return errors.New("nats: no servers available for connection")
+	}
 	c.subs = make(map[string]*Subscription)
 	c.reqs = make(chan *Request, 256)
 	c.connected = true
@@ -108,6 +141,29 @@ func (c *NATSTestClient) Close() {
 // SendRequest sends an asynchronous request on a subject, expecting the Response
 // callback to be called once.
 func (c *NATSTestClient) SendRequest(subj string, payload []byte, cb mq.Response) {
+	c.sendRequest(subj, nil, payload, 0, cb)
+}
+
+// SendRequestWithHeader sends an asynchronous request on a subject, with the
+// message headers set, expecting the Response callback to be called once.
+func (c *NATSTestClient) SendRequestWithHeader(subj string, header map[string][]string, payload []byte, cb mq.Response) {
+	c.sendRequest(subj, header, payload, 0, cb)
+}
+
+// SendRequestWithTimeout sends an asynchronous request on a subject, with the
+// message headers set unless nil, recording the timeout on the request.
+func (c *NATSTestClient) SendRequestWithTimeout(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
+	c.sendRequest(subj, header, payload, timeout, cb)
+}
+
+// Publish publishes the payload on a subject. The message is received as a
+// request that must not be responded to.
+func (c *NATSTestClient) Publish(subj string, payload []byte) error {
+	c.sendRequest(subj, nil, payload, 0, nil)
+	return nil
+}
+
+func (c *NATSTestClient) sendRequest(subj string, header map[string][]string, payload []byte, timeout time.Duration, cb mq.Response) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -118,11 +174,21 @@ func (c *NATSTestClient) SendRequest(subj string, payload []byte, cb mq.Response
 	}
 
 	r := &Request{
-		Subject:    subj,
-		RawPayload: payload,
-		Payload:    p,
-		c:          c,
-		cb:         cb,
+		Subject:         subj,
+		RawPayload:      payload,
+		Payload:         p,
+		Header:          header,
+		TimeoutDuration: timeout,
+		c:               c,
+		cb:              cb,
+	}
+	if cb != nil {
+		c.inboxes++
+		inbox := c.inbox
+		if inbox == "" {
+			inbox = "_INBOX"
+		}
+		r.Reply = inbox + "." + strconv.Itoa(c.inboxes)
 	}
 
 	c.Tracef("<== %s: %s", subj, payload)
@@ -154,6 +220,39 @@ func (c *NATSTestClient) SetClosedHandler(_ func(error)) {
 	// Does nothing
 }
 
+// SetInboxPrefix sets the prefix of the reply inbox subjects.
+func (c *NATSTestClient) SetInboxPrefix(prefix string) {
+	c.mu.Lock()
+	c.inbox = prefix
+	c.mu.Unlock()
+}
+
+// SetReconnectHandler sets the handler called by Reconnect.
+func (c *NATSTestClient) SetReconnectHandler(cb func()) {
+	c.mu.Lock()
+	c.reconnect = cb
+	c.mu.Unlock()
+}
+
+// Reconnect simulates a reconnect after a lost connection, calling the
+// reconnect handler. Subscriptions are kept, as if restored.
+// It panics if no reconnect handler is set.
+func (c *NATSTestClient) Reconnect() {
+	c.mu.Lock()
+	cb := c.reconnect
+	c.mu.Unlock()
+	if cb == nil {
+		panic("test: no reconnect handler set")
+	}
+	cb()
+}
+
+// Timeout returns the request timeout reported to the service.
+// Test requests never time out unless Request.Timeout is called.
+func (c *NATSTestClient) Timeout() time.Duration {
+	return RequestTimeout
+}
+
 // HasSubscriptions asserts that there is a subscription for the given resource IDs
 func (c *NATSTestClient) HasSubscriptions(t *testing.T, rids ...string) {
 	c.mu.Lock()
@@ -218,15 +317,23 @@ func (c *NATSTestClient) SystemEvent(event string, payload interface{}) {
 func (c *NATSTestClient) event(ns string, event string, payload interface{}) {
 	c.mu.Lock()
 
-	s, ok := c.subs[ns]
-	if !ok {
+	var subs []*Subscription
+	if s, ok := c.subs[ns]; ok {
+		subs = append(subs, s)
+	}
+	for sns, s := range c.subs {
+		if sns != ns && namespaceMatch(sns, ns) {
+			subs = append(subs, s)
+		}
+	}
+	if len(subs) == 0 {
 		c.mu.Unlock()
 		panic("test: no subscription for " + ns)
 	}
 
-	var data []byte
 	var err error
-	if data, ok = payload.([]byte); !ok {
+	data, ok := payload.([]byte)
+	if !ok {
 		data, err = json.Marshal(payload)
 		if err != nil {
 			c.mu.Unlock()
@@ -237,7 +344,28 @@ func (c *NATSTestClient) event(ns string, event string, payload interface{}) {
 	c.mu.Unlock()
 	subj := ns + "." + event
 	c.Tracef("=>> %s: %s", subj, data)
-	s.cb(subj, data, nil)
+	for _, s := range subs {
+		s.cb(subj, data, nil)
+	}
+}
+
+// namespaceMatch reports whether a subscription namespace, that may contain
+// the wildcards * and >, matches the event namespace.
+func namespaceMatch(sns, ns string) bool {
+	if !strings.ContainsAny(sns, "*>") {
+		return false
+	}
+	st := strings.Split(sns, ".")
+	nt := strings.Split(ns, ".")
+	for i, t := range st {
+		if t == ">" {
+			return len(nt) > i
+		}
+		if i >= len(nt) || (t != "*" && t != nt[i]) {
+			return false
+		}
+	}
+	return len(st) == len(nt)
 }
 
 // Unsubscribe removes the subscription.
@@ -369,6 +497,38 @@ func (r *Request) AssertSubject(t *testing.T, subject string) *Request {
 	return r
 }
 
+// AssertHeader asserts that the request has the expected message header
+// values. Nil values asserts that the header is not set.
+func (r *Request) AssertHeader(t *testing.T, key string, values []string) *Request {
+	v, ok := r.Header[key]
+	if values == nil {
+		if ok {
+			t.Fatalf("expected request %#v to have no header %#v, but got %#v", r.Subject, key, v)
+		}
+	} else if !reflect.DeepEqual(v, values) {
+		t.Fatalf("expected request %#v to have header %#v set to %#v, but got %#v", r.Subject, key, values, v)
+	}
+	return r
+}
+
+// AssertReplyPrefix asserts that the reply inbox subject of the request has
+// the expected prefix.
+func (r *Request) AssertReplyPrefix(t *testing.T, prefix string) *Request {
+	if !strings.HasPrefix(r.Reply, prefix+".") {
+		t.Fatalf("expected reply inbox to have prefix %#v, but got %#v", prefix, r.Reply)
+	}
+	return r
+}
+
+// AssertTimeout asserts that the request has the expected timeout. Zero
+// asserts that the default timeout is used.
+func (r *Request) AssertTimeout(t *testing.T, timeout time.Duration) *Request {
+	if r.TimeoutDuration != timeout {
+		t.Fatalf("expected request %#v to have timeout %s, but got %s", r.Subject, timeout, r.TimeoutDuration)
+	}
+	return r
+}
+
 // AssertPayload asserts that the request has the expected payload
 func (r *Request) AssertPayload(t *testing.T, payload interface{}) *Request {
 	var err error
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -105,6 +106,26 @@ func (s *Session) ConnectWithoutVersion() *Conn {
 	return s.ConnectWithChannel(make(chan *ClientEvent, 256))
 }
 
+// ConnectWithMsgpack makes a new mock client websocket connection using the
+// msgpack subprotocol, that handshakes with version v1.999.999.
+func (s *Session) ConnectWithMsgpack() *Conn {
+	d := wstest.NewDialer(s.s.GetWSHandlerFunc())
+	d.Subprotocols = []string{server.MsgpackSubprotocol}
+	ws, _, err := d.Dial("ws://example.org/", nil)
+	if err != nil {
+		panic(err)
+	}
+	if ws.Subprotocol() != server.MsgpackSubprotocol {
+		s.t.Fatalf("expected subprotocol %s to be negotiated, but got %#v", server.MsgpackSubprotocol, ws.Subprotocol())
+	}
+	c := NewConn(s, d, ws, make(chan *ClientEvent, 256))
+	s.conns[c] = struct{}{}
+
+	creq := c.Request("version", versionRequest)
+	creq.GetResponse(s.t).AssertResult(s.t, versionResult)
+	return c
+}
+
 // ConnectWithHeader makes a new mock client websocket connection
 // using provided headers. It does not send a version handshake.
 func (s *Session) ConnectWithHeader(h http.Header) *Conn {
@@ -143,18 +164,46 @@ func (s *Session) HTTPRequest(method, url string, body []byte, opts ...func(r *h
 	return hr
 }
 
-func teardown(s *Session) {
-	for conn := range s.conns {
-		err := conn.Error()
-		if err != nil {
-			panic(err.Error())
+// Metrics returns the metrics served by the service, in the Prometheus text format.
+func (s *Session) Metrics() string {
+	rr := httptest.NewRecorder()
+	s.s.GetMetricsHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	return rr.Body.String()
+}
+
+// AssertMetric asserts that the service metrics contains a sample with the
+// given name, including any labels, and value.
+func (s *Session) AssertMetric(t *testing.T, name string, value string) {
+	m := s.Metrics()
+	for _, line := range strings.Split(m, "\n") {
+		if line == name+" "+value {
+			return
 		}
-		conn.Disconnect()
-		if s.t != nil {
-			conn.AssertClosed(s.t)
+	}
+	t.Fatalf("expected metrics to contain:\n%s %s\nbut got:\n%s", name, value, m)
+}
+
+// WaitMetric waits for the service metrics to contain a sample with the given
+// name, including any labels, and value.
+func (s *Session) WaitMetric(t *testing.T, name string, value string) {
+	for i := 0; i < 1000; i++ {
+		for _, line := range strings.Split(s.Metrics(), "\n") {
+			if line == name+" "+value {
+				return
+			}
 		}
+		time.Sleep(time.Millisecond)
 	}
+	s.AssertMetric(t, name, value)
+}
+
+// Stop stops the service and waits for it to be stopped.
+// Calling Stop on a stopped service does nothing.
+func (s *Session) Stop() {
 	st := s.s.StopChannel()
+	if st == nil {
+		return
+	}
 	go s.s.Stop(nil)
 
 	select {
@@ -162,6 +211,20 @@ func teardown(s *Session) {
 	case <-time.After(3 * time.Second):
 		panic("test: failed to stop server: timeout")
 	}
+}
+
+func teardown(s *Session) {
+	for conn := range s.conns {
+		err := conn.Error()
+		if err != nil {
+			panic(err.Error())
+		}
+		conn.Disconnect()
+		if s.t != nil {
+			conn.AssertClosed(s.t)
+		}
+	}
+	s.Stop()
 	if s.t != nil {
 		s.AssertNoErrorsLogged(s.t)
 	}
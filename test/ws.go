@@ -12,19 +12,23 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/resgateio/resgate/server"
+	"github.com/resgateio/resgate/server/codec"
 	"github.com/resgateio/resgate/server/reserr"
 )
 
 // Conn represents a client websocket connection
 type Conn struct {
-	s       *Session
-	d       *websocket.Dialer
-	ws      *websocket.Conn
-	reqs    map[uint64]*ClientRequest
-	evs     chan *ClientEvent
-	mu      sync.Mutex
-	closeCh chan struct{}
-	err     error
+	s        *Session
+	d        *websocket.Dialer
+	ws       *websocket.Conn
+	reqs     map[uint64]*ClientRequest
+	evs      chan *ClientEvent
+	mu       sync.Mutex
+	closeCh  chan struct{}
+	closeErr error
+	err      error
+	msgpack  bool
 }
 
 type clientRequest struct {
@@ -35,10 +39,12 @@ type clientRequest struct {
 
 type clientResponse struct {
 	Result interface{}   `json:"result"`
+	Meta   interface{}   `json:"meta"`
 	Error  *reserr.Error `json:"error"`
 	ID     uint64        `json:"id"`
 	Event  *string       `json:"event"`
 	Data   interface{}   `json:"data"`
+	Ack    *uint64       `json:"ack"`
 }
 
 var clientRequestID uint64
@@ -54,6 +60,7 @@ type ClientRequest struct {
 // ClientResponse represents a response to a RES-client request
 type ClientResponse struct {
 	Result interface{}
+	Meta   interface{}
 	Error  *reserr.Error
 }
 
@@ -61,6 +68,7 @@ type ClientResponse struct {
 type ClientEvent struct {
 	Event string
 	Data  interface{}
+	Ack   *uint64
 }
 
 // ParallelEvents holds multiple events in undetermined order
@@ -75,6 +83,7 @@ func NewConn(s *Session, d *websocket.Dialer, ws *websocket.Conn, evs chan *Clie
 		reqs:    make(map[uint64]*ClientRequest),
 		evs:     evs,
 		closeCh: make(chan struct{}),
+		msgpack: ws.Subprotocol() == server.MsgpackSubprotocol,
 	}
 	go c.listen()
 	return c
@@ -92,7 +101,7 @@ func (c *Conn) Request(method string, params interface{}) *ClientRequest {
 
 	id := clientRequestID
 	clientRequestID++
-	err := c.ws.WriteJSON(clientRequest{
+	err := c.writeJSON(clientRequest{
 		ID:     id,
 		Method: method,
 		Params: params,
@@ -113,6 +122,37 @@ func (c *Conn) Request(method string, params interface{}) *ClientRequest {
 	return req
 }
 
+// writeJSON writes the value as a JSON text message, or as a binary msgpack
+// message if the msgpack subprotocol is used.
+func (c *Conn) writeJSON(v interface{}) error {
+	if !c.msgpack {
+		return c.ws.WriteJSON(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if data, err = codec.JSONToMsgpack(data); err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// SendRaw sends a raw text message to the gateway, without any response
+// being expected.
+func (c *Conn) SendRaw(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		panic(c.err)
+	}
+
+	if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		panic("test: error writing raw message: " + err.Error())
+	}
+}
+
 // Disconnect closes the connection to the gateway
 func (c *Conn) Disconnect() {
 	c.ws.Close()
@@ -198,9 +238,20 @@ func (c *Conn) listen() {
 	// Loop until an error is returned when reading
 Loop:
 	for {
-		if _, in, err = c.ws.ReadMessage(); err != nil {
+		var mt int
+		if mt, in, err = c.ws.ReadMessage(); err != nil {
 			break
 		}
+		if c.msgpack {
+			if mt != websocket.BinaryMessage {
+				c.setError(errors.New("test: expected binary message on msgpack connection"))
+				break Loop
+			}
+			if in, err = codec.MsgpackToJSON(in); err != nil {
+				c.setError(errors.New("test: error decoding msgpack message: " + err.Error()))
+				break Loop
+			}
+		}
 
 		cr := clientResponse{}
 		err := json.Unmarshal(in, &cr)
@@ -215,6 +266,7 @@ Loop:
 			c.evs <- &ClientEvent{
 				Event: *cr.Event,
 				Data:  cr.Data,
+				Ack:   cr.Ack,
 			}
 			c.mu.Unlock()
 		} else {
@@ -229,6 +281,7 @@ Loop:
 			select {
 			case req.ch <- &ClientResponse{
 				Result: cr.Result,
+				Meta:   cr.Meta,
 				Error:  cr.Error,
 			}:
 			default:
@@ -237,6 +290,9 @@ Loop:
 			}
 		}
 	}
+	c.mu.Lock()
+	c.closeErr = err
+	c.mu.Unlock()
 	close(c.closeCh)
 }
 
@@ -373,6 +429,19 @@ func (ev *ClientEvent) AssertEventName(t *testing.T, event string) *ClientEvent
 	return ev
 }
 
+// AssertAck asserts that the event has the expected ack ID. An ID of zero
+// asserts that the event has no ack ID.
+func (ev *ClientEvent) AssertAck(t *testing.T, id uint64) *ClientEvent {
+	if ev.Ack == nil {
+		if id != 0 {
+			t.Fatalf("expected event %#v to have ack ID %d, but got none", ev.Event, id)
+		}
+	} else if *ev.Ack != id {
+		t.Fatalf("expected event %#v to have ack ID %d, but got %d", ev.Event, id, *ev.Ack)
+	}
+	return ev
+}
+
 // AssertData asserts that the event has the expected data
 func (ev *ClientEvent) AssertData(t *testing.T, data interface{}) *ClientEvent {
 	var err error
@@ -405,3 +474,31 @@ func (c *Conn) AssertClosed(t *testing.T) {
 		t.Fatal("expected the connection to be closed, but it was not")
 	}
 }
+
+// AssertClosedWithText asserts that the connection is closed by the gateway
+// with the given close code and text.
+func (c *Conn) AssertClosedWithText(t *testing.T, code int, text string) {
+	c.AssertClosedWithCode(t, code)
+	c.mu.Lock()
+	cerr := c.closeErr.(*websocket.CloseError)
+	c.mu.Unlock()
+	if cerr.Text != text {
+		t.Fatalf("expected the connection to be closed with text:\n%s\nbut got:\n%s", text, cerr.Text)
+	}
+}
+
+// AssertClosedWithCode asserts that the connection is closed by the gateway
+// with the given close code.
+func (c *Conn) AssertClosedWithCode(t *testing.T, code int) {
+	c.AssertClosed(t)
+	c.mu.Lock()
+	err := c.closeErr
+	c.mu.Unlock()
+	cerr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("expected the connection to be closed with code %d, but got error: %v", code, err)
+	}
+	if cerr.Code != code {
+		t.Fatalf("expected the connection to be closed with code %d, but got code %d", code, cerr.Code)
+	}
+}